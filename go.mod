@@ -12,10 +12,13 @@ require (
 	github.com/nehemming/fsio v0.6.0
 	github.com/nehemming/testsupport v0.0.0-20201206084157-e42fc749801f
 	github.com/nehemming/yaff v0.2.0
+	github.com/pelletier/go-toml v1.9.3
 	github.com/pkg/errors v0.8.1
 	github.com/spf13/cobra v1.2.1
 	github.com/spf13/viper v1.8.1
+	github.com/ulikunitz/xz v0.5.15
 	golang.org/x/net v0.0.0-20210614182718-04defd469f4e
 	golang.org/x/text v0.3.7 // indirect
 	gopkg.in/yaml.v2 v2.4.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -17,6 +17,8 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
+
 	"github.com/nehemming/cirocket/pkg/rocket"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -74,5 +76,7 @@ func (cli *cli) runAssembleCmd(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	return rocket.Default().Assemble(cli.ctx, prep.blueprintName, prep.sources, prep.runbookLocation, prep.params)
+	return cli.withLock(func(ctx context.Context) error {
+		return rocket.Default().Assemble(ctx, prep.blueprintName, prep.sources, prep.runbookLocation, prep.params)
+	})
 }
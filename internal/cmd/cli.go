@@ -32,6 +32,8 @@ import (
 	"github.com/nehemming/cirocket/pkg/buildinfo"
 	"github.com/nehemming/cirocket/pkg/loggee"
 	"github.com/nehemming/cirocket/pkg/loggee/apexlog"
+	"github.com/nehemming/cirocket/pkg/plugin"
+	"github.com/nehemming/cirocket/pkg/resource"
 	"github.com/nehemming/cirocket/pkg/rocket"
 	"github.com/nehemming/fsio"
 	"github.com/spf13/cobra"
@@ -47,6 +49,8 @@ const (
 
 	configAssemblySources = "assembly.sources"
 
+	configPluginPaths = "plugins.paths"
+
 	configFileType = "yml"
 )
 
@@ -64,6 +68,8 @@ type (
 		config           *viper.Viper
 		debug            bool
 		silent           bool
+		offline          bool
+		dryRun           bool
 		logger           loggee.Logger
 		homeDir          string
 	}
@@ -113,6 +119,7 @@ func runWithArgs(ctx context.Context, args []string, logger loggee.Logger) int {
 	cli.rootCmd.AddCommand(cli.newLaunchCommand())
 	cli.rootCmd.AddCommand(cli.newListCommand())
 	cli.rootCmd.AddCommand(cli.newVersionCommand())
+	cli.rootCmd.AddCommand(cli.newWatchCommand())
 
 	initCmd := cli.newInitCommand()
 
@@ -120,6 +127,10 @@ func runWithArgs(ctx context.Context, args []string, logger loggee.Logger) int {
 	initCmd.AddCommand(cli.newInitRunbookCommand())
 	cli.rootCmd.AddCommand(initCmd)
 
+	lockCmd := cli.newLockCommand()
+	lockCmd.AddCommand(cli.newLockUpdateCommand())
+	cli.rootCmd.AddCommand(lockCmd)
+
 	if cli.configError != nil {
 		// early exit due to config building error
 		loggee.Error(cli.configError.Error())
@@ -183,6 +194,12 @@ func newCli(ctx context.Context, logger loggee.Logger) *cli {
 	cli.rootCmd.PersistentFlags().BoolVar(&cli.silent, flagSilent, false,
 		"silence output (ignored if debug is specified too)")
 
+	cli.rootCmd.PersistentFlags().BoolVar(&cli.offline, flagOffline, false,
+		"only use cached copies of remote includes, params and blueprints")
+
+	cli.rootCmd.PersistentFlags().BoolVar(&cli.dryRun, flagDryRun, false,
+		"log the changes the copy, edit, move, patch, remove, cleaner and exportEnv tasks would make without making them")
+
 	return cli
 }
 
@@ -227,12 +244,21 @@ func (cli *cli) loadMissionAndConfig() {
 		return
 	}
 
+	// remote resources are read through the cache
+	cli.setupCache()
+
+	// file tasks only log their changes in a dry run
+	cli.setupDryRun()
+
 	// load config
 	if err := cli.loadConfig(cli.homeDir); err != nil {
 		cli.configError = err
 		return
 	}
 
+	// plugin task types are discovered when first used
+	cli.setupPlugins()
+
 	// load mission
 	if err := cli.loadMission(); err != nil {
 		cli.missionFileError = err
@@ -251,6 +277,39 @@ func (cli *cli) homeConfigName(home string) string {
 	return filepath.Join(home, cli.configDir(), "config.yml")
 }
 
+func (cli *cli) cacheDir() string {
+	return filepath.Join(cli.homeDir, cli.configDir(), "cache")
+}
+
+// setupCache adds the resource cache to the cli context.
+func (cli *cli) setupCache() {
+	if cli.homeDir == "" {
+		return
+	}
+
+	cli.ctx = resource.ContextWithCache(cli.ctx, resource.NewCache(cli.cacheDir(), cli.offline))
+}
+
+// setupDryRun marks the cli context as a dry run when requested.
+func (cli *cli) setupDryRun() {
+	cli.ctx = rocket.ContextWithDryRun(cli.ctx, cli.dryRun)
+}
+
+// setupPlugins resolves unknown task types from the plugins found on the path or listed in the config.
+func (cli *cli) setupPlugins() {
+	resolver := plugin.Resolver(cli.config.GetStringSlice(configPluginPaths))
+	if err := rocket.Default().SetOptions(rocket.TaskTypeResolverOption(resolver)); err != nil {
+		cli.logger.Warnf("setting up plugins: %s", err)
+	}
+}
+
+// registerPlugins registers the task types of all of the plugins found on the path or listed in the config.
+func (cli *cli) registerPlugins() {
+	if err := plugin.Register(cli.ctx, rocket.Default(), cli.config.GetStringSlice(configPluginPaths)); err != nil {
+		cli.logger.Warnf("registering plugins: %s", err)
+	}
+}
+
 // loadConfig loads the users config.
 func (cli *cli) loadConfig(home string) error {
 	config := cli.config
@@ -330,12 +389,17 @@ func (cli *cli) loadMission() error {
 
 	// Establish logging
 	isCustomConfig := false
-	mission.SetConfigType("yml")
+	mission.SetConfigType(configFileType)
 
 	if cli.missionFile != "" {
 		// Use mission file from the flag.
 		mission.SetConfigFile(cli.missionFile)
+		mission.SetConfigType(missionFileType(cli.missionFile))
 		isCustomConfig = true
+	} else if name := cli.findMissionFile(); name != "" {
+		// Found ".(appName).(yml|yaml|json|toml)" in the current dir.
+		mission.SetConfigFile(name)
+		mission.SetConfigType(missionFileType(name))
 	} else {
 		// Search for mission in current dir ".(appName).yml".
 		mission.AddConfigPath(".")
@@ -353,3 +417,32 @@ func (cli *cli) loadMission() error {
 
 	return err
 }
+
+// findMissionFile returns the first mission file found in the current directory, trying each document extension in turn.
+func (cli *cli) findMissionFile() string {
+	for _, ext := range rocket.DocumentExtensions {
+		name := fmt.Sprintf(".%s.%s", cli.appName, ext)
+		if info, err := os.Stat(name); err == nil && !info.IsDir() {
+			if abs, err := filepath.Abs(name); err == nil {
+				return abs
+			}
+			return name
+		}
+	}
+
+	return ""
+}
+
+// missionFileType returns the viper config type of a mission file, detected from its extension or content.
+func missionFileType(name string) string {
+	b, _ := os.ReadFile(name)
+
+	switch rocket.DocumentFormat(name, b) {
+	case rocket.FormatJSON:
+		return "json"
+	case rocket.FormatTOML:
+		return "toml"
+	default:
+		return configFileType
+	}
+}
@@ -30,6 +30,7 @@ import (
 	"github.com/nehemming/cirocket/pkg/loggee"
 	"github.com/nehemming/cirocket/pkg/loggee/stdlog"
 	"github.com/nehemming/cirocket/pkg/resource"
+	"github.com/nehemming/cirocket/pkg/rocket"
 )
 
 func TestNewCli(t *testing.T) {
@@ -220,3 +221,93 @@ func TestMultipleErrorLoggingMulti(t *testing.T) {
 
 	log.Warn(err.Error())
 }
+
+func TestSetupCache(t *testing.T) {
+	cli := newCli(context.Background(), stdlog.New())
+
+	cli.setupCache()
+	if resource.CacheFromContext(cli.ctx) != nil {
+		t.Error("unexpected cache without a home dir")
+	}
+
+	cli.homeDir = "home"
+	cli.offline = true
+	cli.setupCache()
+
+	cache := resource.CacheFromContext(cli.ctx)
+	if cache == nil || !cache.Offline() || cache.Dir() != filepath.Join("home", cli.configDir(), "cache") {
+		t.Error("unexpected cache", cache)
+	}
+	if cli.rootCmd.PersistentFlags().Lookup(flagOffline) == nil {
+		t.Error("missing offline flag")
+	}
+}
+
+func TestDryRunFlag(t *testing.T) {
+	cli := newCli(context.Background(), stdlog.New())
+
+	if cli.rootCmd.PersistentFlags().Lookup(flagDryRun) == nil {
+		t.Error("missing dry run flag")
+	}
+
+	cli.setupDryRun()
+	if rocket.IsDryRun(cli.ctx) {
+		t.Error("unexpected dry run context")
+	}
+
+	cli.dryRun = true
+	cli.setupDryRun()
+
+	if !rocket.IsDryRun(cli.ctx) {
+		t.Error("expected dry run context")
+	}
+}
+
+func TestMissionFileType(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"mission.json":  "{}",
+		"mission.toml":  "name = \"toml\"",
+		"mission.yml":   "name: yml",
+		"mission":       "{\"name\": \"json\"}",
+		"mission.other": "name = \"toml\"\n[params]\n",
+	}
+	expected := map[string]string{
+		"mission.json":  "json",
+		"mission.toml":  "toml",
+		"mission.yml":   "yml",
+		"mission":       "json",
+		"mission.other": "toml",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		if ft := missionFileType(path); ft != expected[name] {
+			t.Error("unexpected type", name, ft)
+		}
+	}
+}
+
+func TestLoadMissionJSON(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "mission.json")
+	if err := os.WriteFile(path, []byte(`{"name": "json", "stages": []}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	cli := newCli(context.Background(), stdlog.New())
+	cli.missionFile = path
+
+	if err := cli.loadMission(); err != nil {
+		t.Fatal(err)
+	}
+
+	if cli.mission.GetString("name") != "json" {
+		t.Error("unexpected name", cli.mission.GetString("name"))
+	}
+}
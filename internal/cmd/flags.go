@@ -36,6 +36,12 @@ const (
 	flagConfig      = "config"
 	flagWorkingDir  = "dir"
 	flagOverwrite   = "replace"
+	flagWatch       = "watch"
+	flagDebounce    = "debounce"
+	flagInterval    = "interval"
+	flagShowMerged  = "show-merged"
+	flagOffline     = "offline"
+	flagDryRun      = "dry-run"
 )
 
 func (cli *cli) addFlagMission(cmd *cobra.Command) *cobra.Command {
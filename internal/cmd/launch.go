@@ -17,8 +17,11 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
+
 	"github.com/nehemming/cirocket/pkg/rocket"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
 )
 
 func (cli *cli) newLaunchCommand() *cobra.Command {
@@ -32,6 +35,8 @@ func (cli *cli) newLaunchCommand() *cobra.Command {
 		RunE:          cli.runLaunchCmd,
 	}
 
+	launchCmd.Flags().Bool(flagShowMerged, false, "show the mission after merging its includes instead of launching it")
+
 	cli.addFlagMission(launchCmd)
 	return addFlagParam(launchCmd)
 }
@@ -50,8 +55,33 @@ func (cli *cli) runLaunchCmd(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	if showMerged, err := cmd.Flags().GetBool(flagShowMerged); err != nil {
+		return err
+	} else if showMerged {
+		return cli.showMergedMission(cmd)
+	}
+
 	// Attempt to launch mission
-	return rocket.Default().
-		LaunchMissionWithParams(cli.ctx, cli.missionFile,
-			cli.mission.AllSettings(), params, args...)
+	return cli.withLock(func(ctx context.Context) error {
+		return rocket.Default().
+			LaunchMissionWithParams(ctx, cli.missionFile,
+				cli.mission.AllSettings(), params, args...)
+	})
+}
+
+func (cli *cli) showMergedMission(cmd *cobra.Command) error {
+	return cli.withLock(func(ctx context.Context) error {
+		mission, err := rocket.Default().LoadMission(ctx, cli.missionFile, cli.mission.AllSettings())
+		if err != nil {
+			return err
+		}
+
+		b, err := yaml.Marshal(mission)
+		if err != nil {
+			return err
+		}
+
+		_, err = cmd.OutOrStdout().Write(b)
+		return err
+	})
 }
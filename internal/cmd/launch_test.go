@@ -17,7 +17,9 @@ limitations under the License.
 package cmd
 
 import (
+	"bytes"
 	"context"
+	"strings"
 	"testing"
 
 	"github.com/nehemming/cirocket/pkg/loggee/stdlog"
@@ -127,3 +129,30 @@ func TestRunMission(t *testing.T) {
 		t.Error("unexpected", err)
 	}
 }
+
+func TestRunMissionShowMerged(t *testing.T) {
+	cli := newCli(context.Background(), stdlog.New())
+	cmd := cli.newLaunchCommand()
+
+	err := cli.preRunCheckInitErrors(cmd, []string{})
+	if err != nil {
+		t.Error("unexpected", err)
+	}
+
+	cli.mission.Set("name", "merged")
+	if err := cmd.Flags().Set(flagShowMerged, "true"); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+
+	err = cli.runLaunchCmd(cmd, []string{})
+	if err != nil {
+		t.Error("unexpected", err)
+	}
+
+	if !strings.Contains(out.String(), "name: merged") {
+		t.Error("unexpected output", out.String())
+	}
+}
@@ -18,8 +18,10 @@ package cmd
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"runtime"
+	"sort"
 
 	"github.com/nehemming/cirocket/pkg/rocket"
 	"github.com/nehemming/yaff/cliflags"
@@ -118,7 +120,27 @@ func (cli *cli) runReport(cmd *cobra.Command, getData func() (interface{}, error
 
 func (cli *cli) listTaskTypes(cmd *cobra.Command) error {
 	return cli.runReport(cmd, func() (interface{}, error) {
-		return rocket.Default().ListTaskTypes(cli.ctx)
+		// plugins are otherwise only described when used
+		cli.registerPlugins()
+
+		list, err := rocket.Default().ListTaskTypes(cli.ctx)
+		if err != nil || cli.missionFileError != nil {
+			return list, err
+		}
+
+		// include the task types defined by the mission
+		err = cli.withLock(func(ctx context.Context) error {
+			mission, err := rocket.Default().LoadMission(ctx, cli.missionFile, cli.mission.AllSettings())
+			if err != nil {
+				return err
+			}
+
+			list = append(list, mission.TaskTypeInfo()...)
+			sort.Sort(list)
+			return nil
+		})
+
+		return list, err
 	})
 }
 
@@ -0,0 +1,110 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/nehemming/cirocket/pkg/resource"
+	"github.com/nehemming/cirocket/pkg/rocket"
+	"github.com/spf13/cobra"
+)
+
+func (cli *cli) newLockCommand() *cobra.Command {
+	lockCmd := &cobra.Command{
+		Use:           "lock",
+		Short:         "manage the lock file of remote includes and blueprints \U0001F512",
+		Long:          "lock subcommands manage the lock file recording the SHA-256 hashes of remote includes and blueprints",
+		Args:          cobra.NoArgs,
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+
+	return lockCmd
+}
+
+func (cli *cli) newLockUpdateCommand() *cobra.Command {
+	updateCmd := &cobra.Command{
+		Use:           "update [blueprint...]",
+		Short:         "rebuild the lock file \U0001F512",
+		Long:          "rebuild the lock file from the remote includes of the mission and any blueprints specified as additional args",
+		Args:          cobra.ArbitraryArgs,
+		SilenceErrors: true,
+		SilenceUsage:  false,
+		RunE:          cli.runLockUpdateCmd,
+	}
+
+	return cli.addFlagMission(updateCmd)
+}
+
+func (cli *cli) runLockUpdateCmd(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	// Without blueprints there must be a mission to lock
+	if cli.missionFileError != nil && len(args) == 0 {
+		return cli.missionFileError
+	}
+
+	// Start from an empty lock so documents no longer used are dropped
+	lock := resource.NewLock(cli.lockPath())
+	ctx := resource.ContextWithLock(cli.ctx, lock)
+
+	if cli.missionFileError == nil {
+		if _, err := rocket.Default().LoadMission(ctx, cli.missionFile, cli.mission.AllSettings()); err != nil {
+			return err
+		}
+	}
+
+	sources := cli.config.GetStringSlice(configAssemblySources)
+	for _, blueprintName := range args {
+		if _, err := rocket.Default().GetRunbook(ctx, blueprintName, sources); err != nil {
+			return err
+		}
+	}
+
+	if err := lock.Save(); err != nil {
+		return err
+	}
+
+	cli.logger.Infof("locked %d documents in %s", len(lock.Entries()), lock.Path())
+	return nil
+}
+
+// lockPath returns the path of the lock file, it is placed alongside the mission file.
+func (cli *cli) lockPath() string {
+	if cli.missionFile == "" {
+		return resource.LockFileName
+	}
+
+	return filepath.Join(filepath.Dir(cli.missionFile), resource.LockFileName)
+}
+
+// withLock runs fn with the lock file loaded into its context.  Remote documents missing from the lock
+// file fail, only lock update adds them.  If there is no lock file remote documents are not checked.
+func (cli *cli) withLock(fn func(ctx context.Context) error) error {
+	lock, err := resource.LoadLock(cli.lockPath())
+	if err != nil {
+		return err
+	}
+
+	if lock == nil {
+		return fn(cli.ctx)
+	}
+
+	return fn(resource.ContextWithLock(cli.ctx, lock))
+}
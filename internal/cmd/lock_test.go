@@ -0,0 +1,101 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nehemming/cirocket/pkg/loggee/stdlog"
+	"github.com/nehemming/cirocket/pkg/resource"
+)
+
+func TestNewLockUpdateCommand(t *testing.T) {
+	cli := newCli(context.Background(), stdlog.New())
+	cmd := cli.newLockUpdateCommand()
+
+	if cmd.Use != "update [blueprint...]" {
+		t.Error("unexpected use", cmd.Use)
+	}
+	if cmd.Flags().Lookup(flagMission) == nil {
+		t.Error("missing mission flag")
+	}
+}
+
+func TestLockPath(t *testing.T) {
+	cli := newCli(context.Background(), stdlog.New())
+
+	if cli.lockPath() != resource.LockFileName {
+		t.Error("unexpected default path", cli.lockPath())
+	}
+
+	cli.missionFile = filepath.Join("some", "dir", "mission.yml")
+	if cli.lockPath() != filepath.Join("some", "dir", resource.LockFileName) {
+		t.Error("unexpected path", cli.lockPath())
+	}
+}
+
+func TestLockUpdateNeedsMissionOrBlueprint(t *testing.T) {
+	cli := newCli(context.Background(), stdlog.New())
+	cmd := cli.newLockUpdateCommand()
+
+	cli.missionFileError = errors.New("no mission")
+	if err := cli.runLockUpdateCmd(cmd, nil); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestWithLockProvidesLock(t *testing.T) {
+	cli := newCli(context.Background(), stdlog.New())
+	cli.missionFile = filepath.Join(t.TempDir(), "mission.yml")
+
+	if err := resource.NewLock(cli.lockPath()).Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := cli.withLock(func(ctx context.Context) error {
+		if resource.LockFromContext(ctx) == nil {
+			t.Error("no lock in context")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error("unexpected error", err)
+	}
+}
+
+func TestWithLockNoLockFile(t *testing.T) {
+	cli := newCli(context.Background(), stdlog.New())
+	cli.missionFile = filepath.Join(t.TempDir(), "mission.yml")
+
+	err := cli.withLock(func(ctx context.Context) error {
+		if resource.LockFromContext(ctx) != nil {
+			t.Error("unexpected lock in context")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error("unexpected error", err)
+	}
+
+	if _, err := os.Stat(cli.lockPath()); !os.IsNotExist(err) {
+		t.Error("lock file should not be written", err)
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -52,7 +53,12 @@ func (cli *cli) runInitRunbookCmd(cmd *cobra.Command, args []string) error {
 	// Get assembly sources
 	sources := cli.config.GetStringSlice(configAssemblySources)
 
-	runbook, err := rocket.Default().GetRunbook(cli.ctx, blueprintName, sources)
+	var runbook string
+	err := cli.withLock(func(ctx context.Context) error {
+		var err error
+		runbook, err = rocket.Default().GetRunbook(ctx, blueprintName, sources)
+		return err
+	})
 	if err != nil {
 		return err
 	}
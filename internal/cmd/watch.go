@@ -0,0 +1,76 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+
+	"github.com/nehemming/cirocket/pkg/rocket"
+	"github.com/spf13/cobra"
+)
+
+func (cli *cli) newWatchCommand() *cobra.Command {
+	watchCmd := &cobra.Command{
+		Use:           "watch [{flightSequence}]",
+		Short:         "launch the cirocket \U0001F680 and relaunch on file changes",
+		Long:          "runs the CI config and then re-runs stages when files matching their watch patterns change, if the config uses sequences, one or more can be specified as additional args",
+		Args:          cobra.ArbitraryArgs,
+		SilenceErrors: true,
+		SilenceUsage:  false,
+		RunE:          cli.runWatchCmd,
+	}
+
+	watchCmd.Flags().StringArray(flagWatch, nil, "glob pattern of files to watch for all stages, multiple watch flags can be provided")
+	watchCmd.Flags().Duration(flagDebounce, rocket.DefaultWatchDebounce, "quiet period after a change before re-running")
+	watchCmd.Flags().Duration(flagInterval, rocket.DefaultWatchInterval, "interval between checks for changes")
+
+	cli.addFlagMission(watchCmd)
+	return addFlagParam(watchCmd)
+}
+
+func (cli *cli) runWatchCmd(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	// Check that the init process found a config file
+	if cli.missionFileError != nil {
+		return cli.missionFileError
+	}
+
+	// Handle params
+	params, err := cli.getCliParams(cmd)
+	if err != nil {
+		return err
+	}
+
+	settings := rocket.WatchSettings{}
+	if settings.Patterns, err = cmd.Flags().GetStringArray(flagWatch); err != nil {
+		return err
+	}
+	if settings.Debounce, err = cmd.Flags().GetDuration(flagDebounce); err != nil {
+		return err
+	}
+	if settings.Interval, err = cmd.Flags().GetDuration(flagInterval); err != nil {
+		return err
+	}
+
+	// Launch and watch the mission until cancelled
+	return cli.withLock(func(ctx context.Context) error {
+		return rocket.Default().
+			WatchMission(ctx, cli.missionFile,
+				cli.mission.AllSettings(), params, settings, args...)
+	})
+}
@@ -0,0 +1,44 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nehemming/cirocket/pkg/loggee/stdlog"
+	"github.com/nehemming/cirocket/pkg/rocket"
+)
+
+func TestNewWatchCommand(t *testing.T) {
+	cli := newCli(context.Background(), stdlog.New())
+	cmd := cli.newWatchCommand()
+
+	if cmd.Use != "watch [{flightSequence}]" {
+		t.Error("unexpected use", cmd.Use)
+	}
+
+	for _, name := range []string{flagWatch, flagDebounce, flagInterval, flagMission, flagParam} {
+		if cmd.Flags().Lookup(name) == nil {
+			t.Error("missing flag", name)
+		}
+	}
+
+	if d, err := cmd.Flags().GetDuration(flagDebounce); err != nil || d != rocket.DefaultWatchDebounce {
+		t.Error("unexpected debounce", d, err)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/mitchellh/mapstructure"
 	"github.com/nehemming/cirocket/pkg/loggee"
@@ -32,13 +33,39 @@ type (
 	// Remove lists files or directories to remove
 	Remove struct {
 		Files []string `mapstructure:"files"`
-		Log   bool     `mapstructure:"log"`
+
+		// Exclude are glob patterns of files that are not removed.
+		Exclude []string `mapstructure:"exclude"`
+
+		// IgnoreFiles are .gitignore style files listing further files to exclude.
+		IgnoreFiles []string `mapstructure:"ignoreFiles"`
+
+		Log bool `mapstructure:"log"`
+	}
+
+	// Cleaner removes the files ignored by the .gitignore files of a directory, like git clean -X.
+	Cleaner struct {
+		// Dir is the directory to clean, it defaults to the mission's directory.
+		Dir string `mapstructure:"dir"`
+
+		// Keep are glob patterns, relative to the dir, of ignored files that are not removed.
+		Keep []string `mapstructure:"keep"`
+
+		// DryRun lists the files that would be removed without removing them.
+		DryRun bool `mapstructure:"dryRun"`
+
+		// Files is not supported by the cleaner, use a remove task to delete listed files.
+		Files []string `mapstructure:"files"`
+
+		Log bool `mapstructure:"log"`
 	}
 
 	removeType  struct{}
 	cleanerType struct{}
 )
 
+const gitIgnoreFile = ".gitignore"
+
 func (removeType) Type() string {
 	return "remove"
 }
@@ -52,18 +79,55 @@ func (cleanerType) Type() string {
 }
 
 func (cleanerType) Description() string {
-	return "cleans up files matching on of the file glob specs."
+	return "cleans up the files ignored by the .gitignore files of a directory."
 }
 
 // Prepare prepares the cleaner task and returns an operation or an error.
 func (cleanerType) Prepare(ctx context.Context, capComm *rocket.CapComm, task rocket.Task) (rocket.ExecuteFunc, error) {
-	removeType := &Remove{}
+	cleaner := &Cleaner{}
 
-	if err := mapstructure.WeakDecode(task.Definition, removeType); err != nil {
-		return nil, errors.Wrap(err, "parsing template type")
+	if err := mapstructure.WeakDecode(task.Definition, cleaner); err != nil {
+		return nil, errors.Wrap(err, "parsing cleaner type")
 	}
 
-	return buildRemoveOp(capComm, removeType)
+	if len(cleaner.Files) > 0 {
+		return nil, errors.New("cleaner removes ignored files, use a remove task to delete files")
+	}
+
+	fn := func(execCtx context.Context) error {
+		root, err := getCleanerDir(execCtx, capComm, cleaner.Dir)
+		if err != nil {
+			return err
+		}
+
+		keep := &patternSet{}
+		for index, k := range cleaner.Keep {
+			pattern, err := capComm.ExpandString(execCtx, "keep", k)
+			if err != nil {
+				return errors.Wrapf(err, "expanding keep %d", index)
+			}
+
+			if err := keep.addRule(root, pattern, true, false); err != nil {
+				return err
+			}
+		}
+
+		ignored, partial, err := findIgnored(root, keep)
+		if err != nil {
+			return err
+		}
+
+		if cleaner.DryRun || rocket.IsDryRun(execCtx) {
+			for _, path := range ignored {
+				capComm.Log().Infof("would remove %s", friendlyRelativePath(path))
+			}
+			return nil
+		}
+
+		return removeIgnored(ignored, partial, getLogFromCapComm(capComm, cleaner.Log))
+	}
+
+	return fn, nil
 }
 
 // Prepare loads the tasks configuration and returns the operation function or an error.
@@ -90,38 +154,79 @@ func buildRemoveOp(capComm *rocket.CapComm, config *Remove) (rocket.ExecuteFunc,
 		}
 
 		// glob the files
-		files, err := globFile(specs)
+		ps, err := newPatternSetFromConfig(execCtx, capComm, specs, config.Exclude, config.IgnoreFiles)
+		if err != nil {
+			return err
+		}
+
+		if err := checkGlobBases(capComm, ps); err != nil {
+			return err
+		}
+
+		matches, _, err := ps.Glob()
 		if err != nil {
 			return err
 		}
 
+		// refuse to remove anything if a match is outside of the sandbox
+		for _, m := range matches {
+			if err := capComm.CheckSandbox(m.path); err != nil {
+				return err
+			}
+		}
+
+		if rocket.IsDryRun(execCtx) {
+			for _, m := range matches {
+				capComm.Log().Infof("would remove %s", friendlyRelativePath(m.path))
+			}
+			return nil
+		}
+
 		// clean
-		return deleteFiles(files, getLogFromCapComm(capComm, config.Log))
+		log := getLogFromCapComm(capComm, config.Log)
+		if ps.HasExclusions() {
+			return deleteMatches(ps, matches, log)
+		}
+
+		files := make([]string, 0, len(matches))
+		for _, m := range matches {
+			files = append(files, m.path)
+		}
+
+		return deleteFiles(files, log)
 	}
 
 	return fn, nil
 }
 
-// getLogFromCapComm returns the capComm logger or nil based on the log bool value.
-func getLogFromCapComm(capComm *rocket.CapComm, log bool) loggee.Logger {
-	if log {
-		return capComm.Log()
+// checkGlobBases refuses patterns whose base directory is outside of the sandbox before they are globbed,
+// a pattern such as /** would otherwise walk the whole file system.
+func checkGlobBases(capComm *rocket.CapComm, ps *patternSet) error {
+	for _, spec := range ps.specs {
+		if !strings.ContainsAny(spec, "*?[{") {
+			continue
+		}
+
+		base, err := getBaseDir(spec)
+		if err != nil {
+			return errors.Wrapf(err, "dir walk %s", spec)
+		}
+
+		if err := capComm.CheckSandbox(base); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func globFile(specs []string) ([]string, error) {
-	files := make([]string, 0, len(specs))
-	for _, spec := range specs {
-		list, err := recursiveGlob(spec)
-		if err != nil {
-			return nil, errors.Wrapf(err, "globbing %s", spec)
-		}
-		files = append(files, list...)
+// getLogFromCapComm returns the capComm logger or nil based on the log bool value.
+func getLogFromCapComm(capComm *rocket.CapComm, log bool) loggee.Logger {
+	if log {
+		return capComm.Log()
 	}
 
-	return toDistinctStrings(files...), nil
+	return nil
 }
 
 func deleteFiles(files []string, log loggee.Logger) error {
@@ -154,18 +259,211 @@ func deleteFiles(files []string, log loggee.Logger) error {
 	return nil
 }
 
-func toDistinctStrings(files ...string) []string {
-	// Make list distinct, as more than one ref may match
-	m := make(map[string]bool)
-	res := make([]string, 0, len(files))
-	for _, v := range files {
-		if m[v] {
+// deleteMatches deletes the matched files.  Matched directories are only partially deleted, keeping
+// the files excluded by the pattern set.
+func deleteMatches(ps *patternSet, matches []patternMatch, log loggee.Logger) error {
+	for _, m := range matches {
+		stat, err := os.Lstat(m.path)
+		if os.IsNotExist(err) {
+			// already removed with a parent directory
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "stat %s:", m.path)
+		}
+
+		if !stat.IsDir() {
+			if err := deleteFiles([]string{m.path}, log); err != nil {
+				return err
+			}
 			continue
 		}
-		m[v] = true
-		res = append(res, v)
+
+		if err := deleteDirExcept(ps, m, log); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteDirExcept deletes the contents of a directory that are not excluded, along with any directories left empty.
+func deleteDirExcept(ps *patternSet, m patternMatch, log loggee.Logger) error {
+	var files, dirs []string
+
+	err := filepath.Walk(m.path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if ps.Excluded(m.base, path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			dirs = append(dirs, path)
+		} else {
+			files = append(files, path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "walking %s:", m.path)
+	}
+
+	if err := deleteFiles(files, log); err != nil {
+		return err
+	}
+
+	// deepest first, directories still holding excluded files are kept
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if os.Remove(dirs[i]) == nil && log != nil {
+			log.Infof("removed %s", friendlyRelativePath(dirs[i]))
+		}
+	}
+
+	return nil
+}
+
+// getCleanerDir returns the absolute directory to clean, which defaults to the mission's directory,
+// or the working directory if the mission was not loaded from the file system.  The directory must be within the sandbox.
+func getCleanerDir(ctx context.Context, capComm *rocket.CapComm, dir string) (string, error) {
+	if dir == "" {
+		dir = capComm.GetParam(rocket.MissionDirAbsParamName)
+		if dir == "" {
+			dir = capComm.GetParam(rocket.WorkingDirectoryParamName)
+		}
+	} else {
+		expanded, err := capComm.ExpandString(ctx, "dir", dir)
+		if err != nil {
+			return "", errors.Wrap(err, "expanding dir")
+		}
+		dir = expanded
+	}
+
+	root, err := filepath.Abs(filepath.FromSlash(dir))
+	if err != nil {
+		return "", errors.Wrapf(err, "abs %s", dir)
+	}
+
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", errors.Wrapf(err, "dir %s", dir)
+	}
+
+	if err := capComm.CheckSandbox(root); err != nil {
+		return "", err
+	}
+
+	return root, nil
+}
+
+// findIgnored walks the root directory returning the ignored paths to remove.  The .gitignore files of the
+// root's parent directories, up to the repository root, apply along with those found by the walk.
+// Ignored directories holding kept files are returned as partial and are only removed if emptied.
+func findIgnored(root string, keep *patternSet) (ignored, partial []string, err error) {
+	ignore := &patternSet{}
+	if err := addParentIgnoreFiles(ignore, root); err != nil {
+		return nil, nil, err
+	}
+
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path == root {
+			return ignore.addIgnoreFile(filepath.Join(path, gitIgnoreFile))
+		}
+
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+
+		if keep.Excluded(root, path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		switch {
+		case !ignore.Excluded(root, path):
+			if info.IsDir() {
+				return ignore.addIgnoreFile(filepath.Join(path, gitIgnoreFile))
+			}
+		case !info.IsDir():
+			ignored = append(ignored, path)
+		case keep.HasExclusions():
+			// may hold kept files
+			partial = append(partial, path)
+		default:
+			ignored = append(ignored, path)
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "walking %s", root)
+	}
+
+	return ignored, partial, nil
+}
+
+// addParentIgnoreFiles adds the .gitignore files of the directories above the dir, up to the repository root,
+// and the repository's exclude file.  Nothing is added if the dir is not in a repository.
+func addParentIgnoreFiles(ps *patternSet, dir string) error {
+	var parents []string
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			if err := ps.addIgnoreFile(filepath.Join(dir, ".git", "info", "exclude")); err != nil {
+				return err
+			}
+
+			// outermost first so nearer files take precedence
+			for i := len(parents) - 1; i >= 0; i-- {
+				if err := ps.addIgnoreFile(filepath.Join(parents[i], gitIgnoreFile)); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+
+		dir = parent
+		parents = append(parents, dir)
+	}
+}
+
+// removeIgnored removes the ignored paths and then any partial directories left empty.
+func removeIgnored(ignored, partial []string, log loggee.Logger) error {
+	for _, path := range ignored {
+		if err := os.RemoveAll(path); err != nil {
+			return errors.Wrapf(err, "rm %s:", path)
+		}
+
+		if log != nil {
+			log.Infof("removed %s", friendlyRelativePath(path))
+		}
 	}
-	return res
+
+	// deepest first, directories still holding kept files are kept
+	for i := len(partial) - 1; i >= 0; i-- {
+		if os.Remove(partial[i]) == nil && log != nil {
+			log.Infof("removed %s", friendlyRelativePath(partial[i]))
+		}
+	}
+
+	return nil
 }
 
 func init() {
@@ -89,7 +89,94 @@ func validateCleanerTest(t *testing.T) {
 }
 
 func TestCleanerRun(t *testing.T) {
-	removeRun(t, "cleaner")
+	loggee.SetLogger(stdlog.New())
+
+	mc := rocket.NewMissionControl()
+	RegisterAll(mc)
+
+	dir := filepath.Join("testdata", "clean-ignored")
+	defer os.RemoveAll(dir)
+	writeCopyFiles(t, dir, map[string]string{
+		".gitignore":    "*.o\nbuild/\n",
+		"a.o":           "",
+		"keep.o":        "",
+		"src/main.c":    "",
+		"src/x.o":       "",
+		"build/out/bin": "",
+	})
+
+	mission, cfgFile := loadMission("cleaner")
+
+	if err := mc.LaunchMission(context.Background(), cfgFile, mission); err != nil {
+		t.Error("failure", err)
+	}
+
+	for _, name := range []string{".gitignore", "keep.o", "src/main.c"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(name, "removed", err)
+		}
+	}
+
+	for _, name := range []string{"a.o", "src/x.o", "build"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			t.Error(name, "not removed")
+		}
+	}
+}
+
+func launchCleaner(dir string, cleanerCfg map[string]interface{}) error {
+	return launchTasks(context.Background(), filepath.Join(dir, "mission", "mission.yml"), nil, newTask("cleaner", "clean", cleanerCfg))
+}
+
+func TestCleanerKeepAndDryRun(t *testing.T) {
+	dir := t.TempDir()
+	writeCopyFiles(t, dir, map[string]string{
+		"mission/.gitignore":           "dist/\n",
+		"mission/dist/app":             "",
+		"mission/dist/config/.env":     "",
+		"mission/web/.gitignore":       "*.js\n",
+		"mission/web/app.js":           "",
+		"mission/api/server.js":        "",
+		"mission/web/vendor/lib.js":    "",
+		"outside/dist/should-stay.txt": "",
+	})
+	mission := filepath.Join(dir, "mission")
+
+	if err := launchCleaner(dir, map[string]interface{}{"dryRun": true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(mission, "dist", "app")); err != nil {
+		t.Error("dry run removed files", err)
+	}
+
+	if err := launchCleaner(dir, map[string]interface{}{"keep": []interface{}{".env"}, "log": true}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"dist/config/.env", "api/server.js", "web/.gitignore"} {
+		if _, err := os.Stat(filepath.Join(mission, name)); err != nil {
+			t.Error(name, "removed", err)
+		}
+	}
+
+	for _, name := range []string{"dist/app", "web/app.js", "web/vendor/lib.js"} {
+		if _, err := os.Stat(filepath.Join(mission, name)); err == nil {
+			t.Error(name, "not removed")
+		}
+	}
+
+	if err := launchCleaner(dir, map[string]interface{}{"dir": filepath.Join(dir, "outside")}); err == nil {
+		t.Error("expected outside of mission directory error")
+	}
+
+	if err := launchCleaner(dir, map[string]interface{}{"files": []interface{}{"dist"}}); err == nil {
+		t.Error("expected files error")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "outside", "dist", "should-stay.txt")); err != nil {
+		t.Error("removed outside of mission directory", err)
+	}
 }
 
 func TestRemoveRun(t *testing.T) {
@@ -130,6 +217,49 @@ func removeRun(t *testing.T, missionName string) {
 	validateCleanerTest(t)
 }
 
+func launchRemove(ctx context.Context, dir string, files ...interface{}) error {
+	task := newTask("remove", "remove files", map[string]interface{}{"files": files})
+	return launchTasks(ctx, filepath.Join(dir, "mission", "mission.yml"), nil, task)
+}
+
+func TestRemoveSandbox(t *testing.T) {
+	dir := t.TempDir()
+	writeCopyFiles(t, dir, map[string]string{"mission/build/a.txt": "a", "outside/b.txt": "b"})
+	base := filepath.ToSlash(dir)
+
+	// a match outside of the sandbox stops all removals
+	if err := launchRemove(context.Background(), dir, base+"/mission/build/**", base+"/outside/**"); err == nil {
+		t.Error("expected sandbox error")
+	}
+
+	for _, name := range []string{"mission/build/a.txt", "outside/b.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(name, "removed", err)
+		}
+	}
+
+	// patterns based outside of the sandbox fail before globbing, even if nothing matches
+	if err := launchRemove(context.Background(), dir, base+"/missing/**"); err == nil {
+		t.Error("expected sandbox error for the pattern base")
+	}
+
+	if err := launchRemove(rocket.ContextWithDryRun(context.Background(), true), dir, base+"/mission/build/**"); err != nil {
+		t.Error("dry run", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "mission", "build", "a.txt")); err != nil {
+		t.Error("dry run removed file", err)
+	}
+
+	if err := launchRemove(context.Background(), dir, base+"/mission/build/**"); err != nil {
+		t.Error("remove", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "mission", "build", "a.txt")); err == nil {
+		t.Error("file not removed")
+	}
+}
+
 func TestDeleteMissing(t *testing.T) {
 	files := []string{"filenotexists.gogo"}
 
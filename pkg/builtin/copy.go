@@ -18,11 +18,13 @@ package builtin
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
 
+	globber "github.com/bmatcuk/doublestar/v4"
 	"github.com/mitchellh/mapstructure"
 	"github.com/nehemming/cirocket/pkg/loggee"
 	"github.com/nehemming/cirocket/pkg/rocket"
@@ -45,10 +47,66 @@ type (
 		Sources     []string `mapstructure:"sources"`
 		Destination string   `mapstructure:"destination"`
 		Overwrite   string   `mapstructure:"overwrite"`
-		Log         bool     `mapstructure:"log"`
+
+		// Mode is copy (the default) or sync.  Sync deletes destination files not present in the sources.
+		Mode string `mapstructure:"mode"`
+
+		// Exclude are glob patterns of files that are not copied, or deleted when syncing.
+		Exclude []string `mapstructure:"exclude"`
+
+		// IgnoreFiles are .gitignore style files listing further files to exclude.
+		IgnoreFiles []string `mapstructure:"ignoreFiles"`
+
+		// OnlyNewer skips unchanged files, mtime compares the modified time and size and hash compares the contents.
+		// Existing files are overwritten unless overwrite is false.
+		OnlyNewer string `mapstructure:"onlyNewer"`
+
+		// PreserveTimes sets the modified time of copied files to that of their source.
+		PreserveTimes bool `mapstructure:"preserveTimes"`
+
+		// FollowSymlinks copies the targets of symbolic links, defaults to true.
+		FollowSymlinks string `mapstructure:"followSymlinks"`
+
+		// PreserveSymlinks recreates symbolic links in the destination rather than copying their targets.
+		PreserveSymlinks bool `mapstructure:"preserveSymlinks"`
+
+		Log bool `mapstructure:"log"`
 	}
 
 	copyType struct{}
+
+	// copySource is a file to copy, link is set when a symbolic link is being preserved.
+	copySource struct {
+		absRel
+		link string
+	}
+
+	copyOptions struct {
+		overwrite     bool
+		onlyNewer     string
+		preserveTimes bool
+		dryRun        bool
+		patterns      *patternSet
+	}
+
+	// copySummary counts the outcome of a copy.
+	copySummary struct {
+		copied  int
+		skipped int
+		deleted int
+	}
+)
+
+// Copy modes.
+const (
+	CopyModeCopy = "copy"
+	CopyModeSync = "sync"
+)
+
+// OnlyNewer comparisons.
+const (
+	OnlyNewerMTime = "mtime"
+	OnlyNewerHash  = "hash"
 )
 
 func (copyType) Type() string {
@@ -64,7 +122,23 @@ func (copyType) Prepare(ctx context.Context, capComm *rocket.CapComm, task rocke
 	copyCfg := &Copy{}
 
 	if err := mapstructure.WeakDecode(task.Definition, copyCfg); err != nil {
-		return nil, errors.Wrap(err, "parsing template type")
+		return nil, errors.Wrap(err, "parsing copy type")
+	}
+
+	mode := strings.ToLower(copyCfg.Mode)
+	if mode != "" && mode != CopyModeCopy && mode != CopyModeSync {
+		return nil, fmt.Errorf("unknown copy mode %s, use copy or sync", copyCfg.Mode)
+	}
+
+	onlyNewer, err := getOnlyNewer(copyCfg.OnlyNewer)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pattern := range copyCfg.Exclude {
+		if !globber.ValidatePattern(filepath.ToSlash(pattern)) {
+			return nil, fmt.Errorf("invalid exclude pattern %s", pattern)
+		}
 	}
 
 	fn := func(execCtx context.Context) error {
@@ -80,6 +154,13 @@ func (copyType) Prepare(ctx context.Context, capComm *rocket.CapComm, task rocke
 			return errors.Wrapf(err, "expanding dest %s", copyCfg.Destination)
 		}
 
+		follow := true
+		if copyCfg.FollowSymlinks != "" {
+			if follow, err = capComm.ExpandBool(ctx, "followSymlinks", copyCfg.FollowSymlinks); err != nil {
+				return errors.Wrap(err, "expanding followSymlinks")
+			}
+		}
+
 		// get the destination spec from the raw path entered
 		destSpec, err := getDestSpec(dest)
 		if err != nil {
@@ -97,18 +178,78 @@ func (copyType) Prepare(ctx context.Context, capComm *rocket.CapComm, task rocke
 		}
 
 		// glob the files
-		files, err := globFileAbsRel(rawSpecs...)
+		ps, err := newPatternSetFromConfig(ctx, capComm, rawSpecs, copyCfg.Exclude, copyCfg.IgnoreFiles)
+		if err != nil {
+			return err
+		}
+
+		files, err := globCopySources(ps, follow, copyCfg.PreserveSymlinks)
 		if err != nil {
 			return err
 		}
 
+		opts := copyOptions{
+			overwrite:     overwrite || mode == CopyModeSync || (onlyNewer != "" && copyCfg.Overwrite == ""),
+			onlyNewer:     onlyNewer,
+			preserveTimes: copyCfg.PreserveTimes,
+			dryRun:        rocket.IsDryRun(execCtx),
+			patterns:      ps,
+		}
+
+		if err := capComm.CheckSandbox(destSpec.Path); err != nil {
+			return err
+		}
+
+		if mode == CopyModeSync {
+			if err := checkSyncDestination(rawSpecs, destSpec); err != nil {
+				return err
+			}
+
+			// a sync destination is always a directory, created on the first sync
+			destSpec.IsDir = true
+			if !opts.dryRun {
+				if err := os.MkdirAll(destSpec.Path, 0777); err != nil {
+					return errors.Wrapf(err, "dir %s", destSpec.Path)
+				}
+			}
+		}
+
 		// copy
-		return copyFiles(execCtx, files, destSpec, overwrite, getLogFromCapComm(capComm, copyCfg.Log))
+		log := getLogFromCapComm(capComm, copyCfg.Log)
+		if opts.dryRun {
+			log = capComm.Log()
+		}
+
+		summary, err := copyFiles(execCtx, files, destSpec, opts, log)
+		if err == nil && mode == CopyModeSync {
+			err = pruneDestination(execCtx, files, destSpec, opts, &summary, log)
+		}
+
+		if opts.dryRun {
+			log.Infof("would copy %d, skip %d, delete %d files", summary.copied, summary.skipped, summary.deleted)
+		} else if log != nil {
+			log.Infof("copied %d, skipped %d, deleted %d files", summary.copied, summary.skipped, summary.deleted)
+		}
+
+		return err
 	}
 
 	return fn, nil
 }
 
+func getOnlyNewer(onlyNewer string) (string, error) {
+	switch strings.ToLower(onlyNewer) {
+	case "", "false", "0":
+		return "", nil
+	case "true", "1", OnlyNewerMTime:
+		return OnlyNewerMTime, nil
+	case OnlyNewerHash:
+		return OnlyNewerHash, nil
+	}
+
+	return "", fmt.Errorf("unknown onlyNewer %s, use mtime or hash", onlyNewer)
+}
+
 func getDestSpec(rawDest string) (dSpec destSpec, err error) {
 	if rawDest == "" {
 		return dSpec, errors.New("destination cannot be blank")
@@ -147,52 +288,48 @@ func getBaseDir(dir string) (string, error) {
 	return dir, nil
 }
 
-func appendAbsRelList(files []absRel, spec string, list ...string) ([]absRel, error) {
-	dir, err := getBaseDir(spec)
-	if err != nil {
-		return nil, errors.Wrapf(err, "dir walk %s", spec)
-	}
-
-	for _, l := range list {
-
-		stat, err := os.Stat(l)
+func appendAbsRelList(files []absRel, matches ...patternMatch) ([]absRel, error) {
+	for _, m := range matches {
+		stat, err := os.Stat(m.path)
 		if err != nil {
-			return nil, errors.Wrapf(err, "stat %s", l)
+			return nil, errors.Wrapf(err, "stat %s", m.path)
 		}
 		if stat.IsDir() {
 			// skip dirs
 			continue
 		}
 
-		abs, err := filepath.Abs(l)
+		rel, err := filepath.Rel(m.base, m.path)
 		if err != nil {
-			return nil, errors.Wrapf(err, "abs %s", l)
+			return nil, errors.Wrapf(err, "rel dir of %s", m.path)
 		}
 
-		rel, err := filepath.Rel(dir, abs)
-		if err != nil {
-			return nil, errors.Wrapf(err, "rel dir of %s", l)
-		}
-
-		files = append(files, absRel{Abs: abs, Rel: rel})
+		files = append(files, absRel{Abs: m.path, Rel: rel})
 	}
 
 	return files, nil
 }
 
-func globFileAbsRel(rawSpecs ...string) (files []absRel, err error) {
-	for _, rawSpec := range rawSpecs {
+// globFileAbsRel globs the specs, specs prefixed with ! exclude the files they match.
+func globFileAbsRel(rawSpecs ...string) ([]absRel, error) {
+	ps, err := newPatternSet(rawSpecs, nil, nil)
+	if err != nil {
+		return nil, err
+	}
 
-		clean := filepath.Clean(filepath.FromSlash(rawSpec))
-		list, err := recursiveGlob(clean)
-		if err != nil {
-			return nil, errors.Wrapf(err, "globbing %s", clean)
-		}
+	return globPatternSetAbsRel(ps)
+}
 
-		files, err = appendAbsRelList(files, clean, list...)
-		if err != nil {
-			return nil, err
-		}
+// globPatternSetAbsRel globs the files included by the pattern set.
+func globPatternSetAbsRel(ps *patternSet) ([]absRel, error) {
+	included, _, err := ps.Glob()
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := appendAbsRelList(nil, included...)
+	if err != nil {
+		return nil, err
 	}
 
 	return toDistinctAbsRelSlice(files...), nil
@@ -212,56 +349,166 @@ func toDistinctAbsRelSlice(files ...absRel) []absRel {
 	return res
 }
 
-func copyFiles(ctx context.Context, sources []absRel, dest destSpec, allowOverwrite bool, log loggee.Logger) error {
+// linkedAncestor returns true if a directory between base and path is a symbolic link.
+func linkedAncestor(base, path string) bool {
+	for dir := filepath.Dir(path); len(dir) > len(base) && strings.HasPrefix(dir, base); dir = filepath.Dir(dir) {
+		if stat, err := os.Lstat(dir); err == nil && stat.Mode()&os.ModeSymlink != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// globCopySources globs the source files.  Files reached through symbolic links are skipped unless
+// follow is set, or with preserve the links themselves are returned.
+func globCopySources(ps *patternSet, follow, preserve bool) ([]copySource, error) {
+	included, _, err := ps.Glob()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]copySource, 0, len(included))
+	seen := make(map[string]bool)
+
+	for _, m := range included {
+		source, ok, err := getCopySource(m.base, m.path, follow, preserve)
+		if err != nil {
+			return nil, err
+		}
+
+		// Make list distinct, as more than one ref may match
+		if !ok || seen[source.Rel] {
+			continue
+		}
+		seen[source.Rel] = true
+		files = append(files, source)
+	}
+
+	return files, nil
+}
+
+func getCopySource(dir, path string, follow, preserve bool) (copySource, bool, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return copySource{}, false, errors.Wrapf(err, "abs %s", path)
+	}
+
+	rel, err := filepath.Rel(dir, abs)
+	if err != nil {
+		return copySource{}, false, errors.Wrapf(err, "rel dir of %s", path)
+	}
+
+	source := copySource{absRel: absRel{Abs: abs, Rel: rel}}
+
+	lstat, err := os.Lstat(abs)
+	if err != nil {
+		return source, false, errors.Wrapf(err, "stat %s", path)
+	}
+
+	isLink := lstat.Mode()&os.ModeSymlink != 0
+	if (preserve || !follow) && linkedAncestor(dir, abs) {
+		// reached through a linked directory
+		return source, false, nil
+	}
+
+	if isLink && preserve {
+		source.link, err = os.Readlink(abs)
+		return source, err == nil, errors.Wrapf(err, "link %s", path)
+	}
+
+	if isLink && !follow {
+		return source, false, nil
+	}
+
+	stat, err := os.Stat(abs)
+	if err != nil {
+		return source, false, errors.Wrapf(err, "stat %s", path)
+	}
+
+	// skip dirs
+	return source, !stat.IsDir(), nil
+}
+
+func copyFiles(ctx context.Context, sources []copySource, dest destSpec, opts copyOptions, log loggee.Logger) (copySummary, error) {
+	var summary copySummary
+
+	copyFn := copyFile
+	if opts.dryRun {
+		copyFn = dryRunCopy
+	}
+
 	for _, source := range sources {
 		if ctx.Err() != nil {
-			return ctx.Err()
+			return summary, ctx.Err()
 		}
 
-		if err := copyFile(source, dest, allowOverwrite, log); err != nil {
-			return err
+		copied, err := copyFn(source, dest, opts, log)
+		if err != nil {
+			return summary, err
+		}
+
+		if copied {
+			summary.copied++
+		} else {
+			summary.skipped++
 		}
 	}
-	return nil
+
+	return summary, nil
 }
 
-func copyFile(source absRel, dest destSpec, allowOverwrite bool, log loggee.Logger) error {
+func copyFile(source copySource, dest destSpec, opts copyOptions, log loggee.Logger) (bool, error) {
+	if source.link != "" {
+		return copyLink(source, dest, opts, log)
+	}
+
 	// Get the source files permission
 	stat, err := os.Stat(source.Abs)
 	if err != nil {
-		return errors.Wrapf(err, "stat %s:", source.Abs)
+		return false, errors.Wrapf(err, "stat %s:", source.Abs)
 	}
 
 	// open reader
 	srcFile, err := os.Open(source.Abs)
 	if err != nil {
-		return errors.Wrapf(err, "open %s:", source.Abs)
+		return false, errors.Wrapf(err, "open %s:", source.Abs)
 	}
 	defer srcFile.Close()
 
-	destAbsRel, err := prepDestination(source, dest, allowOverwrite)
+	destAbsRel, err := prepDestination(source.absRel, dest, opts.overwrite)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	if destAbsRel == nil || source.Abs == destAbsRel.Abs {
+	if destAbsRel == nil || source.Abs == destAbsRel.Abs || isUnchanged(source.Abs, stat, destAbsRel.Abs, opts.onlyNewer) {
 		// skipping
 		if log != nil {
 			log.Infof("skipping %s", source.Rel)
 		}
-		return nil
+		return false, nil
 	}
 
 	destFile, err := os.OpenFile(destAbsRel.Abs, os.O_RDWR|os.O_CREATE|os.O_TRUNC, stat.Mode())
 	if err != nil {
-		return errors.Wrapf(err, "dest %s:", destAbsRel.Rel)
+		return false, errors.Wrapf(err, "dest %s:", destAbsRel.Rel)
 	}
 	defer destFile.Close()
 
 	// Do copy
 	_, err = io.Copy(destFile, srcFile)
 	if err != nil {
-		return errors.Wrapf(err, "copy %s => %s:", source.Rel, destAbsRel.Rel)
+		return false, errors.Wrapf(err, "copy %s => %s:", source.Rel, destAbsRel.Rel)
+	}
+
+	if opts.preserveTimes {
+		if err := destFile.Close(); err != nil {
+			return false, errors.Wrapf(err, "dest %s:", destAbsRel.Rel)
+		}
+
+		if err := os.Chtimes(destAbsRel.Abs, stat.ModTime(), stat.ModTime()); err != nil {
+			return false, errors.Wrapf(err, "times %s:", destAbsRel.Rel)
+		}
 	}
 
 	// log
@@ -269,6 +516,210 @@ func copyFile(source absRel, dest destSpec, allowOverwrite bool, log loggee.Logg
 		log.Infof("copy %s => %s", source.Rel, destAbsRel.Rel)
 	}
 
+	return true, nil
+}
+
+// dryRunCopy logs the copy that would be made without changing the destination, returning true if it would copy.
+func dryRunCopy(source copySource, dest destSpec, opts copyOptions, log loggee.Logger) (bool, error) {
+	destPath := dest.Path
+	if dest.IsDir {
+		destPath = filepath.Join(dest.Path, source.Rel)
+	}
+
+	if _, err := os.Lstat(destPath); err == nil && (!opts.overwrite || destPath == source.Abs) {
+		return false, nil
+	}
+
+	if stat, err := os.Stat(source.Abs); source.link == "" && err == nil && isUnchanged(source.Abs, stat, destPath, opts.onlyNewer) {
+		return false, nil
+	}
+
+	log.Infof("would copy %s => %s", source.Rel, friendlyRelativePath(destPath))
+
+	return true, nil
+}
+
+// isUnchanged returns true if the destination matches the source using the onlyNewer comparison.
+func isUnchanged(src string, srcStat os.FileInfo, dest, onlyNewer string) bool {
+	if onlyNewer == "" {
+		return false
+	}
+
+	destStat, err := os.Stat(dest)
+	if err != nil || destStat.IsDir() || destStat.Size() != srcStat.Size() {
+		return false
+	}
+
+	if onlyNewer == OnlyNewerMTime {
+		return !destStat.ModTime().Before(srcStat.ModTime())
+	}
+
+	srcDigest, err := fileDigest(src, ChecksumSHA256)
+	if err != nil {
+		return false
+	}
+
+	destDigest, err := fileDigest(dest, ChecksumSHA256)
+
+	return err == nil && srcDigest == destDigest
+}
+
+// copyLink recreates a symbolic link in the destination.
+func copyLink(source copySource, dest destSpec, opts copyOptions, log loggee.Logger) (bool, error) {
+	destAbsRel, err := prepLinkDestination(source.absRel, dest)
+	if err != nil {
+		return false, err
+	}
+
+	if existing, err := os.Readlink(destAbsRel.Abs); err == nil && existing == source.link {
+		if log != nil {
+			log.Infof("skipping %s", source.Rel)
+		}
+		return false, nil
+	}
+
+	if _, err := os.Lstat(destAbsRel.Abs); err == nil {
+		if !opts.overwrite {
+			if log != nil {
+				log.Infof("skipping %s", source.Rel)
+			}
+			return false, nil
+		}
+
+		if err := os.Remove(destAbsRel.Abs); err != nil {
+			return false, errors.Wrapf(err, "dest %s:", destAbsRel.Rel)
+		}
+	}
+
+	if err := os.Symlink(source.link, destAbsRel.Abs); err != nil {
+		return false, errors.Wrapf(err, "link %s => %s:", source.Rel, destAbsRel.Rel)
+	}
+
+	if log != nil {
+		log.Infof("link %s => %s", source.Rel, destAbsRel.Rel)
+	}
+
+	return true, nil
+}
+
+func prepLinkDestination(source absRel, dest destSpec) (*absRel, error) {
+	finalPath := dest.Path
+	if dest.IsDir {
+		finalPath = filepath.Join(dest.Path, source.Rel)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(finalPath), 0777); err != nil {
+		return nil, errors.Wrapf(err, "dir %s:", filepath.Dir(finalPath))
+	}
+
+	return &absRel{Abs: finalPath, Rel: source.Rel}, nil
+}
+
+// checkSyncDestination prevents syncing into a source's base directory, a directory containing it or one within it,
+// as pruning would delete unrelated files.  The destination must be a directory if it exists.
+func checkSyncDestination(rawSpecs []string, dest destSpec) error {
+	if stat, err := os.Stat(dest.Path); err == nil && !stat.IsDir() {
+		return fmt.Errorf("sync destination %s must be a directory", dest.Path)
+	}
+
+	for _, rawSpec := range rawSpecs {
+		dir, err := getBaseDir(filepath.Clean(filepath.FromSlash(rawSpec)))
+		if err != nil {
+			return err
+		}
+
+		if isWithin(dest.Path, dir) || isWithin(dir, dest.Path) {
+			return fmt.Errorf("sync destination %s overlaps the source %s", dest.Path, rawSpec)
+		}
+	}
+
+	return nil
+}
+
+// isSyncExcluded returns true if the destination relative path is excluded from the sources.
+func isSyncExcluded(ps *patternSet, bases []string, rel string) bool {
+	for _, base := range bases {
+		if ps.Excluded(base, filepath.Join(base, rel)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pruneDestination deletes destination files that are not in the sources and are not excluded,
+// along with any directories left empty.
+func pruneDestination(ctx context.Context, sources []copySource, dest destSpec, opts copyOptions, summary *copySummary, log loggee.Logger) error {
+	bases, err := opts.patterns.Bases()
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(dest.Path); os.IsNotExist(err) && opts.dryRun {
+		// a dry run does not create the destination
+		return nil
+	}
+
+	keep := make(map[string]bool, len(sources))
+	for _, source := range sources {
+		keep[filepath.Clean(source.Rel)] = true
+	}
+
+	var stale []string
+	err = filepath.Walk(dest.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		rel, err := filepath.Rel(dest.Path, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		if isSyncExcluded(opts.patterns, bases, rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !info.IsDir() && !keep[rel] {
+			stale = append(stale, path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "walking %s", dest.Path)
+	}
+
+	for _, path := range stale {
+		if opts.dryRun {
+			summary.deleted++
+			log.Infof("would delete %s", friendlyRelativePath(path, dest.Path))
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			return errors.Wrapf(err, "deleting %s", path)
+		}
+
+		summary.deleted++
+		if log != nil {
+			log.Infof("delete %s", friendlyRelativePath(path, dest.Path))
+		}
+
+		// remove emptied parent directories, os.Remove fails if they are not empty
+		for dir := filepath.Dir(path); dir != dest.Path && isWithin(dest.Path, dir); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -20,7 +20,9 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/nehemming/cirocket/pkg/loggee"
 	"github.com/nehemming/cirocket/pkg/loggee/stdlog"
@@ -124,7 +126,7 @@ func TestGlobFileAbsRelDistinct(t *testing.T) {
 	fc := len(files)
 
 	// don't break for every add src file
-	if fc < 14 || fc > 20 {
+	if fc < 14 || fc > 40 {
 		t.Error("unexpected len", fc, files)
 		return
 	}
@@ -220,3 +222,293 @@ func validateCopyTest(t *testing.T, dir string) {
 		t.Errorf("File mismatch wanted %d got %d", len(src), len(dest))
 	}
 }
+
+func launchCopy(t *testing.T, dir string, copyCfg map[string]interface{}) {
+	if err := launchTasks(context.Background(), filepath.Join(dir, "mission.yml"), nil, newTask("copy", "stage files", copyCfg)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeCopyFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCopySync(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dist := filepath.Join(dir, "dist")
+
+	writeCopyFiles(t, src, map[string]string{"a.txt": "a", "sub/b.txt": "b", "tmp/skip.txt": "skip"})
+	writeCopyFiles(t, dist, map[string]string{"a.txt": "old", "stale/c.txt": "c", "keep/k.txt": "k"})
+
+	launchCopy(t, dir, map[string]interface{}{
+		"sources":       []interface{}{filepath.ToSlash(src) + "/**"},
+		"destination":   filepath.ToSlash(dist) + "/",
+		"mode":          "sync",
+		"exclude":       []interface{}{"tmp", filepath.ToSlash(src) + "/keep/**"},
+		"preserveTimes": true,
+		"log":           true,
+	})
+
+	if b, err := os.ReadFile(filepath.Join(dist, "a.txt")); err != nil || string(b) != "a" {
+		t.Error("a.txt not updated", string(b), err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dist, "sub", "b.txt")); err != nil {
+		t.Error("sub/b.txt not copied", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dist, "stale")); err == nil {
+		t.Error("stale files not pruned")
+	}
+
+	if _, err := os.Stat(filepath.Join(dist, "tmp")); err == nil {
+		t.Error("excluded files copied")
+	}
+
+	if _, err := os.Stat(filepath.Join(dist, "keep", "k.txt")); err != nil {
+		t.Error("excluded destination file deleted", err)
+	}
+
+	srcStat, _ := os.Stat(filepath.Join(src, "sub", "b.txt"))
+	destStat, err := os.Stat(filepath.Join(dist, "sub", "b.txt"))
+	if err != nil || !destStat.ModTime().Equal(srcStat.ModTime()) {
+		t.Error("times not preserved", err)
+	}
+}
+
+func TestCopyOnlyNewer(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dist := filepath.Join(dir, "dist")
+
+	writeCopyFiles(t, src, map[string]string{"same.txt": "same", "changed.txt": "new"})
+	writeCopyFiles(t, dist, map[string]string{"same.txt": "same", "changed.txt": "old"})
+
+	// make the sources newer so mtime would copy both
+	future := time.Now().Add(time.Hour)
+	for _, name := range []string{"same.txt", "changed.txt"} {
+		if err := os.Chtimes(filepath.Join(src, name), future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	destSame := filepath.Join(dist, "same.txt")
+	before, _ := os.Stat(destSame)
+
+	launchCopy(t, dir, map[string]interface{}{
+		"sources":     []interface{}{filepath.ToSlash(src) + "/*.txt"},
+		"destination": filepath.ToSlash(dist) + "/",
+		"onlyNewer":   "hash",
+	})
+
+	if b, err := os.ReadFile(filepath.Join(dist, "changed.txt")); err != nil || string(b) != "new" {
+		t.Error("changed.txt not updated", string(b), err)
+	}
+
+	if after, err := os.Stat(destSame); err != nil || !after.ModTime().Equal(before.ModTime()) {
+		t.Error("unchanged file copied", err)
+	}
+
+	if onlyNewer, err := getOnlyNewer("true"); err != nil || onlyNewer != OnlyNewerMTime {
+		t.Error("unexpected onlyNewer", onlyNewer, err)
+	}
+
+	if _, err := getOnlyNewer("size"); err == nil {
+		t.Error("expected onlyNewer error")
+	}
+}
+
+func TestCopyOnlyNewerNoOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dist := filepath.Join(dir, "dist")
+
+	writeCopyFiles(t, src, map[string]string{"changed.txt": "new", "added.txt": "added"})
+	writeCopyFiles(t, dist, map[string]string{"changed.txt": "old"})
+
+	launchCopy(t, dir, map[string]interface{}{
+		"sources":     []interface{}{filepath.ToSlash(src) + "/*.txt"},
+		"destination": filepath.ToSlash(dist) + "/",
+		"onlyNewer":   "hash",
+		"overwrite":   false,
+	})
+
+	if b, err := os.ReadFile(filepath.Join(dist, "changed.txt")); err != nil || string(b) != "old" {
+		t.Error("changed.txt overwritten", string(b), err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dist, "added.txt")); err != nil {
+		t.Error("added.txt not copied", err)
+	}
+}
+
+func TestCopySyncNewDestination(t *testing.T) {
+	dir := t.TempDir()
+	writeCopyFiles(t, dir, map[string]string{"src/a.txt": "a", "src/sub/b.txt": "b"})
+
+	launchCopy(t, dir, map[string]interface{}{
+		"sources":     []interface{}{filepath.ToSlash(dir) + "/src/**"},
+		"destination": filepath.ToSlash(dir) + "/dist",
+		"mode":        "sync",
+	})
+
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, "dist", filepath.FromSlash(name))); err != nil {
+			t.Error("not synced", name, err)
+		}
+	}
+
+	writeCopyFiles(t, dir, map[string]string{"file.txt": "file"})
+	if err := checkSyncDestination(nil, destSpec{Path: filepath.Join(dir, "file.txt")}); err == nil {
+		t.Error("expected file destination error")
+	}
+}
+
+func TestCopySymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on windows")
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeCopyFiles(t, src, map[string]string{"real/a.txt": "a"})
+
+	if err := os.Symlink("real", filepath.Join(src, "linked")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("real/a.txt", filepath.Join(src, "a.lnk")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		cfg      map[string]interface{}
+		expected map[string]string
+	}{
+		{
+			cfg:      map[string]interface{}{},
+			expected: map[string]string{"linked/a.txt": "file", "a.lnk": "file"},
+		},
+		{
+			cfg:      map[string]interface{}{"followSymlinks": "false"},
+			expected: map[string]string{"linked": "missing", "a.lnk": "missing"},
+		},
+		{
+			cfg:      map[string]interface{}{"preserveSymlinks": true},
+			expected: map[string]string{"linked": "link", "a.lnk": "link"},
+		},
+	}
+
+	for i, test := range tests {
+		dist := filepath.Join(dir, "dist", string(rune('a'+i)))
+		test.cfg["sources"] = []interface{}{filepath.ToSlash(src) + "/**"}
+		test.cfg["destination"] = filepath.ToSlash(dist) + "/"
+
+		launchCopy(t, dir, test.cfg)
+
+		for name, kind := range test.expected {
+			stat, err := os.Lstat(filepath.Join(dist, filepath.FromSlash(name)))
+			switch {
+			case kind == "missing" && err == nil:
+				t.Error(i, name, "should not be copied")
+			case kind == "file" && (err != nil || !stat.Mode().IsRegular()):
+				t.Error(i, name, "should be a file", err)
+			case kind == "link" && (err != nil || stat.Mode()&os.ModeSymlink == 0):
+				t.Error(i, name, "should be a link", err)
+			}
+		}
+	}
+}
+
+func TestCopySyncIntoSources(t *testing.T) {
+	dir := t.TempDir()
+	writeCopyFiles(t, dir, map[string]string{"src/a.txt": "a"})
+
+	if err := checkSyncDestination([]string{filepath.Join(dir, "src", "**")}, destSpec{Path: dir, IsDir: true}); err == nil {
+		t.Error("expected sync destination error")
+	}
+
+	if err := checkSyncDestination([]string{filepath.Join(dir, "src", "**")}, destSpec{Path: filepath.Join(dir, "src", "out"), IsDir: true}); err == nil {
+		t.Error("expected sync destination within the source error")
+	}
+
+	if err := checkSyncDestination([]string{filepath.Join(dir, "src", "**")}, destSpec{Path: filepath.Join(dir, "dist"), IsDir: true}); err != nil {
+		t.Error("unexpected error", err)
+	}
+}
+
+func TestCopySyncIntoSourceBase(t *testing.T) {
+	dir := t.TempDir()
+	writeCopyFiles(t, dir, map[string]string{"src/main.go": "package main", "src/README.md": "readme", "src/notes.txt": "notes"})
+
+	task := newTask("copy", "sync", map[string]interface{}{
+		"sources":     []interface{}{filepath.ToSlash(dir) + "/src/*.go"},
+		"destination": filepath.ToSlash(dir) + "/src/",
+		"mode":        "sync",
+	})
+
+	if err := launchTasks(context.Background(), filepath.Join(dir, "mission.yml"), nil, task); err == nil {
+		t.Error("expected sync into the source base to fail")
+	}
+
+	for _, name := range []string{"main.go", "README.md", "notes.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, "src", name)); err != nil {
+			t.Error("file removed", name, err)
+		}
+	}
+}
+
+func TestCopySyncDryRun(t *testing.T) {
+	dir := t.TempDir()
+	writeCopyFiles(t, dir, map[string]string{"src/a.txt": "a", "src/sub/b.txt": "b", "dest/stale.txt": "old"})
+
+	loggee.SetLogger(stdlog.New())
+
+	mc := rocket.NewMissionControl()
+	RegisterAll(mc)
+
+	mission := map[string]interface{}{
+		"stages": []interface{}{
+			map[string]interface{}{
+				"name": "copy",
+				"tasks": []interface{}{
+					map[string]interface{}{
+						"type":        "copy",
+						"name":        "sync files",
+						"mode":        "sync",
+						"sources":     []interface{}{filepath.ToSlash(dir) + "/src/**"},
+						"destination": filepath.ToSlash(dir) + "/dest/",
+					},
+				},
+			},
+		},
+	}
+
+	ctx := rocket.ContextWithDryRun(context.Background(), true)
+	if err := mc.LaunchMission(ctx, filepath.Join(dir, "mission.yml"), mission); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "dest", "stale.txt")); err != nil {
+		t.Error("dry run deleted file", err)
+	}
+
+	for _, name := range []string{"a.txt", "sub"} {
+		if _, err := os.Stat(filepath.Join(dir, "dest", name)); err == nil {
+			t.Error("dry run copied", name)
+		}
+	}
+
+	// the destination must be within the sandbox
+	if err := mc.LaunchMission(context.Background(), filepath.Join(dir, "src", "mission.yml"), mission); err == nil {
+		t.Error("expected sandbox error")
+	}
+}
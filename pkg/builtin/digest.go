@@ -0,0 +1,404 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builtin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5" //nolint:gosec
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/nehemming/cirocket/pkg/loggee"
+	"github.com/nehemming/cirocket/pkg/providers"
+	"github.com/nehemming/cirocket/pkg/rocket"
+	"github.com/pkg/errors"
+)
+
+// Checksum algorithms.
+const (
+	ChecksumSHA256 = "sha256"
+	ChecksumSHA512 = "sha512"
+	ChecksumMD5    = "md5"
+)
+
+const sumsResourceID = providers.ResourceID("sums")
+
+type (
+	// Checksum task is used to generate or verify file digests.
+	Checksum struct {
+		// Sources are glob patterns of the files to digest.  When verifying they limit the files checked
+		// and every matching file must have an expected digest.
+		Sources []string `mapstructure:"sources"`
+
+		// Algorithm is sha256, sha512 or md5.  The default is sha256, or when verifying
+		// the algorithm matching the length of the expected digests.
+		Algorithm string `mapstructure:"algorithm"`
+
+		// Output is the path of a SHA256SUMS style file written with the digests of the sources.
+		Output string `mapstructure:"output"`
+
+		// Variables maps variable names to files, the variables are set to the digests of the files.
+		Variables map[string]string `mapstructure:"variables"`
+
+		// Verify checks the files against the expected digests rather than generating digests.
+		Verify bool `mapstructure:"verify"`
+
+		// Sums is a SHA256SUMS style input containing the expected digests when verifying.
+		Sums *rocket.InputSpec `mapstructure:"sums"`
+
+		// Expected maps file paths to their expected digests when verifying.
+		Expected map[string]string `mapstructure:"expected"`
+
+		// Dir is the directory the file paths of the expected digests are relative to.
+		// If blank the working directory is used.
+		Dir string `mapstructure:"dir"`
+
+		Log bool `mapstructure:"log"`
+	}
+
+	checksumType struct{}
+)
+
+func (checksumType) Type() string {
+	return "checksum"
+}
+
+func (checksumType) Description() string {
+	return "generates or verifies the sha256, sha512 or md5 digests of files."
+}
+
+// Prepare loads the tasks configuration and returns the operation function or an error.
+func (checksumType) Prepare(ctx context.Context, capComm *rocket.CapComm, task rocket.Task) (rocket.ExecuteFunc, error) {
+	checksumCfg := &Checksum{}
+
+	if err := mapstructure.WeakDecode(task.Definition, checksumCfg); err != nil {
+		return nil, errors.Wrap(err, "parsing checksum type")
+	}
+
+	if checksumCfg.Algorithm != "" {
+		if _, err := newDigestHash(checksumCfg.Algorithm); err != nil {
+			return nil, err
+		}
+	}
+
+	if checksumCfg.Verify {
+		if checksumCfg.Sums == nil && len(checksumCfg.Expected) == 0 {
+			return nil, errors.New("verify needs sums or expected digests")
+		}
+	} else if len(checksumCfg.Sources) == 0 && len(checksumCfg.Variables) == 0 {
+		return nil, errors.New("no sources or variables specified")
+	}
+
+	fn := func(execCtx context.Context) error {
+		log := getLogFromCapComm(capComm, checksumCfg.Log)
+
+		if checksumCfg.Verify {
+			return verifyChecksums(execCtx, capComm, checksumCfg, log)
+		}
+
+		return generateChecksums(execCtx, capComm, checksumCfg, log)
+	}
+
+	return fn, nil
+}
+
+func newDigestHash(algorithm string) (hash.Hash, error) {
+	switch strings.ToLower(algorithm) {
+	case "", ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumSHA512:
+		return sha512.New(), nil
+	case ChecksumMD5:
+		return md5.New(), nil //nolint:gosec
+	}
+
+	return nil, fmt.Errorf("unknown checksum algorithm %s, use sha256, sha512 or md5", algorithm)
+}
+
+// algorithmForDigest returns the algorithm producing hex digests of the length of the digest.
+func algorithmForDigest(digest string) (string, error) {
+	switch len(digest) {
+	case 2 * sha256.Size:
+		return ChecksumSHA256, nil
+	case 2 * sha512.Size:
+		return ChecksumSHA512, nil
+	case 2 * md5.Size:
+		return ChecksumMD5, nil
+	}
+
+	return "", fmt.Errorf("digest %s is not a sha256, sha512 or md5 digest", digest)
+}
+
+// fileDigest returns the hex digest of the file.
+func fileDigest(file, algorithm string) (string, error) {
+	h, err := newDigestHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errors.Wrapf(err, "reading %s", file)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func globChecksumSources(ctx context.Context, capComm *rocket.CapComm, sources []string) ([]absRel, error) {
+	rawSpecs := make([]string, 0, len(sources))
+	for index, s := range sources {
+		rawSpec, err := capComm.ExpandString(ctx, "source", s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "expanding source %d", index)
+		}
+		rawSpecs = append(rawSpecs, rawSpec)
+	}
+
+	files, err := globFileAbsRel(rawSpecs...)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Rel < files[j].Rel })
+
+	return files, nil
+}
+
+func generateChecksums(ctx context.Context, capComm *rocket.CapComm, checksumCfg *Checksum, log loggee.Logger) error {
+	algorithm := strings.ToLower(checksumCfg.Algorithm)
+
+	output, err := capComm.ExpandString(ctx, "output", checksumCfg.Output)
+	if err != nil {
+		return errors.Wrap(err, "expanding output")
+	}
+
+	if output != "" {
+		if output, err = filepath.Abs(filepath.FromSlash(output)); err != nil {
+			return err
+		}
+	}
+
+	files, err := globChecksumSources(ctx, capComm, checksumCfg.Sources)
+	if err != nil {
+		return err
+	}
+
+	var sums bytes.Buffer
+	for _, file := range files {
+		// the sums file never includes itself
+		if file.Abs == output {
+			continue
+		}
+
+		digest, err := fileDigest(file.Abs, algorithm)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(&sums, "%s  %s\n", digest, filepath.ToSlash(file.Rel))
+		if log != nil {
+			log.Infof("%s %s", digest, file.Rel)
+		}
+	}
+
+	if output != "" {
+		if err := os.MkdirAll(filepath.Dir(output), 0777); err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(output, sums.Bytes(), 0666); err != nil {
+			return errors.Wrapf(err, "writing %s", output)
+		}
+	}
+
+	for name, file := range checksumCfg.Variables {
+		path, err := capComm.ExpandString(ctx, name, file)
+		if err != nil {
+			return errors.Wrapf(err, "expanding variable %s", name)
+		}
+
+		digest, err := fileDigest(filepath.FromSlash(path), algorithm)
+		if err != nil {
+			return err
+		}
+
+		capComm.SetLocalVariable(name, digest)
+	}
+
+	return nil
+}
+
+// parseSums reads the digests of a SHA256SUMS style file, lines hold a digest followed by the file name.
+// A '*' before the file name, marking binary mode, is ignored.
+func parseSums(r io.Reader) (map[string]string, error) {
+	sums := make(map[string]string)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid sums line %s", line)
+		}
+
+		name := strings.TrimPrefix(strings.TrimLeft(fields[1], " "), "*")
+		sums[filepath.ToSlash(filepath.Clean(filepath.FromSlash(name)))] = strings.ToLower(fields[0])
+	}
+
+	return sums, scanner.Err()
+}
+
+func getExpectedSums(ctx context.Context, capComm *rocket.CapComm, checksumCfg *Checksum) (map[string]string, error) {
+	expected := make(map[string]string)
+
+	if checksumCfg.Sums != nil {
+		if err := capComm.AttachInputSpec(ctx, sumsResourceID, *checksumCfg.Sums); err != nil {
+			return nil, errors.Wrap(err, "sums")
+		}
+
+		r, err := capComm.GetResource(sumsResourceID).OpenRead(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "sums")
+		}
+		defer r.Close()
+
+		expected, err = parseSums(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "sums")
+		}
+	}
+
+	for file, digest := range checksumCfg.Expected {
+		path, err := capComm.ExpandString(ctx, "expected", file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "expanding expected %s", file)
+		}
+
+		d, err := capComm.ExpandString(ctx, "expected", digest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "expanding expected digest of %s", file)
+		}
+
+		expected[filepath.ToSlash(filepath.Clean(filepath.FromSlash(path)))] = strings.ToLower(strings.TrimSpace(d))
+	}
+
+	return expected, nil
+}
+
+func verifyChecksums(ctx context.Context, capComm *rocket.CapComm, checksumCfg *Checksum, log loggee.Logger) error {
+	expected, err := getExpectedSums(ctx, capComm, checksumCfg)
+	if err != nil {
+		return err
+	}
+
+	dir, err := capComm.ExpandString(ctx, "dir", checksumCfg.Dir)
+	if err != nil {
+		return errors.Wrap(err, "expanding dir")
+	}
+
+	dir, err = filepath.Abs(filepath.FromSlash(dir))
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(expected))
+	if len(checksumCfg.Sources) > 0 {
+		files, err := globChecksumSources(ctx, capComm, checksumCfg.Sources)
+		if err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			rel, err := filepath.Rel(dir, file.Abs)
+			if err != nil {
+				return err
+			}
+
+			name := filepath.ToSlash(rel)
+			if _, ok := expected[name]; !ok {
+				return fmt.Errorf("no expected digest for %s", name)
+			}
+			names = append(names, name)
+		}
+	} else {
+		for name := range expected {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	var failed []string
+	for _, name := range names {
+		want := expected[name]
+
+		algorithm := checksumCfg.Algorithm
+		if algorithm == "" {
+			if algorithm, err = algorithmForDigest(want); err != nil {
+				return errors.Wrap(err, name)
+			}
+		}
+
+		file := filepath.FromSlash(name)
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+
+		got, err := fileDigest(file, algorithm)
+		if err != nil {
+			return err
+		}
+
+		if got != want {
+			failed = append(failed, name)
+			if log != nil {
+				log.Errorf("%s: FAILED", name)
+			}
+		} else if log != nil {
+			log.Infof("%s: OK", name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("checksum mismatch: %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+func init() {
+	rocket.Default().RegisterTaskTypes(checksumType{})
+}
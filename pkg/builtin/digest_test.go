@@ -0,0 +1,194 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builtin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nehemming/cirocket/pkg/rocket"
+)
+
+const (
+	sha256OfA = "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb"
+	md5OfA    = "0cc175b9c0f1b6a831c399e269772661"
+)
+
+func TestChecksumType(t *testing.T) {
+	var ct checksumType
+
+	if ct.Type() != "checksum" {
+		t.Error("Wrong checksum type", ct.Type())
+	}
+
+	if ct.Description() == "" {
+		t.Error("needs description", ct.Type())
+	}
+}
+
+func launchChecksums(dir string, tasks ...interface{}) error {
+	for i, task := range tasks {
+		m := task.(map[string]interface{})
+		m["type"] = "checksum"
+		if _, ok := m["name"]; !ok {
+			m["name"] = "sum" + string(rune('a'+i))
+		}
+	}
+
+	return launchTasks(context.Background(), filepath.Join(dir, "mission.yml"), nil, tasks...)
+}
+
+func writeChecksumFiles(t *testing.T) string {
+	dir := t.TempDir()
+	dist := filepath.Join(dir, "dist")
+	if err := os.MkdirAll(dist, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string]string{"a.txt": "a", "b.txt": "b"} {
+		if err := os.WriteFile(filepath.Join(dist, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestChecksumGenerateAndVerify(t *testing.T) {
+	dir := writeChecksumFiles(t)
+	dist := filepath.Join(dir, "dist")
+	sums := filepath.Join(dist, "SHA256SUMS")
+
+	generate := map[string]interface{}{
+		"sources": []interface{}{filepath.ToSlash(filepath.Join(dist, "*"))},
+		"output":  sums,
+	}
+
+	// run twice, the second run must not digest the sums file
+	for i := 0; i < 2; i++ {
+		if err := launchChecksums(dir, generate); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := os.ReadFile(sums)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 || lines[0] != sha256OfA+"  a.txt" || !strings.HasSuffix(lines[1], "  b.txt") {
+		t.Error("unexpected sums", lines)
+	}
+
+	verify := map[string]interface{}{
+		"verify": true,
+		"sums":   map[string]interface{}{"path": sums},
+		"dir":    dist,
+	}
+
+	if err := launchChecksums(dir, verify); err != nil {
+		t.Error("verify", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dist, "b.txt"), []byte("changed"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := launchChecksums(dir, verify); err == nil || !strings.Contains(err.Error(), "checksum mismatch: b.txt") {
+		t.Error("expected mismatch", err)
+	}
+}
+
+func TestChecksumVerifySources(t *testing.T) {
+	dir := writeChecksumFiles(t)
+	dist := filepath.Join(dir, "dist")
+
+	err := launchChecksums(dir, map[string]interface{}{
+		"verify":   true,
+		"sources":  []interface{}{filepath.ToSlash(filepath.Join(dist, "a.*"))},
+		"expected": map[string]interface{}{"dist/a.txt": strings.ToUpper(md5OfA)},
+		"dir":      dir,
+	})
+	if err != nil {
+		t.Error("verify", err)
+	}
+
+	err = launchChecksums(dir, map[string]interface{}{
+		"verify":   true,
+		"sources":  []interface{}{filepath.ToSlash(filepath.Join(dist, "*"))},
+		"expected": map[string]interface{}{"dist/a.txt": md5OfA},
+		"dir":      dir,
+	})
+	if err == nil || !strings.HasSuffix(err.Error(), "no expected digest for dist/b.txt") {
+		t.Error("expected missing digest", err)
+	}
+}
+
+func TestChecksumVariables(t *testing.T) {
+	dir := writeChecksumFiles(t)
+	file := filepath.Join(dir, "dist", "a.txt")
+
+	err := launchChecksums(dir,
+		map[string]interface{}{
+			"algorithm": "sha512",
+			"variables": map[string]interface{}{"digest": file},
+			"export":    []interface{}{"digest"},
+		},
+		map[string]interface{}{
+			"verify":   true,
+			"expected": map[string]interface{}{file: "{{.Var.digest}}"},
+		},
+	)
+	if err != nil {
+		t.Error("variables", err)
+	}
+}
+
+func TestChecksumPrepareErrors(t *testing.T) {
+	var ct checksumType
+
+	tests := []map[string]interface{}{
+		{},
+		{"verify": true},
+		{"sources": []interface{}{"*"}, "algorithm": "sha1"},
+	}
+
+	for i, definition := range tests {
+		if _, err := ct.Prepare(context.Background(), nil, rocket.Task{Definition: definition}); err == nil {
+			t.Error(i, "expected error")
+		}
+	}
+}
+
+func TestParseSums(t *testing.T) {
+	sums, err := parseSums(strings.NewReader("# comment\n\nABC  dir/a.txt\ndef *b.bin\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sums) != 2 || sums["dir/a.txt"] != "abc" || sums["b.bin"] != "def" {
+		t.Error("unexpected sums", sums)
+	}
+
+	if _, err := parseSums(strings.NewReader("nodigest\n")); err == nil {
+		t.Error("expected error")
+	}
+}
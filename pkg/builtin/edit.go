@@ -0,0 +1,413 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builtin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/nehemming/cirocket/pkg/rocket"
+	"github.com/pkg/errors"
+)
+
+type (
+	// Edit applies its operations, in order, to each of the files matching the file glob specs.
+	Edit struct {
+		Files []string `mapstructure:"files"`
+
+		// Exclude are glob patterns of files that are not edited.
+		Exclude []string `mapstructure:"exclude"`
+
+		// IgnoreFiles are .gitignore style files listing further files to exclude.
+		IgnoreFiles []string `mapstructure:"ignoreFiles"`
+
+		Operations []EditOperation `mapstructure:"operations"`
+
+		Log bool `mapstructure:"log"`
+	}
+
+	// EditOperation is a single edit.  Replace replaces the matches of a regular expression with the With text,
+	// which can refer to capture groups as $1 or ${name}.  Insert adds lines Before or After each line matching
+	// a regular expression and Delete removes the lines matching a regular expression.  Ensure appends a line
+	// if it is not already present, or inserts it Before or After the first matching line.
+	// If Required is set the task fails when a file does not match the operation's regular expression.
+	EditOperation struct {
+		Replace  string   `mapstructure:"replace"`
+		With     string   `mapstructure:"with"`
+		Insert   []string `mapstructure:"insert"`
+		Before   string   `mapstructure:"before"`
+		After    string   `mapstructure:"after"`
+		Delete   string   `mapstructure:"delete"`
+		Ensure   string   `mapstructure:"ensure"`
+		Required bool     `mapstructure:"required"`
+	}
+
+	// editOp is an operation with its values expanded and its regular expression compiled.
+	// Lines are inserted, or ensured, after matching lines unless before is set.
+	editOp struct {
+		kind     string
+		re       *regexp.Regexp
+		with     string
+		lines    []string
+		before   bool
+		required bool
+	}
+
+	// fileEdit is the edited content of a file, written once all the files have been edited.
+	fileEdit struct {
+		path    string
+		content []byte
+		mode    os.FileMode
+	}
+
+	editType struct{}
+)
+
+// Edit operation kinds.
+const (
+	editReplace = "replace"
+	editInsert  = "insert"
+	editDelete  = "delete"
+	editEnsure  = "ensure"
+)
+
+func (editType) Type() string {
+	return "edit"
+}
+
+func (editType) Description() string {
+	return "edits files in place, replacing regular expression matches and inserting, deleting or ensuring lines."
+}
+
+// Prepare loads the tasks configuration and returns the operation function or an error.
+func (editType) Prepare(ctx context.Context, capComm *rocket.CapComm, task rocket.Task) (rocket.ExecuteFunc, error) {
+	editCfg := &Edit{}
+
+	if err := mapstructure.WeakDecode(task.Definition, editCfg); err != nil {
+		return nil, errors.Wrap(err, "parsing edit type")
+	}
+
+	if len(editCfg.Operations) == 0 {
+		return nil, errors.New("no edit operations specified")
+	}
+
+	for index, op := range editCfg.Operations {
+		if _, err := op.kind(); err != nil {
+			return nil, errors.Wrapf(err, "operation %d", index)
+		}
+	}
+
+	fn := func(execCtx context.Context) error {
+		ops := make([]*editOp, 0, len(editCfg.Operations))
+		for index, op := range editCfg.Operations {
+			eop, err := op.expand(execCtx, capComm)
+			if err != nil {
+				return errors.Wrapf(err, "operation %d", index)
+			}
+			ops = append(ops, eop)
+		}
+
+		// Expand files
+		specs := make([]string, 0, len(editCfg.Files))
+		for index, f := range editCfg.Files {
+			fileSpec, err := capComm.ExpandString(execCtx, "file", f)
+			if err != nil {
+				return errors.Wrapf(err, "expanding file %d", index)
+			}
+			specs = append(specs, fileSpec)
+		}
+
+		ps, err := newPatternSetFromConfig(execCtx, capComm, specs, editCfg.Exclude, editCfg.IgnoreFiles)
+		if err != nil {
+			return err
+		}
+
+		matches, _, err := ps.Glob()
+		if err != nil {
+			return err
+		}
+
+		files := make([]string, 0, len(matches))
+		for _, m := range matches {
+			if stat, err := os.Stat(m.path); err == nil && !stat.IsDir() {
+				if err := capComm.CheckSandbox(m.path); err != nil {
+					return err
+				}
+				files = append(files, m.path)
+			}
+		}
+
+		// Edit all the files before writing any, so a failing required operation leaves them unchanged
+		edits := make([]*fileEdit, 0, len(files))
+		for _, file := range files {
+			edit, err := editFile(file, ops)
+			if err != nil {
+				return err
+			}
+
+			if edit != nil {
+				edits = append(edits, edit)
+			}
+		}
+
+		dryRun := rocket.IsDryRun(execCtx)
+		for _, edit := range edits {
+			if dryRun {
+				capComm.Log().Infof("would edit %s", friendlyRelativePath(edit.path))
+				continue
+			}
+
+			if err := os.WriteFile(edit.path, edit.content, edit.mode); err != nil {
+				return errors.Wrapf(err, "writing %s", edit.path)
+			}
+
+			if editCfg.Log {
+				capComm.Log().Infof("edited %s", friendlyRelativePath(edit.path))
+			}
+		}
+
+		capComm.Log().Infof("edited %d of %d files", len(edits), len(files))
+
+		return nil
+	}
+
+	return fn, nil
+}
+
+// kind returns the kind of the operation or an error if its settings conflict.
+func (op EditOperation) kind() (string, error) {
+	var kinds []string
+	if op.Replace != "" {
+		kinds = append(kinds, editReplace)
+	}
+	if op.Delete != "" {
+		kinds = append(kinds, editDelete)
+	}
+	if op.Ensure != "" {
+		kinds = append(kinds, editEnsure)
+	}
+	if len(op.Insert) > 0 {
+		kinds = append(kinds, editInsert)
+	}
+
+	switch {
+	case len(kinds) == 0:
+		return "", errors.New("specify one of replace, insert, delete or ensure")
+	case len(kinds) > 1:
+		return "", fmt.Errorf("conflicting %s operations", strings.Join(kinds, " and "))
+	case op.Before != "" && op.After != "":
+		return "", errors.New("before and after cannot be used together")
+	case kinds[0] == editInsert && op.Before == "" && op.After == "":
+		return "", errors.New("insert needs a before or after pattern")
+	case (op.Before != "" || op.After != "") && kinds[0] != editInsert && kinds[0] != editEnsure:
+		return "", errors.New("before and after are only used with insert or ensure")
+	case op.With != "" && kinds[0] != editReplace:
+		return "", errors.New("with is only used with replace")
+	}
+
+	return kinds[0], nil
+}
+
+// expand expands the operation's values and compiles its regular expression.
+func (op EditOperation) expand(ctx context.Context, capComm *rocket.CapComm) (*editOp, error) {
+	kind, err := op.kind()
+	if err != nil {
+		return nil, err
+	}
+
+	eop := &editOp{kind: kind, before: op.Before != "", required: op.Required}
+
+	var pattern string
+	switch {
+	case kind == editReplace:
+		pattern = op.Replace
+		if eop.with, err = capComm.ExpandString(ctx, "with", op.With); err != nil {
+			return nil, errors.Wrap(err, "expanding with")
+		}
+	case kind == editDelete:
+		pattern = op.Delete
+	case op.Before != "":
+		pattern = op.Before
+	default:
+		pattern = op.After
+	}
+
+	lines := op.Insert
+	if kind == editEnsure {
+		lines = []string{op.Ensure}
+	}
+
+	for index, line := range lines {
+		expanded, err := capComm.ExpandString(ctx, "insert", line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "expanding line %d", index)
+		}
+		eop.lines = append(eop.lines, expanded)
+	}
+
+	if pattern != "" {
+		if pattern, err = capComm.ExpandString(ctx, "pattern", pattern); err != nil {
+			return nil, errors.Wrap(err, "expanding pattern")
+		}
+
+		if eop.re, err = regexp.Compile(pattern); err != nil {
+			return nil, errors.Wrapf(err, "pattern %s", pattern)
+		}
+	}
+
+	return eop, nil
+}
+
+// editFile applies the operations to a file, returning the edit or nil if the file is unchanged.
+func editFile(path string, ops []*editOp) (*fileEdit, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "stat %s", path)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", path)
+	}
+
+	content := string(b)
+	edited, err := applyEditOps(content, ops)
+	if err != nil {
+		return nil, errors.Wrapf(err, "editing %s", friendlyRelativePath(path))
+	}
+
+	if edited == content {
+		return nil, nil
+	}
+
+	return &fileEdit{path: path, content: []byte(edited), mode: stat.Mode().Perm()}, nil
+}
+
+// applyEditOps applies the operations to the content in order.  Line endings, and the presence of a final
+// line ending, are preserved.
+func applyEditOps(content string, ops []*editOp) (string, error) {
+	for index, op := range ops {
+		var matched bool
+		if op.kind == editReplace {
+			matched = op.re.MatchString(content)
+			content = op.re.ReplaceAllString(content, op.with)
+		} else {
+			content, matched = editLines(content, op)
+		}
+
+		if op.required && !matched {
+			return "", fmt.Errorf("operation %d: %s does not match", index, op.re)
+		}
+	}
+
+	return content, nil
+}
+
+// editLines applies a line based operation, returning the edited content and true if the operation's
+// regular expression matched a line.
+func editLines(content string, op *editOp) (string, bool) {
+	eol := "\n"
+	if strings.Contains(content, "\r\n") {
+		eol = "\r\n"
+	}
+
+	finalEOL := strings.HasSuffix(content, eol)
+	var lines []string
+	if content != "" {
+		lines = strings.Split(strings.TrimSuffix(content, eol), eol)
+	}
+
+	matched := false
+	edited := make([]string, 0, len(lines)+len(op.lines))
+
+	switch op.kind {
+	case editDelete:
+		for _, line := range lines {
+			if op.re.MatchString(line) {
+				matched = true
+				continue
+			}
+			edited = append(edited, line)
+		}
+	case editInsert:
+		for _, line := range lines {
+			isMatch := op.re.MatchString(line)
+			matched = matched || isMatch
+			if isMatch && op.before {
+				edited = append(edited, op.lines...)
+			}
+			edited = append(edited, line)
+			if isMatch && !op.before {
+				edited = append(edited, op.lines...)
+			}
+		}
+	default:
+		edited, matched = ensureLine(lines, op)
+	}
+
+	if len(edited) == 0 {
+		return "", matched
+	}
+
+	result := strings.Join(edited, eol)
+	if finalEOL || len(lines) == 0 {
+		result += eol
+	}
+
+	return result, matched
+}
+
+// ensureLine adds the operation's line if no line equals it, after the last line or before or after the first
+// line matching the operation's regular expression.  If the expression does not match the line is appended.
+func ensureLine(lines []string, op *editOp) ([]string, bool) {
+	matched := op.re == nil
+	for _, line := range lines {
+		if op.re != nil && op.re.MatchString(line) {
+			matched = true
+		}
+		if line == op.lines[0] {
+			return lines, matched
+		}
+	}
+
+	if op.re != nil {
+		for i, line := range lines {
+			if !op.re.MatchString(line) {
+				continue
+			}
+
+			if !op.before {
+				i++
+			}
+
+			edited := make([]string, 0, len(lines)+1)
+			edited = append(edited, lines[:i]...)
+			edited = append(edited, op.lines[0])
+			return append(edited, lines[i:]...), true
+		}
+	}
+
+	return append(lines, op.lines[0]), matched
+}
+
+func init() {
+	rocket.Default().RegisterTaskTypes(editType{})
+}
@@ -0,0 +1,169 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builtin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/nehemming/cirocket/pkg/loggee"
+	"github.com/nehemming/cirocket/pkg/loggee/stdlog"
+	"github.com/nehemming/cirocket/pkg/rocket"
+)
+
+func TestEditType(t *testing.T) {
+	var et editType
+
+	if et.Type() != "edit" {
+		t.Error("Wrong edit type", et.Type())
+	}
+
+	if et.Description() == "" {
+		t.Error("needs description", et.Type())
+	}
+}
+
+func TestEditOperationKind(t *testing.T) {
+	valid := []EditOperation{
+		{Replace: "a", With: "b"},
+		{Insert: []string{"x"}, After: "a"},
+		{Delete: "a"},
+		{Ensure: "x"},
+		{Ensure: "x", Before: "a"},
+	}
+	for _, op := range valid {
+		if _, err := op.kind(); err != nil {
+			t.Error("unexpected", op, err)
+		}
+	}
+
+	invalid := []EditOperation{
+		{},
+		{Replace: "a", Delete: "b"},
+		{Insert: []string{"x"}},
+		{Insert: []string{"x"}, Before: "a", After: "b"},
+		{Delete: "a", After: "b"},
+		{Ensure: "x", With: "y"},
+	}
+	for _, op := range invalid {
+		if _, err := op.kind(); err == nil {
+			t.Error("expected error", op)
+		}
+	}
+}
+
+func TestApplyEditOps(t *testing.T) {
+	tests := []struct {
+		content  string
+		op       *editOp
+		expected string
+	}{
+		{"version = \"1.0\"\n", &editOp{kind: editReplace, re: regexp.MustCompile(`version = "(\d+)\.\d+"`), with: `version = "$1.1"`}, "version = \"1.1\"\n"},
+		{"a\r\nb\r\n", &editOp{kind: editInsert, re: regexp.MustCompile(`^a$`), lines: []string{"x", "y"}}, "a\r\nx\r\ny\r\nb\r\n"},
+		{"a\nb", &editOp{kind: editInsert, re: regexp.MustCompile(`^b$`), lines: []string{"x"}, before: true}, "a\nx\nb"},
+		{"a\n// TODO\nb\n", &editOp{kind: editDelete, re: regexp.MustCompile(`TODO`)}, "a\nb\n"},
+		{"a\n", &editOp{kind: editDelete, re: regexp.MustCompile(`a`)}, ""},
+		{"a\n", &editOp{kind: editEnsure, lines: []string{"b"}}, "a\nb\n"},
+		{"a\nb\n", &editOp{kind: editEnsure, lines: []string{"b"}}, "a\nb\n"},
+		{"", &editOp{kind: editEnsure, lines: []string{"b"}}, "b\n"},
+		{"[a]\n[b]\n", &editOp{kind: editEnsure, re: regexp.MustCompile(`^\[a\]`), lines: []string{"k=v"}}, "[a]\nk=v\n[b]\n"},
+		{"[a]\n", &editOp{kind: editEnsure, re: regexp.MustCompile(`^\[z\]`), lines: []string{"k=v"}}, "[a]\nk=v\n"},
+	}
+
+	for index, test := range tests {
+		edited, err := applyEditOps(test.content, []*editOp{test.op})
+		if err != nil {
+			t.Error(index, err)
+		} else if edited != test.expected {
+			t.Errorf("%d: got %q expected %q", index, edited, test.expected)
+		}
+	}
+
+	required := &editOp{kind: editDelete, re: regexp.MustCompile(`missing`), required: true}
+	if _, err := applyEditOps("a\n", []*editOp{required}); err == nil {
+		t.Error("expected required error")
+	}
+}
+
+func TestEditRun(t *testing.T) {
+	dir := t.TempDir()
+	writeCopyFiles(t, dir, map[string]string{
+		"src/a.go":     "package a\n\nconst Version = \"0.1.0\"\n",
+		"src/b.go":     "package b\n",
+		"src/skip.txt": "const Version = \"0.1.0\"\n",
+	})
+
+	loggee.SetLogger(stdlog.New())
+
+	mc := rocket.NewMissionControl()
+	RegisterAll(mc)
+
+	edit := map[string]interface{}{
+		"type":  "edit",
+		"name":  "edit files",
+		"log":   true,
+		"files": []interface{}{filepath.ToSlash(dir) + "/src/*.go"},
+		"operations": []interface{}{
+			map[string]interface{}{"replace": `Version = "[^"]*"`, "with": `Version = "{{ .version }}"`},
+			map[string]interface{}{"insert": []interface{}{"", "// edited"}, "after": "^package "},
+		},
+	}
+
+	mission := map[string]interface{}{
+		"params": []interface{}{map[string]interface{}{"name": "version", "value": "1.2.3"}},
+		"stages": []interface{}{
+			map[string]interface{}{
+				"name":  "edit",
+				"tasks": []interface{}{edit},
+			},
+		},
+	}
+
+	missionFile := filepath.Join(dir, "mission.yml")
+	if err := mc.LaunchMission(context.Background(), missionFile, mission); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"src/a.go":     "package a\n\n// edited\n\nconst Version = \"1.2.3\"\n",
+		"src/b.go":     "package b\n\n// edited\n",
+		"src/skip.txt": "const Version = \"0.1.0\"\n",
+	}
+	for name, content := range expected {
+		if b, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(b) != content {
+			t.Errorf("%s: got %q %v", name, string(b), err)
+		}
+	}
+
+	// a required operation that does not match fails the task
+	edit["operations"] = []interface{}{map[string]interface{}{"delete": "^import", "required": true}}
+	if err := mc.LaunchMission(context.Background(), missionFile, mission); err == nil {
+		t.Error("expected required error")
+	}
+
+	// no file is written if a required operation fails to match any one of them
+	edit["operations"] = []interface{}{map[string]interface{}{"replace": "1.2.3", "with": "2.0.0", "required": true}}
+	if err := mc.LaunchMission(context.Background(), missionFile, mission); err == nil {
+		t.Error("expected required error for b.go")
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "src/a.go")); string(b) != expected["src/a.go"] {
+		t.Errorf("a.go was written %q", string(b))
+	}
+}
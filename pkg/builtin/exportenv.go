@@ -0,0 +1,75 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builtin
+
+import (
+	"context"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/nehemming/cirocket/pkg/rocket"
+	"github.com/pkg/errors"
+)
+
+type (
+	// ExportEnv writes variables and params to a file read by later CI pipeline steps.
+	ExportEnv struct {
+		rocket.CIExport `mapstructure:",squash"`
+
+		Log bool `mapstructure:"log"`
+	}
+
+	exportEnvType struct{}
+)
+
+func (exportEnvType) Type() string {
+	return "exportEnv"
+}
+
+func (exportEnvType) Description() string {
+	return "writes variables to GitHub Actions env or output files, GitLab dotenv reports or KEY=VALUE files."
+}
+
+// Prepare loads the tasks configuration and returns the operation function or an error.
+func (exportEnvType) Prepare(ctx context.Context, capComm *rocket.CapComm, task rocket.Task) (rocket.ExecuteFunc, error) {
+	exportCfg := &ExportEnv{}
+
+	if err := mapstructure.WeakDecode(task.Definition, exportCfg); err != nil {
+		return nil, errors.Wrap(err, "parsing exportEnv type")
+	}
+
+	if err := exportCfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	fn := func(execCtx context.Context) error {
+		if err := capComm.ExportToCI(execCtx, exportCfg.CIExport); err != nil {
+			return err
+		}
+
+		if exportCfg.Log && !rocket.IsDryRun(execCtx) {
+			capComm.Log().Infof("exported %d variables", len(exportCfg.Variables))
+		}
+
+		return nil
+	}
+
+	return fn, nil
+}
+
+func init() {
+	rocket.Default().RegisterTaskTypes(exportEnvType{})
+}
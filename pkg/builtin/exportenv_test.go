@@ -0,0 +1,94 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builtin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nehemming/cirocket/pkg/loggee"
+	"github.com/nehemming/cirocket/pkg/loggee/stdlog"
+	"github.com/nehemming/cirocket/pkg/rocket"
+)
+
+func TestExportEnvType(t *testing.T) {
+	var et exportEnvType
+
+	if et.Type() != "exportEnv" {
+		t.Error("Wrong exportEnv type", et.Type())
+	}
+
+	if et.Description() == "" {
+		t.Error("needs description", et.Type())
+	}
+}
+
+func TestExportEnvRun(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "github_output")
+
+	loggee.SetLogger(stdlog.New())
+
+	mc := rocket.NewMissionControl()
+	RegisterAll(mc)
+
+	mission := map[string]interface{}{
+		"basicEnv": map[string]interface{}{"GITHUB_OUTPUT": output},
+		"params": []interface{}{
+			map[string]interface{}{"name": "image-tag", "value": "app:1.0.0"},
+			map[string]interface{}{"name": "CHANNEL", "value": "beta"},
+		},
+		"stages": []interface{}{
+			map[string]interface{}{
+				"name": "export",
+				"tasks": []interface{}{
+					map[string]interface{}{
+						"type":      "exportEnv",
+						"name":      "outputs",
+						"format":    "github-output",
+						"variables": []interface{}{"image-tag", "notes"},
+						"prevars":   map[string]interface{}{"notes": "first\nsecond"},
+						"log":       true,
+					},
+					map[string]interface{}{
+						"type":      "exportEnv",
+						"name":      "gitlab",
+						"format":    "gitlab",
+						"path":      filepath.ToSlash(dir) + "/build.env",
+						"variables": []interface{}{"CHANNEL"},
+					},
+				},
+			},
+		},
+	}
+
+	if err := mc.LaunchMission(context.Background(), filepath.Join(dir, "mission.yml"), mission); err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := os.ReadFile(output)
+	if !strings.HasPrefix(string(b), "image-tag=app:1.0.0\nnotes<<") || !strings.Contains(string(b), "\nfirst\nsecond\n") {
+		t.Errorf("unexpected output %q", string(b))
+	}
+
+	if b, _ := os.ReadFile(filepath.Join(dir, "build.env")); string(b) != "CHANNEL=beta\n" {
+		t.Errorf("unexpected dotenv %q", string(b))
+	}
+}
@@ -18,35 +18,72 @@ package builtin
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
 	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
+	"github.com/nehemming/cirocket/pkg/loggee"
+	"github.com/nehemming/cirocket/pkg/resource"
 	"github.com/nehemming/cirocket/pkg/rocket"
 	"github.com/pkg/errors"
 )
 
+const defaultFetchConcurrency = 4
+
+// fetchProgressInterval is the interval between progress log messages of a download.
+var fetchProgressInterval = 5 * time.Second
+
 type (
 	// Fetch task is used get one or more web resources.
 	Fetch struct {
 		Resources []FetchResource `mapstructure:"resources"`
-		Log       bool            `mapstructure:"log"`
+
+		// Concurrency is the maximum number of resources fetched at the same time, defaults to 4.
+		Concurrency uint `mapstructure:"concurrency"`
+
+		// Retries is the number of times a failed fetch is retried.
+		Retries uint `mapstructure:"retries"`
+
+		// RetryDelay is the seconds waited before the first retry, doubling for each further retry.  Defaults to 1.
+		RetryDelay uint `mapstructure:"retryDelay"`
+
+		Log bool `mapstructure:"log"`
 	}
 
 	// FetchResource defines the input source and output target runbooks for a fetch request.
 	FetchResource struct {
 		Source rocket.InputSpec  `mapstructure:"source"`
 		Output rocket.OutputSpec `mapstructure:"output"`
+
+		// Headers are added to url requests, values are expanded.
+		Headers map[string]string `mapstructure:"headers"`
+
+		// Auth adds basic or bearer authorization to url requests.
+		Auth *HTTPAuth `mapstructure:"auth"`
+
+		// SHA256 is the expected hex digest of the resource.  The output is only written if the digest matches.
+		SHA256 string `mapstructure:"sha256"`
 	}
 
 	fetchType struct{}
 
-	fetchOp struct {
-		source io.ReadCloser
-		target io.WriteCloser
+	// progressReader logs the progress of a read.
+	progressReader struct {
+		reader io.Reader
+		name   string
+		log    loggee.Logger
+		read   int64
+		next   time.Time
 	}
-
-	fetchOps []*fetchOp
 )
 
 func (fetchType) Type() string {
@@ -61,16 +98,20 @@ func (fetchType) Prepare(ctx context.Context, capComm *rocket.CapComm, task rock
 	fetchCfg := &Fetch{}
 
 	if err := mapstructure.Decode(task.Definition, fetchCfg); err != nil {
-		return nil, errors.Wrap(err, "parsing template type")
+		return nil, errors.Wrap(err, "parsing fetch type")
 	}
 
-	fn := func(execCtx context.Context) (err error) {
-		ops, err := getFetchOpsFromResourceList(ctx, capComm, fetchCfg.Resources)
-		if err != nil {
-			return
+	for index, res := range fetchCfg.Resources {
+		if res.Output.Path == "" && res.Output.Variable == "" {
+			return nil, fmt.Errorf("resource[%d]: no output specified", index)
+		}
+
+		if err := validateHTTPAuth(res.Auth); err != nil {
+			return nil, errors.Wrapf(err, "resource[%d]", index)
 		}
-		defer ops.Close()
+	}
 
+	fn := func(execCtx context.Context) (err error) {
 		// Create a context we can cancel
 		fetchCtx, cancel := context.WithCancel(execCtx)
 		defer cancel()
@@ -104,25 +145,34 @@ func (fetchType) Prepare(ctx context.Context, capComm *rocket.CapComm, task rock
 			}
 		}()
 
+		// slots limits the number of concurrent requests
+		concurrency := fetchCfg.Concurrency
+		if concurrency == 0 {
+			concurrency = defaultFetchConcurrency
+		}
+		slots := make(chan struct{}, concurrency)
+
 		// Run over requests
-		for i, res := range ops {
+		for i, res := range fetchCfg.Resources {
+			select {
+			case slots <- struct{}{}:
+			case <-fetchCtx.Done():
+			}
+
 			// Check if exit requested
 			if fetchCtx.Err() != nil {
-				return nil
+				break
 			}
 
 			// Run in parallel
 			wg.Add(1)
-			go func(index int, fetch *fetchOp) {
+			go func(index int, res FetchResource) {
 				defer wg.Done()
+				defer func() { <-slots }()
 
-				_, err := io.Copy(fetch.target, fetch.source)
-				if err != nil {
-					errCh <- err
+				if err := fetchWithRetry(fetchCtx, capComm, fetchCfg, res); err != nil {
+					errCh <- errors.Wrapf(err, "resource[%d]", index)
 				}
-				// Close asap
-				fetch.Close()
-				ops[index] = nil
 			}(i, res)
 		}
 
@@ -140,60 +190,254 @@ func (fetchType) Prepare(ctx context.Context, capComm *rocket.CapComm, task rock
 	return fn, nil
 }
 
-func getFetchOpsFromResourceList(ctx context.Context, capComm *rocket.CapComm, list []FetchResource) (fetchOps, error) {
-	resources := make(fetchOps, 0, len(list))
-	for index, resource := range list {
-		op, err := getResource(ctx, capComm, resource)
+// fetchWithRetry fetches the resource, retrying failures other than not found with a doubling delay.
+func fetchWithRetry(ctx context.Context, capComm *rocket.CapComm, fetchCfg *Fetch, res FetchResource) error {
+	delay := time.Duration(fetchCfg.RetryDelay) * time.Second
+	if delay == 0 {
+		delay = time.Second
+	}
+
+	for attempt := uint(0); ; attempt++ {
+		err := fetchResource(ctx, capComm, res, getLogFromCapComm(capComm, fetchCfg.Log))
+		if err == nil || attempt >= fetchCfg.Retries || resource.IsNotFoundError(err) != nil || ctx.Err() != nil {
+			return err
+		}
+
+		capComm.Log().Warnf("%s, retrying in %s", err, delay)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+
+		delay *= 2
+	}
+}
+
+// fetchResource downloads the source into a temporary file, verifies its digest and then commits it to the output.
+// Outputs to a new file are committed by renaming the temporary file.  Fetched resources are streamed and
+// not stored in the resource cache.
+func fetchResource(ctx context.Context, capComm *rocket.CapComm, res FetchResource, log loggee.Logger) error {
+	headerCtx, err := fetchHeaderContext(ctx, capComm, res)
+	if err != nil {
+		return err
+	}
+	headerCtx = resource.ContextWithoutCache(headerCtx)
+
+	srcRp, err := capComm.InputSpecToResourceProvider(headerCtx, res.Source)
+	if err != nil {
+		return errors.Wrap(err, "source")
+	}
+
+	outputPath, err := fetchOutputPath(ctx, capComm, res.Output)
+	if err != nil {
+		return errors.Wrap(err, "output")
+	}
+
+	tempDir := ""
+	if outputPath != "" {
+		tempDir = filepath.Dir(outputPath)
+		if err := os.MkdirAll(tempDir, 0777); err != nil {
+			return errors.Wrap(err, "output")
+		}
+	}
+
+	temp, err := os.CreateTemp(tempDir, ".fetch-*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "output")
+	}
+	defer func() {
+		temp.Close()
+		os.Remove(temp.Name())
+	}()
+
+	digest, err := download(headerCtx, srcRp.OpenRead, temp, sourceName(res.Source), log)
+	if err != nil {
+		return err
+	}
+
+	if err := verifyFetchDigest(ctx, capComm, res, digest); err != nil {
+		return err
+	}
+
+	if outputPath != "" {
+		return commitFetchRename(temp, outputPath, res.Output.FileMode)
+	}
+
+	return commitFetchCopy(ctx, capComm, temp, res.Output)
+}
+
+// fetchHeaderContext returns a context carrying the headers and authorization of the resource.
+func fetchHeaderContext(ctx context.Context, capComm *rocket.CapComm, res FetchResource) (context.Context, error) {
+	header := make(http.Header)
+	for k, v := range res.Headers {
+		value, err := capComm.ExpandString(ctx, k, v)
 		if err != nil {
-			resources.Close()
-			return nil, errors.Wrapf(err, "resource[%d]", index)
+			return nil, errors.Wrapf(err, "expanding header %s", k)
 		}
+		header.Set(k, value)
+	}
+
+	authorization, err := httpAuthorization(ctx, capComm, res.Auth)
+	if err != nil {
+		return nil, err
+	}
+	if authorization != "" {
+		header.Set("Authorization", authorization)
+	}
+
+	if len(header) == 0 {
+		return ctx, nil
+	}
+
+	return resource.ContextWithHeader(ctx, header), nil
+}
+
+// fetchOutputPath returns the expanded path of a file output that can be replaced by renaming, otherwise blank.
+func fetchOutputPath(ctx context.Context, capComm *rocket.CapComm, output rocket.OutputSpec) (string, error) {
+	if output.Path == "" || output.Variable != "" || output.Append {
+		return "", nil
+	}
 
-		resources = append(resources, op)
+	if output.SkipExpand {
+		return output.Path, nil
 	}
 
-	return resources, nil
+	return capComm.ExpandString(ctx, "path", output.Path)
 }
 
-func getResource(ctx context.Context, capComm *rocket.CapComm, resource FetchResource) (*fetchOp, error) {
-	srcRp, err := capComm.InputSpecToResourceProvider(ctx, resource.Source)
+func sourceName(source rocket.InputSpec) string {
+	switch {
+	case source.URL != "":
+		return source.URL
+	case source.Path != "":
+		return source.Path
+	case source.Variable != "":
+		return source.Variable
+	}
+
+	return "inline"
+}
+
+// download copies the source into the writer returning its sha256 digest.
+func download(ctx context.Context, open func(context.Context) (io.ReadCloser, error), w io.Writer, name string, log loggee.Logger) (hash.Hash, error) {
+	reader, err := open(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "source")
 	}
+	defer reader.Close()
+
+	digest := sha256.New()
+
+	var src io.Reader = reader
+	var progress *progressReader
+	if log != nil {
+		progress = &progressReader{reader: reader, name: name, log: log, next: time.Now().Add(fetchProgressInterval)}
+		src = progress
+	}
+
+	if _, err := io.Copy(io.MultiWriter(w, digest), src); err != nil {
+		return nil, errors.Wrapf(err, "fetching %s", name)
+	}
+
+	if progress != nil {
+		log.Infof("fetched %s (%s)", name, formatBytes(progress.read))
+	}
+
+	return digest, nil
+}
 
-	outRp, err := capComm.OutputSpecToResourceProvider(ctx, resource.Output)
+func verifyFetchDigest(ctx context.Context, capComm *rocket.CapComm, res FetchResource, digest hash.Hash) error {
+	if res.SHA256 == "" {
+		return nil
+	}
+
+	expected, err := capComm.ExpandString(ctx, "sha256", res.SHA256)
 	if err != nil {
-		return nil, errors.Wrap(err, "output")
+		return errors.Wrap(err, "expanding sha256")
+	}
+
+	got := hex.EncodeToString(digest.Sum(nil))
+	if got != strings.ToLower(strings.TrimSpace(expected)) {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", sourceName(res.Source), expected, got)
+	}
+
+	return nil
+}
+
+// commitFetchRename replaces the output file with the downloaded temporary file.  Without a configured
+// mode the file keeps the mode of the file it replaces, or 0644 if it is new.
+func commitFetchRename(temp *os.File, outputPath string, fileMode uint) error {
+	mode := os.FileMode(fileMode)
+	if mode == 0 {
+		mode = 0644
+		if stat, err := os.Stat(outputPath); err == nil {
+			mode = stat.Mode().Perm()
+		}
+	}
+
+	if err := temp.Chmod(mode); err != nil {
+		return errors.Wrap(err, "output")
 	}
 
-	reader, err := srcRp.OpenRead(ctx)
+	if err := temp.Close(); err != nil {
+		return errors.Wrap(err, "output")
+	}
+
+	return errors.Wrap(os.Rename(temp.Name(), outputPath), "output")
+}
+
+// commitFetchCopy copies the downloaded temporary file to the output.
+func commitFetchCopy(ctx context.Context, capComm *rocket.CapComm, temp *os.File, output rocket.OutputSpec) error {
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "output")
+	}
+
+	outRp, err := capComm.OutputSpecToResourceProvider(ctx, output)
 	if err != nil {
-		return nil, errors.Wrap(err, "source")
+		return errors.Wrap(err, "output")
 	}
 
 	writer, err := outRp.OpenWrite(ctx)
 	if err != nil {
-		reader.Close()
-		return nil, errors.Wrap(err, "output")
+		return errors.Wrap(err, "output")
 	}
 
-	return &fetchOp{
-		source: reader,
-		target: writer,
-	}, nil
+	if _, err := io.Copy(writer, temp); err != nil {
+		writer.Close()
+		return errors.Wrap(err, "output")
+	}
+
+	return errors.Wrap(writer.Close(), "output")
 }
 
-func (op fetchOp) Close() {
-	_ = op.source.Close()
-	_ = op.target.Close()
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.reader.Read(p)
+	pr.read += int64(n)
+
+	if now := time.Now(); now.After(pr.next) {
+		pr.log.Infof("fetching %s: %s", pr.name, formatBytes(pr.read))
+		pr.next = now.Add(fetchProgressInterval)
+	}
+
+	return n, err
 }
 
-func (ops fetchOps) Close() {
-	for _, op := range ops {
-		if op != nil {
-			op.Close()
-		}
+// formatBytes formats a byte count using binary units.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
 	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
 }
 
 func init() {
@@ -2,12 +2,21 @@ package builtin
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/nehemming/cirocket/pkg/loggee"
 	"github.com/nehemming/cirocket/pkg/loggee/stdlog"
+	"github.com/nehemming/cirocket/pkg/resource"
 	"github.com/nehemming/cirocket/pkg/rocket"
 )
 
@@ -67,3 +76,216 @@ func TestFetchRunWithError(t *testing.T) {
 	file = filepath.Join("testdata", "readme2.tmp")
 	_ = os.Remove(file)
 }
+
+func launchFetch(dir string, fetch map[string]interface{}) error {
+	return launchTasks(context.Background(), filepath.Join(dir, "mission.yml"), nil, newTask("fetch", "fetcher", fetch))
+}
+
+func TestFetchHeadersRetriesAndChecksum(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		first := calls == 1
+		mu.Unlock()
+
+		if r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("X-Channel") != "stable" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if first {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		_, _ = w.Write([]byte("release"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	output := filepath.Join(dir, "dl", "release.bin")
+	sum := sha256.Sum256([]byte("release"))
+
+	resource := map[string]interface{}{
+		"source":  map[string]interface{}{"url": server.URL + "/release.bin"},
+		"output":  map[string]interface{}{"path": output},
+		"headers": map[string]interface{}{"X-Channel": "stable"},
+		"auth":    map[string]interface{}{"token": "secret"},
+		"sha256":  hex.EncodeToString(sum[:]),
+	}
+
+	fetch := map[string]interface{}{
+		"resources":  []interface{}{resource},
+		"retries":    2,
+		"retryDelay": 1,
+		"log":        true,
+	}
+
+	if err := launchFetch(dir, fetch); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := os.ReadFile(output); err != nil || string(b) != "release" {
+		t.Error("unexpected output", string(b), err)
+	}
+
+	if calls != 2 {
+		t.Error("expected a retry", calls)
+	}
+
+	// a bad digest must not replace the output
+	if err := os.Remove(output); err != nil {
+		t.Fatal(err)
+	}
+	resource["sha256"] = strings.Repeat("0", 64)
+	fetch["retries"] = 0
+
+	if err := launchFetch(dir, fetch); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Error("expected checksum error", err)
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		t.Error("output written with a bad checksum")
+	}
+
+	if entries, err := os.ReadDir(filepath.Dir(output)); err != nil || len(entries) != 0 {
+		t.Error("temporary files left behind", entries, err)
+	}
+}
+
+func TestFetchUncachedKeepsMode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("#!/bin/sh\n"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "cache")
+	output := filepath.Join(dir, "tool.sh")
+	if err := os.WriteFile(output, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := resource.ContextWithCache(context.Background(), resource.NewCache(cacheDir, false))
+	task := newTask("fetch", "fetcher", map[string]interface{}{
+		"resources": []interface{}{
+			map[string]interface{}{
+				"source": map[string]interface{}{"url": server.URL + "/tool.sh"},
+				"output": map[string]interface{}{"path": output},
+			},
+		},
+	})
+
+	if err := launchTasks(ctx, filepath.Join(dir, "mission.yml"), nil, task); err != nil {
+		t.Fatal(err)
+	}
+
+	stat, err := os.Stat(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && stat.Mode().Perm() != 0755 {
+		t.Error("expected the existing mode to be kept", stat.Mode())
+	}
+
+	if _, err := os.Stat(cacheDir); !os.IsNotExist(err) {
+		t.Error("fetched resources should not be cached", err)
+	}
+}
+
+func TestFetchConcurrencyAndAppend(t *testing.T) {
+	var mu sync.Mutex
+	active, peak := 0, 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		if active > peak {
+			peak = active
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+		_, _ = w.Write([]byte(strings.TrimPrefix(r.URL.Path, "/")))
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+
+	resources := []interface{}{}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		resources = append(resources, map[string]interface{}{
+			"source": map[string]interface{}{"url": server.URL + "/" + name},
+			"output": map[string]interface{}{"path": filepath.Join(dir, name+".txt")},
+		})
+	}
+
+	appended := filepath.Join(dir, "appended.txt")
+	if err := os.WriteFile(appended, []byte("x"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	resources = append(resources, map[string]interface{}{
+		"source": map[string]interface{}{"url": server.URL + "/y"},
+		"output": map[string]interface{}{"path": appended, "append": true},
+	})
+
+	err := launchFetch(dir, map[string]interface{}{
+		"resources":   resources,
+		"concurrency": 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if peak > 2 {
+		t.Error("concurrency exceeded", peak)
+	}
+
+	if b, err := os.ReadFile(filepath.Join(dir, "e.txt")); err != nil || string(b) != "e" {
+		t.Error("unexpected output", string(b), err)
+	}
+
+	if b, err := os.ReadFile(appended); err != nil || string(b) != "xy" {
+		t.Error("unexpected appended output", string(b), err)
+	}
+}
+
+func TestFetchPrepareErrors(t *testing.T) {
+	var ft fetchType
+
+	tests := []map[string]interface{}{
+		{"resources": []interface{}{map[string]interface{}{"source": map[string]interface{}{"url": "http://localhost"}}}},
+		{"resources": []interface{}{map[string]interface{}{
+			"source": map[string]interface{}{"url": "http://localhost"},
+			"output": map[string]interface{}{"path": "out"},
+			"auth":   map[string]interface{}{"token": "t", "password": "p"},
+		}}},
+	}
+
+	for i, definition := range tests {
+		if _, err := ft.Prepare(context.Background(), nil, rocket.Task{Definition: definition}); err == nil {
+			t.Error(i, "expected error")
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := map[int64]string{
+		10:          "10 B",
+		2048:        "2.0 KiB",
+		5 * 1 << 20: "5.0 MiB",
+	}
+
+	for n, expected := range tests {
+		if s := formatBytes(n); s != expected {
+			t.Error(n, "unexpected", s)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builtin
+
+import (
+	"context"
+
+	"github.com/nehemming/cirocket/pkg/loggee"
+	"github.com/nehemming/cirocket/pkg/loggee/stdlog"
+	"github.com/nehemming/cirocket/pkg/rocket"
+)
+
+// launchTasks launches a mission, located at missionFile, with a single stage running the tasks.
+// The params are added to the mission when given.
+func launchTasks(ctx context.Context, missionFile string, params []interface{}, tasks ...interface{}) error {
+	loggee.SetLogger(stdlog.New())
+
+	mc := rocket.NewMissionControl()
+	RegisterAll(mc)
+
+	mission := map[string]interface{}{
+		"stages": []interface{}{
+			map[string]interface{}{
+				"name":  "test",
+				"tasks": tasks,
+			},
+		},
+	}
+
+	if params != nil {
+		mission["params"] = params
+	}
+
+	return mc.LaunchMission(ctx, missionFile, mission)
+}
+
+// newTask sets the type and name of a task definition.
+func newTask(taskType, name string, definition map[string]interface{}) map[string]interface{} {
+	definition["type"] = taskType
+	definition["name"] = name
+	return definition
+}
@@ -0,0 +1,367 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builtin
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/nehemming/cirocket/pkg/rocket"
+	"github.com/pkg/errors"
+)
+
+type (
+	// HTTP task is used to make a http request.
+	HTTP struct {
+		// URL of the request.
+		URL string `mapstructure:"url"`
+
+		// Method of the request, defaults to GET.
+		Method string `mapstructure:"method"`
+
+		// Headers are added to the request, values are expanded.
+		Headers map[string]string `mapstructure:"headers"`
+
+		// Auth adds basic or bearer authorization to the request.
+		Auth *HTTPAuth `mapstructure:"auth"`
+
+		// Body is the request body.
+		Body *rocket.InputSpec `mapstructure:"body"`
+
+		// ExpectStatus are the acceptable response status codes.  If blank any 2xx status is accepted.
+		ExpectStatus []int `mapstructure:"expectStatus"`
+
+		// Output receives the response body.
+		Output *rocket.OutputSpec `mapstructure:"output"`
+
+		// Extract maps variable names to paths of fields in a json response, i.e. data.items[0].id.
+		Extract map[string]string `mapstructure:"extract"`
+
+		// Timeout of the request in seconds, defaults to 30.
+		Timeout uint `mapstructure:"timeout"`
+
+		Log bool `mapstructure:"log"`
+	}
+
+	// HTTPAuth is the authorization of a http request.  Values are expanded so can be taken from params.
+	HTTPAuth struct {
+		// Username for basic authorization.
+		Username string `mapstructure:"username"`
+
+		// Password for basic authorization.
+		Password string `mapstructure:"password"`
+
+		// Token for bearer authorization.
+		Token string `mapstructure:"token"`
+	}
+
+	httpType struct{}
+)
+
+func (httpType) Type() string {
+	return "http"
+}
+
+func (httpType) Description() string {
+	return "makes a http request and captures the response."
+}
+
+// Prepare loads the tasks configuration and returns the operation function or an error.
+func (httpType) Prepare(ctx context.Context, capComm *rocket.CapComm, task rocket.Task) (rocket.ExecuteFunc, error) {
+	httpCfg := &HTTP{}
+
+	if err := mapstructure.WeakDecode(task.Definition, httpCfg); err != nil {
+		return nil, errors.Wrap(err, "parsing http type")
+	}
+
+	if httpCfg.URL == "" {
+		return nil, errors.New("no url specified")
+	}
+
+	if err := validateHTTPAuth(httpCfg.Auth); err != nil {
+		return nil, err
+	}
+
+	fn := func(execCtx context.Context) error {
+		timeout := time.Duration(httpCfg.Timeout) * time.Second
+		if timeout == 0 {
+			timeout = 30 * time.Second
+		}
+
+		reqCtx, cancel := context.WithTimeout(execCtx, timeout)
+		defer cancel()
+
+		req, err := newHTTPRequest(reqCtx, capComm, httpCfg)
+		if err != nil {
+			return err
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return errors.Wrapf(err, "%s %s", req.Method, req.URL)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return errors.Wrapf(err, "reading response %s", req.URL)
+		}
+
+		if log := getLogFromCapComm(capComm, httpCfg.Log); log != nil {
+			log.Infof("%s %s: %s", req.Method, req.URL, resp.Status)
+		}
+
+		if !expectedStatus(resp.StatusCode, httpCfg.ExpectStatus) {
+			return fmt.Errorf("%s %s: unexpected status %s", req.Method, req.URL, resp.Status)
+		}
+
+		if err := writeHTTPOutput(execCtx, capComm, httpCfg.Output, body); err != nil {
+			return err
+		}
+
+		return extractHTTPVariables(capComm, httpCfg.Extract, body)
+	}
+
+	return fn, nil
+}
+
+func newHTTPRequest(ctx context.Context, capComm *rocket.CapComm, httpCfg *HTTP) (*http.Request, error) {
+	url, err := capComm.ExpandString(ctx, "url", httpCfg.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "expanding url")
+	}
+
+	method := strings.ToUpper(httpCfg.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	var body io.Reader
+	if httpCfg.Body != nil {
+		rp, err := capComm.InputSpecToResourceProvider(ctx, *httpCfg.Body)
+		if err != nil {
+			return nil, errors.Wrap(err, "body")
+		}
+
+		r, err := rp.OpenRead(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "body")
+		}
+		defer r.Close()
+
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "body")
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range httpCfg.Headers {
+		value, err := capComm.ExpandString(ctx, k, v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "expanding header %s", k)
+		}
+		req.Header.Set(k, value)
+	}
+
+	authorization, err := httpAuthorization(ctx, capComm, httpCfg.Auth)
+	if err != nil {
+		return nil, err
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	return req, nil
+}
+
+// httpAuthorization returns the Authorization header value of the auth, or blank if there is no auth.
+func httpAuthorization(ctx context.Context, capComm *rocket.CapComm, auth *HTTPAuth) (string, error) {
+	if auth == nil {
+		return "", nil
+	}
+
+	if auth.Token != "" {
+		token, err := capComm.ExpandString(ctx, "token", auth.Token)
+		if err != nil {
+			return "", errors.Wrap(err, "expanding token")
+		}
+		return "Bearer " + token, nil
+	}
+
+	username, err := capComm.ExpandString(ctx, "username", auth.Username)
+	if err != nil {
+		return "", errors.Wrap(err, "expanding username")
+	}
+
+	password, err := capComm.ExpandString(ctx, "password", auth.Password)
+	if err != nil {
+		return "", errors.Wrap(err, "expanding password")
+	}
+
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password)), nil
+}
+
+// validateHTTPAuth checks only one of a token or basic authorization is used.
+func validateHTTPAuth(auth *HTTPAuth) error {
+	if auth != nil && auth.Token != "" && (auth.Username != "" || auth.Password != "") {
+		return errors.New("auth cannot have both a token and a username or password")
+	}
+
+	return nil
+}
+
+func expectedStatus(status int, expected []int) bool {
+	if len(expected) == 0 {
+		return status >= http.StatusOK && status < http.StatusMultipleChoices
+	}
+
+	for _, s := range expected {
+		if s == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeHTTPOutput(ctx context.Context, capComm *rocket.CapComm, outputSpec *rocket.OutputSpec, body []byte) error {
+	if outputSpec == nil {
+		return nil
+	}
+
+	rp, err := capComm.OutputSpecToResourceProvider(ctx, *outputSpec)
+	if err != nil {
+		return errors.Wrap(err, "output")
+	}
+
+	w, err := rp.OpenWrite(ctx)
+	if err != nil {
+		return errors.Wrap(err, "output")
+	}
+
+	if _, err := w.Write(body); err != nil {
+		w.Close()
+		return errors.Wrap(err, "output")
+	}
+
+	return w.Close()
+}
+
+func extractHTTPVariables(capComm *rocket.CapComm, extract map[string]string, body []byte) error {
+	if len(extract) == 0 {
+		return nil
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return errors.Wrap(err, "parsing json response")
+	}
+
+	names := make([]string, 0, len(extract))
+	for name := range extract {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value, err := jsonPathValue(doc, extract[name])
+		if err != nil {
+			return errors.Wrapf(err, "extracting %s", name)
+		}
+
+		capComm.SetLocalVariable(name, value)
+	}
+
+	return nil
+}
+
+// jsonPathValue returns the value at the path within the decoded json document.  Paths are dot separated
+// field names with optional [index] array selectors and an optional leading $.  Strings are returned
+// as is and other values as json.
+func jsonPathValue(doc interface{}, jsonPath string) (string, error) {
+	p := strings.TrimPrefix(strings.TrimPrefix(jsonPath, "$"), ".")
+
+	value := doc
+	for p != "" {
+		var segment string
+		if i := strings.IndexAny(p, ".["); i < 0 {
+			segment, p = p, ""
+		} else if i > 0 {
+			segment, p = p[:i], strings.TrimPrefix(p[i:], ".")
+		}
+
+		if segment != "" {
+			m, ok := value.(map[string]interface{})
+			if !ok {
+				return "", fmt.Errorf("%s: %s is not an object", jsonPath, segment)
+			}
+			if value, ok = m[segment]; !ok {
+				return "", fmt.Errorf("%s: %s not found", jsonPath, segment)
+			}
+			continue
+		}
+
+		// array index
+		end := strings.Index(p, "]")
+		if !strings.HasPrefix(p, "[") || end < 0 {
+			return "", fmt.Errorf("%s: invalid path", jsonPath)
+		}
+
+		index, err := strconv.Atoi(p[1:end])
+		if err != nil {
+			return "", fmt.Errorf("%s: invalid index %s", jsonPath, p[1:end])
+		}
+		p = strings.TrimPrefix(p[end+1:], ".")
+
+		list, ok := value.([]interface{})
+		if !ok || index < 0 || index >= len(list) {
+			return "", fmt.Errorf("%s: index %d not found", jsonPath, index)
+		}
+		value = list[index]
+	}
+
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func init() {
+	rocket.Default().RegisterTaskTypes(httpType{})
+}
@@ -0,0 +1,180 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builtin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nehemming/cirocket/pkg/rocket"
+)
+
+func TestHTTPType(t *testing.T) {
+	var ht httpType
+
+	if ht.Type() != "http" {
+		t.Error("Wrong http type", ht.Type())
+	}
+
+	if ht.Description() == "" {
+		t.Error("needs description", ht.Type())
+	}
+}
+
+func launchHTTP(dir string, tasks ...interface{}) error {
+	params := []interface{}{
+		map[string]interface{}{"name": "token", "value": "secret"},
+	}
+
+	return launchTasks(context.Background(), filepath.Join(dir, "mission.yml"), params, tasks...)
+}
+
+func TestHTTPRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("X-Release") != "v1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"items": []interface{}{map[string]interface{}{"id": 42, "name": string(body)}},
+			},
+		})
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	output := filepath.Join(dir, "response.json")
+
+	err := launchHTTP(dir,
+		map[string]interface{}{
+			"type":         "http",
+			"name":         "release",
+			"url":          server.URL + "/releases",
+			"method":       "post",
+			"headers":      map[string]interface{}{"X-Release": "v1"},
+			"auth":         map[string]interface{}{"token": "{{.token}}"},
+			"body":         map[string]interface{}{"inline": "build-{{.token}}"},
+			"expectStatus": []interface{}{201},
+			"output":       map[string]interface{}{"path": output},
+			"extract":      map[string]interface{}{"releaseId": "$.data.items[0].id", "releaseName": "data.items[0].name"},
+			"export":       []interface{}{"releaseId", "releaseName"},
+		},
+		map[string]interface{}{
+			"type": "mkdir",
+			"name": "after",
+			"dirs": []interface{}{filepath.ToSlash(dir) + "/{{.Var.releaseId}}-{{.Var.releaseName}}"},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "42-build-secret")); err != nil {
+		t.Error("extracted variables", err)
+	}
+
+	if b, err := os.ReadFile(output); err != nil || !strings.Contains(string(b), `"id":42`) {
+		t.Error("unexpected output", string(b), err)
+	}
+}
+
+func TestHTTPUnexpectedStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "bob" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+
+	request := map[string]interface{}{
+		"type": "http",
+		"name": "status",
+		"url":  server.URL,
+		"auth": map[string]interface{}{"username": "bob", "password": "{{.token}}"},
+	}
+
+	if err := launchHTTP(dir, request); err != nil {
+		t.Error("basic auth", err)
+	}
+
+	request["auth"] = map[string]interface{}{"username": "bob", "password": "wrong"}
+	if err := launchHTTP(dir, request); err == nil || !strings.Contains(err.Error(), "unexpected status 401") {
+		t.Error("expected status error", err)
+	}
+
+	request["expectStatus"] = []interface{}{401}
+	if err := launchHTTP(dir, request); err != nil {
+		t.Error("expected 401", err)
+	}
+}
+
+func TestHTTPPrepareErrors(t *testing.T) {
+	var ht httpType
+
+	tests := []map[string]interface{}{
+		{},
+		{"url": "http://localhost", "auth": map[string]interface{}{"token": "t", "username": "u"}},
+	}
+
+	for i, definition := range tests {
+		if _, err := ht.Prepare(context.Background(), nil, rocket.Task{Definition: definition}); err == nil {
+			t.Error(i, "expected error")
+		}
+	}
+}
+
+func TestJSONPathValue(t *testing.T) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(`{"a":{"b":[{"c":"x"},{"c":true}],"n":1.5},"list":[[1,2]]}`), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"a.b[0].c":   "x",
+		"$.a.b[1].c": "true",
+		"a.n":        "1.5",
+		"list[0][1]": "2",
+		"a.b[0]":     `{"c":"x"}`,
+	}
+
+	for path, expected := range tests {
+		if v, err := jsonPathValue(doc, path); err != nil || v != expected {
+			t.Error(path, "unexpected value", v, err)
+		}
+	}
+
+	for _, path := range []string{"a.x", "a.b[2]", "a.n.x", "a.b[x]", "list[0"} {
+		if _, err := jsonPathValue(doc, path); err == nil {
+			t.Error(path, "expected error")
+		}
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builtin
+
+import (
+	"context"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/nehemming/cirocket/pkg/rocket"
+	"github.com/pkg/errors"
+)
+
+type (
+	// Mission task is used to launch another mission.
+	Mission struct {
+		// Mission is the path or url of the mission file, it is subject to string expansion.
+		// A relative path is relative to the calling mission's directory.
+		Mission string `mapstructure:"mission"`
+
+		// Dir is the directory to run the mission in.  If blank the directory of a local mission file is used.
+		Dir string `mapstructure:"dir"`
+
+		// Sequences are the flight sequences of the mission to run.
+		Sequences []string `mapstructure:"sequences"`
+
+		// Inherit is a list of the calling mission's params passed to the mission.
+		Inherit []string `mapstructure:"inherit"`
+
+		// PassParams are passed to the mission, values are expanded by the calling task.
+		PassParams rocket.Params `mapstructure:"passParams"`
+
+		// PassEnv are environment variables passed to the mission, values are expanded by the calling task.
+		PassEnv rocket.VarMap `mapstructure:"passEnv"`
+	}
+
+	missionType struct {
+		mc rocket.MissionController
+	}
+)
+
+func (missionType) Type() string {
+	return "mission"
+}
+
+func (missionType) Description() string {
+	return "launches another mission and imports its exported variables."
+}
+
+func (mt missionType) Prepare(ctx context.Context, capComm *rocket.CapComm, task rocket.Task) (rocket.ExecuteFunc, error) {
+	missionCfg := &Mission{}
+
+	if err := mapstructure.Decode(task.Definition, missionCfg); err != nil {
+		return nil, errors.Wrap(err, "parsing mission type")
+	}
+
+	if missionCfg.Mission == "" {
+		return nil, errors.New("no mission specified")
+	}
+
+	fn := func(execCtx context.Context) error {
+		sub, err := getSubMission(execCtx, capComm, missionCfg)
+		if err != nil {
+			return err
+		}
+
+		exports, err := mt.mc.LaunchSubMission(execCtx, *sub)
+		if err != nil {
+			return errors.Wrapf(err, "mission %s", sub.Location)
+		}
+
+		// import the mission's exported variables
+		for k, v := range exports {
+			capComm.SetLocalVariable(k, v)
+		}
+
+		return nil
+	}
+
+	return fn, nil
+}
+
+func getSubMission(ctx context.Context, capComm *rocket.CapComm, missionCfg *Mission) (*rocket.SubMission, error) {
+	location, err := capComm.ExpandString(ctx, "mission", missionCfg.Mission)
+	if err != nil {
+		return nil, errors.Wrap(err, "expanding mission")
+	}
+
+	dir, err := capComm.ExpandString(ctx, "dir", missionCfg.Dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "expanding dir")
+	}
+
+	sub := &rocket.SubMission{
+		Location:  location,
+		Parent:    capComm.GetParam(rocket.MissionURLParamName),
+		Dir:       dir,
+		Sequences: missionCfg.Sequences,
+		Env:       make(rocket.VarMap),
+	}
+
+	for _, name := range missionCfg.Inherit {
+		sub.Params = append(sub.Params, rocket.Param{Name: name, Value: capComm.GetParam(name)})
+	}
+
+	for _, p := range missionCfg.PassParams {
+		value, err := capComm.ExpandString(ctx, p.Name, p.Value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "expanding param %s", p.Name)
+		}
+
+		p.Value = value
+		sub.Params = append(sub.Params, p)
+	}
+
+	for k, v := range missionCfg.PassEnv {
+		value, err := capComm.ExpandString(ctx, k, v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "expanding env %s", k)
+		}
+
+		sub.Env[k] = value
+	}
+
+	return sub, nil
+}
+
+func init() {
+	rocket.Default().RegisterTaskTypes(missionType{mc: rocket.Default()})
+}
@@ -0,0 +1,144 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builtin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nehemming/cirocket/pkg/loggee"
+	"github.com/nehemming/cirocket/pkg/loggee/stdlog"
+	"github.com/nehemming/cirocket/pkg/rocket"
+)
+
+func TestMissionType(t *testing.T) {
+	var mt missionType
+
+	if mt.Type() != "mission" {
+		t.Error("Wrong mission type", mt.Type())
+	}
+
+	if mt.Description() == "" {
+		t.Error("needs description", mt.Type())
+	}
+}
+
+func TestMissionRunsSubMission(t *testing.T) {
+	loggee.SetLogger(stdlog.New())
+
+	mc := rocket.NewMissionControl()
+	RegisterAll(mc)
+
+	dir := t.TempDir()
+	subDir := filepath.Join(dir, "sub")
+	if err := os.Mkdir(subDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	child := `
+stages:
+  - name: work
+    tasks:
+      - type: mkdir
+        name: made
+        dirs:
+          - "{{.colour}}-{{.Env.SUB_ENV}}"
+        postvars:
+          result: "done-{{.who}}"
+`
+	childFile := filepath.Join(subDir, ".cirocket.yml")
+	if err := os.WriteFile(childFile, []byte(child), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	mission := map[string]interface{}{
+		"params": []interface{}{
+			map[string]interface{}{"name": "who", "value": "world"},
+		},
+		"stages": []interface{}{
+			map[string]interface{}{
+				"name": "build",
+				"tasks": []interface{}{
+					map[string]interface{}{
+						"type":    "mission",
+						"name":    "sub",
+						"mission": filepath.ToSlash(childFile),
+						"inherit": []interface{}{"who"},
+						"passParams": []interface{}{
+							map[string]interface{}{"name": "colour", "value": "{{.who}}-blue"},
+						},
+						"passEnv": map[string]interface{}{"SUB_ENV": "parent"},
+						"export":  []interface{}{"result"},
+					},
+					map[string]interface{}{
+						"type": "mkdir",
+						"name": "after",
+						"dirs": []interface{}{filepath.ToSlash(dir) + "/{{.Var.result}}"},
+					},
+				},
+			},
+		},
+	}
+
+	if err := mc.LaunchMission(context.Background(), filepath.Join(dir, "parent.yml"), mission); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(subDir, "world-blue-parent")); err != nil {
+		t.Error("sub mission did not run in its directory", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "done-world")); err != nil {
+		t.Error("exported variable not imported", err)
+	}
+}
+
+func TestMissionRelativeConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	child := "stages:\n  - name: work\n    tasks:\n      - type: mkdir\n        name: made\n        dirs:\n          - made\n"
+	writeCopyFiles(t, dir, map[string]string{"a/child.yml": child, "b/child.yml": child})
+
+	// the locations are relative to the parent mission, not the working directory
+	task := map[string]interface{}{
+		"name": "both",
+		"concurrent": []interface{}{
+			newTask("mission", "a", map[string]interface{}{"mission": "a/child.yml"}),
+			newTask("mission", "b", map[string]interface{}{"mission": "b/child.yml"}),
+		},
+	}
+
+	if err := launchTasks(context.Background(), filepath.Join(dir, "parent.yml"), nil, task); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a", "b"} {
+		if _, err := os.Stat(filepath.Join(dir, name, "made")); err != nil {
+			t.Error("sub mission did not run in its directory", name, err)
+		}
+	}
+}
+
+func TestMissionNeedsLocation(t *testing.T) {
+	mt := missionType{mc: rocket.NewMissionControl()}
+	capComm := rocket.NewCapComm("testdata/mission.yml", stdlog.New())
+
+	if _, err := mt.Prepare(context.Background(), capComm, rocket.Task{Type: "mission"}); err == nil {
+		t.Error("expected error")
+	}
+}
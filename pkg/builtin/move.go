@@ -18,8 +18,12 @@ package builtin
 
 import (
 	"context"
-	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
 
 	"github.com/mitchellh/mapstructure"
 	"github.com/nehemming/cirocket/pkg/loggee"
@@ -29,26 +33,39 @@ import (
 
 type (
 
-	// Move task is used to move files.  The tool can recursively move files.
-	// Directories cannot be specified as a source, the wild card ** pattern can
-	// be used to recursively move the contents of the directory.
-	// The directory structure is moved, but the source sub directories are not deleted.
+	// Move task is used to move files and directories.  Directories matched by a source, whose contents are not
+	// also matched, are moved whole.  The wild card ** pattern can be used to move the contents of a directory
+	// file by file, which leaves the source sub directories in place unless RemoveEmptyDirs is set.
+	// Moves across file systems fall back to copying and then deleting the source.
 	Move struct {
 		Sources     []string `mapstructure:"sources"`
 		Destination string   `mapstructure:"destination"`
 		Overwrite   string   `mapstructure:"overwrite"`
-		Log         bool     `mapstructure:"log"`
+
+		// Exclude are glob patterns of files that are not moved.
+		Exclude []string `mapstructure:"exclude"`
+
+		// IgnoreFiles are .gitignore style files listing further files to exclude.
+		IgnoreFiles []string `mapstructure:"ignoreFiles"`
+
+		// RemoveEmptyDirs removes source directories left empty by the move.
+		RemoveEmptyDirs bool `mapstructure:"removeEmptyDirs"`
+
+		Log bool `mapstructure:"log"`
 	}
 
 	moveType struct{}
 )
 
+// renameFile renames a file or directory, it can be replaced by tests.
+var renameFile = os.Rename
+
 func (moveType) Type() string {
 	return "move"
 }
 
 func (moveType) Description() string {
-	return "moves files matching the source glob specs to the destination folder."
+	return "moves files and directories matching the source glob specs to the destination folder."
 }
 
 // Prepare loads the tasks configuration and returns the operation function or an error.
@@ -56,7 +73,7 @@ func (moveType) Prepare(ctx context.Context, capComm *rocket.CapComm, task rocke
 	moveCfg := &Move{}
 
 	if err := mapstructure.WeakDecode(task.Definition, moveCfg); err != nil {
-		return nil, errors.Wrap(err, "parsing template type")
+		return nil, errors.Wrap(err, "parsing move type")
 	}
 
 	fn := func(execCtx context.Context) error {
@@ -89,43 +106,184 @@ func (moveType) Prepare(ctx context.Context, capComm *rocket.CapComm, task rocke
 		}
 
 		// glob the files
-		files, err := globFileAbsRel(rawSpecs...)
+		ps, err := newPatternSetFromConfig(ctx, capComm, rawSpecs, moveCfg.Exclude, moveCfg.IgnoreFiles)
 		if err != nil {
 			return err
 		}
 
-		// copy
-		return moveFiles(execCtx, files, destSpec, overwrite, getLogFromCapComm(capComm, moveCfg.Log))
+		matches, _, err := ps.Glob()
+		if err != nil {
+			return err
+		}
+
+		// refuse to move anything if a path is outside of the sandbox
+		if err := capComm.CheckSandbox(destSpec.Path); err != nil {
+			return err
+		}
+		for _, m := range matches {
+			if err := capComm.CheckSandbox(m.path); err != nil {
+				return err
+			}
+		}
+
+		// move
+		log := getLogFromCapComm(capComm, moveCfg.Log)
+		dryRun := rocket.IsDryRun(execCtx)
+		if dryRun {
+			log = capComm.Log()
+		}
+
+		if err := moveFiles(execCtx, ps, matches, destSpec, overwrite, dryRun, log); err != nil {
+			return err
+		}
+
+		if moveCfg.RemoveEmptyDirs && !dryRun {
+			removeEmptyDirs(matches, log)
+		}
+
+		return nil
 	}
 
 	return fn, nil
 }
 
-func moveFiles(ctx context.Context, sources []absRel, dest destSpec, allowOverwrite bool, log loggee.Logger) error {
-	for _, source := range sources {
+// hasMatchedChildren returns the matched paths that have other matched paths beneath them.
+func hasMatchedChildren(matches []patternMatch) map[string]bool {
+	paths := make([]string, 0, len(matches))
+	for _, m := range matches {
+		paths = append(paths, m.path)
+	}
+	sort.Strings(paths)
+
+	parents := make(map[string]bool)
+	for i, path := range paths {
+		prefix := strings.TrimSuffix(path, string(filepath.Separator)) + string(filepath.Separator)
+		for _, next := range paths[i+1:] {
+			if strings.HasPrefix(next, prefix) {
+				parents[path] = true
+				break
+			}
+		}
+	}
+
+	return parents
+}
+
+// moveFiles moves the matched files and directories, a dry run only logs the moves.
+// If the patterns exclude files, matched directories are moved file by file so the excluded files stay put.
+func moveFiles(ctx context.Context, ps *patternSet, matches []patternMatch, dest destSpec, allowOverwrite, dryRun bool, log loggee.Logger) error {
+	parents := hasMatchedChildren(matches)
+
+	for _, m := range matches {
 		if ctx.Err() != nil {
 			return ctx.Err()
 		}
 
-		if err := moveFile(source, dest, allowOverwrite, log); err != nil {
+		stat, err := os.Lstat(m.path)
+		if os.IsNotExist(err) {
+			// moved with a parent directory
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "stat %s:", m.path)
+		}
+
+		rel, err := filepath.Rel(m.base, m.path)
+		if err != nil {
+			return errors.Wrapf(err, "rel dir of %s", m.path)
+		}
+
+		// directories with matched contents are moved file by file, as are base directories moving into a directory
+		if stat.IsDir() && (parents[m.path] || (rel == "." && dest.IsDir)) {
+			continue
+		}
+
+		if stat.IsDir() && ps.HasExclusions() {
+			if err := moveDirExcept(ps, m, dest, allowOverwrite, dryRun, log); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if dryRun {
+			target := dest.Path
+			if dest.IsDir {
+				target = filepath.Join(dest.Path, rel)
+			}
+			log.Infof("would move %s => %s", friendlyRelativePath(m.path), friendlyRelativePath(target))
+			continue
+		}
+
+		if err := moveFile(absRel{Abs: m.path, Rel: rel}, stat, dest, allowOverwrite, log); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func moveFile(source absRel, dest destSpec, allowOverwrite bool, log loggee.Logger) error {
-	// Get the source files permission
-	stat, err := os.Stat(source.Abs)
+// moveDirExcept moves the files of a matched directory that are not excluded, removing the directories left empty.
+func moveDirExcept(ps *patternSet, m patternMatch, dest destSpec, allowOverwrite, dryRun bool, log loggee.Logger) error {
+	var dirs []string
+
+	err := filepath.Walk(m.path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if ps.Excluded(m.base, path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			dirs = append(dirs, path)
+			return nil
+		}
+
+		rel, err := filepath.Rel(m.base, path)
+		if err != nil {
+			return errors.Wrapf(err, "rel dir of %s", path)
+		}
+
+		// a directory moved to a new name keeps its layout below the new name
+		target := dest
+		if !dest.IsDir {
+			inner, err := filepath.Rel(m.path, path)
+			if err != nil {
+				return errors.Wrapf(err, "rel dir of %s", path)
+			}
+			target = destSpec{Path: filepath.Join(dest.Path, inner)}
+		}
+
+		if dryRun {
+			finalPath := target.Path
+			if target.IsDir {
+				finalPath = filepath.Join(target.Path, rel)
+			}
+			log.Infof("would move %s => %s", friendlyRelativePath(path), friendlyRelativePath(finalPath))
+			return nil
+		}
+
+		return moveFile(absRel{Abs: path, Rel: rel}, info, target, allowOverwrite, log)
+	})
 	if err != nil {
-		return errors.Wrapf(err, "stat %s:", source.Abs)
+		return errors.Wrapf(err, "walking %s:", m.path)
 	}
 
-	if stat.IsDir() {
-		// cannot move dirs, move as dir/**
-		return fmt.Errorf("%s is a directory and cannot be moved, replace source with %[1]s/**", source.Rel)
+	if dryRun {
+		return nil
+	}
+
+	// deepest first, directories still holding excluded files are kept
+	for i := len(dirs) - 1; i >= 0; i-- {
+		_ = os.Remove(dirs[i])
 	}
 
+	return nil
+}
+
+func moveFile(source absRel, stat os.FileInfo, dest destSpec, allowOverwrite bool, log loggee.Logger) error {
 	destAbsRel, err := prepDestination(source, dest, allowOverwrite)
 	if err != nil {
 		return err
@@ -138,9 +296,15 @@ func moveFile(source absRel, dest destSpec, allowOverwrite bool, log loggee.Logg
 		return nil
 	}
 
+	if stat.IsDir() {
+		if _, err := os.Stat(destAbsRel.Abs); err == nil {
+			// merge into the existing directory
+			return mergeDir(source, destAbsRel.Abs, allowOverwrite, log)
+		}
+	}
+
 	// Do the move
-	err = os.Rename(source.Abs, destAbsRel.Abs)
-	if err != nil {
+	if err := movePath(source.Abs, destAbsRel.Abs); err != nil {
 		return errors.Wrapf(err, "move %s => %s:", source.Rel, destAbsRel.Rel)
 	}
 
@@ -152,6 +316,155 @@ func moveFile(source absRel, dest destSpec, allowOverwrite bool, log loggee.Logg
 	return nil
 }
 
+// mergeDir moves the contents of a directory into an existing directory, removing the source directory if it is emptied.
+func mergeDir(source absRel, destDir string, allowOverwrite bool, log loggee.Logger) error {
+	entries, err := os.ReadDir(source.Abs)
+	if err != nil {
+		return errors.Wrapf(err, "read dir %s:", source.Rel)
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return errors.Wrapf(err, "stat %s:", entry.Name())
+		}
+
+		child := absRel{Abs: filepath.Join(source.Abs, entry.Name()), Rel: filepath.Join(source.Rel, entry.Name())}
+		dest := destSpec{Path: filepath.Join(destDir, entry.Name())}
+
+		if err := moveFile(child, info, dest, allowOverwrite, log); err != nil {
+			return err
+		}
+	}
+
+	// only succeeds if all the contents moved
+	_ = os.Remove(source.Abs)
+
+	return nil
+}
+
+// movePath renames the source, falling back to copying and then deleting it when the rename crosses file systems.
+func movePath(source, dest string) error {
+	err := renameFile(source, dest)
+	if err == nil || !isCrossDevice(err) {
+		return err
+	}
+
+	// replace existing files as a rename would
+	if stat, err := os.Lstat(dest); err == nil && !stat.IsDir() {
+		if err := os.Remove(dest); err != nil {
+			return err
+		}
+	}
+
+	if err := copyPath(source, dest); err != nil {
+		_ = os.RemoveAll(dest)
+		return err
+	}
+
+	return os.RemoveAll(source)
+}
+
+func isCrossDevice(err error) bool {
+	linkErr, ok := err.(*os.LinkError)
+	return ok && linkErr.Err == syscall.EXDEV
+}
+
+// copyPath copies a file, link or directory tree preserving permissions and modified times.
+func copyPath(source, dest string) error {
+	stat, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case stat.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(source)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(link, dest)
+
+	case stat.IsDir():
+		if err := os.Mkdir(dest, stat.Mode().Perm()); err != nil {
+			return err
+		}
+
+		entries, err := os.ReadDir(source)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if err := copyPath(filepath.Join(source, entry.Name()), filepath.Join(dest, entry.Name())); err != nil {
+				return err
+			}
+		}
+
+	default:
+		if err := copyFileContents(source, dest, stat.Mode()); err != nil {
+			return err
+		}
+	}
+
+	// permissions are set explicitly as creation is subject to the umask
+	if err := os.Chmod(dest, stat.Mode().Perm()); err != nil {
+		return err
+	}
+
+	return os.Chtimes(dest, stat.ModTime(), stat.ModTime())
+}
+
+func copyFileContents(source, dest string, mode os.FileMode) error {
+	srcFile, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	destFile, err := os.OpenFile(dest, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(destFile, srcFile); err != nil {
+		destFile.Close()
+		return err
+	}
+
+	return destFile.Close()
+}
+
+// removeEmptyDirs removes the matched directories and the parent directories of matched files, beneath their
+// base directory, that are empty.
+func removeEmptyDirs(matches []patternMatch, log loggee.Logger) {
+	dirs := make(map[string]bool)
+	for _, m := range matches {
+		if stat, err := os.Lstat(m.path); err == nil && stat.IsDir() {
+			dirs[m.path] = true
+		}
+
+		for dir := filepath.Dir(m.path); len(dir) > len(m.base) && isWithin(m.base, dir); dir = filepath.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+
+	list := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		list = append(list, dir)
+	}
+
+	// deepest first
+	sort.Slice(list, func(i, j int) bool { return len(list[i]) > len(list[j]) })
+
+	for _, dir := range list {
+		// fails unless the directory is empty
+		if os.Remove(dir) == nil && log != nil {
+			log.Infof("removed %s", friendlyRelativePath(dir))
+		}
+	}
+}
+
 func init() {
 	rocket.Default().RegisterTaskTypes(moveType{})
 }
@@ -20,7 +20,10 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"runtime"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/nehemming/cirocket/pkg/loggee"
 	"github.com/nehemming/cirocket/pkg/loggee/stdlog"
@@ -65,3 +68,196 @@ func validateMoveTest(t *testing.T, dir string) {
 		t.Errorf("File mismatch stage wanted 0 got %d", len(stage))
 	}
 }
+
+func launchMove(t *testing.T, dir string, moveCfg map[string]interface{}) {
+	if err := launchTasks(context.Background(), filepath.Join(dir, "mission.yml"), nil, newTask("move", "move files", moveCfg)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMoveDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeCopyFiles(t, dir, map[string]string{"src/pkg/a.txt": "a", "src/pkg/sub/b.txt": "b", "dist/pkg/old.txt": "old"})
+
+	launchMove(t, dir, map[string]interface{}{
+		"sources":     []interface{}{filepath.ToSlash(dir) + "/src/pkg"},
+		"destination": filepath.ToSlash(dir) + "/out/",
+	})
+
+	if _, err := os.Stat(filepath.Join(dir, "out", "pkg", "sub", "b.txt")); err != nil {
+		t.Error("directory not moved", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "src", "pkg")); err == nil {
+		t.Error("source directory not moved")
+	}
+
+	// merge into an existing directory
+	writeCopyFiles(t, dir, map[string]string{"src/pkg/c.txt": "c"})
+	launchMove(t, dir, map[string]interface{}{
+		"sources":     []interface{}{filepath.ToSlash(dir) + "/src/pkg"},
+		"destination": filepath.ToSlash(dir) + "/dist/",
+		"overwrite":   "true",
+	})
+
+	for _, name := range []string{"old.txt", "c.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, "dist", "pkg", name)); err != nil {
+			t.Error(name, "missing after merge", err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "src", "pkg")); err == nil {
+		t.Error("merged source directory not removed")
+	}
+}
+
+func TestMoveDirectoryExcludes(t *testing.T) {
+	dir := t.TempDir()
+	writeCopyFiles(t, dir, map[string]string{
+		"src/pkg/a.txt":       "a",
+		"src/pkg/debug.log":   "log",
+		"src/pkg/sub/b.txt":   "b",
+		"src/pkg/cache/c.txt": "c",
+		"src/other/d.txt":     "d",
+		"src/other/sub/e.txt": "e",
+		"src/other/sub/f.tmp": "f",
+	})
+
+	launchMove(t, dir, map[string]interface{}{
+		"sources":     []interface{}{filepath.ToSlash(dir) + "/src/pkg"},
+		"destination": filepath.ToSlash(dir) + "/out/",
+		"exclude":     []interface{}{"*.log", "cache"},
+	})
+
+	for _, name := range []string{"out/pkg/a.txt", "out/pkg/sub/b.txt", "src/pkg/debug.log", "src/pkg/cache/c.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			t.Error(name, "missing", err)
+		}
+	}
+
+	for _, name := range []string{"out/pkg/debug.log", "out/pkg/cache", "src/pkg/sub"} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err == nil {
+			t.Error(name, "should not exist")
+		}
+	}
+
+	// renaming a directory keeps the excluded files in place
+	launchMove(t, dir, map[string]interface{}{
+		"sources":     []interface{}{filepath.ToSlash(dir) + "/src/other"},
+		"destination": filepath.ToSlash(dir) + "/renamed",
+		"exclude":     []interface{}{"*.tmp"},
+	})
+
+	for _, name := range []string{"renamed/d.txt", "renamed/sub/e.txt", "src/other/sub/f.tmp"} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			t.Error(name, "missing", err)
+		}
+	}
+}
+
+func TestMoveRemoveEmptyDirs(t *testing.T) {
+	dir := t.TempDir()
+	writeCopyFiles(t, dir, map[string]string{"src/a.txt": "a", "src/sub/deep/b.txt": "b"})
+
+	launchMove(t, dir, map[string]interface{}{
+		"sources":         []interface{}{filepath.ToSlash(dir) + "/src/**"},
+		"destination":     filepath.ToSlash(dir) + "/out/",
+		"removeEmptyDirs": true,
+		"log":             true,
+	})
+
+	if _, err := os.Stat(filepath.Join(dir, "out", "sub", "deep", "b.txt")); err != nil {
+		t.Error("file not moved", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "src")); err == nil {
+		t.Error("emptied source directories not removed")
+	}
+}
+
+func TestMoveCrossDevice(t *testing.T) {
+	defer func(rename func(string, string) error) { renameFile = rename }(renameFile)
+	renameFile = func(oldpath, newpath string) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+	}
+
+	dir := t.TempDir()
+	writeCopyFiles(t, dir, map[string]string{"tmpfs/build/bin/tool": "tool", "tmpfs/build/README": "readme"})
+
+	tool := filepath.Join(dir, "tmpfs", "build", "bin", "tool")
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chmod(tool, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(tool, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	launchMove(t, dir, map[string]interface{}{
+		"sources":     []interface{}{filepath.ToSlash(dir) + "/tmpfs/build"},
+		"destination": filepath.ToSlash(dir) + "/volume/",
+	})
+
+	stat, err := os.Stat(filepath.Join(dir, "volume", "build", "bin", "tool"))
+	if err != nil {
+		t.Fatal("not copied", err)
+	}
+
+	if runtime.GOOS != "windows" && stat.Mode().Perm() != 0750 {
+		t.Error("permissions not preserved", stat.Mode())
+	}
+
+	if !stat.ModTime().Equal(modTime) {
+		t.Error("modified time not preserved", stat.ModTime())
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "tmpfs", "build")); err == nil {
+		t.Error("source not deleted")
+	}
+}
+
+func TestMoveSandboxAndDryRun(t *testing.T) {
+	dir := t.TempDir()
+	writeCopyFiles(t, dir, map[string]string{"src/a.txt": "a"})
+
+	loggee.SetLogger(stdlog.New())
+
+	mc := rocket.NewMissionControl()
+	RegisterAll(mc)
+
+	mission := map[string]interface{}{
+		"sandbox": map[string]interface{}{"allow": []interface{}{filepath.ToSlash(dir) + "/out"}},
+		"stages": []interface{}{
+			map[string]interface{}{
+				"name": "move",
+				"tasks": []interface{}{
+					map[string]interface{}{
+						"type":        "move",
+						"name":        "move files",
+						"sources":     []interface{}{filepath.ToSlash(dir) + "/src/**"},
+						"destination": filepath.ToSlash(dir) + "/out/",
+					},
+				},
+			},
+		},
+	}
+
+	// the sources are outside of the mission directory sandbox
+	missionFile := filepath.Join(dir, "mission", "mission.yml")
+	if err := mc.LaunchMission(context.Background(), missionFile, mission); err == nil {
+		t.Error("expected sandbox error")
+	}
+
+	mission["sandbox"] = map[string]interface{}{"root": filepath.ToSlash(dir)}
+	if err := mc.LaunchMission(rocket.ContextWithDryRun(context.Background(), true), missionFile, mission); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "src", "a.txt")); err != nil {
+		t.Error("dry run moved file", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "out")); err == nil {
+		t.Error("dry run created destination")
+	}
+}
@@ -0,0 +1,493 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builtin
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	globber "github.com/bmatcuk/doublestar/v4"
+	"github.com/mitchellh/mapstructure"
+	"github.com/nehemming/cirocket/pkg/loggee"
+	"github.com/nehemming/cirocket/pkg/rocket"
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+)
+
+// Archive formats.
+const (
+	ArchiveZip   = "zip"
+	ArchiveTar   = "tar"
+	ArchiveTarGz = "tar.gz"
+	ArchiveTarXz = "tar.xz"
+)
+
+type (
+	// Archive task is used to pack files into a zip or tar archive.
+	Archive struct {
+		// Sources are glob patterns of the files to archive.
+		Sources []string `mapstructure:"sources"`
+
+		// Output is the archive file to create.
+		Output string `mapstructure:"output"`
+
+		// Format is zip, tar, tar.gz or tar.xz.  If blank it is taken from the output file's extension.
+		Format string `mapstructure:"format"`
+
+		// Prefix is prepended to the path of each file in the archive.
+		Prefix string `mapstructure:"prefix"`
+
+		// Strip is the number of leading directories removed from the path of each file in the archive.
+		Strip int `mapstructure:"strip"`
+
+		// FileMode is an octal file mode applied to all files, i.e. 0644.  If blank the file's own mode is used.
+		FileMode string `mapstructure:"fileMode"`
+
+		// Modes maps glob patterns, matched against the path in the archive, to octal file modes.
+		// They take precedence over FileMode.
+		Modes map[string]string `mapstructure:"modes"`
+
+		// ModTime is the RFC 3339 modification time given to all files.  If blank SOURCE_DATE_EPOCH is used
+		// and failing that 1980-01-01T00:00:00Z.
+		ModTime string `mapstructure:"modTime"`
+
+		Log bool `mapstructure:"log"`
+	}
+
+	archiveType struct{}
+
+	// archiveEntry is a file to add to an archive.
+	archiveEntry struct {
+		source absRel
+		name   string
+		mode   os.FileMode
+	}
+
+	// archiveSettings are the expanded settings of an archive task.
+	archiveSettings struct {
+		output  string
+		format  string
+		modTime time.Time
+		entries []archiveEntry
+	}
+)
+
+func (archiveType) Type() string {
+	return "archive"
+}
+
+func (archiveType) Description() string {
+	return "packs files matching the source glob patterns into a zip, tar, tar.gz or tar.xz archive."
+}
+
+// Prepare loads the tasks configuration and returns the operation function or an error.
+func (archiveType) Prepare(ctx context.Context, capComm *rocket.CapComm, task rocket.Task) (rocket.ExecuteFunc, error) {
+	archiveCfg := &Archive{}
+
+	if err := mapstructure.WeakDecode(task.Definition, archiveCfg); err != nil {
+		return nil, errors.Wrap(err, "parsing archive type")
+	}
+
+	if archiveCfg.Output == "" {
+		return nil, errors.New("no output specified")
+	}
+
+	if archiveCfg.Strip < 0 {
+		return nil, errors.New("strip cannot be negative")
+	}
+
+	fn := func(execCtx context.Context) error {
+		settings, err := getArchiveSettings(execCtx, capComm, archiveCfg)
+		if err != nil {
+			return err
+		}
+
+		log := getLogFromCapComm(capComm, archiveCfg.Log)
+
+		if err := writeArchive(execCtx, settings, log); err != nil {
+			return errors.Wrapf(err, "archive %s", settings.output)
+		}
+
+		if log != nil {
+			log.Infof("archived %d files into %s", len(settings.entries), settings.output)
+		}
+
+		return nil
+	}
+
+	return fn, nil
+}
+
+func getArchiveSettings(ctx context.Context, capComm *rocket.CapComm, archiveCfg *Archive) (*archiveSettings, error) {
+	output, err := capComm.ExpandString(ctx, "output", archiveCfg.Output)
+	if err != nil {
+		return nil, errors.Wrap(err, "expanding output")
+	}
+
+	output, err = filepath.Abs(filepath.FromSlash(output))
+	if err != nil {
+		return nil, err
+	}
+
+	format, err := getArchiveFormat(archiveCfg.Format, output)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix, err := capComm.ExpandString(ctx, "prefix", archiveCfg.Prefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "expanding prefix")
+	}
+
+	modTime, err := getArchiveModTime(archiveCfg.ModTime)
+	if err != nil {
+		return nil, err
+	}
+
+	rawSpecs := make([]string, 0, len(archiveCfg.Sources))
+	for index, s := range archiveCfg.Sources {
+		rawSpec, err := capComm.ExpandString(ctx, "source", s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "expanding source %d", index)
+		}
+		rawSpecs = append(rawSpecs, rawSpec)
+	}
+
+	files, err := globFileAbsRel(rawSpecs...)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := getArchiveEntries(files, output, prefix, archiveCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &archiveSettings{
+		output:  output,
+		format:  format,
+		modTime: modTime,
+		entries: entries,
+	}, nil
+}
+
+// getArchiveFormat returns the archive format, defaulting to the format matching the output extension.
+func getArchiveFormat(format, output string) (string, error) {
+	if format == "" {
+		format = strings.ToLower(filepath.Base(output))
+	}
+
+	switch {
+	case strings.HasSuffix(format, "zip"):
+		return ArchiveZip, nil
+	case strings.HasSuffix(format, "tar.gz"), strings.HasSuffix(format, "tgz"):
+		return ArchiveTarGz, nil
+	case strings.HasSuffix(format, "tar.xz"), strings.HasSuffix(format, "txz"):
+		return ArchiveTarXz, nil
+	case strings.HasSuffix(format, "tar"):
+		return ArchiveTar, nil
+	}
+
+	return "", fmt.Errorf("unknown archive format %s, use zip, tar, tar.gz or tar.xz", format)
+}
+
+// getArchiveModTime returns the modification time given to archived files.
+func getArchiveModTime(modTime string) (time.Time, error) {
+	if modTime != "" {
+		t, err := time.Parse(time.RFC3339, modTime)
+		if err != nil {
+			return t, errors.Wrap(err, "parsing modTime")
+		}
+		return t.UTC(), nil
+	}
+
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		secs, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return time.Time{}, errors.Wrap(err, "parsing SOURCE_DATE_EPOCH")
+		}
+		return time.Unix(secs, 0).UTC(), nil
+	}
+
+	return time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC), nil
+}
+
+// getArchiveEntries returns the sorted entries of the archive.  The output file is never archived.
+func getArchiveEntries(files []absRel, output, prefix string, archiveCfg *Archive) ([]archiveEntry, error) {
+	fileMode, err := parseFileMode(archiveCfg.FileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]archiveEntry, 0, len(files))
+	names := make(map[string]bool)
+
+	for _, file := range files {
+		if file.Abs == output {
+			continue
+		}
+
+		name := stripPath(filepath.ToSlash(file.Rel), archiveCfg.Strip)
+		if name == "" {
+			continue
+		}
+		name = path.Join(prefix, name)
+
+		if names[name] {
+			return nil, fmt.Errorf("%s is archived more than once", name)
+		}
+		names[name] = true
+
+		mode, err := getArchiveFileMode(file, name, fileMode, archiveCfg.Modes)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, archiveEntry{source: file, name: name, mode: mode})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	return entries, nil
+}
+
+// stripPath removes the leading directories from a slashed path.  Blank is returned if nothing remains.
+func stripPath(name string, strip int) string {
+	parts := strings.Split(name, "/")
+	if strip >= len(parts) {
+		return ""
+	}
+
+	return strings.Join(parts[strip:], "/")
+}
+
+func parseFileMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0, nil
+	}
+
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return 0, errors.Wrapf(err, "file mode %s", mode)
+	}
+
+	return os.FileMode(m) & os.ModePerm, nil
+}
+
+// getArchiveFileMode returns the mode of an archived file.  The first matching pattern in modes is used,
+// patterns are checked in sorted order.  If none match fileMode or the files own permissions are used.
+func getArchiveFileMode(file absRel, name string, fileMode os.FileMode, modes map[string]string) (os.FileMode, error) {
+	patterns := make([]string, 0, len(modes))
+	for pattern := range modes {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	for _, pattern := range patterns {
+		ok, err := globber.Match(pattern, name)
+		if err != nil {
+			return 0, errors.Wrapf(err, "mode pattern %s", pattern)
+		}
+
+		if ok {
+			return parseFileMode(modes[pattern])
+		}
+	}
+
+	if fileMode != 0 {
+		return fileMode, nil
+	}
+
+	stat, err := os.Stat(file.Abs)
+	if err != nil {
+		return 0, errors.Wrapf(err, "stat %s", file.Rel)
+	}
+
+	return stat.Mode() & os.ModePerm, nil
+}
+
+// writeArchive writes the archive to a temporary file alongside the output, renaming it once complete
+// so a failure does not leave a partial archive.
+func writeArchive(ctx context.Context, settings *archiveSettings, log loggee.Logger) error {
+	dir := filepath.Dir(settings.output)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(dir, ".archive-*.tmp")
+	if err != nil {
+		return err
+	}
+
+	err = writeArchiveFormat(ctx, f, settings, log)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), archiveOutputMode(settings.output))
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), settings.output)
+	}
+
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+
+	return err
+}
+
+// archiveOutputMode returns the mode of an existing output file, or 0644 for a new one.
+func archiveOutputMode(output string) os.FileMode {
+	if stat, err := os.Stat(output); err == nil {
+		return stat.Mode().Perm()
+	}
+
+	return 0644
+}
+
+func writeArchiveFormat(ctx context.Context, f io.Writer, settings *archiveSettings, log loggee.Logger) error {
+	switch settings.format {
+	case ArchiveZip:
+		return writeZip(ctx, f, settings, log)
+	case ArchiveTarGz:
+		gz, err := gzip.NewWriterLevel(f, gzip.BestCompression)
+		if err != nil {
+			return err
+		}
+		if err := writeTar(ctx, gz, settings, log); err != nil {
+			return err
+		}
+		return gz.Close()
+	case ArchiveTarXz:
+		return writeTarXz(ctx, f, settings, log)
+	default:
+		return writeTar(ctx, f, settings, log)
+	}
+}
+
+func writeZip(ctx context.Context, w io.Writer, settings *archiveSettings, log loggee.Logger) error {
+	zw := zip.NewWriter(w)
+	zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, flate.BestCompression)
+	})
+
+	for _, entry := range settings.entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		header := &zip.FileHeader{
+			Name:     entry.name,
+			Method:   zip.Deflate,
+			Modified: settings.modTime,
+		}
+		header.SetMode(entry.mode)
+
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		if err := copyArchiveEntry(fw, entry, log); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func writeTar(ctx context.Context, w io.Writer, settings *archiveSettings, log loggee.Logger) error {
+	tw := tar.NewWriter(w)
+
+	for _, entry := range settings.entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		stat, err := os.Stat(entry.source.Abs)
+		if err != nil {
+			return errors.Wrapf(err, "stat %s", entry.source.Rel)
+		}
+
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     entry.name,
+			Size:     stat.Size(),
+			Mode:     int64(entry.mode),
+			ModTime:  settings.modTime,
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if err := copyArchiveEntry(tw, entry, log); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// writeTarXz compresses the tar with xz.
+func writeTarXz(ctx context.Context, w io.Writer, settings *archiveSettings, log loggee.Logger) error {
+	xw, err := xz.NewWriter(w)
+	if err != nil {
+		return err
+	}
+
+	if err := writeTar(ctx, xw, settings, log); err != nil {
+		return err
+	}
+
+	return xw.Close()
+}
+
+func copyArchiveEntry(w io.Writer, entry archiveEntry, log loggee.Logger) error {
+	f, err := os.Open(entry.source.Abs)
+	if err != nil {
+		return errors.Wrapf(err, "open %s", entry.source.Rel)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(w, f); err != nil {
+		return errors.Wrapf(err, "archiving %s", entry.source.Rel)
+	}
+
+	if log != nil {
+		log.Infof("archive %s => %s", entry.source.Rel, entry.name)
+	}
+
+	return nil
+}
+
+func init() {
+	rocket.Default().RegisterTaskTypes(archiveType{})
+}
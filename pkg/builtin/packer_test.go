@@ -0,0 +1,267 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builtin
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nehemming/cirocket/pkg/rocket"
+	"github.com/ulikunitz/xz"
+)
+
+func TestArchiveType(t *testing.T) {
+	var at archiveType
+
+	if at.Type() != "archive" {
+		t.Error("Wrong archive type", at.Type())
+	}
+
+	if at.Description() == "" {
+		t.Error("needs description", at.Type())
+	}
+}
+
+func writeArchiveSources(t *testing.T) string {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"dist/bin/tool":  "tool",
+		"dist/README.md": "readme",
+		"dist/sub/a.txt": "a",
+	}
+
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func launchArchive(t *testing.T, dir string, archive map[string]interface{}) {
+	t.Helper()
+
+	if _, ok := archive["sources"]; !ok {
+		archive["sources"] = []interface{}{filepath.ToSlash(filepath.Join(dir, "dist", "**", "*"))}
+	}
+
+	if err := launchTasks(context.Background(), filepath.Join(dir, "mission.yml"), nil, newTask("archive", "pack", archive)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveZip(t *testing.T) {
+	dir := writeArchiveSources(t)
+	output := filepath.Join(dir, "out", "app.zip")
+
+	launchArchive(t, dir, map[string]interface{}{
+		"output":   output,
+		"prefix":   "app-1.0",
+		"fileMode": "0644",
+		"modes":    map[string]interface{}{"**/bin/*": "0755"},
+	})
+
+	zr, err := zip.OpenReader(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+
+		mode := os.FileMode(0644)
+		if strings.HasSuffix(f.Name, "tool") {
+			mode = 0755
+		}
+		if f.Mode().Perm() != mode {
+			t.Error("unexpected mode", f.Name, f.Mode())
+		}
+
+		if !f.Modified.Equal(time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Error("unexpected time", f.Name, f.Modified)
+		}
+	}
+
+	if n := strings.Join(names, ","); n != "app-1.0/README.md,app-1.0/bin/tool,app-1.0/sub/a.txt" {
+		t.Error("unexpected names", n)
+	}
+}
+
+func readTarNames(t *testing.T, r io.Reader) []string {
+	var names []string
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !header.ModTime.Equal(time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Error("unexpected time", header.Name, header.ModTime)
+		}
+
+		if os.FileMode(header.Mode) != 0600 {
+			t.Error("unexpected mode", header.Name, os.FileMode(header.Mode))
+		}
+
+		names = append(names, header.Name)
+	}
+}
+
+func TestArchiveTarGzReproducible(t *testing.T) {
+	dir := writeArchiveSources(t)
+	first := filepath.Join(dir, "first.tar.gz")
+	second := filepath.Join(dir, "second.tgz")
+
+	for _, output := range []string{first, second} {
+		launchArchive(t, dir, map[string]interface{}{
+			"output":  output,
+			"modTime": "2021-06-01T00:00:00Z",
+		})
+	}
+
+	a, err := os.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(a, b) {
+		t.Error("archives differ")
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := strings.Join(readTarNames(t, gz), ","); n != "README.md,bin/tool,sub/a.txt" {
+		t.Error("unexpected names", n)
+	}
+}
+
+func TestArchiveTarXz(t *testing.T) {
+	dir := writeArchiveSources(t)
+	output := filepath.Join(dir, "app.tar.xz")
+
+	launchArchive(t, dir, map[string]interface{}{
+		"output":  output,
+		"strip":   1,
+		"modTime": "2021-06-01T00:00:00Z",
+	})
+
+	b, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(b, []byte("\xfd7zXZ\x00")) {
+		t.Error("not an xz file")
+	}
+
+	xr, err := xz.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// README.md is stripped away entirely
+	if n := strings.Join(readTarNames(t, xr), ","); n != "a.txt,tool" {
+		t.Error("unexpected names", n)
+	}
+}
+
+func TestArchiveFailureLeavesNoOutput(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "app.tar")
+
+	settings := &archiveSettings{
+		output: output,
+		format: ArchiveTar,
+		entries: []archiveEntry{
+			{source: absRel{Abs: filepath.Join(dir, "missing"), Rel: "missing"}, name: "missing", mode: 0644},
+		},
+	}
+
+	if err := writeArchive(context.Background(), settings, nil); err == nil {
+		t.Error("expected missing source to fail")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 0 {
+		t.Error("expected no output", entries[0].Name())
+	}
+}
+
+func TestGetArchiveFormat(t *testing.T) {
+	tests := map[string]string{
+		"out.zip":     ArchiveZip,
+		"out.tar":     ArchiveTar,
+		"out.tar.gz":  ArchiveTarGz,
+		"out.TGZ":     ArchiveTarGz,
+		"out.tar.xz":  ArchiveTarXz,
+		"out.txz":     ArchiveTarXz,
+		"out.unknown": "",
+	}
+
+	for output, expected := range tests {
+		format, err := getArchiveFormat("", output)
+		if format != expected || (expected == "" && err == nil) {
+			t.Error("unexpected format", output, format, err)
+		}
+	}
+
+	if format, err := getArchiveFormat("tar", "out.zip"); err != nil || format != ArchiveTar {
+		t.Error("format setting should win", format, err)
+	}
+}
+
+func TestArchiveNeedsOutput(t *testing.T) {
+	var at archiveType
+
+	if _, err := at.Prepare(context.Background(), nil, rocket.Task{Definition: map[string]interface{}{}}); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,970 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builtin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/nehemming/cirocket/pkg/rocket"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	// Patch applies its operations, in order, to the yaml, json or toml files matching the file glob specs.
+	// Yaml comments and the key order of all formats are preserved.
+	Patch struct {
+		Files []string `mapstructure:"files"`
+
+		// Exclude are glob patterns of files that are not patched.
+		Exclude []string `mapstructure:"exclude"`
+
+		// IgnoreFiles are .gitignore style files listing further files to exclude.
+		IgnoreFiles []string `mapstructure:"ignoreFiles"`
+
+		// Format is yaml, json or toml.  If blank it is detected from each file's extension or content.
+		Format string `mapstructure:"format"`
+
+		// Document selects a document, counting from 0, of multi document yaml files.  By default all are patched.
+		Document *int `mapstructure:"document"`
+
+		Operations []PatchOperation `mapstructure:"operations"`
+
+		Log bool `mapstructure:"log"`
+	}
+
+	// PatchOperation is a single patch.  Set sets the Value at a key path, creating missing keys, Merge deep merges
+	// a map Value into the map at a key path, Delete removes a key path and Read sets the Variable to the value at a key path.
+	// Key paths are dot separated keys with [n] array indexes, keys containing dots can be quoted, i.e. labels["app.io/name"].
+	PatchOperation struct {
+		Set      string      `mapstructure:"set"`
+		Merge    string      `mapstructure:"merge"`
+		Delete   string      `mapstructure:"delete"`
+		Read     string      `mapstructure:"read"`
+		Value    interface{} `mapstructure:"value"`
+		Variable string      `mapstructure:"variable"`
+	}
+
+	// patchOp is an operation with its key path parsed and value expanded.
+	patchOp struct {
+		kind     string
+		path     []pathSegment
+		value    interface{}
+		variable string
+	}
+
+	// pathSegment is a key or, if index is zero or more, an array index of a key path.
+	pathSegment struct {
+		key   string
+		index int
+	}
+
+	// patchDocument is a loaded document that can be patched.  Set, merge and delete return true if they changed the document.
+	patchDocument interface {
+		get(path []pathSegment) (string, bool, error)
+		set(path []pathSegment, value interface{}) (bool, error)
+		merge(path []pathSegment, value map[string]interface{}) (bool, error)
+		delete(path []pathSegment) (bool, error)
+		encode() ([]byte, error)
+	}
+
+	// yamlDocument holds the nodes of a yaml, or json, document.  Json is written back as json.
+	yamlDocument struct {
+		docs    []*yaml.Node
+		json    bool
+		indent  string
+		spaces  int
+		newline bool
+	}
+
+	// selectedDocument patches one document of a multi document yaml file, encoding them all.
+	selectedDocument struct {
+		*yamlDocument
+		all *yamlDocument
+	}
+
+	patchType struct{}
+)
+
+// Patch operation kinds.
+const (
+	patchSet    = "set"
+	patchMerge  = "merge"
+	patchDelete = "delete"
+	patchRead   = "read"
+)
+
+func (patchType) Type() string {
+	return "patch"
+}
+
+func (patchType) Description() string {
+	return "sets, merges, deletes or reads values at key paths in yaml, json or toml files."
+}
+
+// Prepare loads the tasks configuration and returns the operation function or an error.
+func (patchType) Prepare(ctx context.Context, capComm *rocket.CapComm, task rocket.Task) (rocket.ExecuteFunc, error) {
+	patchCfg := &Patch{}
+
+	if err := mapstructure.WeakDecode(task.Definition, patchCfg); err != nil {
+		return nil, errors.Wrap(err, "parsing patch type")
+	}
+
+	if len(patchCfg.Operations) == 0 {
+		return nil, errors.New("no patch operations specified")
+	}
+
+	switch patchCfg.Format {
+	case "", rocket.FormatYAML, rocket.FormatJSON, rocket.FormatTOML:
+	default:
+		return nil, fmt.Errorf("unknown format %s, use yaml, json or toml", patchCfg.Format)
+	}
+
+	for index, op := range patchCfg.Operations {
+		if _, _, err := op.kind(); err != nil {
+			return nil, errors.Wrapf(err, "operation %d", index)
+		}
+	}
+
+	fn := func(execCtx context.Context) error {
+		ops := make([]*patchOp, 0, len(patchCfg.Operations))
+		for index, op := range patchCfg.Operations {
+			pop, err := op.expand(execCtx, capComm)
+			if err != nil {
+				return errors.Wrapf(err, "operation %d", index)
+			}
+			ops = append(ops, pop)
+		}
+
+		// Expand files
+		specs := make([]string, 0, len(patchCfg.Files))
+		for index, f := range patchCfg.Files {
+			fileSpec, err := capComm.ExpandString(execCtx, "file", f)
+			if err != nil {
+				return errors.Wrapf(err, "expanding file %d", index)
+			}
+			specs = append(specs, fileSpec)
+		}
+
+		ps, err := newPatternSetFromConfig(execCtx, capComm, specs, patchCfg.Exclude, patchCfg.IgnoreFiles)
+		if err != nil {
+			return err
+		}
+
+		matches, _, err := ps.Glob()
+		if err != nil {
+			return err
+		}
+
+		dryRun := rocket.IsDryRun(execCtx)
+		patched := 0
+		for _, m := range matches {
+			if stat, err := os.Stat(m.path); err != nil || stat.IsDir() {
+				continue
+			}
+
+			changed, err := patchFile(capComm, m.path, patchCfg, ops, dryRun)
+			if err != nil {
+				return errors.Wrapf(err, "patching %s", friendlyRelativePath(m.path))
+			}
+
+			if !changed {
+				continue
+			}
+
+			patched++
+			if dryRun {
+				capComm.Log().Infof("would patch %s", friendlyRelativePath(m.path))
+			} else if patchCfg.Log {
+				capComm.Log().Infof("patched %s", friendlyRelativePath(m.path))
+			}
+		}
+
+		if patched > 0 || patchCfg.Log {
+			capComm.Log().Infof("patched %d files", patched)
+		}
+
+		return nil
+	}
+
+	return fn, nil
+}
+
+// kind returns the kind and key path of the operation or an error if its settings conflict.
+func (op PatchOperation) kind() (string, string, error) {
+	var kind, path string
+	for _, k := range []struct{ kind, path string }{
+		{patchSet, op.Set}, {patchMerge, op.Merge}, {patchDelete, op.Delete}, {patchRead, op.Read},
+	} {
+		if k.path == "" {
+			continue
+		}
+		if kind != "" {
+			return "", "", fmt.Errorf("conflicting %s and %s operations", kind, k.kind)
+		}
+		kind, path = k.kind, k.path
+	}
+
+	switch {
+	case kind == "":
+		return "", "", errors.New("specify one of set, merge, delete or read")
+	case (kind == patchSet || kind == patchMerge) && op.Value == nil:
+		return "", "", fmt.Errorf("%s needs a value", kind)
+	case kind == patchMerge && normalizeValue(op.Value) != nil && !isMap(normalizeValue(op.Value)):
+		return "", "", errors.New("merge value must be a map")
+	case kind == patchRead && op.Variable == "":
+		return "", "", errors.New("read needs a variable")
+	case kind != patchRead && op.Variable != "":
+		return "", "", errors.New("variable is only used with read")
+	}
+
+	if _, err := parseKeyPath(path); err != nil {
+		return "", "", err
+	}
+
+	return kind, path, nil
+}
+
+// expand expands the operation's key path and value.
+func (op PatchOperation) expand(ctx context.Context, capComm *rocket.CapComm) (*patchOp, error) {
+	kind, path, err := op.kind()
+	if err != nil {
+		return nil, err
+	}
+
+	if path, err = capComm.ExpandString(ctx, "path", path); err != nil {
+		return nil, errors.Wrap(err, "expanding path")
+	}
+
+	pop := &patchOp{kind: kind, variable: op.Variable}
+	if pop.path, err = parseKeyPath(path); err != nil {
+		return nil, err
+	}
+
+	if pop.value, err = expandValue(ctx, capComm, normalizeValue(op.Value)); err != nil {
+		return nil, err
+	}
+
+	return pop, nil
+}
+
+// patchFile applies the operations to a file, writing it if it changed.  A dry run does not write the file.
+func patchFile(capComm *rocket.CapComm, path string, cfg *Patch, ops []*patchOp, dryRun bool) (bool, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	format := cfg.Format
+	if format == "" {
+		format = rocket.DocumentFormat(path, b)
+	}
+
+	doc, err := loadPatchDocuments(b, format, cfg.Document)
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+	for index, op := range ops {
+		c, err := applyPatchOp(capComm, doc, op)
+		if err != nil {
+			return false, errors.Wrapf(err, "operation %d", index)
+		}
+		changed = changed || c
+	}
+
+	if !changed {
+		return false, nil
+	}
+
+	if err := capComm.CheckSandbox(path); err != nil {
+		return false, err
+	}
+
+	if dryRun {
+		return true, nil
+	}
+
+	out, err := doc.encode()
+	if err != nil {
+		return false, err
+	}
+
+	return true, os.WriteFile(path, out, stat.Mode().Perm())
+}
+
+// loadPatchDocuments loads the document to patch, limited to the selected document of a multi document yaml file.
+func loadPatchDocuments(b []byte, format string, document *int) (patchDocument, error) {
+	if format == rocket.FormatTOML {
+		return newTOMLDocument(b)
+	}
+
+	yd := &yamlDocument{json: format == rocket.FormatJSON, newline: len(b) == 0 || bytes.HasSuffix(b, []byte("\n"))}
+	yd.indent, yd.spaces = detectIndent(b)
+
+	if yd.json {
+		if len(bytes.TrimSpace(b)) > 0 {
+			node, err := decodeJSONDocument(b)
+			if err != nil {
+				return nil, err
+			}
+			yd.docs = append(yd.docs, node)
+		}
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(b))
+		for {
+			node := &yaml.Node{}
+			err := decoder.Decode(node)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			yd.docs = append(yd.docs, node)
+		}
+	}
+
+	if len(yd.docs) == 0 {
+		yd.docs = []*yaml.Node{{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}}
+	}
+
+	if document == nil {
+		return yd, nil
+	}
+
+	if *document < 0 || *document >= len(yd.docs) {
+		return nil, fmt.Errorf("document %d not found", *document)
+	}
+
+	selected := &yamlDocument{docs: []*yaml.Node{yd.docs[*document]}}
+	return &selectedDocument{yamlDocument: selected, all: yd}, nil
+}
+
+// decodeJSONDocument parses json into a yaml document node, keeping the order of object keys and the text of numbers.
+func decodeJSONDocument(b []byte) (*yaml.Node, error) {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+
+	node, err := decodeJSONNode(decoder)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data following the json value")
+	}
+
+	return &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{node}}, nil
+}
+
+// decodeJSONNode reads the next json value from the decoder.
+func decodeJSONNode(decoder *json.Decoder) (*yaml.Node, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := token.(type) {
+	case json.Delim:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		if v == '{' {
+			node = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+
+		for decoder.More() {
+			if node.Kind == yaml.MappingNode {
+				key, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key.(string)})
+			}
+
+			child, err := decodeJSONNode(decoder)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+
+		// closing delimiter
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+		return node, nil
+	case string:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v}, nil
+	case json.Number:
+		tag := "!!int"
+		if strings.ContainsAny(v.String(), ".eE") {
+			tag = "!!float"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: v.String()}, nil
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(v)}, nil
+	default:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+	}
+}
+
+func (sd *selectedDocument) encode() ([]byte, error) {
+	return sd.all.encode()
+}
+
+// applyPatchOp applies an operation to a document.
+func applyPatchOp(capComm *rocket.CapComm, doc patchDocument, op *patchOp) (bool, error) {
+	switch op.kind {
+	case patchSet:
+		return doc.set(op.path, op.value)
+	case patchMerge:
+		m, _ := op.value.(map[string]interface{})
+		return doc.merge(op.path, m)
+	case patchDelete:
+		return doc.delete(op.path)
+	default:
+		value, ok, err := doc.get(op.path)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, fmt.Errorf("%s not found", formatKeyPath(op.path))
+		}
+		capComm.SetLocalVariable(op.variable, value)
+		return false, nil
+	}
+}
+
+// parseKeyPath parses a key path such as spec.containers[0].image or labels["app.io/name"].
+func parseKeyPath(path string) ([]pathSegment, error) {
+	var segments []pathSegment
+
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '.':
+			if i == 0 || i == len(path)-1 || path[i+1] == '.' {
+				return nil, fmt.Errorf("invalid key path %s", path)
+			}
+			i++
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed [ in key path %s", path)
+			}
+
+			inner := path[i+1 : i+end]
+			if len(inner) >= 2 && (inner[0] == '"' || inner[0] == '\'') && inner[len(inner)-1] == inner[0] {
+				segments = append(segments, pathSegment{key: inner[1 : len(inner)-1], index: -1})
+			} else if n, err := strconv.Atoi(inner); err == nil && n >= 0 {
+				segments = append(segments, pathSegment{index: n})
+			} else {
+				return nil, fmt.Errorf("invalid index %s in key path %s", inner, path)
+			}
+			i += end + 1
+		default:
+			end := strings.IndexAny(path[i:], ".[")
+			if end < 0 {
+				end = len(path) - i
+			}
+			segments = append(segments, pathSegment{key: path[i : i+end], index: -1})
+			i += end
+		}
+	}
+
+	if len(segments) == 0 {
+		return nil, errors.New("blank key path")
+	}
+
+	return segments, nil
+}
+
+// formatKeyPath formats a parsed key path.
+func formatKeyPath(path []pathSegment) string {
+	var sb strings.Builder
+	for i, seg := range path {
+		switch {
+		case seg.index >= 0:
+			fmt.Fprintf(&sb, "[%d]", seg.index)
+		case strings.ContainsAny(seg.key, ".[]"):
+			fmt.Fprintf(&sb, "[%q]", seg.key)
+		default:
+			if i > 0 {
+				sb.WriteString(".")
+			}
+			sb.WriteString(seg.key)
+		}
+	}
+
+	return sb.String()
+}
+
+// normalizeValue converts the maps of a decoded mission value to string keyed maps.
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = normalizeValue(e)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = normalizeValue(e)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, 0, len(v))
+		for _, e := range v {
+			l = append(l, normalizeValue(e))
+		}
+		return l
+	case int:
+		return int64(v)
+	case float32:
+		return float64(v)
+	default:
+		return value
+	}
+}
+
+func isMap(value interface{}) bool {
+	_, ok := value.(map[string]interface{})
+	return ok
+}
+
+// sortedKeys returns the keys of a map in order, so new keys are added in a stable order.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// expandValue template expands the strings of a normalized value.
+func expandValue(ctx context.Context, capComm *rocket.CapComm, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return capComm.ExpandString(ctx, "value", v)
+	case map[string]interface{}:
+		for k, e := range v {
+			expanded, err := expandValue(ctx, capComm, e)
+			if err != nil {
+				return nil, errors.Wrapf(err, "value %s", k)
+			}
+			v[k] = expanded
+		}
+	case []interface{}:
+		for i, e := range v {
+			expanded, err := expandValue(ctx, capComm, e)
+			if err != nil {
+				return nil, errors.Wrapf(err, "value %d", i)
+			}
+			v[i] = expanded
+		}
+	}
+
+	return value, nil
+}
+
+// detectIndent returns the indentation of the first indented line, defaulting to two spaces.
+func detectIndent(b []byte) (string, int) {
+	for _, line := range strings.Split(string(b), "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == line || trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "- ") {
+			continue
+		}
+
+		indent := line[:len(line)-len(trimmed)]
+		if strings.Contains(indent, "\t") {
+			return "\t", 2
+		}
+		return indent, len(indent)
+	}
+
+	return "  ", 2
+}
+
+// root returns the root node of a yaml document.
+func root(doc *yaml.Node) *yaml.Node {
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		return doc.Content[0]
+	}
+	return doc
+}
+
+// lookup returns the child node of a node for a segment, and for maps the index of its key.
+func lookup(node *yaml.Node, seg pathSegment) (*yaml.Node, int) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	switch {
+	case seg.index >= 0 && node.Kind == yaml.SequenceNode:
+		if seg.index < len(node.Content) {
+			return node.Content[seg.index], seg.index
+		}
+	case seg.index < 0 && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == seg.key {
+				return node.Content[i+1], i
+			}
+		}
+	}
+
+	return nil, -1
+}
+
+// find returns the node at the path.
+func (yd *yamlDocument) find(path []pathSegment) *yaml.Node {
+	var found []*yaml.Node
+	for _, doc := range yd.docs {
+		node := root(doc)
+		for _, seg := range path {
+			if node, _ = lookup(node, seg); node == nil {
+				break
+			}
+		}
+		if node != nil {
+			found = append(found, node)
+		}
+	}
+
+	if len(found) == 0 {
+		return nil
+	}
+	return found[0]
+}
+
+func (yd *yamlDocument) get(path []pathSegment) (string, bool, error) {
+	node := yd.find(path)
+	if node == nil {
+		return "", false, nil
+	}
+
+	if node.Kind == yaml.ScalarNode {
+		return node.Value, true, nil
+	}
+
+	var v interface{}
+	if err := node.Decode(&v); err != nil {
+		return "", false, err
+	}
+
+	b, err := marshalJSON(normalizeValue(v))
+	return string(b), true, err
+}
+
+// parent returns the parent node of the path's last segment, creating missing maps if create is set.
+func parent(node *yaml.Node, path []pathSegment, create bool) (*yaml.Node, error) {
+	for i, seg := range path[:len(path)-1] {
+		child, _ := lookup(node, seg)
+		if child == nil {
+			if !create {
+				return nil, nil
+			}
+			if seg.index >= 0 || node.Kind != yaml.MappingNode {
+				return nil, fmt.Errorf("%s not found", formatKeyPath(path[:i+1]))
+			}
+			child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			node.Content = append(node.Content, keyNode(seg.key), child)
+		}
+		node = child
+	}
+
+	return node, nil
+}
+
+func keyNode(key string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+}
+
+// valueNode returns the node for a value replacing an existing node.  Strings replacing a scalar of another type
+// keep that type if they can, and comments are kept.
+func valueNode(value interface{}, existing *yaml.Node) (*yaml.Node, error) {
+	node := &yaml.Node{}
+	if err := node.Encode(value); err != nil {
+		return nil, err
+	}
+
+	if existing == nil {
+		return node, nil
+	}
+
+	if s, ok := value.(string); ok && existing.Kind == yaml.ScalarNode && existing.ShortTag() != "!!str" {
+		plain := &yaml.Node{Kind: yaml.ScalarNode, Value: s}
+		if plain.ShortTag() == existing.ShortTag() {
+			node = plain
+		}
+	}
+
+	node.HeadComment = existing.HeadComment
+	node.LineComment = existing.LineComment
+	node.FootComment = existing.FootComment
+	if node.Kind == yaml.ScalarNode && existing.Kind == yaml.ScalarNode && node.Tag == "!!str" && existing.ShortTag() == "!!str" {
+		node.Style = existing.Style
+	}
+
+	return node, nil
+}
+
+func sameNode(a, b *yaml.Node) bool {
+	if a.Kind != b.Kind || a.ShortTag() != b.ShortTag() || a.Value != b.Value || len(a.Content) != len(b.Content) {
+		return false
+	}
+
+	for i := range a.Content {
+		if !sameNode(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (yd *yamlDocument) set(path []pathSegment, value interface{}) (bool, error) {
+	changed := false
+	for _, doc := range yd.docs {
+		c, err := setNode(root(doc), path, value)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || c
+	}
+
+	return changed, nil
+}
+
+func setNode(node *yaml.Node, path []pathSegment, value interface{}) (bool, error) {
+	p, err := parent(node, path, true)
+	if err != nil {
+		return false, err
+	}
+
+	last := path[len(path)-1]
+	existing, index := lookup(p, last)
+
+	replacement, err := valueNode(value, existing)
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case existing != nil && sameNode(existing, replacement):
+		return false, nil
+	case existing != nil && p.Kind == yaml.SequenceNode:
+		p.Content[index] = replacement
+	case existing != nil:
+		p.Content[index+1] = replacement
+	case last.index >= 0 || p.Kind != yaml.MappingNode:
+		return false, fmt.Errorf("%s not found", formatKeyPath(path))
+	default:
+		p.Content = append(p.Content, keyNode(last.key), replacement)
+	}
+
+	return true, nil
+}
+
+func (yd *yamlDocument) merge(path []pathSegment, value map[string]interface{}) (bool, error) {
+	changed := false
+	for _, doc := range yd.docs {
+		c, err := mergeNode(root(doc), path, value)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || c
+	}
+
+	return changed, nil
+}
+
+func mergeNode(node *yaml.Node, path []pathSegment, value map[string]interface{}) (bool, error) {
+	target := node
+	for _, seg := range path {
+		if target, _ = lookup(target, seg); target == nil {
+			break
+		}
+	}
+
+	if target == nil || target.Kind != yaml.MappingNode {
+		return setNode(node, path, value)
+	}
+
+	changed := false
+	for _, key := range sortedKeys(value) {
+		seg := []pathSegment{{key: key, index: -1}}
+		existing, _ := lookup(target, seg[0])
+
+		var c bool
+		var err error
+		if m, ok := value[key].(map[string]interface{}); ok && existing != nil && existing.Kind == yaml.MappingNode {
+			c, err = mergeNode(target, seg, m)
+		} else {
+			c, err = setNode(target, seg, value[key])
+		}
+		if err != nil {
+			return false, err
+		}
+		changed = changed || c
+	}
+
+	return changed, nil
+}
+
+func (yd *yamlDocument) delete(path []pathSegment) (bool, error) {
+	changed := false
+	for _, doc := range yd.docs {
+		p, err := parent(root(doc), path, false)
+		if err != nil || p == nil {
+			continue
+		}
+
+		_, index := lookup(p, path[len(path)-1])
+		switch {
+		case index < 0:
+			continue
+		case p.Kind == yaml.SequenceNode:
+			p.Content = append(p.Content[:index], p.Content[index+1:]...)
+		default:
+			p.Content = append(p.Content[:index], p.Content[index+2:]...)
+		}
+		changed = true
+	}
+
+	return changed, nil
+}
+
+func (yd *yamlDocument) encode() ([]byte, error) {
+	if yd.json {
+		var compact bytes.Buffer
+		if err := writeJSONNode(&compact, root(yd.docs[0])); err != nil {
+			return nil, err
+		}
+
+		var out bytes.Buffer
+		if yd.indent == "" {
+			out = compact
+		} else if err := json.Indent(&out, compact.Bytes(), "", yd.indent); err != nil {
+			return nil, err
+		}
+
+		if yd.newline {
+			out.WriteString("\n")
+		}
+		return out.Bytes(), nil
+	}
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(yd.spaces)
+	for _, doc := range yd.docs {
+		if err := encoder.Encode(doc); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// marshalJSON encodes a value as json without escaping the html characters <, > and &.
+func marshalJSON(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// writeJSONNode writes a yaml node as compact json, keeping the order of keys and the text of numbers.
+func writeJSONNode(w *bytes.Buffer, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.AliasNode:
+		return writeJSONNode(w, node.Alias)
+	case yaml.MappingNode:
+		w.WriteString("{")
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if i > 0 {
+				w.WriteString(",")
+			}
+			key, _ := marshalJSON(node.Content[i].Value)
+			w.Write(key)
+			w.WriteString(":")
+			if err := writeJSONNode(w, node.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		w.WriteString("}")
+	case yaml.SequenceNode:
+		w.WriteString("[")
+		for i, child := range node.Content {
+			if i > 0 {
+				w.WriteString(",")
+			}
+			if err := writeJSONNode(w, child); err != nil {
+				return err
+			}
+		}
+		w.WriteString("]")
+	default:
+		switch node.ShortTag() {
+		case "!!null":
+			w.WriteString("null")
+		case "!!bool", "!!int", "!!float":
+			if json.Valid([]byte(node.Value)) {
+				w.WriteString(node.Value)
+				return nil
+			}
+			fallthrough
+		default:
+			b, err := marshalJSON(node.Value)
+			if err != nil {
+				return err
+			}
+			w.Write(b)
+		}
+	}
+
+	return nil
+}
+
+func init() {
+	rocket.Default().RegisterTaskTypes(patchType{})
+}
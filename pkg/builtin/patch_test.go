@@ -0,0 +1,332 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builtin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nehemming/cirocket/pkg/loggee"
+	"github.com/nehemming/cirocket/pkg/loggee/stdlog"
+	"github.com/nehemming/cirocket/pkg/rocket"
+)
+
+func TestPatchType(t *testing.T) {
+	var pt patchType
+
+	if pt.Type() != "patch" {
+		t.Error("Wrong patch type", pt.Type())
+	}
+
+	if pt.Description() == "" {
+		t.Error("needs description", pt.Type())
+	}
+}
+
+func TestParseKeyPath(t *testing.T) {
+	path, err := parseKeyPath(`spec.containers[1].env["app.io/name"]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(path) != 5 || path[1].key != "containers" || path[2].index != 1 || path[4].key != "app.io/name" {
+		t.Error("unexpected path", path)
+	}
+
+	if s := formatKeyPath(path); s != `spec.containers[1].env["app.io/name"]` {
+		t.Error("unexpected format", s)
+	}
+
+	for _, bad := range []string{"", "a..b", ".a", "a.", "a[", "a[x]", "a[-1]"} {
+		if _, err := parseKeyPath(bad); err == nil {
+			t.Error("expected error", bad)
+		}
+	}
+}
+
+func patchContent(t *testing.T, content, format string, document *int, ops ...*patchOp) string {
+	t.Helper()
+
+	doc, err := loadPatchDocuments([]byte(content), format, document)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, op := range ops {
+		if _, err := applyPatchOp(nil, doc, op); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := doc.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+func mustKeyPath(path string) []pathSegment {
+	p, err := parseKeyPath(path)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func TestPatchYAML(t *testing.T) {
+	content := `# chart values
+image:
+  repository: app # the image
+  tag: "1.0.0"
+replicas: 1
+drop: true
+`
+	patched := patchContent(t, content, rocket.FormatYAML, nil,
+		&patchOp{kind: patchSet, path: mustKeyPath("image.tag"), value: "1.1.0"},
+		&patchOp{kind: patchSet, path: mustKeyPath("replicas"), value: "3"},
+		&patchOp{kind: patchMerge, path: mustKeyPath("resources.limits"), value: map[string]interface{}{"cpu": "500m"}},
+		&patchOp{kind: patchDelete, path: mustKeyPath("drop")},
+	)
+
+	expected := `# chart values
+image:
+  repository: app # the image
+  tag: "1.1.0"
+replicas: 3
+resources:
+  limits:
+    cpu: 500m
+`
+	if patched != expected {
+		t.Errorf("got\n%s\nexpected\n%s", patched, expected)
+	}
+}
+
+func TestPatchYAMLDocuments(t *testing.T) {
+	content := "kind: Deployment\nspec:\n  image: a:1\n---\nkind: Service\n"
+
+	patched := patchContent(t, content, rocket.FormatYAML, nil, &patchOp{kind: patchDelete, path: mustKeyPath("kind")})
+	if patched != "spec:\n  image: a:1\n---\n{}\n" {
+		t.Errorf("unexpected %q", patched)
+	}
+
+	document := 0
+	patched = patchContent(t, content, rocket.FormatYAML, &document, &patchOp{kind: patchSet, path: mustKeyPath("spec.image"), value: "a:2"})
+	if patched != "kind: Deployment\nspec:\n  image: a:2\n---\nkind: Service\n" {
+		t.Errorf("unexpected %q", patched)
+	}
+
+	document = 2
+	if _, err := loadPatchDocuments([]byte(content), rocket.FormatYAML, &document); err == nil {
+		t.Error("expected missing document error")
+	}
+}
+
+func TestPatchJSON(t *testing.T) {
+	content := "{\n    \"name\": \"app\",\n    \"version\": \"1.0.0\",\n    \"scripts\": {\"test\": \"go test\"},\n    \"big\": 12345678901234567890,\n    \"ratio\": 1.50\n}\n"
+
+	patched := patchContent(t, content, rocket.FormatJSON, nil,
+		&patchOp{kind: patchSet, path: mustKeyPath("version"), value: "1.1.0"},
+		&patchOp{kind: patchSet, path: mustKeyPath("scripts.build"), value: "go build"},
+		&patchOp{kind: patchSet, path: mustKeyPath("private"), value: true},
+	)
+
+	expected := "{\n    \"name\": \"app\",\n    \"version\": \"1.1.0\",\n    \"scripts\": {\n        \"test\": \"go test\",\n        \"build\": \"go build\"\n    },\n" +
+		"    \"big\": 12345678901234567890,\n    \"ratio\": 1.50,\n    \"private\": true\n}\n"
+	if patched != expected {
+		t.Errorf("got\n%s\nexpected\n%s", patched, expected)
+	}
+}
+
+func TestPatchTOML(t *testing.T) {
+	content := "name = \"app\"\nversion = \"1.0.0\"\n\n[build]\ntarget = \"linux\"\n"
+
+	patched := patchContent(t, content, rocket.FormatTOML, nil,
+		&patchOp{kind: patchSet, path: mustKeyPath("version"), value: "1.1.0"},
+		&patchOp{kind: patchMerge, path: mustKeyPath("build"), value: map[string]interface{}{"arch": "amd64"}},
+	)
+
+	expected := "name = \"app\"\nversion = \"1.1.0\"\n\n[build]\ntarget = \"linux\"\narch = \"amd64\"\n"
+	if patched != expected {
+		t.Errorf("got\n%s\nexpected\n%s", patched, expected)
+	}
+
+	doc, _ := loadPatchDocuments([]byte(content), rocket.FormatTOML, nil)
+	if _, err := doc.set(mustKeyPath("list[0]"), "x"); err == nil {
+		t.Error("expected index error")
+	}
+}
+
+func TestPatchTOMLKeepsComments(t *testing.T) {
+	content := "# app settings\nname = \"app\" # the name\n\n# package details\n[package]\nversion = \"1.0.0\"\n" +
+		"deps = { log = \"1.0\" }\n\n[package.meta]\nowner = \"me\"\n\n[[bin]]\nname = \"cli\"\n"
+
+	patched := patchContent(t, content, rocket.FormatTOML, nil,
+		&patchOp{kind: patchSet, path: mustKeyPath("version"), value: "2.0.0"},
+		&patchOp{kind: patchSet, path: mustKeyPath("name"), value: "tool"},
+		&patchOp{kind: patchSet, path: mustKeyPath("package.version"), value: "1.1.0"},
+		&patchOp{kind: patchSet, path: mustKeyPath("package.deps.fmt"), value: "2.0"},
+		&patchOp{kind: patchMerge, path: mustKeyPath("package"), value: map[string]interface{}{"edition": 2021}},
+		&patchOp{kind: patchDelete, path: mustKeyPath("package.meta")},
+	)
+
+	expected := "# app settings\nname = \"tool\" # the name\nversion = \"2.0.0\"\n\n# package details\n[package]\nversion = \"1.1.0\"\n" +
+		"deps = { log = \"1.0\", fmt = \"2.0\" }\nedition = 2021\n\n\n[[bin]]\nname = \"cli\"\n"
+	if patched != expected {
+		t.Errorf("got\n%s\nexpected\n%s", patched, expected)
+	}
+
+	doc, err := loadPatchDocuments([]byte(patched), rocket.FormatTOML, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, _ := doc.get(mustKeyPath("version")); !ok || v != "2.0.0" {
+		t.Errorf("root version %s %v", v, ok)
+	}
+	if v, ok, _ := doc.get(mustKeyPath("package.version")); !ok || v != "1.1.0" {
+		t.Errorf("package version %s %v", v, ok)
+	}
+
+	rootOnly := patchContent(t, "# settings\n[build]\ntarget = \"linux\"\n", rocket.FormatTOML, nil,
+		&patchOp{kind: patchSet, path: mustKeyPath("name"), value: "app"},
+	)
+	if expected := "name = \"app\"\n\n# settings\n[build]\ntarget = \"linux\"\n"; rootOnly != expected {
+		t.Errorf("got\n%s\nexpected\n%s", rootOnly, expected)
+	}
+
+	if _, err := doc.set(mustKeyPath("bin.path"), "x"); err == nil {
+		t.Error("expected array of tables error")
+	}
+}
+
+func TestPatchTOMLInlineTableOrder(t *testing.T) {
+	content := "[dependencies]\nserde = { version = \"1.0\", features = [\"derive\"], optional = true } # serde\n"
+
+	patched := patchContent(t, content, rocket.FormatTOML, nil,
+		&patchOp{kind: patchSet, path: mustKeyPath("dependencies.serde.version"), value: "2.0.0"},
+		&patchOp{kind: patchDelete, path: mustKeyPath("dependencies.serde.optional")},
+		&patchOp{kind: patchSet, path: mustKeyPath("dependencies.serde.package"), value: "serde2"},
+	)
+
+	expected := "[dependencies]\nserde = { version = \"2.0.0\", features = [\"derive\"], package = \"serde2\" } # serde\n"
+	if patched != expected {
+		t.Errorf("got\n%s\nexpected\n%s", patched, expected)
+	}
+}
+
+func TestPatchJSONSurrogatePair(t *testing.T) {
+	content := "{\n  \"smile\": \"\\ud83d\\ude00\",\n  \"version\": \"1.0.0\",\n  \"alpha\": 1e3\n}\n"
+
+	patched := patchContent(t, content, rocket.FormatJSON, nil,
+		&patchOp{kind: patchSet, path: mustKeyPath("version"), value: "1.1.0"},
+	)
+
+	expected := "{\n  \"smile\": \"\U0001F600\",\n  \"version\": \"1.1.0\",\n  \"alpha\": 1e3\n}\n"
+	if patched != expected {
+		t.Errorf("got\n%s\nexpected\n%s", patched, expected)
+	}
+
+	if _, err := loadPatchDocuments([]byte("{} {}"), rocket.FormatJSON, nil); err == nil {
+		t.Error("expected trailing data error")
+	}
+}
+
+func TestPatchJSONNoHTMLEscape(t *testing.T) {
+	patched := patchContent(t, "{\n  \"url\": \"a\"\n}\n", rocket.FormatJSON, nil,
+		&patchOp{kind: patchSet, path: mustKeyPath("url"), value: "http://x/?a=1&b=<2>"},
+	)
+
+	if expected := "{\n  \"url\": \"http://x/?a=1&b=<2>\"\n}\n"; patched != expected {
+		t.Errorf("got %s expected %s", patched, expected)
+	}
+}
+
+func TestPatchRun(t *testing.T) {
+	dir := t.TempDir()
+	writeCopyFiles(t, dir, map[string]string{
+		"package.json":       "{\n  \"name\": \"app\",\n  \"version\": \"2.0.0\"\n}\n",
+		"chart/values.yaml":  "image:\n  tag: latest\n",
+		"chart/Chart.yaml":   "version: 0.1.0\n",
+		"chart/unchanged.md": "readme",
+	})
+
+	loggee.SetLogger(stdlog.New())
+
+	mc := rocket.NewMissionControl()
+	RegisterAll(mc)
+
+	mission := map[string]interface{}{
+		"stages": []interface{}{
+			map[string]interface{}{
+				"name": "release",
+				"tasks": []interface{}{
+					map[string]interface{}{
+						"type":       "patch",
+						"name":       "read version",
+						"files":      []interface{}{filepath.ToSlash(dir) + "/package.json"},
+						"operations": []interface{}{map[string]interface{}{"read": "version", "variable": "appVersion"}},
+						"export":     []interface{}{"appVersion"},
+					},
+					map[string]interface{}{
+						"type":  "patch",
+						"name":  "bump chart",
+						"log":   true,
+						"files": []interface{}{filepath.ToSlash(dir) + "/chart/*.yaml"},
+						"operations": []interface{}{
+							map[string]interface{}{"set": "image.tag", "value": "{{ .Var.appVersion }}"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if err := mc.LaunchMission(context.Background(), filepath.Join(dir, "mission.yml"), mission); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"package.json":      "{\n  \"name\": \"app\",\n  \"version\": \"2.0.0\"\n}\n",
+		"chart/values.yaml": "image:\n  tag: 2.0.0\n",
+		"chart/Chart.yaml":  "version: 0.1.0\nimage:\n  tag: 2.0.0\n",
+	}
+	for name, content := range expected {
+		if b, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(b) != content {
+			t.Errorf("%s: got %q %v", name, string(b), err)
+		}
+	}
+}
+
+func TestPatchOperationKind(t *testing.T) {
+	invalid := []PatchOperation{
+		{},
+		{Set: "a"},
+		{Set: "a", Delete: "b", Value: 1},
+		{Merge: "a", Value: "x"},
+		{Read: "a"},
+		{Delete: "a", Variable: "v"},
+		{Delete: "a..b"},
+	}
+	for _, op := range invalid {
+		if _, _, err := op.kind(); err == nil {
+			t.Error("expected error", op)
+		}
+	}
+}
@@ -0,0 +1,821 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builtin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml"
+	"github.com/pkg/errors"
+)
+
+type (
+	// tomlDocument patches the text of a toml file, keeping its comments and layout.  The tree parsed from the
+	// text is used to read values, both are reloaded after each edit.
+	tomlDocument struct {
+		text    []byte
+		tree    *toml.Tree
+		entries []tomlEntry
+	}
+
+	// tomlEntry is a table header or key value line of a toml file.  Start and end are the offsets of its
+	// lines, end following the line ending, and the value is between valueStart and valueEnd.
+	// Array is true for the headers of arrays of tables and the keys within them.
+	tomlEntry struct {
+		path       []string
+		header     bool
+		array      bool
+		start      int
+		end        int
+		valueStart int
+		valueEnd   int
+	}
+)
+
+var bareTOMLKeyRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// newTOMLDocument loads the text of a toml file.
+func newTOMLDocument(b []byte) (*tomlDocument, error) {
+	td := &tomlDocument{}
+	if err := td.load(b); err != nil {
+		return nil, err
+	}
+
+	return td, nil
+}
+
+// load parses the text, replacing the tree and entries of the document.
+func (td *tomlDocument) load(b []byte) error {
+	tree, err := toml.LoadBytes(b)
+	if err != nil {
+		return err
+	}
+
+	entries, err := scanTOML(b)
+	if err != nil {
+		return err
+	}
+
+	td.text, td.tree, td.entries = b, tree, entries
+	return nil
+}
+
+// replace replaces the text of each range with the replacement and reloads the document.
+func (td *tomlDocument) replace(ranges [][2]int, replacement string) error {
+	var b bytes.Buffer
+	offset := 0
+	for _, r := range ranges {
+		b.Write(td.text[offset:r[0]])
+		b.WriteString(replacement)
+		offset = r[1]
+	}
+	b.Write(td.text[offset:])
+
+	if err := td.load(b.Bytes()); err != nil {
+		return errors.Wrap(err, "patched toml is invalid")
+	}
+
+	return nil
+}
+
+// newline returns the line ending used by the text.
+func (td *tomlDocument) newline() string {
+	if bytes.Contains(td.text, []byte("\r\n")) {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// find returns the key value entry with the path, keys within arrays of tables are not found.
+func (td *tomlDocument) find(keys []string) *tomlEntry {
+	for i := range td.entries {
+		e := &td.entries[i]
+		if !e.header && !e.array && equalTOMLKeys(e.path, keys) {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// findAncestor returns the key value entry whose value, such as an inline table, holds the path.
+func (td *tomlDocument) findAncestor(keys []string) *tomlEntry {
+	for i := range td.entries {
+		e := &td.entries[i]
+		if !e.header && !e.array && len(e.path) < len(keys) && hasTOMLKeyPrefix(keys, e.path) {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// tomlKeys returns the toml keys of a path, toml arrays are not supported.
+func tomlKeys(path []pathSegment) ([]string, error) {
+	keys := make([]string, 0, len(path))
+	for _, seg := range path {
+		if seg.index >= 0 {
+			return nil, fmt.Errorf("array indexes are not supported in toml key paths, %s", formatKeyPath(path))
+		}
+		keys = append(keys, seg.key)
+	}
+
+	return keys, nil
+}
+
+func (td *tomlDocument) get(path []pathSegment) (string, bool, error) {
+	keys, err := tomlKeys(path)
+	if err != nil || !td.tree.HasPath(keys) {
+		return "", false, err
+	}
+
+	switch v := td.tree.GetPath(keys).(type) {
+	case *toml.Tree:
+		b, err := marshalJSON(v.ToMap())
+		return string(b), true, err
+	case []interface{}, []*toml.Tree:
+		b, err := marshalJSON(v)
+		return string(b), true, err
+	default:
+		return fmt.Sprint(v), true, nil
+	}
+}
+
+// set replaces the value of an existing key in place.  New keys are added to the table holding them and
+// tables are replaced by the value.
+func (td *tomlDocument) set(path []pathSegment, value interface{}) (bool, error) {
+	keys, err := tomlKeys(path)
+	if err != nil {
+		return false, err
+	}
+
+	if td.tree.HasPath(keys) && reflect.DeepEqual(normalizeValue(toMap(td.tree.GetPath(keys))), normalizeValue(value)) {
+		return false, nil
+	}
+
+	rendered, err := renderTOMLValue(value)
+	if err != nil {
+		return false, errors.Wrap(err, formatKeyPath(path))
+	}
+
+	if entry := td.find(keys); entry != nil {
+		return true, td.replace([][2]int{{entry.valueStart, entry.valueEnd}}, rendered)
+	}
+
+	if ancestor := td.findAncestor(keys); ancestor != nil {
+		return true, td.rewriteAncestor(ancestor, keys, value, false)
+	}
+
+	if td.tree.HasPath(keys) {
+		if _, err := td.delete(path); err != nil {
+			return false, err
+		}
+	}
+
+	return true, td.insert(keys, rendered)
+}
+
+func toMap(value interface{}) interface{} {
+	if t, ok := value.(*toml.Tree); ok {
+		return t.ToMap()
+	}
+	return value
+}
+
+// insert adds a key after the last key of the deepest table, with a header, holding it.  The rest of the path
+// is written as a dotted key.  Keys of the root table are added before the first table header.
+func (td *tomlDocument) insert(keys []string, rendered string) error {
+	header := -1
+	for i, e := range td.entries {
+		if !e.header || len(e.path) >= len(keys) || !hasTOMLKeyPrefix(keys, e.path) {
+			continue
+		}
+		if e.array {
+			return fmt.Errorf("%s is within an array of tables", strings.Join(keys, "."))
+		}
+		if header < 0 || len(e.path) > len(td.entries[header].path) {
+			header = i
+		}
+	}
+
+	prefix := 0
+	if header >= 0 {
+		prefix = len(td.entries[header].path)
+	}
+
+	newline := td.newline()
+	line := dottedTOMLKey(keys[prefix:]) + " = " + rendered + newline
+
+	offset, beforeHeader := td.tableEnd(header)
+	if offset > 0 && td.text[offset-1] != '\n' {
+		line = newline + line
+	}
+	if beforeHeader {
+		line += newline
+	}
+
+	return td.replace([][2]int{{offset, offset}}, line)
+}
+
+// tableEnd returns the offset following the last key of the table with the header entry, or of the root table
+// if header is -1.  A table without keys ends after its header, and a root table without keys before the comment
+// lines above the first header, in which case true is returned.
+func (td *tomlDocument) tableEnd(header int) (int, bool) {
+	last := -1
+	for i := header + 1; i < len(td.entries) && !td.entries[i].header; i++ {
+		last = i
+	}
+
+	switch {
+	case last >= 0:
+		return td.entries[last].end, false
+	case header >= 0:
+		return td.entries[header].end, false
+	case len(td.entries) == 0:
+		return len(td.text), false
+	default:
+		return commentBlockStart(td.text, td.entries[0].start), true
+	}
+}
+
+// rewriteAncestor sets, or removes, the path within the inline table value of the entry, editing the
+// inline table in place so its other keys keep their order and layout.
+func (td *tomlDocument) rewriteAncestor(entry *tomlEntry, keys []string, value interface{}, remove bool) error {
+	table, err := editInlineTOMLTable(td.text[entry.valueStart:entry.valueEnd], entry.path, keys[len(entry.path):], value, remove)
+	if err != nil {
+		return err
+	}
+
+	return td.replace([][2]int{{entry.valueStart, entry.valueEnd}}, string(table))
+}
+
+// inlineTOMLEntry is a key value of an inline table, start is the offset of its key.
+type inlineTOMLEntry struct {
+	path       []string
+	start      int
+	valueStart int
+	valueEnd   int
+}
+
+// editInlineTOMLTable sets, or removes, the keys within the text of the inline table of the path.  New keys are
+// added after the existing keys.
+func editInlineTOMLTable(table []byte, path, keys []string, value interface{}, remove bool) ([]byte, error) {
+	if len(table) == 0 || table[0] != '{' {
+		return nil, fmt.Errorf("%s is not a table", strings.Join(path, "."))
+	}
+
+	entries, err := scanInlineTOMLTable(table)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if len(e.path) < len(keys) && hasTOMLKeyPrefix(keys, e.path) {
+			inner, err := editInlineTOMLTable(table[e.valueStart:e.valueEnd],
+				append(append([]string{}, path...), e.path...), keys[len(e.path):], value, remove)
+			if err != nil {
+				return nil, err
+			}
+			return spliceBytes(table, e.valueStart, e.valueEnd, inner), nil
+		}
+	}
+
+	if remove {
+		return removeInlineTOMLEntries(table, entries, keys), nil
+	}
+
+	rendered, err := renderTOMLValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if equalTOMLKeys(e.path, keys) {
+			return spliceBytes(table, e.valueStart, e.valueEnd, []byte(rendered)), nil
+		}
+	}
+
+	// dotted keys within the replaced key are removed before it is added
+	table = removeInlineTOMLEntries(table, entries, keys)
+	if entries, err = scanInlineTOMLTable(table); err != nil {
+		return nil, err
+	}
+
+	item := []byte(dottedTOMLKey(keys) + " = " + rendered)
+	if len(entries) == 0 {
+		return append(append([]byte("{ "), item...), " }"...), nil
+	}
+
+	last := entries[len(entries)-1].valueEnd
+	return spliceBytes(table, last, last, append([]byte(", "), item...)), nil
+}
+
+// removeInlineTOMLEntries removes the entries of the keys, and of any keys within them, from the inline table.
+func removeInlineTOMLEntries(table []byte, entries []inlineTOMLEntry, keys []string) []byte {
+	var kept []inlineTOMLEntry
+	for _, e := range entries {
+		if !hasTOMLKeyPrefix(e.path, keys) {
+			kept = append(kept, e)
+		}
+	}
+
+	switch {
+	case len(kept) == len(entries):
+		return table
+	case len(kept) == 0:
+		return []byte("{}")
+	}
+
+	var b bytes.Buffer
+	b.Write(table[:entries[0].start])
+	for i, e := range kept {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.Write(table[e.start:e.valueEnd])
+	}
+	b.Write(table[entries[len(entries)-1].valueEnd:])
+	return b.Bytes()
+}
+
+// scanInlineTOMLTable finds the key values of the inline table text.
+func scanInlineTOMLTable(b []byte) ([]inlineTOMLEntry, error) {
+	var entries []inlineTOMLEntry
+	i := skipTOMLSpace(b, 1)
+	for i < len(b) && b[i] != '}' {
+		start := i
+		keys, j, err := scanTOMLKey(b, i)
+		if err != nil {
+			return nil, err
+		}
+
+		j = skipTOMLSpace(b, j)
+		if j >= len(b) || b[j] != '=' {
+			return nil, fmt.Errorf("expected = at offset %d", j)
+		}
+
+		valueStart := skipTOMLSpace(b, j+1)
+		valueEnd, err := scanInlineTOMLValue(b, valueStart)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, inlineTOMLEntry{path: keys, start: start, valueStart: valueStart, valueEnd: valueEnd})
+
+		i = skipTOMLSpace(b, valueEnd)
+		if i < len(b) && b[i] == ',' {
+			i = skipTOMLSpace(b, i+1)
+		}
+	}
+
+	if i >= len(b) {
+		return nil, errors.New("unterminated inline table")
+	}
+
+	return entries, nil
+}
+
+// scanInlineTOMLValue returns the offset following the value, within an inline table, starting at i.
+func scanInlineTOMLValue(b []byte, i int) (int, error) {
+	if i < len(b) && strings.IndexByte(`"'[{`, b[i]) >= 0 {
+		return scanTOMLValue(b, i)
+	}
+
+	j := i
+	for j < len(b) && b[j] != ',' && b[j] != '}' {
+		j++
+	}
+	for j > i && (b[j-1] == ' ' || b[j-1] == '\t') {
+		j--
+	}
+	if j == i {
+		return 0, fmt.Errorf("expected a value at offset %d", i)
+	}
+	return j, nil
+}
+
+// spliceBytes returns a copy of b with the range from start to end replaced.
+func spliceBytes(b []byte, start, end int, replacement []byte) []byte {
+	result := make([]byte, 0, len(b)-(end-start)+len(replacement))
+	result = append(result, b[:start]...)
+	result = append(result, replacement...)
+	return append(result, b[end:]...)
+}
+
+func (td *tomlDocument) merge(path []pathSegment, value map[string]interface{}) (bool, error) {
+	changed := false
+	for _, key := range sortedKeys(value) {
+		child := append(append([]pathSegment{}, path...), pathSegment{key: key, index: -1})
+		keys, err := tomlKeys(child)
+		if err != nil {
+			return false, err
+		}
+
+		var c bool
+		if m, ok := value[key].(map[string]interface{}); ok {
+			if _, isTree := td.tree.GetPath(keys).(*toml.Tree); isTree {
+				if c, err = td.merge(child, m); err != nil {
+					return false, err
+				}
+				changed = changed || c
+				continue
+			}
+		}
+
+		if c, err = td.set(child, value[key]); err != nil {
+			return false, err
+		}
+		changed = changed || c
+	}
+
+	return changed, nil
+}
+
+// delete removes the lines of the key, or of a table with its keys and sub tables.
+func (td *tomlDocument) delete(path []pathSegment) (bool, error) {
+	keys, err := tomlKeys(path)
+	if err != nil || !td.tree.HasPath(keys) {
+		return false, err
+	}
+
+	var ranges [][2]int
+	for _, e := range td.entries {
+		if hasTOMLKeyPrefix(e.path, keys) {
+			ranges = append(ranges, [2]int{e.start, e.end})
+		}
+	}
+
+	if len(ranges) > 0 {
+		return true, td.replace(ranges, "")
+	}
+
+	if ancestor := td.findAncestor(keys); ancestor != nil {
+		return true, td.rewriteAncestor(ancestor, keys, nil, true)
+	}
+
+	return false, fmt.Errorf("cannot delete %s", formatKeyPath(path))
+}
+
+func (td *tomlDocument) encode() ([]byte, error) {
+	return td.text, nil
+}
+
+func equalTOMLKeys(a, b []string) bool {
+	return len(a) == len(b) && hasTOMLKeyPrefix(a, b)
+}
+
+// hasTOMLKeyPrefix returns true if the path starts with the keys of the prefix.
+func hasTOMLKeyPrefix(path, prefix []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+
+	for i, key := range prefix {
+		if path[i] != key {
+			return false
+		}
+	}
+
+	return true
+}
+
+// dottedTOMLKey writes the keys as a dotted key, quoting the keys that are not bare.
+func dottedTOMLKey(keys []string) string {
+	quoted := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if bareTOMLKeyRegex.MatchString(key) {
+			quoted = append(quoted, key)
+			continue
+		}
+
+		b, _ := marshalJSON(key)
+		quoted = append(quoted, string(b))
+	}
+
+	return strings.Join(quoted, ".")
+}
+
+// renderTOMLValue writes a value as an inline toml value, maps are written as inline tables.
+func renderTOMLValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", errors.New("toml has no null value")
+	case string:
+		b, err := marshalJSON(v)
+		return string(b), err
+	case bool:
+		return strconv.FormatBool(v), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case *toml.Tree:
+		return renderTOMLValue(v.ToMap())
+	case fmt.Stringer:
+		// toml local dates and times
+		return v.String(), nil
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return formatTOMLFloat(rv.Float()), nil
+	case reflect.Slice, reflect.Array:
+		items := make([]string, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			item, err := renderTOMLValue(rv.Index(i).Interface())
+			if err != nil {
+				return "", err
+			}
+			items = append(items, item)
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case reflect.Map:
+		keys := make([]string, 0, rv.Len())
+		values := make(map[string]interface{}, rv.Len())
+		for _, k := range rv.MapKeys() {
+			key := fmt.Sprint(k.Interface())
+			keys = append(keys, key)
+			values[key] = rv.MapIndex(k).Interface()
+		}
+		sort.Strings(keys)
+
+		if len(keys) == 0 {
+			return "{}", nil
+		}
+
+		items := make([]string, 0, len(keys))
+		for _, key := range keys {
+			item, err := renderTOMLValue(values[key])
+			if err != nil {
+				return "", err
+			}
+			items = append(items, dottedTOMLKey([]string{key})+" = "+item)
+		}
+		return "{ " + strings.Join(items, ", ") + " }", nil
+	}
+
+	return "", fmt.Errorf("%T values are not supported in toml", value)
+}
+
+func formatTOMLFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "nan"
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+
+	return s
+}
+
+// scanTOML finds the table headers and key value entries of toml text.  The text must be valid toml.
+func scanTOML(b []byte) ([]tomlEntry, error) {
+	var entries []tomlEntry
+	var table []string
+	array := false
+
+	for i := 0; i < len(b); {
+		start := i
+		i = skipTOMLSpace(b, i)
+
+		switch {
+		case i >= len(b):
+			return entries, nil
+		case b[i] == '\r' || b[i] == '\n' || b[i] == '#':
+			i = tomlLineEnd(b, i)
+		case b[i] == '[':
+			array = bytes.HasPrefix(b[i:], []byte("[["))
+			j := i + 1
+			if array {
+				j++
+			}
+
+			keys, j, err := scanTOMLKey(b, j)
+			if err != nil {
+				return nil, err
+			}
+
+			table = keys
+			i = tomlLineEnd(b, j)
+			entries = append(entries, tomlEntry{path: keys, header: true, array: array, start: start, end: i})
+		default:
+			keys, j, err := scanTOMLKey(b, i)
+			if err != nil {
+				return nil, err
+			}
+
+			j = skipTOMLSpace(b, j)
+			if j >= len(b) || b[j] != '=' {
+				return nil, fmt.Errorf("expected = at offset %d", j)
+			}
+
+			valueStart := skipTOMLSpace(b, j+1)
+			valueEnd, err := scanTOMLValue(b, valueStart)
+			if err != nil {
+				return nil, err
+			}
+
+			i = tomlLineEnd(b, valueEnd)
+			entries = append(entries, tomlEntry{
+				path:       append(append([]string{}, table...), keys...),
+				array:      array,
+				start:      start,
+				end:        i,
+				valueStart: valueStart,
+				valueEnd:   valueEnd,
+			})
+		}
+	}
+
+	return entries, nil
+}
+
+func skipTOMLSpace(b []byte, i int) int {
+	for i < len(b) && (b[i] == ' ' || b[i] == '\t') {
+		i++
+	}
+	return i
+}
+
+// tomlLineEnd returns the offset following the end of the line.
+func tomlLineEnd(b []byte, i int) int {
+	if n := bytes.IndexByte(b[i:], '\n'); n >= 0 {
+		return i + n + 1
+	}
+	return len(b)
+}
+
+// commentBlockStart returns the start of the comment lines directly above the line starting at offset.
+func commentBlockStart(b []byte, offset int) int {
+	for offset > 0 {
+		prev := bytes.LastIndexByte(b[:offset-1], '\n') + 1
+		if !bytes.HasPrefix(bytes.TrimLeft(b[prev:offset], " \t"), []byte("#")) {
+			break
+		}
+		offset = prev
+	}
+
+	return offset
+}
+
+// scanTOMLKey reads a dotted key of bare and quoted keys.
+func scanTOMLKey(b []byte, i int) ([]string, int, error) {
+	var keys []string
+	for {
+		i = skipTOMLSpace(b, i)
+		if i >= len(b) {
+			return nil, i, errors.New("unexpected end of toml key")
+		}
+
+		switch b[i] {
+		case '"':
+			end, err := scanTOMLString(b, i)
+			if err != nil {
+				return nil, i, err
+			}
+
+			var key string
+			if err := json.Unmarshal(b[i:end], &key); err != nil {
+				return nil, i, errors.Wrapf(err, "key at offset %d", i)
+			}
+			keys = append(keys, key)
+			i = end
+		case '\'':
+			end, err := scanTOMLString(b, i)
+			if err != nil {
+				return nil, i, err
+			}
+			keys = append(keys, string(b[i+1:end-1]))
+			i = end
+		default:
+			j := i
+			for j < len(b) && bareTOMLKeyRegex.Match(b[j:j+1]) {
+				j++
+			}
+			if j == i {
+				return nil, i, fmt.Errorf("expected a key at offset %d", i)
+			}
+			keys = append(keys, string(b[i:j]))
+			i = j
+		}
+
+		i = skipTOMLSpace(b, i)
+		if i >= len(b) || b[i] != '.' {
+			return keys, i, nil
+		}
+		i++
+	}
+}
+
+// scanTOMLString returns the offset following the basic, literal or multi-line string starting at i.
+func scanTOMLString(b []byte, i int) (int, error) {
+	quote := b[i]
+	delim := []byte{quote, quote, quote}
+
+	if bytes.HasPrefix(b[i:], delim) {
+		for j := i + 3; j < len(b); j++ {
+			if quote == '"' && b[j] == '\\' {
+				j++
+				continue
+			}
+
+			if bytes.HasPrefix(b[j:], delim) {
+				// up to two quotes can precede the closing delimiter
+				end := j + 3
+				for n := 0; n < 2 && end < len(b) && b[end] == quote; n++ {
+					end++
+				}
+				return end, nil
+			}
+		}
+	} else {
+		for j := i + 1; j < len(b) && b[j] != '\n'; j++ {
+			if quote == '"' && b[j] == '\\' {
+				j++
+				continue
+			}
+
+			if b[j] == quote {
+				return j + 1, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("unterminated string at offset %d", i)
+}
+
+// scanTOMLValue returns the offset following the value starting at i.
+func scanTOMLValue(b []byte, i int) (int, error) {
+	if i >= len(b) {
+		return 0, errors.New("unexpected end of toml value")
+	}
+
+	switch b[i] {
+	case '"', '\'':
+		return scanTOMLString(b, i)
+	case '[', '{':
+		depth := 0
+		for j := i; j < len(b); j++ {
+			switch b[j] {
+			case '"', '\'':
+				end, err := scanTOMLString(b, j)
+				if err != nil {
+					return 0, err
+				}
+				j = end - 1
+			case '#':
+				j = tomlLineEnd(b, j) - 1
+			case '[', '{':
+				depth++
+			case ']', '}':
+				depth--
+				if depth == 0 {
+					return j + 1, nil
+				}
+			}
+		}
+		return 0, fmt.Errorf("unterminated value at offset %d", i)
+	default:
+		j := i
+		for j < len(b) && b[j] != '#' && b[j] != '\r' && b[j] != '\n' {
+			j++
+		}
+		for j > i && (b[j-1] == ' ' || b[j-1] == '\t') {
+			j--
+		}
+		return j, nil
+	}
+}
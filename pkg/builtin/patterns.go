@@ -0,0 +1,305 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builtin
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	globber "github.com/bmatcuk/doublestar/v4"
+	"github.com/nehemming/cirocket/pkg/rocket"
+	"github.com/pkg/errors"
+)
+
+type (
+	// patternSet is an ordered list of glob patterns.  Patterns prefixed with ! exclude the paths they match,
+	// the last pattern matching a path decides if it is included.  Excluding a directory excludes its contents.
+	patternSet struct {
+		specs []string
+		rules []patternRule
+	}
+
+	patternRule struct {
+		pattern string
+		exclude bool
+		dirOnly bool
+	}
+
+	// patternMatch is a path matched by a pattern set and the base directory of the matching glob.
+	// The name is the path as globbed, relative if the pattern was relative.
+	patternMatch struct {
+		path string
+		base string
+		name string
+	}
+)
+
+// newPatternSet creates a pattern set from the source patterns, followed by the rules of the ignore files
+// and then the exclude patterns.  Missing ignore files are skipped.
+func newPatternSet(patterns, exclude, ignoreFiles []string) (*patternSet, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	ps := &patternSet{}
+
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "!") {
+			if err := ps.addRule(cwd, pattern[1:], true, false); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		clean := filepath.Clean(filepath.FromSlash(pattern))
+		ps.specs = append(ps.specs, clean)
+		if err := ps.addRule(cwd, pattern, false, true); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, ignoreFile := range ignoreFiles {
+		if err := ps.addIgnoreFile(ignoreFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, pattern := range exclude {
+		if err := ps.addRule(cwd, pattern, true, false); err != nil {
+			return nil, err
+		}
+	}
+
+	return ps, nil
+}
+
+// newPatternSetFromConfig expands the exclude patterns and ignore file names of a task's configuration
+// before creating a pattern set.  The source patterns should already be expanded.
+func newPatternSetFromConfig(ctx context.Context, capComm *rocket.CapComm, patterns, exclude, ignoreFiles []string) (*patternSet, error) {
+	expandedExclude := make([]string, 0, len(exclude))
+	for index, e := range exclude {
+		pattern, err := capComm.ExpandString(ctx, "exclude", e)
+		if err != nil {
+			return nil, errors.Wrapf(err, "expanding exclude %d", index)
+		}
+		expandedExclude = append(expandedExclude, pattern)
+	}
+
+	expandedIgnoreFiles := make([]string, 0, len(ignoreFiles))
+	for index, f := range ignoreFiles {
+		ignoreFile, err := capComm.ExpandString(ctx, "ignoreFile", f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "expanding ignore file %d", index)
+		}
+		expandedIgnoreFiles = append(expandedIgnoreFiles, ignoreFile)
+	}
+
+	return newPatternSet(patterns, expandedExclude, expandedIgnoreFiles)
+}
+
+// addRule adds a rule for a pattern relative to the dir.  Patterns without a slash, other than a trailing one,
+// match at any depth unless anchored.  A trailing slash only matches directories.
+func (ps *patternSet) addRule(dir, pattern string, exclude, anchored bool) error {
+	pattern = filepath.ToSlash(pattern)
+
+	dirOnly := strings.HasSuffix(pattern, "/") && len(pattern) > 1
+	pattern = strings.TrimSuffix(pattern, "/")
+
+	switch {
+	case pattern == "":
+		return errors.New("blank pattern")
+	case filepath.IsAbs(filepath.FromSlash(pattern)):
+		// already absolute
+	case anchored || strings.Contains(strings.TrimPrefix(pattern, "/"), "/"):
+		pattern = filepath.ToSlash(filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(pattern, "/"))))
+	default:
+		pattern = "**/" + strings.TrimPrefix(pattern, "/")
+	}
+
+	pattern = strings.TrimPrefix(pattern, "/")
+	if !globber.ValidatePattern(pattern) {
+		return fmt.Errorf("invalid pattern %s", pattern)
+	}
+
+	ps.rules = append(ps.rules, patternRule{pattern: pattern, exclude: exclude, dirOnly: dirOnly})
+
+	return nil
+}
+
+// addIgnoreFile adds the rules of a .gitignore style file, patterns are relative to the file's directory.
+func (ps *patternSet) addIgnoreFile(ignoreFile string) error {
+	path, err := filepath.Abs(filepath.FromSlash(ignoreFile))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "ignore file %s", ignoreFile)
+	}
+	defer f.Close()
+
+	dir := filepath.Dir(path)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// an ignore file's ! re-includes paths
+		exclude := true
+		if strings.HasPrefix(line, "!") {
+			exclude = false
+			line = line[1:]
+		}
+
+		// a leading slash anchors the pattern to the ignore file's directory,
+		// other patterns without a slash match at any depth below it
+		line = strings.TrimPrefix(line, "\\")
+		if strings.HasPrefix(line, "/") {
+			line = filepath.ToSlash(dir) + line
+		} else if !strings.Contains(strings.TrimSuffix(line, "/"), "/") {
+			line = filepath.ToSlash(dir) + "/**/" + line
+		}
+
+		if err := ps.addRule(dir, line, exclude, false); err != nil {
+			return errors.Wrapf(err, "ignore file %s", ignoreFile)
+		}
+	}
+
+	return errors.Wrapf(scanner.Err(), "ignore file %s", ignoreFile)
+}
+
+// matches returns true if the rule matches the path or one of its parent directories below the base.
+func (rule patternRule) matches(base, path string) bool {
+	slashed := strings.TrimPrefix(filepath.ToSlash(path), "/")
+
+	if ok, _ := globber.Match(rule.pattern, slashed); ok {
+		if !rule.dirOnly {
+			return true
+		}
+		if stat, err := os.Stat(path); err == nil && stat.IsDir() {
+			return true
+		}
+	}
+
+	for dir := filepath.Dir(path); len(dir) > len(base) && isWithin(base, dir); dir = filepath.Dir(dir) {
+		if ok, _ := globber.Match(rule.pattern, strings.TrimPrefix(filepath.ToSlash(dir), "/")); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasExclusions returns true if any rule excludes paths.
+func (ps *patternSet) HasExclusions() bool {
+	for _, rule := range ps.rules {
+		if rule.exclude {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Excluded returns true if the absolute path, found beneath the base directory, is excluded by the rules.
+func (ps *patternSet) Excluded(base, path string) bool {
+	excluded := false
+	for _, rule := range ps.rules {
+		if rule.exclude != excluded && rule.matches(base, path) {
+			excluded = rule.exclude
+		}
+	}
+
+	return excluded
+}
+
+// Glob returns the distinct absolute paths matched by the source patterns split into those included and excluded.
+func (ps *patternSet) Glob() (included, excluded []patternMatch, err error) {
+	seen := make(map[string]bool)
+
+	for _, spec := range ps.specs {
+		list, err := recursiveGlob(spec)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "globbing %s", spec)
+		}
+
+		base, err := getBaseDir(spec)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "dir walk %s", spec)
+		}
+
+		for _, l := range list {
+			path, err := filepath.Abs(l)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "abs %s", l)
+			}
+
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+
+			m := patternMatch{path: path, base: base, name: l}
+			if ps.Excluded(base, path) {
+				excluded = append(excluded, m)
+			} else {
+				included = append(included, m)
+			}
+		}
+	}
+
+	return included, excluded, nil
+}
+
+// Bases returns the base directories of the source patterns.
+func (ps *patternSet) Bases() ([]string, error) {
+	bases := make([]string, 0, len(ps.specs))
+	for _, spec := range ps.specs {
+		base, err := getBaseDir(spec)
+		if err != nil {
+			return nil, errors.Wrapf(err, "dir walk %s", spec)
+		}
+		bases = append(bases, base)
+	}
+
+	return bases, nil
+}
+
+// Names returns the included paths as globbed.
+func (ps *patternSet) Names() ([]string, error) {
+	included, _, err := ps.Glob()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(included))
+	for _, m := range included {
+		names = append(names, m.name)
+	}
+
+	return names, nil
+}
@@ -0,0 +1,156 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builtin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/nehemming/cirocket/pkg/loggee"
+	"github.com/nehemming/cirocket/pkg/loggee/stdlog"
+	"github.com/nehemming/cirocket/pkg/rocket"
+)
+
+func relNames(t *testing.T, dir string, ps *patternSet) []string {
+	t.Helper()
+
+	names, err := ps.Names()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rels := make([]string, 0, len(names))
+	for _, name := range names {
+		if stat, err := os.Stat(name); err != nil || stat.IsDir() {
+			continue
+		}
+
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rels = append(rels, filepath.ToSlash(rel))
+	}
+	sort.Strings(rels)
+
+	return rels
+}
+
+func TestRemoveNegation(t *testing.T) {
+	dir := t.TempDir()
+	writeCopyFiles(t, dir, map[string]string{"build/a.txt": "a", "build/sub/b.txt": "b", "build/keep/k.txt": "k"})
+
+	loggee.SetLogger(stdlog.New())
+
+	mc := rocket.NewMissionControl()
+	RegisterAll(mc)
+
+	base := filepath.ToSlash(dir)
+	mission := map[string]interface{}{
+		"stages": []interface{}{
+			map[string]interface{}{
+				"name": "clean",
+				"tasks": []interface{}{
+					map[string]interface{}{
+						"type":  "remove",
+						"name":  "clean build",
+						"files": []interface{}{base + "/build/**", "!" + base + "/build/keep/**"},
+						"log":   true,
+					},
+				},
+			},
+		},
+	}
+
+	if err := mc.LaunchMission(context.Background(), filepath.Join(dir, "mission.yml"), mission); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "build", "keep", "k.txt")); err != nil {
+		t.Error("kept file removed", err)
+	}
+
+	for _, name := range []string{"a.txt", "sub"} {
+		if _, err := os.Stat(filepath.Join(dir, "build", name)); err == nil {
+			t.Error(name, "not removed")
+		}
+	}
+}
+
+func TestPatternSetIgnoreFile(t *testing.T) {
+	dir := t.TempDir()
+	writeCopyFiles(t, dir, map[string]string{
+		".gitignore":    "# build output\n*.log\n!keep.log\n/dist/\n",
+		"a.log":         "",
+		"keep.log":      "",
+		"sub/b.log":     "",
+		"sub/dist/y.go": "",
+		"dist/x.go":     "",
+		"src/c.go":      "",
+	})
+
+	ps, err := newPatternSet([]string{filepath.Join(dir, "**")}, []string{".gitignore"}, []string{filepath.Join(dir, ".gitignore")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"keep.log", "src/c.go", "sub/dist/y.go"}
+	if names := relNames(t, dir, ps); strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Error("unexpected names", names)
+	}
+
+	// missing ignore files are skipped
+	if _, err := newPatternSet([]string{"*"}, nil, []string{filepath.Join(dir, ".dockerignore")}); err != nil {
+		t.Error("missing ignore file", err)
+	}
+}
+
+func TestPatternSetExcludeNames(t *testing.T) {
+	dir := t.TempDir()
+	writeCopyFiles(t, dir, map[string]string{
+		"app/main.go":                  "",
+		"app/node_modules/lib/x.js":    "",
+		"node_modules/y.js":            "",
+		"app/vendor/z.go":              "",
+		"app/vendor/keep/important.go": "",
+	})
+
+	ps, err := newPatternSet(
+		[]string{filepath.Join(dir, "**"), "!" + filepath.Join(dir, "app", "vendor", "**"), filepath.Join(dir, "app", "vendor", "keep", "**")},
+		[]string{"node_modules"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"app/main.go", "app/vendor/keep/important.go"}
+	if names := relNames(t, dir, ps); strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Error("unexpected names", names)
+	}
+
+	files, err := globFileAbsRel(filepath.Join(dir, "app", "**", "*.go"), "!"+filepath.Join(dir, "app", "vendor"))
+	if err != nil || len(files) != 1 || filepath.ToSlash(files[0].Rel) != "main.go" {
+		t.Error("unexpected files", files, err)
+	}
+
+	if _, err := newPatternSet([]string{"*"}, []string{"["}, nil); err == nil {
+		t.Error("expected invalid pattern error")
+	}
+}
@@ -22,12 +22,20 @@ import "github.com/nehemming/cirocket/pkg/rocket"
 func RegisterAll(mc rocket.MissionController) {
 	mc.RegisterTaskTypes(
 		templateType{},
+		archiveType{},
+		extractType{},
+		checksumType{},
 		runType{},
 		cleanerType{},
 		removeType{},
 		fetchType{},
+		httpType{},
 		mkDirType{},
 		copyType{},
 		moveType{},
+		editType{},
+		patchType{},
+		exportEnvType{},
+		missionType{mc: mc},
 	)
 }
@@ -47,6 +47,12 @@ type (
 		// to passing the program.  If true *.go would ne expanded as a arg per matching file.
 		GlobArgs bool `mapstructure:"glob"`
 
+		// Exclude are glob patterns of files that are not included when globbing arguments.
+		Exclude []string `mapstructure:"exclude"`
+
+		// IgnoreFiles are .gitignore style files listing further files to exclude when globbing arguments.
+		IgnoreFiles []string `mapstructure:"ignoreFiles"`
+
 		// Redirect handles input and output redirection.
 		rocket.Redirection `mapstructure:",squash"`
 	}
@@ -243,6 +249,14 @@ func parseCommandLine(ctx context.Context, capComm *rocket.CapComm, runCfg *Run)
 	glob := recursiveGlob
 	if !runCfg.GlobArgs {
 		glob = nil
+	} else if len(runCfg.Exclude) > 0 || len(runCfg.IgnoreFiles) > 0 {
+		glob = func(arg string) ([]string, error) {
+			ps, err := newPatternSetFromConfig(ctx, capComm, []string{arg}, runCfg.Exclude, runCfg.IgnoreFiles)
+			if err != nil {
+				return nil, err
+			}
+			return ps.Names()
+		}
 	}
 
 	return cliparse.NewParse().WithGlob(glob).Parse(cmd, args...)
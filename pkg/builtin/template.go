@@ -54,6 +54,9 @@ type (
 		// Delims are the delimiters used to identify template script.
 		// Leave blank for the default go templating delimiters
 		Delims Delims `mapstructure:"delims"`
+
+		// Tree renders a directory of templates instead of the single template and output.
+		Tree *TemplateTree `mapstructure:"tree"`
 	}
 
 	templateType struct{}
@@ -64,7 +67,7 @@ func (templateType) Type() string {
 }
 
 func (templateType) Description() string {
-	return "processes an input template to generate output."
+	return "processes an input template, or a tree of templates, to generate output."
 }
 
 func configureSources(ctx context.Context, capComm *rocket.CapComm, templateCfg *Template) error {
@@ -91,6 +94,20 @@ func (templateType) Prepare(ctx context.Context, capComm *rocket.CapComm, task r
 		return nil, errors.Wrap(err, "parsing template type")
 	}
 
+	if templateCfg.Tree != nil {
+		if templateCfg.Template != (rocket.InputSpec{}) || templateCfg.Output != nil {
+			return nil, errors.New("tree cannot be used with a template or output")
+		}
+
+		if templateCfg.Tree.Source == "" {
+			return nil, errors.New("tree has no source")
+		}
+
+		return func(runCtx context.Context) error {
+			return renderTree(runCtx, capComm, templateCfg)
+		}, nil
+	}
+
 	fn := func(runCtx context.Context) error {
 		// Late configure sources, allow previous steps to be available
 		if err := configureSources(runCtx, capComm, templateCfg); err != nil {
@@ -136,10 +153,12 @@ func loadTemplate(ctx context.Context, capComm *rocket.CapComm, name string, tem
 		return nil, err
 	}
 
-	return template.New(name).
-		Option("missingkey=zero").
-		Funcs(capComm.FuncMap()).
-		Delims(templateCfg.Delims.Left, templateCfg.Delims.Right).Parse(string(b))
+	t, err := capComm.NewTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.Delims(templateCfg.Delims.Left, templateCfg.Delims.Right).Parse(string(b))
 }
 
 func init() {
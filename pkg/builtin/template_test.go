@@ -34,6 +34,8 @@ package builtin
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/nehemming/cirocket/pkg/loggee"
@@ -93,3 +95,150 @@ func TestTemplateMissingArgIsBalk(t *testing.T) {
 		t.Error("unexpected", err)
 	}
 }
+
+func launchTemplateTree(t *testing.T, dir string, tree map[string]interface{}) error {
+	t.Helper()
+
+	params := []interface{}{
+		map[string]interface{}{"name": "project", "value": "demo"},
+		map[string]interface{}{"name": "docker", "value": "false"},
+	}
+
+	task := newTask("template", "scaffold", map[string]interface{}{
+		"tree":   tree,
+		"delims": map[string]interface{}{"left": "<<", "right": ">>"},
+	})
+
+	return launchTasks(context.Background(), filepath.Join(dir, "mission.yml"), params, task)
+}
+
+func TestTemplateTree(t *testing.T) {
+	dir := t.TempDir()
+	writeCopyFiles(t, dir, map[string]string{
+		"skeleton/README.md":                      "# <<.project>>\n",
+		"skeleton/cmd/<<.project>>/main.go":       "package main // <<.project>>\n",
+		"skeleton/<<if .cli>>cli<<end>>/flags.go": "package cli\n",
+		"skeleton/Dockerfile":                     "FROM <<.project>>\n",
+		"skeleton/logo.png":                       "<<binary>>",
+		"skeleton/.cirocket.yml":                  "name: '[[.project]]' {{ .keep }}\n",
+	})
+
+	if err := os.Chmod(filepath.Join(dir, "skeleton", "cmd", "<<.project>>", "main.go"), 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	err := launchTemplateTree(t, dir, map[string]interface{}{
+		"source":      filepath.ToSlash(dir) + "/skeleton",
+		"destination": filepath.ToSlash(dir) + "/out",
+		"verbatim":    []interface{}{"*.png"},
+		"files": []interface{}{
+			map[string]interface{}{"match": "Dockerfile", "skip": "{{ not (eq .docker \"true\") }}"},
+			map[string]interface{}{"match": ".cirocket.yml", "delims": map[string]interface{}{"left": "[[", "right": "]]"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"README.md":        "# demo\n",
+		"cmd/demo/main.go": "package main // demo\n",
+		"logo.png":         "<<binary>>",
+		".cirocket.yml":    "name: 'demo' {{ .keep }}\n",
+	}
+	for name, content := range expected {
+		if b, err := os.ReadFile(filepath.Join(dir, "out", name)); err != nil || string(b) != content {
+			t.Errorf("%s: got %q %v", name, string(b), err)
+		}
+	}
+
+	for _, name := range []string{"Dockerfile", "cli"} {
+		if _, err := os.Stat(filepath.Join(dir, "out", name)); err == nil {
+			t.Error("not skipped", name)
+		}
+	}
+
+	if stat, err := os.Stat(filepath.Join(dir, "out", "cmd", "demo", "main.go")); err != nil || stat.Mode().Perm() != 0750 {
+		t.Error("permissions not kept", err)
+	}
+}
+
+func TestTemplateTreePaths(t *testing.T) {
+	dir := t.TempDir()
+	writeCopyFiles(t, dir, map[string]string{"skeleton/a.txt": "<<.project>>", "skeleton/b.txt": "b"})
+
+	err := launchTemplateTree(t, dir, map[string]interface{}{
+		"source":      filepath.ToSlash(dir) + "/skeleton",
+		"paths":       []interface{}{"a.txt"},
+		"destination": filepath.ToSlash(dir) + "/out",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := os.ReadFile(filepath.Join(dir, "out", "a.txt")); err != nil || string(b) != "demo" {
+		t.Error("a.txt", string(b), err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "out", "b.txt")); err == nil {
+		t.Error("unlisted path rendered")
+	}
+
+	if err := launchTemplateTree(t, dir, map[string]interface{}{
+		"source": filepath.ToSlash(dir) + "/skeleton",
+		"paths":  []interface{}{"../mission.yml"},
+	}); err == nil {
+		t.Error("expected path outside source error")
+	}
+}
+
+func TestTemplateSharedTemplates(t *testing.T) {
+	dir := t.TempDir()
+	writeCopyFiles(t, dir, map[string]string{"skeleton/main.go": "<< include \"header\" . >>package main\n"})
+
+	loggee.SetLogger(stdlog.New())
+
+	mc := rocket.NewMissionControl()
+	RegisterAll(mc)
+
+	mission := map[string]interface{}{
+		"params": []interface{}{map[string]interface{}{"name": "project", "value": "demo"}},
+		"templates": []interface{}{
+			map[string]interface{}{"name": "header", "inline": "// {{ .project }} is generated\n"},
+		},
+		"stages": []interface{}{
+			map[string]interface{}{
+				"name": "generate",
+				"tasks": []interface{}{
+					map[string]interface{}{
+						"type":     "template",
+						"name":     "readme",
+						"template": map[string]interface{}{"inline": "{{ template \"header\" . }}docs:\n  {{ include \"header\" . | indent 2 }}"},
+						"output":   map[string]interface{}{"path": filepath.ToSlash(dir) + "/README.md"},
+					},
+					map[string]interface{}{
+						"type": "template",
+						"name": "tree",
+						"tree": map[string]interface{}{
+							"source":      filepath.ToSlash(dir) + "/skeleton",
+							"destination": filepath.ToSlash(dir) + "/out",
+						},
+						"delims": map[string]interface{}{"left": "<<", "right": ">>"},
+					},
+				},
+			},
+		},
+	}
+
+	if err := mc.LaunchMission(context.Background(), filepath.Join(dir, "mission.yml"), mission); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := os.ReadFile(filepath.Join(dir, "README.md")); err != nil || string(b) != "// demo is generated\ndocs:\n  // demo is generated\n  \n" {
+		t.Errorf("README.md: got %q %v", string(b), err)
+	}
+
+	if b, err := os.ReadFile(filepath.Join(dir, "out", "main.go")); err != nil || string(b) != "// demo is generated\npackage main\n" {
+		t.Errorf("main.go: got %q %v", string(b), err)
+	}
+}
@@ -0,0 +1,342 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builtin
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	globber "github.com/bmatcuk/doublestar/v4"
+	"github.com/nehemming/cirocket/pkg/resource"
+	"github.com/nehemming/cirocket/pkg/rocket"
+	"github.com/pkg/errors"
+)
+
+type (
+	// TemplateTree renders every file below a source directory into a destination directory.  The names of
+	// the files and directories are templates too, an entry whose name renders empty is skipped.
+	TemplateTree struct {
+		// Source is the directory, or url, of the templates.  Relative paths are relative to the working
+		// directory, use {{ .missionDirURL }} for a blueprint's files.
+		Source string `mapstructure:"source"`
+
+		// Paths lists the files, relative to the source, to render.  They are not expanded, as file names can be
+		// templates, and default to all of the files of a local source.  They must be given for a http(s) source.
+		Paths []string `mapstructure:"paths"`
+
+		// Destination is the directory the files are written to, it defaults to the working directory.
+		Destination string `mapstructure:"destination"`
+
+		// Verbatim are glob patterns of files, such as binaries, copied without being rendered.
+		Verbatim []string `mapstructure:"verbatim"`
+
+		// Files are rules applied to the files and directories they match.  The first matching rule applies.
+		Files []TemplateTreeFile `mapstructure:"files"`
+
+		Log bool `mapstructure:"log"`
+	}
+
+	// TemplateTreeFile is a rule for the source files or directories matching a glob pattern.
+	// Skip is a template expression, if it is true the matches are skipped.  Verbatim copies the matches without
+	// rendering them and Delims replaces the tree's delimiters.
+	TemplateTreeFile struct {
+		Match    string  `mapstructure:"match"`
+		Skip     string  `mapstructure:"skip"`
+		Verbatim bool    `mapstructure:"verbatim"`
+		Delims   *Delims `mapstructure:"delims"`
+	}
+
+	// treeRule is a file rule with its skip condition evaluated.
+	treeRule struct {
+		match    string
+		skip     bool
+		verbatim bool
+		delims   Delims
+	}
+)
+
+// matchTreePattern matches a slash separated path relative to the tree's source.  Patterns without a slash
+// match the file name at any depth.
+func matchTreePattern(pattern, rel string) bool {
+	name := rel
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(rel)
+	}
+
+	matched, err := globber.Match(pattern, name)
+	return err == nil && matched
+}
+
+// newTreeRules evaluates the skip conditions of the rules.
+func newTreeRules(ctx context.Context, capComm *rocket.CapComm, tree *TemplateTree, delims Delims) ([]*treeRule, error) {
+	rules := make([]*treeRule, 0, len(tree.Files))
+	for index, file := range tree.Files {
+		if file.Match == "" {
+			return nil, errors.Errorf("file rule %d has no match pattern", index)
+		}
+
+		skip, err := capComm.ExpandBool(ctx, "skip", file.Skip)
+		if err != nil {
+			return nil, errors.Wrapf(err, "file rule %d skip", index)
+		}
+
+		rule := &treeRule{match: file.Match, skip: skip, verbatim: file.Verbatim, delims: delims}
+		if file.Delims != nil {
+			rule.delims = *file.Delims
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// findTreeRule returns the first rule matching the path, or nil if none match.
+func findTreeRule(rules []*treeRule, rel string) *treeRule {
+	for _, rule := range rules {
+		if matchTreePattern(rule.match, rel) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// treeSource returns the url of the source and the files below it.
+func treeSource(ctx context.Context, capComm *rocket.CapComm, tree *TemplateTree) (string, []string, error) {
+	source, err := capComm.ExpandString(ctx, "source", tree.Source)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "expanding source")
+	}
+
+	sourceURL, err := resource.UltimateURL(source)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "source %s", source)
+	}
+
+	files := make([]string, 0, len(tree.Paths))
+	for _, p := range tree.Paths {
+		rel := path.Clean(filepath.ToSlash(p))
+		if path.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+			return "", nil, errors.Errorf("path %s is not within the source", p)
+		}
+		files = append(files, rel)
+	}
+
+	if len(files) > 0 {
+		return sourceURL.String(), files, nil
+	}
+
+	dir, err := resource.URLToPath(sourceURL)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "listing %s, give the paths of a remote source", resource.Relative(sourceURL))
+	}
+
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.Type().IsRegular() {
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "listing %s", dir)
+	}
+
+	return sourceURL.String(), files, nil
+}
+
+// renderTreeName renders each segment of the relative path, returning the rendered path or an empty
+// string if a segment rendered empty.
+func renderTreeName(capComm *rocket.CapComm, rel string, delims Delims, data interface{}) (string, error) {
+	segments := strings.Split(rel, "/")
+	rendered := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		t, err := capComm.NewTemplate(segment)
+		if err != nil {
+			return "", err
+		}
+
+		if _, err := t.Delims(delims.Left, delims.Right).Parse(segment); err != nil {
+			return "", errors.Wrapf(err, "name %s", rel)
+		}
+
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			return "", errors.Wrapf(err, "name %s", rel)
+		}
+
+		name := strings.TrimSpace(strings.ReplaceAll(buf.String(), "<no value>", ""))
+		if name == "" {
+			return "", nil
+		}
+		rendered = append(rendered, name)
+	}
+
+	return path.Join(rendered...), nil
+}
+
+// skipTreeFile returns true if a rule skips the file or one of its parent directories.
+func skipTreeFile(rules []*treeRule, rel string) bool {
+	for p := rel; p != "."; p = path.Dir(p) {
+		if rule := findTreeRule(rules, p); rule != nil && rule.skip {
+			return true
+		}
+	}
+	return false
+}
+
+// renderTree renders the tree's source files to its destination.
+func renderTree(ctx context.Context, capComm *rocket.CapComm, templateCfg *Template) error {
+	tree := templateCfg.Tree
+
+	rules, err := newTreeRules(ctx, capComm, tree, templateCfg.Delims)
+	if err != nil {
+		return err
+	}
+
+	sourceURL, files, err := treeSource(ctx, capComm, tree)
+	if err != nil {
+		return err
+	}
+
+	destination, err := capComm.ExpandString(ctx, "destination", tree.Destination)
+	if err != nil {
+		return errors.Wrap(err, "expanding destination")
+	}
+
+	if destination, err = filepath.Abs(filepath.FromSlash(destination)); err != nil {
+		return errors.Wrap(err, "destination")
+	}
+
+	data := capComm.GetTemplateData(ctx)
+	dryRun := rocket.IsDryRun(ctx)
+	rendered, copied := 0, 0
+
+	for _, rel := range files {
+		if skipTreeFile(rules, rel) {
+			continue
+		}
+
+		delims := templateCfg.Delims
+		verbatim := false
+		for _, pattern := range tree.Verbatim {
+			verbatim = verbatim || matchTreePattern(pattern, rel)
+		}
+		if rule := findTreeRule(rules, rel); rule != nil {
+			delims = rule.delims
+			verbatim = verbatim || rule.verbatim
+		}
+
+		name, err := renderTreeName(capComm, rel, delims, data)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			continue
+		}
+
+		target := filepath.Join(destination, filepath.FromSlash(name))
+		if !isWithin(destination, target) {
+			return errors.Errorf("%s renders outside of the destination", rel)
+		}
+
+		content, err := resource.ReadResource(ctx, sourceURL, rel)
+		if err != nil {
+			return errors.Wrapf(err, "reading %s", rel)
+		}
+
+		if !verbatim {
+			if content, err = renderTreeFile(capComm, rel, content, delims, data); err != nil {
+				return err
+			}
+			rendered++
+		} else {
+			copied++
+		}
+
+		if dryRun {
+			capComm.Log().Infof("would write %s", friendlyRelativePath(target))
+			continue
+		}
+
+		if err := writeTreeFile(sourceURL, rel, target, content); err != nil {
+			return err
+		}
+
+		if tree.Log {
+			capComm.Log().Infof("wrote %s", friendlyRelativePath(target))
+		}
+	}
+
+	capComm.Log().Infof("rendered %d and copied %d files to %s", rendered, copied, friendlyRelativePath(destination))
+
+	return nil
+}
+
+// renderTreeFile renders a file's content.
+func renderTreeFile(capComm *rocket.CapComm, rel string, content []byte, delims Delims, data interface{}) ([]byte, error) {
+	t, err := capComm.NewTemplate(rel)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := t.Delims(delims.Left, delims.Right).Parse(string(content)); err != nil {
+		return nil, errors.Wrapf(err, "template %s", rel)
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return nil, errors.Wrapf(err, "template %s", rel)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeTreeFile writes a file, keeping the permissions of a local source file.
+func writeTreeFile(sourceURL, rel, target string, content []byte) error {
+	perm := os.FileMode(0666)
+	if u, err := resource.UltimateURL(sourceURL, rel); err == nil {
+		if p, err := resource.URLToPath(u); err == nil {
+			if stat, err := os.Stat(p); err == nil {
+				perm = stat.Mode().Perm()
+			}
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+		return errors.Wrapf(err, "mkdir %s", filepath.Dir(target))
+	}
+
+	if err := os.WriteFile(target, content, perm); err != nil {
+		return errors.Wrapf(err, "writing %s", target)
+	}
+
+	// WriteFile does not change the permissions of an existing file
+	return os.Chmod(target, perm)
+}
@@ -0,0 +1,512 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builtin
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	globber "github.com/bmatcuk/doublestar/v4"
+	"github.com/mitchellh/mapstructure"
+	"github.com/nehemming/cirocket/pkg/loggee"
+	"github.com/nehemming/cirocket/pkg/providers"
+	"github.com/nehemming/cirocket/pkg/rocket"
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+)
+
+const extractResourceID = providers.ResourceID("archive")
+
+type (
+	// Extract task is used to unpack a zip or tar archive.
+	Extract struct {
+		// Archive is the archive to extract.
+		Archive rocket.InputSpec `mapstructure:"archive"`
+
+		// Destination is the directory the archive is extracted into.
+		Destination string `mapstructure:"destination"`
+
+		// Format is zip, tar, tar.gz or tar.xz.  If blank it is detected from the archive's content.
+		Format string `mapstructure:"format"`
+
+		// StripComponents is the number of leading directories removed from the path of each file.
+		StripComponents int `mapstructure:"stripComponents"`
+
+		// Include are glob patterns, matched against the stripped path, of the files to extract.
+		// If blank all files are extracted.
+		Include []string `mapstructure:"include"`
+
+		// Exclude are glob patterns, matched against the stripped path, of files not to extract.
+		Exclude []string `mapstructure:"exclude"`
+
+		// Overwrite existing files, if false existing files are skipped.
+		Overwrite string `mapstructure:"overwrite"`
+
+		Log bool `mapstructure:"log"`
+	}
+
+	extractType struct{}
+
+	// extractor writes the entries of an archive into the destination.
+	extractor struct {
+		dest      string
+		strip     int
+		include   []string
+		exclude   []string
+		overwrite bool
+		log       loggee.Logger
+	}
+)
+
+func (extractType) Type() string {
+	return "extract"
+}
+
+func (extractType) Description() string {
+	return "extracts the files in a zip, tar, tar.gz or tar.xz archive into the destination folder."
+}
+
+// Prepare loads the tasks configuration and returns the operation function or an error.
+func (extractType) Prepare(ctx context.Context, capComm *rocket.CapComm, task rocket.Task) (rocket.ExecuteFunc, error) {
+	extractCfg := &Extract{}
+
+	if err := mapstructure.WeakDecode(task.Definition, extractCfg); err != nil {
+		return nil, errors.Wrap(err, "parsing extract type")
+	}
+
+	if extractCfg.StripComponents < 0 {
+		return nil, errors.New("stripComponents cannot be negative")
+	}
+
+	if extractCfg.Format != "" {
+		if _, err := getArchiveFormat(extractCfg.Format, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	fn := func(execCtx context.Context) error {
+		if err := capComm.AttachInputSpec(execCtx, extractResourceID, extractCfg.Archive); err != nil {
+			return errors.Wrap(err, "archive")
+		}
+
+		ex, err := getExtractor(execCtx, capComm, extractCfg)
+		if err != nil {
+			return err
+		}
+
+		// archives are spooled to a temporary file as zip needs random access
+		f, err := spoolResource(execCtx, capComm.GetResource(extractResourceID))
+		if err != nil {
+			return errors.Wrap(err, "reading archive")
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		format, err := detectArchiveFormat(f, extractCfg.Format)
+		if err != nil {
+			return err
+		}
+
+		return ex.extract(execCtx, f, format)
+	}
+
+	return fn, nil
+}
+
+func getExtractor(ctx context.Context, capComm *rocket.CapComm, extractCfg *Extract) (*extractor, error) {
+	dest, err := capComm.ExpandString(ctx, "destination", extractCfg.Destination)
+	if err != nil {
+		return nil, errors.Wrap(err, "expanding destination")
+	}
+
+	if dest == "" {
+		return nil, errors.New("destination cannot be blank")
+	}
+
+	dest, err = filepath.Abs(filepath.FromSlash(dest))
+	if err != nil {
+		return nil, err
+	}
+
+	overwrite, err := capComm.ExpandBool(ctx, "overwrite", extractCfg.Overwrite)
+	if err != nil {
+		return nil, errors.Wrap(err, "expanding overwrite")
+	}
+
+	ex := &extractor{
+		dest:      dest,
+		strip:     extractCfg.StripComponents,
+		overwrite: overwrite,
+		log:       getLogFromCapComm(capComm, extractCfg.Log),
+	}
+
+	for _, pattern := range extractCfg.Include {
+		p, err := capComm.ExpandString(ctx, "include", pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "expanding include %s", pattern)
+		}
+		ex.include = append(ex.include, p)
+	}
+
+	for _, pattern := range extractCfg.Exclude {
+		p, err := capComm.ExpandString(ctx, "exclude", pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "expanding exclude %s", pattern)
+		}
+		ex.exclude = append(ex.exclude, p)
+	}
+
+	return ex, nil
+}
+
+// spoolResource copies the resource into a temporary file, the file is returned positioned at its start.
+func spoolResource(ctx context.Context, rp providers.ResourceProvider) (*os.File, error) {
+	r, err := rp.OpenRead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	f, err := os.CreateTemp("", "cirocket-extract-*")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// detectArchiveFormat returns the format of the archive from its leading bytes unless a format is specified.
+func detectArchiveFormat(f *os.File, format string) (string, error) {
+	if format != "" {
+		return getArchiveFormat(format, "")
+	}
+
+	magic := make([]byte, 6)
+	n, err := io.ReadFull(f, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	magic = magic[:n]
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK")):
+		return ArchiveZip, nil
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return ArchiveTarGz, nil
+	case bytes.HasPrefix(magic, []byte("\xfd7zXZ\x00")):
+		return ArchiveTarXz, nil
+	}
+
+	return ArchiveTar, nil
+}
+
+func (ex *extractor) extract(ctx context.Context, f *os.File, format string) error {
+	if err := os.MkdirAll(ex.dest, 0777); err != nil {
+		return err
+	}
+
+	switch format {
+	case ArchiveZip:
+		stat, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		return ex.extractZip(ctx, f, stat.Size())
+	case ArchiveTarGz:
+		gz, err := gzip.NewReader(bufio.NewReader(f))
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return ex.extractTar(ctx, gz)
+	case ArchiveTarXz:
+		return ex.extractTarXz(ctx, f)
+	default:
+		return ex.extractTar(ctx, f)
+	}
+}
+
+func (ex *extractor) extractZip(ctx context.Context, r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	for _, zf := range zr.File {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		mode := zf.Mode()
+		if err := ex.extractEntry(zf.Name, mode, func() (io.ReadCloser, error) { return zf.Open() }); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ex *extractor) extractTar(ctx context.Context, r io.Reader) error {
+	tr := tar.NewReader(r)
+
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var mode os.FileMode
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			mode = os.FileMode(header.Mode) & os.ModePerm
+		case tar.TypeDir:
+			mode = os.ModeDir | os.FileMode(header.Mode)&os.ModePerm
+		case tar.TypeSymlink:
+			mode = os.ModeSymlink
+		default:
+			// devices, hard links etc. are not extracted
+			continue
+		}
+
+		open := func() (io.ReadCloser, error) {
+			if header.Typeflag == tar.TypeSymlink {
+				return io.NopCloser(strings.NewReader(header.Linkname)), nil
+			}
+			return io.NopCloser(tr), nil
+		}
+
+		if err := ex.extractEntry(header.Name, mode, open); err != nil {
+			return err
+		}
+	}
+}
+
+// extractTarXz decompresses the tar with xz.
+func (ex *extractor) extractTarXz(ctx context.Context, r io.Reader) error {
+	xr, err := xz.NewReader(r)
+	if err != nil {
+		return errors.Wrap(err, "reading xz")
+	}
+
+	return ex.extractTar(ctx, xr)
+}
+
+// target returns the destination path of an archive entry.  Blank is returned if the entry is not extracted.
+// An error is returned if the entry would be written outside of the destination.
+func (ex *extractor) target(name string) (string, string, error) {
+	name = strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, "\\", "/")), "/")
+	if name == "" {
+		return "", "", nil
+	}
+
+	name = stripPath(name, ex.strip)
+	if name == "" {
+		return "", "", nil
+	}
+
+	if ok, err := ex.selected(name); err != nil || !ok {
+		return "", "", err
+	}
+
+	target := filepath.Join(ex.dest, filepath.FromSlash(name))
+	if !isWithin(ex.dest, target) {
+		return "", "", fmt.Errorf("%s is outside of the destination", name)
+	}
+
+	return name, target, nil
+}
+
+// selected returns true if the name matches the include patterns and none of the exclude patterns.
+func (ex *extractor) selected(name string) (bool, error) {
+	for _, pattern := range ex.exclude {
+		ok, err := globber.Match(pattern, name)
+		if err != nil {
+			return false, errors.Wrapf(err, "exclude %s", pattern)
+		}
+		if ok {
+			return false, nil
+		}
+	}
+
+	if len(ex.include) == 0 {
+		return true, nil
+	}
+
+	for _, pattern := range ex.include {
+		ok, err := globber.Match(pattern, name)
+		if err != nil {
+			return false, errors.Wrapf(err, "include %s", pattern)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (ex *extractor) extractEntry(entryName string, mode os.FileMode, open func() (io.ReadCloser, error)) error {
+	// entry names are cleaned as though rooted so parent references cannot climb out of the destination
+	if path.IsAbs(entryName) || strings.Contains("/"+filepath.ToSlash(entryName)+"/", "/../") {
+		return fmt.Errorf("%s is outside of the destination", entryName)
+	}
+
+	name, target, err := ex.target(entryName)
+	if err != nil || target == "" {
+		return err
+	}
+
+	if mode.IsDir() {
+		return os.MkdirAll(target, 0777)
+	}
+
+	if _, err := os.Lstat(target); err == nil {
+		if !ex.overwrite {
+			if ex.log != nil {
+				ex.log.Infof("skipping %s", name)
+			}
+			return nil
+		}
+
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+		return err
+	}
+
+	// a directory symlink extracted earlier must not redirect the file outside of the destination
+	if !isWithin(resolveDir(ex.dest), resolveDir(filepath.Dir(target))) {
+		return fmt.Errorf("%s is outside of the destination", name)
+	}
+
+	r, err := open()
+	if err != nil {
+		return errors.Wrapf(err, "open %s", name)
+	}
+	defer r.Close()
+
+	if mode&os.ModeSymlink != 0 {
+		return ex.extractSymlink(name, target, r)
+	}
+
+	if mode.Perm() == 0 {
+		mode = 0666
+	}
+
+	f, err := os.OpenFile(target, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return errors.Wrapf(err, "extracting %s", name)
+	}
+
+	if ex.log != nil {
+		ex.log.Infof("extract %s", name)
+	}
+
+	return nil
+}
+
+// extractSymlink creates a symlink, links pointing outside of the destination are rejected.
+func (ex *extractor) extractSymlink(name, target string, r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	link := string(b)
+	resolved := link
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(filepath.Dir(target), filepath.FromSlash(link))
+	}
+
+	if !isWithin(ex.dest, resolved) {
+		return fmt.Errorf("%s links outside of the destination", name)
+	}
+
+	if err := os.Symlink(link, target); err != nil {
+		return err
+	}
+
+	if ex.log != nil {
+		ex.log.Infof("extract %s -> %s", name, link)
+	}
+
+	return nil
+}
+
+// isWithin returns true if the target is the dir or is inside it.
+func isWithin(dir, target string) bool {
+	rel, err := filepath.Rel(dir, target)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// resolveDir returns the directory with any symlinks resolved.
+func resolveDir(dir string) string {
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		return resolved
+	}
+
+	return dir
+}
+
+func init() {
+	rocket.Default().RegisterTaskTypes(extractType{})
+}
@@ -0,0 +1,304 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builtin
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestExtractType(t *testing.T) {
+	var et extractType
+
+	if et.Type() != "extract" {
+		t.Error("Wrong extract type", et.Type())
+	}
+
+	if et.Description() == "" {
+		t.Error("needs description", et.Type())
+	}
+}
+
+type testEntry struct {
+	name    string
+	content string
+	link    bool
+}
+
+var testEntries = []testEntry{
+	{name: "tool-1.0/bin/tool", content: "tool"},
+	{name: "tool-1.0/README.md", content: "readme"},
+	{name: "tool-1.0/docs/guide.txt", content: "guide"},
+}
+
+func writeTestZip(t *testing.T, file string, entries []testEntry) {
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, e.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestTarGz(t *testing.T, file string, entries []testEntry) {
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		header := &tar.Header{Typeflag: tar.TypeReg, Name: e.name, Mode: 0640, Size: int64(len(e.content))}
+		if e.link {
+			header = &tar.Header{Typeflag: tar.TypeSymlink, Name: e.name, Linkname: e.content}
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if !e.link {
+			if _, err := io.WriteString(tw, e.content); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func launchExtract(dir string, extract map[string]interface{}) error {
+	return launchTasks(context.Background(), filepath.Join(dir, "mission.yml"), nil, newTask("extract", "unpack", extract))
+}
+
+func readExtracted(t *testing.T, file string) string {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+func TestExtractZip(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "tool.zip")
+	writeTestZip(t, archive, testEntries)
+
+	dest := filepath.Join(dir, "out")
+
+	err := launchExtract(dir, map[string]interface{}{
+		"archive":         map[string]interface{}{"path": archive},
+		"destination":     dest,
+		"stripComponents": 1,
+		"exclude":         []interface{}{"docs/**"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := readExtracted(t, filepath.Join(dest, "bin", "tool")); s != "tool" {
+		t.Error("unexpected tool", s)
+	}
+
+	if s := readExtracted(t, filepath.Join(dest, "README.md")); s != "readme" {
+		t.Error("unexpected readme", s)
+	}
+
+	if _, err := os.Stat(filepath.Join(dest, "docs")); err == nil {
+		t.Error("docs should be excluded")
+	}
+}
+
+func TestExtractTarGzOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "tool.download")
+	writeTestTarGz(t, archive, testEntries)
+
+	dest := filepath.Join(dir, "out")
+	if err := os.MkdirAll(filepath.Join(dest, "bin"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dest, "bin", "tool"), []byte("old"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	extract := func(overwrite string) {
+		err := launchExtract(dir, map[string]interface{}{
+			"archive":         map[string]interface{}{"path": archive},
+			"destination":     dest,
+			"stripComponents": 1,
+			"include":         []interface{}{"bin/*"},
+			"overwrite":       overwrite,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	extract("false")
+	if s := readExtracted(t, filepath.Join(dest, "bin", "tool")); s != "old" {
+		t.Error("existing file overwritten", s)
+	}
+
+	extract("true")
+	if s := readExtracted(t, filepath.Join(dest, "bin", "tool")); s != "tool" {
+		t.Error("existing file not overwritten", s)
+	}
+
+	if _, err := os.Stat(filepath.Join(dest, "README.md")); err == nil {
+		t.Error("README.md should not be included")
+	}
+
+	if runtime.GOOS != "windows" {
+		if stat, err := os.Stat(filepath.Join(dest, "bin", "tool")); err != nil || stat.Mode().Perm() != 0640 {
+			t.Error("unexpected mode", stat, err)
+		}
+	}
+}
+
+func TestExtractZipSlip(t *testing.T) {
+	tests := [][]testEntry{
+		{{name: "../evil.txt", content: "evil"}},
+		{{name: "/abs/evil.txt", content: "evil"}},
+		{{name: "a/../../evil.txt", content: "evil"}},
+	}
+
+	for i, entries := range tests {
+		dir := t.TempDir()
+		archive := filepath.Join(dir, "evil.zip")
+		writeTestZip(t, archive, entries)
+
+		err := launchExtract(dir, map[string]interface{}{
+			"archive":     map[string]interface{}{"path": archive},
+			"destination": filepath.Join(dir, "out"),
+		})
+		if err == nil || !strings.Contains(err.Error(), "outside of the destination") {
+			t.Error(i, "expected zip slip error", err)
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, "evil.txt")); err == nil {
+			t.Error(i, "file written outside destination")
+		}
+	}
+}
+
+func TestExtractSymlinkEscape(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on windows")
+	}
+
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "links.tar.gz")
+	writeTestTarGz(t, archive, []testEntry{
+		{name: "inside", content: "docs/guide.txt", link: true},
+		{name: "escape", content: "../..", link: true},
+	})
+
+	err := launchExtract(dir, map[string]interface{}{
+		"archive":     map[string]interface{}{"path": archive},
+		"destination": filepath.Join(dir, "out"),
+	})
+	if err == nil || !strings.Contains(err.Error(), "escape links outside of the destination") {
+		t.Error("expected link error", err)
+	}
+
+	if link, err := os.Readlink(filepath.Join(dir, "out", "inside")); err != nil || link != "docs/guide.txt" {
+		t.Error("unexpected link", link, err)
+	}
+}
+
+func TestDetectArchiveFormat(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := map[string]string{
+		"zip":  "PK\x03\x04",
+		"gz":   "\x1f\x8b\x08",
+		"xz":   "\xfd7zXZ\x00\x00",
+		"tar":  "plain",
+		"tiny": "",
+	}
+
+	expected := map[string]string{"zip": ArchiveZip, "gz": ArchiveTarGz, "xz": ArchiveTarXz, "tar": ArchiveTar, "tiny": ArchiveTar}
+
+	for name, content := range tests {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		format, err := detectArchiveFormat(f, "")
+		f.Close()
+		if err != nil || format != expected[name] {
+			t.Error(name, "unexpected format", format, err)
+		}
+	}
+}
+
+func TestExtractArchivedTarXz(t *testing.T) {
+	dir := writeArchiveSources(t)
+	archive := filepath.Join(dir, "app.tar.xz")
+
+	launchArchive(t, dir, map[string]interface{}{"output": archive, "prefix": "app"})
+
+	dest := filepath.Join(dir, "out")
+	err := launchExtract(dir, map[string]interface{}{
+		"archive":         map[string]interface{}{"path": archive},
+		"destination":     dest,
+		"stripComponents": 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := readExtracted(t, filepath.Join(dest, "sub", "a.txt")); s != "a" {
+		t.Error("unexpected content", s)
+	}
+}
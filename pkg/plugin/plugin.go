@@ -0,0 +1,538 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package plugin supports task types implemented by external executables.
+//
+// A plugin is an executable named cirocket-task-<type>.  When run with the describe argument it writes
+// a json Info document to stdout.  When run with the run argument it reads a json Request from stdin and
+// writes json Message lines to stdout to report progress and set variables.  A non zero exit code fails the task.
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nehemming/cirocket/pkg/loggee"
+	"github.com/nehemming/cirocket/pkg/rocket"
+	"github.com/pkg/errors"
+)
+
+// ExecutablePrefix is the name prefix of plugin executables.
+const ExecutablePrefix = "cirocket-task-"
+
+// DescribeTimeout is how long a plugin has to describe itself.
+const DescribeTimeout = 10 * time.Second
+
+// maxMessageSize is the longest message line a plugin can write.
+const maxMessageSize = 16 * 1024 * 1024
+
+const (
+	describeCommand = "describe"
+	runCommand      = "run"
+)
+
+type (
+	// Info is the description a plugin writes in response to the describe command.
+	Info struct {
+		// Type of task, if blank the type is taken from the executable name.
+		Type string `json:"type"`
+
+		// Description is a free text description of the task.
+		Description string `json:"description,omitempty"`
+
+		// Schema is a json schema describing the task definition.  Only the top level required keys are checked.
+		Schema map[string]interface{} `json:"schema,omitempty"`
+	}
+
+	// Request is written to the plugin's stdin by the run command.
+	Request struct {
+		// Type of the task.
+		Type string `json:"type"`
+
+		// Name of the task.
+		Name string `json:"name"`
+
+		// Definition is the task definition, string values are expanded.
+		Definition map[string]interface{} `json:"definition"`
+
+		// Env is the expanded environment of the task.
+		Env map[string]string `json:"env"`
+	}
+
+	// Message is a line written by the plugin to stdout while running.
+	Message struct {
+		// Level is the log level of the message, debug, info, warn or error.  Defaults to info.
+		Level string `json:"level,omitempty"`
+
+		// Message is logged at the level.
+		Message string `json:"message,omitempty"`
+
+		// Variables are set as local variables of the task.
+		Variables map[string]string `json:"variables,omitempty"`
+	}
+
+	// TaskType is a task type implemented by a plugin executable.
+	TaskType struct {
+		path string
+		info Info
+	}
+
+	// resolver describes plugins on demand, remembering those it has described.
+	resolver struct {
+		configured []string
+		mu         sync.Mutex
+		described  map[string]bool
+		types      map[string]*TaskType
+	}
+)
+
+// Load describes the plugin executable at path.  The plugin is killed if it takes longer than the DescribeTimeout.
+func Load(ctx context.Context, path string) (*TaskType, error) {
+	ctx, cancel := context.WithTimeout(ctx, DescribeTimeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, path, describeCommand)
+	cmd.Stdout = &stdout
+
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "describing plugin %s", path)
+	}
+
+	var info Info
+	if err := json.Unmarshal(stdout.Bytes(), &info); err != nil {
+		return nil, errors.Wrapf(err, "parsing plugin %s description", path)
+	}
+
+	if info.Type == "" {
+		info.Type = typeFromPath(path)
+	}
+
+	return &TaskType{path: path, info: info}, nil
+}
+
+// Find returns the paths of the plugin executables listed in, or found in the directories of, the configured
+// paths followed by those found in the directories of the PATH environment variable.  Only the first executable
+// found for a name is returned, so configured plugins take precedence.
+func Find(configured []string) []string {
+	paths, _ := find(configured)
+	return paths
+}
+
+// find returns the plugin paths and the number of them that were configured.
+func find(configured []string) ([]string, int) {
+	seen := make(map[string]bool)
+	var paths []string
+
+	add := func(path string) {
+		name := filepath.Base(path)
+		if !seen[name] {
+			seen[name] = true
+			paths = append(paths, path)
+		}
+	}
+
+	for _, path := range configured {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			for _, p := range findInDir(path) {
+				add(p)
+			}
+		} else if err == nil {
+			add(path)
+		}
+	}
+
+	count := len(paths)
+
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		for _, path := range findInDir(dir) {
+			add(path)
+		}
+	}
+
+	return paths, count
+}
+
+// Resolver returns a task type resolver that discovers plugins when a task of an unknown type is prepared.
+// The configured plugins are described before those on the PATH and, within each, the plugin named after the
+// type is described first.  Plugins that cannot be described are skipped with a warning.
+func Resolver(configured []string) rocket.TaskTypeResolver {
+	r := &resolver{
+		configured: configured,
+		described:  make(map[string]bool),
+		types:      make(map[string]*TaskType),
+	}
+
+	return r.resolve
+}
+
+func (r *resolver) resolve(ctx context.Context, taskType string) (rocket.TaskType, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if tt, ok := r.types[taskType]; ok {
+		return tt, nil
+	}
+
+	paths, configured := find(r.configured)
+	rank := make(map[string]int, len(paths))
+	for i, path := range paths {
+		if i >= configured {
+			rank[path] += 2
+		}
+		if typeFromPath(path) != taskType {
+			rank[path]++
+		}
+	}
+
+	sort.SliceStable(paths, func(i, j int) bool {
+		return rank[paths[i]] < rank[paths[j]]
+	})
+
+	for _, path := range paths {
+		if r.described[path] {
+			continue
+		}
+		r.described[path] = true
+
+		tt, err := Load(ctx, path)
+		if err != nil {
+			loggee.Warnf("skipping plugin: %s", err)
+			continue
+		}
+
+		if _, ok := r.types[tt.Type()]; !ok {
+			r.types[tt.Type()] = tt
+		}
+
+		if tt.Type() == taskType {
+			return tt, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Register discovers plugins and registers their task types with the mission control.
+// Plugins that cannot be described or whose type is already registered are skipped with a warning.
+func Register(ctx context.Context, mc rocket.MissionController, configured []string) error {
+	types, err := mc.ListTaskTypes(ctx)
+	if err != nil {
+		return err
+	}
+
+	registered := make(map[string]bool)
+	for _, tt := range types {
+		registered[tt.Type] = true
+	}
+
+	for _, path := range Find(configured) {
+		tt, err := Load(ctx, path)
+		if err != nil {
+			loggee.Warnf("skipping plugin: %s", err)
+			continue
+		}
+
+		if registered[tt.Type()] {
+			loggee.Warnf("skipping plugin %s, task type %s is already registered", path, tt.Type())
+			continue
+		}
+
+		registered[tt.Type()] = true
+		mc.RegisterTaskTypes(tt)
+	}
+
+	return nil
+}
+
+// Path returns the path of the plugin executable.
+func (tt *TaskType) Path() string {
+	return tt.path
+}
+
+// Type returns the task type implemented by the plugin.
+func (tt *TaskType) Type() string {
+	return tt.info.Type
+}
+
+// Description returns the plugin's description.
+func (tt *TaskType) Description() string {
+	return tt.info.Description
+}
+
+// Prepare checks the task definition against the plugin schema and returns a function running the plugin.
+func (tt *TaskType) Prepare(ctx context.Context, capComm *rocket.CapComm, task rocket.Task) (rocket.ExecuteFunc, error) {
+	definition := make(map[string]interface{})
+	for k, v := range task.Definition {
+		definition[k] = v
+	}
+
+	for _, key := range tt.requiredKeys() {
+		if _, ok := definition[key]; !ok {
+			return nil, fmt.Errorf("%s task requires %s", tt.Type(), key)
+		}
+	}
+
+	fn := func(execCtx context.Context) error {
+		expanded, err := expandValue(execCtx, capComm, "definition", definition)
+		if err != nil {
+			return err
+		}
+
+		env := capComm.GetExecEnv()
+
+		req := Request{
+			Type:       tt.Type(),
+			Name:       task.Name,
+			Definition: expanded.(map[string]interface{}),
+			Env:        envMap(env),
+		}
+
+		return tt.run(execCtx, capComm, &req, env)
+	}
+
+	return fn, nil
+}
+
+// run executes the plugin, streaming its messages to the capComm.
+func (tt *TaskType) run(ctx context.Context, capComm *rocket.CapComm, req *Request, env []string) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return errors.Wrap(err, "encoding plugin request")
+	}
+
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, tt.path, runCommand)
+	cmd.Env = env
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return errors.Wrapf(err, "starting plugin %s", tt.path)
+	}
+
+	readErr := readMessages(capComm, stdout)
+
+	err = cmd.Wait()
+
+	for _, line := range strings.Split(strings.TrimSpace(stderr.String()), "\n") {
+		if line != "" {
+			capComm.Log().Warn(line)
+		}
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "plugin %s", tt.Type())
+	}
+
+	if readErr != nil {
+		return errors.Wrapf(readErr, "reading plugin %s messages", tt.Type())
+	}
+
+	return nil
+}
+
+// readMessages logs the message lines written by the plugin and sets any variables.
+// Lines that are not json messages are logged as info.  If a line cannot be read the rest
+// of the output is discarded, so the plugin is not blocked writing it, and the error returned.
+func readMessages(capComm *rocket.CapComm, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxMessageSize)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		var msg Message
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			capComm.Log().Info(line)
+			continue
+		}
+
+		if msg.Message != "" {
+			logMessage(capComm.Log(), msg)
+		}
+
+		keys := make([]string, 0, len(msg.Variables))
+		for k := range msg.Variables {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			capComm.SetLocalVariable(k, msg.Variables[k])
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		_, _ = io.Copy(io.Discard, r)
+		return err
+	}
+
+	return nil
+}
+
+func logMessage(log loggee.Logger, msg Message) {
+	switch strings.ToLower(msg.Level) {
+	case "debug":
+		log.Debug(msg.Message)
+	case "warn", "warning":
+		log.Warn(msg.Message)
+	case "error":
+		log.Error(msg.Message)
+	default:
+		log.Info(msg.Message)
+	}
+}
+
+// requiredKeys returns the required keys of the plugin schema.
+func (tt *TaskType) requiredKeys() []string {
+	required, ok := tt.info.Schema["required"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	keys := make([]string, 0, len(required))
+	for _, r := range required {
+		if key, ok := r.(string); ok {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// expandValue expands the string values of a decoded definition, converting maps to json compatible maps.
+func expandValue(ctx context.Context, capComm *rocket.CapComm, name string, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		expanded, err := capComm.ExpandString(ctx, name, v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "expanding %s", name)
+		}
+		return expanded, nil
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			expanded, err := expandValue(ctx, capComm, k, item)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = expanded
+		}
+		return m, nil
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			key := fmt.Sprintf("%v", k)
+			expanded, err := expandValue(ctx, capComm, key, item)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = expanded
+		}
+		return m, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			expanded, err := expandValue(ctx, capComm, name, item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = expanded
+		}
+		return list, nil
+	default:
+		return v, nil
+	}
+}
+
+// envMap converts a KEY=VALUE environment list into a map.
+func envMap(env []string) map[string]string {
+	m := make(map[string]string, len(env))
+	for _, kv := range env {
+		if i := strings.Index(kv, "="); i >= 0 {
+			m[kv[:i]] = kv[i+1:]
+		}
+	}
+
+	return m
+}
+
+// findInDir returns the plugin executables in dir.
+func findInDir(dir string) []string {
+	if dir == "" {
+		return nil
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, ExecutablePrefix+"*"))
+	if err != nil {
+		return nil
+	}
+
+	var paths []string
+	for _, path := range matches {
+		if isExecutable(path) {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return false
+	}
+
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(filepath.Ext(path), ".exe")
+	}
+
+	return info.Mode()&0111 != 0
+}
+
+// typeFromPath returns the task type from a plugin executable name.
+func typeFromPath(path string) string {
+	name := filepath.Base(path)
+	if runtime.GOOS == "windows" {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+
+	return strings.TrimPrefix(name, ExecutablePrefix)
+}
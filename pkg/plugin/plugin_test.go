@@ -0,0 +1,389 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/nehemming/cirocket/pkg/loggee"
+	"github.com/nehemming/cirocket/pkg/loggee/stdlog"
+	"github.com/nehemming/cirocket/pkg/rocket"
+)
+
+const greetPlugin = `#!/bin/sh
+if [ "$1" = "describe" ]; then
+  echo '{"type":"greet","description":"says hello","schema":{"required":["who"]}}'
+  exit 0
+fi
+cat > "$PLUGIN_OUT"
+echo '{"level":"info","message":"hello"}'
+echo 'plain text'
+echo '{"variables":{"greeted":"yes"}}'
+`
+
+const failPlugin = `#!/bin/sh
+if [ "$1" = "describe" ]; then
+  echo '{"description":"always fails"}'
+  exit 0
+fi
+echo 'broken' >&2
+exit 3
+`
+
+func writePlugin(t *testing.T, dir, name, script string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script plugins are not supported on windows")
+	}
+
+	path := filepath.Join(dir, ExecutablePrefix+name)
+	if err := os.WriteFile(path, []byte(script), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func readRequest(t *testing.T, path string) Request {
+	var req Request
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := json.Unmarshal(b, &req); err != nil {
+		t.Fatal(err)
+	}
+
+	return req
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := writePlugin(t, dir, "greet", greetPlugin)
+
+	tt, err := Load(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tt.Type() != "greet" || tt.Description() != "says hello" || tt.Path() != path {
+		t.Error("unexpected plugin", tt.Type(), tt.Description(), tt.Path())
+	}
+
+	if keys := tt.requiredKeys(); len(keys) != 1 || keys[0] != "who" {
+		t.Error("unexpected required keys", keys)
+	}
+
+	failed, err := Load(context.Background(), writePlugin(t, dir, "failing", failPlugin))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if failed.Type() != "failing" {
+		t.Error("type should default to the executable name", failed.Type())
+	}
+}
+
+func TestLoadNotPlugin(t *testing.T) {
+	dir := t.TempDir()
+	path := writePlugin(t, dir, "bad", "#!/bin/sh\necho not json\n")
+
+	if _, err := Load(context.Background(), path); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestFind(t *testing.T) {
+	pathDir := t.TempDir()
+	configDir := t.TempDir()
+
+	writePlugin(t, pathDir, "greet", greetPlugin)
+	writePlugin(t, configDir, "greet", greetPlugin)
+	failing := writePlugin(t, configDir, "failing", failPlugin)
+
+	if err := os.WriteFile(filepath.Join(pathDir, ExecutablePrefix+"data"), []byte("data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	oldPath := os.Getenv("PATH")
+	os.Setenv("PATH", pathDir)
+	defer os.Setenv("PATH", oldPath)
+
+	paths := Find([]string{failing})
+	if len(paths) != 2 || paths[0] != failing || paths[1] != filepath.Join(pathDir, ExecutablePrefix+"greet") {
+		t.Error("unexpected paths", paths)
+	}
+
+	// configured plugins take precedence over those on the path
+	paths = Find([]string{configDir})
+	if len(paths) != 2 || paths[0] != failing || paths[1] != filepath.Join(configDir, ExecutablePrefix+"greet") {
+		t.Error("unexpected paths from dir", paths)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	loggee.SetLogger(stdlog.New())
+
+	dir := t.TempDir()
+	writePlugin(t, dir, "greet", greetPlugin)
+	writePlugin(t, dir, "bad", "#!/bin/sh\nexit 1\n")
+
+	oldPath := os.Getenv("PATH")
+	os.Setenv("PATH", "")
+	defer os.Setenv("PATH", oldPath)
+
+	mc := rocket.NewMissionControl()
+	if err := Register(context.Background(), mc, []string{dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	types, err := mc.ListTaskTypes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(types) != 1 || types[0].Type != "greet" {
+		t.Error("unexpected types", types)
+	}
+}
+
+func TestResolver(t *testing.T) {
+	loggee.SetLogger(stdlog.New())
+
+	dir := t.TempDir()
+	writePlugin(t, dir, "greet", greetPlugin)
+	writePlugin(t, dir, "bad", "#!/bin/sh\nexit 1\n")
+
+	oldPath := os.Getenv("PATH")
+	os.Setenv("PATH", "")
+	defer os.Setenv("PATH", oldPath)
+
+	resolve := Resolver([]string{dir})
+
+	tt, err := resolve(context.Background(), "greet")
+	if err != nil || tt == nil || tt.Type() != "greet" {
+		t.Fatal("expected greet plugin", tt, err)
+	}
+
+	if again, _ := resolve(context.Background(), "greet"); again != tt {
+		t.Error("expected described plugin to be reused")
+	}
+
+	if tt, err := resolve(context.Background(), "missing"); err != nil || tt != nil {
+		t.Error("unexpected missing type", tt, err)
+	}
+
+	// the plugin script needs the path to run
+	os.Setenv("PATH", oldPath)
+
+	mc := rocket.NewMissionControl()
+	if err := mc.SetOptions(rocket.TaskTypeResolverOption(resolve)); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "out.json")
+	mission := map[string]interface{}{
+		"stages": []interface{}{
+			map[string]interface{}{
+				"name": "greeting",
+				"tasks": []interface{}{
+					map[string]interface{}{
+						"type": "greet",
+						"name": "resolved",
+						"who":  "world",
+						"env":  map[string]interface{}{"PLUGIN_OUT": out},
+					},
+				},
+			},
+		},
+	}
+
+	if err := mc.LaunchMission(context.Background(), filepath.Join(dir, "mission.yml"), mission); err != nil {
+		t.Fatal(err)
+	}
+
+	if req := readRequest(t, out); req.Name != "resolved" {
+		t.Error("unexpected request", req)
+	}
+}
+
+func TestResolverPrefersConfigured(t *testing.T) {
+	loggee.SetLogger(stdlog.New())
+
+	pathDir := t.TempDir()
+	configDir := t.TempDir()
+	writePlugin(t, pathDir, "greet", greetPlugin)
+	configured := writePlugin(t, configDir, "greet", greetPlugin)
+
+	oldPath := os.Getenv("PATH")
+	os.Setenv("PATH", pathDir+string(os.PathListSeparator)+oldPath)
+	defer os.Setenv("PATH", oldPath)
+
+	tt, err := Resolver([]string{configDir})(context.Background(), "greet")
+	if err != nil || tt == nil || tt.(*TaskType).Path() != configured {
+		t.Error("expected the configured plugin", tt, err)
+	}
+}
+
+func TestPluginLongMessage(t *testing.T) {
+	loggee.SetLogger(stdlog.New())
+
+	dir := t.TempDir()
+	script := `#!/bin/sh
+if [ "$1" = "describe" ]; then
+  echo '{"type":"long"}'
+  exit 0
+fi
+head -c 200000 /dev/zero | tr '\0' 'x'
+echo
+echo '{"variables":{"done":"yes"}}'
+`
+	tt, err := Load(context.Background(), writePlugin(t, dir, "long", script))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mc := rocket.NewMissionControl()
+	mc.RegisterTaskTypes(tt)
+
+	mission := map[string]interface{}{
+		"stages": []interface{}{
+			map[string]interface{}{
+				"name": "long",
+				"tasks": []interface{}{
+					map[string]interface{}{"type": "long", "name": "long", "export": []interface{}{"done"}},
+				},
+			},
+		},
+	}
+
+	if err := mc.LaunchMission(context.Background(), filepath.Join(dir, "mission.yml"), mission); err != nil {
+		t.Error("unexpected error", err)
+	}
+}
+
+func TestPluginRunsTasks(t *testing.T) {
+	loggee.SetLogger(stdlog.New())
+
+	dir := t.TempDir()
+	tt, err := Load(context.Background(), writePlugin(t, dir, "greet", greetPlugin))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mc := rocket.NewMissionControl()
+	mc.RegisterTaskTypes(tt)
+
+	first := filepath.Join(dir, "first.json")
+	second := filepath.Join(dir, "second.json")
+
+	mission := map[string]interface{}{
+		"params": []interface{}{
+			map[string]interface{}{"name": "who", "value": "world"},
+		},
+		"stages": []interface{}{
+			map[string]interface{}{
+				"name": "greeting",
+				"tasks": []interface{}{
+					map[string]interface{}{
+						"type": "greet",
+						"name": "first",
+						"who":  "{{.who}}",
+						"options": map[interface{}]interface{}{
+							"loud":  true,
+							"names": []interface{}{"{{.who}}", "bob"},
+						},
+						"env":    map[string]interface{}{"PLUGIN_OUT": first},
+						"export": []interface{}{"greeted"},
+					},
+					map[string]interface{}{
+						"type": "greet",
+						"name": "second",
+						"who":  "{{.Var.greeted}}",
+						"env":  map[string]interface{}{"PLUGIN_OUT": second},
+					},
+				},
+			},
+		},
+	}
+
+	if err := mc.LaunchMission(context.Background(), filepath.Join(dir, "mission.yml"), mission); err != nil {
+		t.Fatal(err)
+	}
+
+	req := readRequest(t, first)
+	if req.Type != "greet" || req.Name != "first" || req.Definition["who"] != "world" || req.Env["PLUGIN_OUT"] != first {
+		t.Error("unexpected request", req)
+	}
+
+	options, ok := req.Definition["options"].(map[string]interface{})
+	if !ok || options["loud"] != true {
+		t.Error("unexpected options", req.Definition["options"])
+	} else if names, ok := options["names"].([]interface{}); !ok || len(names) != 2 || names[0] != "world" {
+		t.Error("unexpected names", options["names"])
+	}
+
+	if req := readRequest(t, second); req.Definition["who"] != "yes" {
+		t.Error("variable not set from plugin", req.Definition["who"])
+	}
+}
+
+func TestPluginRequiredKeys(t *testing.T) {
+	dir := t.TempDir()
+	tt, err := Load(context.Background(), writePlugin(t, dir, "greet", greetPlugin))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tt.Prepare(context.Background(), nil, rocket.Task{Name: "missing", Definition: map[string]interface{}{}}); err == nil {
+		t.Error("expected missing key error")
+	}
+}
+
+func TestPluginFails(t *testing.T) {
+	loggee.SetLogger(stdlog.New())
+
+	dir := t.TempDir()
+	tt, err := Load(context.Background(), writePlugin(t, dir, "failing", failPlugin))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mc := rocket.NewMissionControl()
+	mc.RegisterTaskTypes(tt)
+
+	mission := map[string]interface{}{
+		"stages": []interface{}{
+			map[string]interface{}{
+				"name": "failing",
+				"tasks": []interface{}{
+					map[string]interface{}{"type": "failing", "name": "fail"},
+				},
+			},
+		},
+	}
+
+	if err := mc.LaunchMission(context.Background(), filepath.Join(dir, "mission.yml"), mission); err == nil {
+		t.Error("expected plugin failure")
+	}
+}
@@ -32,6 +32,12 @@ type (
 		timeout  time.Duration
 		optional bool
 	}
+
+	// cancelReadCloser cancels the context of a streamed read when closed.
+	cancelReadCloser struct {
+		io.ReadCloser
+		cancel context.CancelFunc
+	}
 )
 
 // NewURLProvider creates a url provider.
@@ -54,15 +60,31 @@ func (rp *urlResourceProvider) OpenWrite(ctx context.Context) (io.WriteCloser, e
 	return nil, errors.New("output is not supported")
 }
 
+// OpenRead opens the url for reading.  The body is streamed, the timeout covers reading the whole body.
 func (rp *urlResourceProvider) OpenRead(ctx context.Context) (io.ReadCloser, error) {
+	url, err := resource.UltimateURL(rp.url)
+	if err != nil {
+		return nil, err
+	}
+
 	ctxTimeout, cancel := context.WithTimeout(ctx, rp.timeout)
-	defer cancel()
 
-	b, err := resource.ReadResource(ctxTimeout, rp.url)
-	if err != nil && (resource.IsNotFoundError(err) == nil || !rp.optional) {
-		return nil, err
+	rc, err := resource.OpenRead(ctxTimeout, url)
+	if err != nil {
+		cancel()
+		if resource.IsNotFoundError(err) == nil || !rp.optional {
+			return nil, err
+		}
+
+		// Optional resources that are not found are empty
+		return resource.NewReadCloser(bytes.NewBuffer(nil)), nil
 	}
 
-	// Return the body (b can be safely nil)
-	return resource.NewReadCloser(bytes.NewBuffer(b)), nil
+	return &cancelReadCloser{ReadCloser: rc, cancel: cancel}, nil
+}
+
+func (rc *cancelReadCloser) Close() error {
+	err := rc.ReadCloser.Close()
+	rc.cancel()
+	return err
 }
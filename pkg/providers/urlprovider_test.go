@@ -19,6 +19,8 @@ package providers
 import (
 	"context"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -119,3 +121,48 @@ func TestNReadURLProviderErrorsNotFound(t *testing.T) {
 		t.Error("no open issue")
 	}
 }
+
+func TestReadURLProviderLocalServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/found" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("found"))
+	}))
+	defer server.Close()
+
+	p, err := NewURLProvider(server.URL+"/found", time.Second*10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := p.OpenRead(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := io.ReadAll(r)
+	if err != nil || string(b) != "found" {
+		t.Error("unexpected body", string(b), err)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Error("close", err)
+	}
+
+	p, err = NewURLProvider(server.URL+"/missing", time.Second*10, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = p.OpenRead(context.Background())
+	if err != nil {
+		t.Fatal("optional not found", err)
+	}
+	defer r.Close()
+
+	if b, err := io.ReadAll(r); err != nil || len(b) != 0 {
+		t.Error("expected empty body", string(b), err)
+	}
+}
@@ -0,0 +1,270 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resource
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/nehemming/cirocket/pkg/loggee"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+type (
+	// Cache is an on disk cache of http(s) resources.
+	// Cached resources are revalidated with the server using their ETag and Last-Modified headers.
+	// When offline resources are only served from the cache.
+	Cache struct {
+		dir     string
+		offline bool
+	}
+
+	// cacheMeta holds the validators of a cached resource and the digest of its body.
+	cacheMeta struct {
+		URL          string `json:"url"`
+		ETag         string `json:"etag,omitempty"`
+		LastModified string `json:"lastModified,omitempty"`
+		SHA256       string `json:"sha256,omitempty"`
+	}
+
+	// OfflineError indicates a resource is not cached and cannot be fetched while offline.
+	// Unlike a NotFoundError it is not treated as a missing optional resource.
+	OfflineError struct {
+		url string
+	}
+
+	cacheKey int
+)
+
+const cacheContextKey = cacheKey(0)
+
+// NewCache creates a cache stored in dir.  If offline is true resources are never fetched from the network.
+func NewCache(dir string, offline bool) *Cache {
+	return &Cache{dir: dir, offline: offline}
+}
+
+// Dir returns the directory of the cache.
+func (cache *Cache) Dir() string {
+	return cache.dir
+}
+
+// Offline returns true if the cache only serves cached resources.
+func (cache *Cache) Offline() bool {
+	return cache.offline
+}
+
+// Error converts the error into a string.
+func (oe *OfflineError) Error() string {
+	return fmt.Sprintf("%s is not cached and cannot be fetched offline", oe.url)
+}
+
+// IsOfflineError checks if an error is a *OfflineError and if so returns it. If it is not nil is returned.
+func IsOfflineError(err error) *OfflineError {
+	if oe, ok := errors.Cause(err).(*OfflineError); ok {
+		return oe
+	}
+
+	return nil
+}
+
+// ContextWithCache returns a context carrying the cache.
+func ContextWithCache(ctx context.Context, cache *Cache) context.Context {
+	return context.WithValue(ctx, cacheContextKey, cache)
+}
+
+// CacheFromContext returns the cache carried by the context or nil if there is none.
+func CacheFromContext(ctx context.Context) *Cache {
+	cache, _ := ctx.Value(cacheContextKey).(*Cache)
+	return cache
+}
+
+// read returns the resource from the cache, revalidating it with the server unless offline.
+func (cache *Cache) read(ctx context.Context, url string) ([]byte, error) {
+	bodyPath, metaPath := cache.paths(url)
+
+	meta, body := cache.load(bodyPath, metaPath)
+
+	if cache.offline {
+		if body == nil {
+			return nil, &OfflineError{url: url}
+		}
+		return body, nil
+	}
+
+	req, err := newGetRequest(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		if meta.ETag != "" {
+			req.Header.Set("If-None-Match", meta.ETag)
+		}
+		if meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", meta.LastModified)
+		}
+	}
+
+	resp, err := ctxhttp.Do(ctx, nil, req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting %s", url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && body != nil {
+		return body, nil
+	}
+
+	b, err := readResponse(url, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	meta = cacheMeta{
+		URL:          url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		SHA256:       sha256Hex(b),
+	}
+
+	// the resource was fetched, failing to cache it only loses the copy
+	if err := cache.store(bodyPath, metaPath, meta, b); err != nil {
+		loggee.Warnf("not caching %s: %s", url, err)
+	}
+
+	return b, nil
+}
+
+// paths returns the locations of the cached body and meta data files for a url.
+func (cache *Cache) paths(url string) (string, string) {
+	key := sha256Hex([]byte(url))
+
+	return filepath.Join(cache.dir, key+".body"), filepath.Join(cache.dir, key+".json")
+}
+
+// load reads a cached resource, a nil body is returned if the resource is not cached.
+func (cache *Cache) load(bodyPath, metaPath string) (cacheMeta, []byte) {
+	var meta cacheMeta
+
+	b, err := os.ReadFile(metaPath)
+	if err != nil {
+		return meta, nil
+	}
+
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return meta, nil
+	}
+
+	body, err := os.ReadFile(bodyPath)
+	if err != nil {
+		return meta, nil
+	}
+
+	// a body replaced by another fetch since the meta data was written is not used
+	if meta.SHA256 != "" && meta.SHA256 != sha256Hex(body) {
+		return meta, nil
+	}
+
+	return meta, body
+}
+
+// store writes a resource to the cache.  The body is written before its meta data and both files are
+// replaced atomically, so concurrent or interrupted stores do not leave a corrupt entry.
+func (cache *Cache) store(bodyPath, metaPath string, meta cacheMeta, body []byte) error {
+	if err := os.MkdirAll(cache.dir, 0777); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(&meta)
+	if err != nil {
+		return err
+	}
+
+	if err := writeCacheFile(bodyPath, body); err != nil {
+		return err
+	}
+
+	return writeCacheFile(metaPath, b)
+}
+
+// writeCacheFile writes to a temporary file in the cache directory and renames it over the path.
+func writeCacheFile(path string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".cache-*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+
+	return err
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// checkResponse returns an error if the response is not successful.
+func checkResponse(url string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return NewNotFoundError(url, nil)
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		// Bad response
+		return fmt.Errorf("response (%d) %s for %s", resp.StatusCode, resp.Status, url)
+	}
+
+	return nil
+}
+
+// readResponse reads the body of a successful response.
+func readResponse(url string, resp *http.Response) ([]byte, error) {
+	if err := checkResponse(url, resp); err != nil {
+		return nil, err
+	}
+
+	b := new(bytes.Buffer)
+	_, err := io.Copy(b, resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "extracting body %s", url)
+	}
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,196 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resource
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nehemming/cirocket/pkg/loggee"
+	"github.com/nehemming/cirocket/pkg/loggee/stdlog"
+)
+
+func newCacheTestServer(body *string, fetches *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := fmt.Sprintf("%q", *body)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		*fetches++
+		w.Header().Set("ETag", etag)
+		fmt.Fprint(w, *body)
+	}))
+}
+
+func TestCacheRevalidates(t *testing.T) {
+	body := "one"
+	fetches := 0
+	server := newCacheTestServer(&body, &fetches)
+	defer server.Close()
+
+	ctx := ContextWithCache(context.Background(), NewCache(t.TempDir(), false))
+	u, _ := url.Parse(server.URL + "/include.yml")
+
+	for i := 0; i < 2; i++ {
+		b, err := ReadURL(ctx, u)
+		if err != nil || string(b) != "one" {
+			t.Error("unexpected read", i, string(b), err)
+		}
+	}
+
+	if fetches != 1 {
+		t.Error("expected cached copy to be revalidated", fetches)
+	}
+
+	body = "two"
+	b, err := ReadURL(ctx, u)
+	if err != nil || string(b) != "two" || fetches != 2 {
+		t.Error("expected changed body", string(b), err, fetches)
+	}
+}
+
+func TestCacheOffline(t *testing.T) {
+	body := "one"
+	fetches := 0
+	server := newCacheTestServer(&body, &fetches)
+	defer server.Close()
+
+	dir := t.TempDir()
+	u, _ := url.Parse(server.URL + "/include.yml")
+
+	if _, err := ReadURL(ContextWithCache(context.Background(), NewCache(dir, false)), u); err != nil {
+		t.Fatal(err)
+	}
+
+	offline := ContextWithCache(context.Background(), NewCache(dir, true))
+	server.Close()
+
+	b, err := ReadURL(offline, u)
+	if err != nil || string(b) != "one" {
+		t.Error("expected cached copy offline", string(b), err)
+	}
+
+	missing, _ := url.Parse(server.URL + "/missing.yml")
+	_, err = ReadURL(offline, missing)
+	if IsOfflineError(err) == nil || IsNotFoundError(err) != nil {
+		t.Error("expected offline error", err)
+	}
+
+	if _, _, err := Search(offline, "missing.yml", nil, server.URL); IsOfflineError(err) == nil {
+		t.Error("expected search to fail offline", err)
+	}
+}
+
+func TestCacheBypassed(t *testing.T) {
+	body := "one"
+	fetches := 0
+	server := newCacheTestServer(&body, &fetches)
+	defer server.Close()
+
+	dir := filepath.Join(t.TempDir(), "cache")
+	ctx := ContextWithCache(context.Background(), NewCache(dir, false))
+	u, _ := url.Parse(server.URL + "/include.yml")
+
+	header := make(http.Header)
+	header.Set("Authorization", "Bearer secret")
+
+	for _, bypass := range []context.Context{ContextWithoutCache(ctx), ContextWithHeader(ctx, header)} {
+		rc, err := OpenRead(bypass, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc.Close()
+	}
+
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Error("expected the cache to be bypassed", err)
+	}
+
+	offline := ContextWithCache(context.Background(), NewCache(dir, true))
+	if _, err := ReadURL(ContextWithoutCache(offline), u); IsOfflineError(err) == nil {
+		t.Error("expected offline error", err)
+	}
+}
+
+func TestCacheStoreFailure(t *testing.T) {
+	body := "one"
+	fetches := 0
+	server := newCacheTestServer(&body, &fetches)
+	defer server.Close()
+
+	loggee.SetLogger(stdlog.New())
+
+	// the cache directory cannot be created below a file
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, []byte("file"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse(server.URL + "/include.yml")
+	b, err := ReadURL(ContextWithCache(context.Background(), NewCache(filepath.Join(file, "cache"), false)), u)
+	if err != nil || string(b) != "one" {
+		t.Error("expected fetched body", string(b), err)
+	}
+}
+
+func TestCacheReplacedBody(t *testing.T) {
+	body := "one"
+	fetches := 0
+	server := newCacheTestServer(&body, &fetches)
+	defer server.Close()
+
+	cache := NewCache(t.TempDir(), false)
+	u, _ := url.Parse(server.URL + "/include.yml")
+	if _, err := ReadURL(ContextWithCache(context.Background(), cache), u); err != nil {
+		t.Fatal(err)
+	}
+
+	bodyPath, _ := cache.paths(u.String())
+	if err := os.WriteFile(bodyPath, []byte("other"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReadURL(ContextWithCache(context.Background(), NewCache(cache.Dir(), true)), u); IsOfflineError(err) == nil {
+		t.Error("expected a body not matching its meta data to be uncached", err)
+	}
+
+	entries, _ := os.ReadDir(cache.Dir())
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".tmp" {
+			t.Error("temporary file left", entry.Name())
+		}
+	}
+}
+
+func TestCacheFromContext(t *testing.T) {
+	if CacheFromContext(context.Background()) != nil {
+		t.Error("unexpected cache")
+	}
+
+	cache := NewCache("dir", true)
+	if CacheFromContext(ContextWithCache(context.Background(), cache)) != cache || !cache.Offline() || cache.Dir() != "dir" {
+		t.Error("cache not in context")
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resource
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// LockFileName is the default name of the lock file.
+const LockFileName = "cirocket.lock"
+
+const lockFileVersion = 1
+
+type (
+	// LockEntry records the SHA-256 hash of a remote document.
+	LockEntry struct {
+		URL    string `yaml:"url"`
+		SHA256 string `yaml:"sha256"`
+	}
+
+	// Lock holds the integrity hashes of the remote documents loaded by a mission.
+	// Documents with an entry must match the recorded hash.  A recording lock adds the
+	// documents without an entry, other locks reject them.
+	Lock struct {
+		path      string
+		mu        sync.Mutex
+		entries   map[string]string
+		recording bool
+		changed   bool
+	}
+
+	lockFile struct {
+		Version   int         `yaml:"version"`
+		Documents []LockEntry `yaml:"documents"`
+	}
+
+	lockKey int
+)
+
+const lockContextKey = lockKey(0)
+
+// NewLock creates an empty recording lock that will be saved to path.
+func NewLock(path string) *Lock {
+	return &Lock{
+		path:      path,
+		entries:   make(map[string]string),
+		recording: true,
+	}
+}
+
+// LoadLock loads the lock file at path. Documents not in the file are rejected by the loaded lock.
+// If the file does not exist nil is returned.
+func LoadLock(path string) (*Lock, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	lock := &Lock{
+		path:    path,
+		entries: make(map[string]string),
+	}
+
+	var lf lockFile
+	if err := yaml.Unmarshal(b, &lf); err != nil {
+		return nil, errors.Wrapf(err, "lock file %s", path)
+	}
+
+	if lf.Version > lockFileVersion {
+		return nil, fmt.Errorf("lock file %s version %d is not supported", path, lf.Version)
+	}
+
+	for _, e := range lf.Documents {
+		lock.entries[e.URL] = e.SHA256
+	}
+
+	return lock, nil
+}
+
+// Path returns the location of the lock file.
+func (lock *Lock) Path() string {
+	return lock.path
+}
+
+// Verify checks the content of a remote document against its locked hash.
+// Documents not already in the lock are recorded by a recording lock and rejected
+// by any other. Only http(s) urls are locked.
+func (lock *Lock) Verify(url *url.URL, content []byte) error {
+	if url.Scheme != "http" && url.Scheme != "https" {
+		return nil
+	}
+
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	key := url.String()
+
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+
+	locked, ok := lock.entries[key]
+	if !ok {
+		if !lock.recording {
+			return fmt.Errorf("%s is not in %s, run lock update to add it", key, lock.path)
+		}
+		lock.entries[key] = hash
+		lock.changed = true
+		return nil
+	}
+
+	if locked != hash {
+		return fmt.Errorf("%s does not match the hash in %s, if the change is expected run lock update", key, lock.path)
+	}
+
+	return nil
+}
+
+// Entries returns the locked documents sorted by url.
+func (lock *Lock) Entries() []LockEntry {
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+
+	entries := make([]LockEntry, 0, len(lock.entries))
+	for u, h := range lock.entries {
+		entries = append(entries, LockEntry{URL: u, SHA256: h})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].URL < entries[j].URL })
+
+	return entries
+}
+
+// Changed returns true if documents have been added since the lock was loaded.
+func (lock *Lock) Changed() bool {
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	return lock.changed
+}
+
+// Save writes the lock file.
+func (lock *Lock) Save() error {
+	b, err := yaml.Marshal(&lockFile{
+		Version:   lockFileVersion,
+		Documents: lock.Entries(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(lock.path, b, 0666); err != nil {
+		return err
+	}
+
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	lock.changed = false
+
+	return nil
+}
+
+// ContextWithLock returns a context carrying the lock.
+func ContextWithLock(ctx context.Context, lock *Lock) context.Context {
+	return context.WithValue(ctx, lockContextKey, lock)
+}
+
+// LockFromContext returns the lock carried by the context or nil if there is none.
+func LockFromContext(ctx context.Context) *Lock {
+	lock, _ := ctx.Value(lockContextKey).(*Lock)
+	return lock
+}
+
+// VerifyLocked verifies the content against the lock carried by the context.
+// If the context has no lock no check is made.
+func VerifyLocked(ctx context.Context, url *url.URL, content []byte) error {
+	lock := LockFromContext(ctx)
+	if lock == nil {
+		return nil
+	}
+
+	return lock.Verify(url, content)
+}
@@ -0,0 +1,120 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resource
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockVerifyRecordsAndChecks(t *testing.T) {
+	lock := NewLock("test.lock")
+	u, _ := url.Parse("https://example.com/include.yml")
+
+	if err := lock.Verify(u, []byte("one")); err != nil {
+		t.Error("unexpected error", err)
+	}
+	if !lock.Changed() || len(lock.Entries()) != 1 {
+		t.Error("expected entry to be recorded")
+	}
+
+	if err := lock.Verify(u, []byte("one")); err != nil {
+		t.Error("unexpected error", err)
+	}
+	if err := lock.Verify(u, []byte("two")); err == nil {
+		t.Error("expected hash mismatch")
+	}
+}
+
+func TestLockVerifyIgnoresFiles(t *testing.T) {
+	lock := NewLock("test.lock")
+	u, _ := url.Parse("file:///tmp/include.yml")
+
+	if err := lock.Verify(u, []byte("one")); err != nil || lock.Changed() {
+		t.Error("file urls should not be locked", err)
+	}
+}
+
+func TestLockSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), LockFileName)
+
+	missing, err := LoadLock(path)
+	if err != nil || missing != nil {
+		t.Fatal("unexpected missing lock", missing, err)
+	}
+
+	u, _ := url.Parse("https://example.com/b.yml")
+	recording := NewLock(path)
+	_ = recording.Verify(u, []byte("b"))
+	if err := recording.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err := LoadLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := lock.Entries()
+	if len(entries) != 1 || entries[0].URL != u.String() ||
+		entries[0].SHA256 != "3e23e8160039594a33894f6564e1b1348bbd7a0088d42c4acb73eeaed59c009d" {
+		t.Error("unexpected entries", entries)
+	}
+	if lock.Changed() {
+		t.Error("loaded lock should not be changed")
+	}
+
+	if err := lock.Verify(u, []byte("b")); err != nil {
+		t.Error("unexpected error", err)
+	}
+
+	other, _ := url.Parse("https://example.com/other.yml")
+	if err := lock.Verify(other, []byte("other")); err == nil {
+		t.Error("expected unlocked document to fail")
+	}
+	if lock.Changed() || len(lock.Entries()) != 1 {
+		t.Error("loaded lock should not record documents")
+	}
+}
+
+func TestSearchVerifiesLock(t *testing.T) {
+	body := "first"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+
+	lock := NewLock("test.lock")
+	ctx := ContextWithLock(context.Background(), lock)
+
+	if LockFromContext(ctx) != lock {
+		t.Error("lock not in context")
+	}
+
+	if _, _, err := Search(ctx, "blue/blueprint.yml", nil, server.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	body = "second"
+	if _, _, err := Search(ctx, "blue/blueprint.yml", nil, server.URL); err == nil {
+		t.Error("expected changed document to fail")
+	}
+}
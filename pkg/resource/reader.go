@@ -29,6 +29,16 @@ import (
 	"golang.org/x/net/context/ctxhttp"
 )
 
+type (
+	headerKey  int
+	noCacheKey int
+)
+
+const (
+	headerContextKey  = headerKey(0)
+	noCacheContextKey = noCacheKey(0)
+)
+
 // ReadResource reads a resource into a byte array or returns an error.
 // The resource parts are merged left to right using UltimateURL.
 // Once defined the url is then access and read.
@@ -49,15 +59,12 @@ func ReadResource(ctx context.Context, pathParts ...string) ([]byte, error) {
 
 // OpenRead opens a URL resource for reading.
 //
-// http(s) and file schemes are supported.
+// http(s) and file schemes are supported.  Uncached http(s) resources, including those requested
+// with headers, are streamed from the response body.
 func OpenRead(ctx context.Context, url *url.URL) (io.ReadCloser, error) {
 	switch url.Scheme {
 	case "http", "https":
-		b, err := readHTTP(ctx, url.String())
-		if err != nil {
-			return nil, err
-		}
-		return NewReadCloser(bytes.NewBuffer(b)), nil
+		return openHTTP(ctx, url.String())
 	case "file":
 		osPath, err := URLToPath(url)
 		if err != nil {
@@ -79,6 +86,8 @@ func OpenRead(ctx context.Context, url *url.URL) (io.ReadCloser, error) {
 }
 
 // ReadURL reads the contents located by the url into a byte slice or returns an error.
+// If the context carries a cache, http(s) resources are read through it unless they are requested
+// with headers or the context is marked as uncached.
 func ReadURL(ctx context.Context, url *url.URL) ([]byte, error) {
 	switch url.Scheme {
 	case "http", "https":
@@ -108,9 +117,18 @@ func readFile(path string) ([]byte, error) {
 }
 
 func readHTTP(ctx context.Context, url string) ([]byte, error) {
-	req, err := http.NewRequest("GET", url, nil)
+	// Use the cache if one has been provided
+	cache, err := contextCache(ctx, url)
 	if err != nil {
-		return nil, errors.Wrapf(err, "creating request %s", url)
+		return nil, err
+	}
+	if cache != nil {
+		return cache.read(ctx, url)
+	}
+
+	req, err := newGetRequest(ctx, url)
+	if err != nil {
+		return nil, err
 	}
 
 	resp, err := ctxhttp.Do(ctx, nil, req)
@@ -119,20 +137,86 @@ func readHTTP(ctx context.Context, url string) ([]byte, error) {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, NewNotFoundError(url, nil)
+	return readResponse(url, resp)
+}
+
+func openHTTP(ctx context.Context, url string) (io.ReadCloser, error) {
+	// Cached resources are held on disk, read them through the cache
+	cache, err := contextCache(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		b, err := cache.read(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		return NewReadCloser(bytes.NewBuffer(b)), nil
 	}
 
-	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
-		// Bad response
-		return nil, fmt.Errorf("response (%d) %s for %s", resp.StatusCode, resp.Status, url)
+	req, err := newGetRequest(ctx, url)
+	if err != nil {
+		return nil, err
 	}
 
-	b := new(bytes.Buffer)
-	_, err = io.Copy(b, resp.Body)
+	resp, err := ctxhttp.Do(ctx, nil, req)
 	if err != nil {
-		return nil, errors.Wrapf(err, "extracting body %s", url)
+		return nil, errors.Wrapf(err, "getting %s", url)
+	}
+
+	if err := checkResponse(url, resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// contextCache returns the cache used to read the url.  Nil is returned if the context has no cache,
+// or the request bypasses it, in which case an offline cache returns an error.
+func contextCache(ctx context.Context, url string) (*Cache, error) {
+	cache := CacheFromContext(ctx)
+	if cache == nil {
+		return nil, nil
+	}
+
+	if bypass, _ := ctx.Value(noCacheContextKey).(bool); !bypass && HeaderFromContext(ctx) == nil {
+		return cache, nil
+	}
+
+	if cache.offline {
+		return nil, &OfflineError{url: url}
+	}
+
+	return nil, nil
+}
+
+// ContextWithoutCache returns a context whose http(s) requests are not read from, or stored in, the cache.
+func ContextWithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheContextKey, true)
+}
+
+// ContextWithHeader returns a context carrying headers that are added to the http(s) requests made with it.
+func ContextWithHeader(ctx context.Context, header http.Header) context.Context {
+	return context.WithValue(ctx, headerContextKey, header)
+}
+
+// HeaderFromContext returns the headers carried by the context or nil if there are none.
+func HeaderFromContext(ctx context.Context) http.Header {
+	header, _ := ctx.Value(headerContextKey).(http.Header)
+	return header
+}
+
+// newGetRequest creates a GET request for the url with any headers carried by the context.
+func newGetRequest(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating request %s", url)
+	}
+
+	for k, v := range HeaderFromContext(ctx) {
+		req.Header[k] = v
 	}
 
-	return b.Bytes(), nil
+	return req, nil
 }
@@ -19,6 +19,9 @@ package resource
 import (
 	"context"
 	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"runtime"
 	"strings"
 	"testing"
@@ -185,3 +188,42 @@ func TestOpenReadSchemeErrors(t *testing.T) {
 		t.Error("expected error")
 	}
 }
+
+func TestOpenReadHTTPStreamsWithHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "abc" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte("streamed"))
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenRead(context.Background(), u); err == nil {
+		t.Error("expected forbidden error")
+	}
+
+	header := make(http.Header)
+	header.Set("X-Token", "abc")
+	ctx := ContextWithHeader(context.Background(), header)
+
+	if HeaderFromContext(ctx).Get("X-Token") != "abc" {
+		t.Error("header not in context")
+	}
+
+	rc, err := OpenRead(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	b, err := io.ReadAll(rc)
+	if err != nil || string(b) != "streamed" {
+		t.Error("unexpected body", string(b), err)
+	}
+}
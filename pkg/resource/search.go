@@ -30,6 +30,7 @@ type Progress func(string, *url.URL, *NotFoundError)
 // If the resource could not be found an error is returned.
 // The search will stop on any error other than a not found error.
 // The relLocation and absSources are merged using UltimateURL.
+// If the context carries a lock the found resource is verified against it.
 func Search(ctx context.Context, relLocation string, progress Progress, absSources ...string) ([]byte, *url.URL, error) {
 	for _, source := range absSources {
 		// Cancelled
@@ -59,7 +60,11 @@ func Search(ctx context.Context, relLocation string, progress Progress, absSourc
 			return nil, nil, err
 		}
 
-		// Found
+		// Found, check it has not changed since it was locked
+		if err := VerifyLocked(ctx, url, b); err != nil {
+			return nil, nil, err
+		}
+
 		if progress != nil {
 			progress(source, url, nil)
 		}
@@ -17,7 +17,6 @@ limitations under the License.
 package rocket
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"net/url"
@@ -29,7 +28,6 @@ import (
 	"github.com/nehemming/cirocket/pkg/loggee"
 	"github.com/nehemming/cirocket/pkg/resource"
 	"github.com/pkg/errors"
-	"gopkg.in/yaml.v2"
 )
 
 // Assemble locates a blueprint from the assembly sources, loads the runbook and builds the assembly following the blueprint.
@@ -77,8 +75,7 @@ func loadMapFromLocation(ctx context.Context,
 	var missionMap map[string]interface{}
 	if location.Inline != "" {
 		// inline, mission text
-		b := bytes.NewBufferString(location.Inline)
-		err = yaml.NewDecoder(b).Decode(&missionMap)
+		missionMap, err = decodeDocument("", []byte(location.Inline))
 		if err != nil {
 			return nil, "", err
 		}
@@ -158,9 +155,12 @@ func (mc *missionControl) searchSources(ctx context.Context, blueprintName strin
 	sources []string) (*Blueprint, string, error) {
 	blueprintName = filepath.ToSlash(blueprintName)
 
-	if strings.HasSuffix(blueprintName, manifestFileName) {
-		// name includes blueprint runbook file, strip as added later
-		blueprintName = path.Dir(blueprintName)
+	for _, name := range documentNames(manifestFileName) {
+		if strings.HasSuffix(blueprintName, name) {
+			// name includes blueprint runbook file, strip as added later
+			blueprintName = path.Dir(blueprintName)
+			break
+		}
 	}
 
 	if isBlueprintNameAbs(blueprintName) {
@@ -186,7 +186,7 @@ func loadBlueprint(ctx context.Context, blueprintName string,
 	log loggee.Logger, sources []string) (*Blueprint, string, error) {
 	path := path.Join(blueprintName, manifestFileName[1:])
 
-	b, u, err := resource.Search(ctx, path, reportProgress(blueprintName, log), sources...)
+	b, u, err := searchDocument(ctx, path, reportProgress(blueprintName, log), sources...)
 	if err != nil {
 		// relate back to blue print rather than path
 		if nfe := resource.IsNotFoundError(err); nfe != nil {
@@ -196,16 +196,17 @@ func loadBlueprint(ctx context.Context, blueprintName string,
 		return nil, "", err
 	}
 
+	blueprint, err := decodeBluerint(u.Path, b)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "blueprint %s", blueprintName)
+	}
+
 	// get back to the
 	u, err = resource.GetParentLocation(u.String())
 	if err != nil {
 		return nil, "", err
 	}
 	location := u.String()
-	blueprint, err := decodeBluerint(b)
-	if err != nil {
-		return nil, "", errors.Wrapf(err, "blueprint %s", blueprintName)
-	}
 
 	// finally init files
 	if blueprint.Name == "" {
@@ -216,9 +217,8 @@ func loadBlueprint(ctx context.Context, blueprintName string,
 	return blueprint, location, nil
 }
 
-func decodeBluerint(b []byte) (*Blueprint, error) {
-	blueStuff := make(map[string]interface{})
-	err := yaml.NewDecoder(bytes.NewBuffer(b)).Decode(&blueStuff)
+func decodeBluerint(location string, b []byte) (*Blueprint, error) {
+	blueStuff, err := decodeDocument(location, b)
 	if err != nil {
 		return nil, err
 	}
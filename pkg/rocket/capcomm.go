@@ -109,14 +109,18 @@ type (
 		env                   Getter
 		entrustedParentEnv    Getter
 		funcMap               template.FuncMap
+		templates             *template.Template
 		additionalMissionData TemplateData
 		params                Getter
+		baseParams            Getter
 		runtime               Runtime
 		sealed                bool
 		mission               *Mission
 		resources             providers.ResourceProviderMap
 		variables             *variableSet
 		exportTo              *variableSet
+		stageExports          *variableSet
+		sandbox               []string
 		log                   loggee.Logger
 	}
 )
@@ -195,11 +199,14 @@ func (capComm *CapComm) Copy(noTrust bool) *CapComm {
 		mission:               capComm.mission,
 		additionalMissionData: capComm.additionalMissionData, // no copy, but safe as set once
 		funcMap:               make(template.FuncMap),
+		templates:             capComm.templates,
 		params:                NewKeyValueGetter(capComm.params),
+		baseParams:            capComm.baseParams,
 		runtime:               capComm.runtime,
 		resources:             capComm.resources.Copy(),
 		variables:             newVariableSet(),
 		exportTo:              capComm.variables,
+		sandbox:               capComm.sandbox,
 		log:                   capComm.log,
 	}
 
@@ -300,6 +307,11 @@ func (capComm *CapComm) GetVariable(key string) (string, bool) {
 	return "", false
 }
 
+// GetParam returns the value of a param, if the param is not defined an empty string is returned.
+func (capComm *CapComm) GetParam(key string) string {
+	return capComm.params.Get(key)
+}
+
 // WithMission attaches the mission to the CapComm.
 func (capComm *CapComm) WithMission(mission *Mission) *CapComm {
 	capComm.mustNotBeSealed()
@@ -309,6 +321,99 @@ func (capComm *CapComm) WithMission(mission *Mission) *CapComm {
 	return capComm
 }
 
+// SetSandbox confines the paths file tasks can change to the sandbox's root, or the mission's directory
+// if no root is given, and its allowed directories.  Relative paths are relative to the working directory.
+// A disabled sandbox leaves the paths unrestricted.
+func (capComm *CapComm) SetSandbox(ctx context.Context, sandbox *Sandbox) error {
+	capComm.mustNotBeSealed()
+
+	if sandbox != nil && sandbox.Disabled {
+		capComm.sandbox = nil
+		return nil
+	}
+
+	root := capComm.GetParam(MissionDirAbsParamName)
+	if root == "" {
+		root = capComm.GetParam(WorkingDirectoryParamName)
+	}
+
+	var dirs []string
+	if sandbox != nil {
+		if sandbox.Root != "" {
+			dirs = append(dirs, sandbox.Root)
+		}
+		dirs = append(dirs, sandbox.Allow...)
+	}
+
+	if sandbox == nil || sandbox.Root == "" {
+		capComm.sandbox = []string{resolveSandboxPath(root)}
+	} else {
+		capComm.sandbox = nil
+	}
+
+	for index, dir := range dirs {
+		expanded, err := capComm.ExpandString(ctx, "sandbox", dir)
+		if err != nil {
+			return errors.Wrapf(err, "expanding sandbox %d", index)
+		}
+
+		if expanded == "" {
+			return fmt.Errorf("sandbox %d is blank", index)
+		}
+
+		path, err := filepath.Abs(filepath.FromSlash(expanded))
+		if err != nil {
+			return errors.Wrapf(err, "sandbox %s", expanded)
+		}
+
+		capComm.sandbox = append(capComm.sandbox, resolveSandboxPath(path))
+	}
+
+	return nil
+}
+
+// CheckSandbox returns an error if the path is outside of the sandbox.  Paths are unrestricted
+// if no sandbox has been set.
+func (capComm *CapComm) CheckSandbox(path string) error {
+	if len(capComm.sandbox) == 0 {
+		return nil
+	}
+
+	abs, err := filepath.Abs(filepath.FromSlash(path))
+	if err != nil {
+		return errors.Wrapf(err, "sandbox check %s", path)
+	}
+	abs = resolveSandboxPath(abs)
+
+	for _, dir := range capComm.sandbox {
+		if rel, err := filepath.Rel(dir, abs); err == nil &&
+			rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s is outside of the sandbox %s, set the mission's sandbox: root or allow to change it",
+		path, strings.Join(capComm.sandbox, ", "))
+}
+
+// resolveSandboxPath resolves the symbolic links of the longest existing part of an absolute path.
+func resolveSandboxPath(path string) string {
+	path = filepath.Clean(path)
+	for dir, rest := path, ""; ; {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(resolved, rest)
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return path
+		}
+
+		rest = filepath.Join(filepath.Base(dir), rest)
+		dir = parent
+	}
+}
+
 // MergeBasicEnvMap adds environment variables into an unsealed CapComm.
 func (capComm *CapComm) MergeBasicEnvMap(env VarMap) *CapComm {
 	capComm.mustNotBeSealed()
@@ -639,7 +744,9 @@ func (capComm *CapComm) MergeParams(ctx context.Context, params []Param) error {
 
 	for index, p := range params {
 		// exclude filtered
-		if p.Filter.IsFiltered() {
+		if filtered, err := capComm.isFiltered(p.Filter); err != nil {
+			return errors.Wrapf(err, "parameter %d filter", index)
+		} else if filtered {
 			continue
 		}
 
@@ -660,6 +767,11 @@ func (capComm *CapComm) MergeParams(ctx context.Context, params []Param) error {
 	return nil
 }
 
+// isFiltered checks the filter against the environment and params of the CapComm.
+func (capComm *CapComm) isFiltered(filter *Filter) (bool, error) {
+	return filter.IsFilteredBy(capComm.env, capComm.params)
+}
+
 // MergeTemplateEnvs adds params into an unsealed CapComm instance.
 func (capComm *CapComm) MergeTemplateEnvs(ctx context.Context, env VarMap) error {
 	// Envs need to be expanded prior to merging
@@ -711,11 +823,12 @@ func (capComm *CapComm) ExpandString(ctx context.Context, name, value string) (s
 	}
 
 	// Create the template
-	template, err := template.New(name).
-		Option("missingkey=zero").
-		Funcs(capComm.funcMap).
-		Parse(value)
+	template, err := capComm.NewTemplate(name)
 	if err != nil {
+		return "", err
+	}
+
+	if _, err = template.Parse(value); err != nil {
 		return "", errors.Wrap(err, "parsing template")
 	}
 
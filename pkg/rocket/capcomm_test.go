@@ -1731,3 +1731,77 @@ func TestGetTemplateDataWithCache(t *testing.T) { //nolint:cyclop
 		t.Error("missing test hacked data from cache", v)
 	}
 }
+
+func TestSandbox(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "mission")
+	if err := os.MkdirAll(root, 0o777); err != nil {
+		t.Fatal(err)
+	}
+
+	capComm := NewCapComm(filepath.Join(root, "mission.yml"), stdlog.New())
+	if err := capComm.CheckSandbox("/"); err != nil {
+		t.Error("unexpected sandbox before set", err)
+	}
+
+	if err := capComm.SetSandbox(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := capComm.CheckSandbox(filepath.Join(root, "build", "new", "file")); err != nil {
+		t.Error("inside", err)
+	}
+
+	for _, path := range []string{"/", dir, filepath.Join(root, "..", "other"), root + "-other"} {
+		if err := capComm.CheckSandbox(path); err == nil {
+			t.Error("expected outside error", path)
+		}
+	}
+
+	if runtime.GOOS != "windows" {
+		if err := os.Symlink(dir, filepath.Join(root, "escape")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := capComm.CheckSandbox(filepath.Join(root, "escape", "file")); err == nil {
+			t.Error("expected symlink escape error")
+		}
+	}
+
+	// allowed directories and copies
+	capComm = NewCapComm(filepath.Join(root, "mission.yml"), stdlog.New())
+	if err := capComm.SetSandbox(context.Background(), &Sandbox{Allow: []string{filepath.Join(dir, "cache")}}); err != nil {
+		t.Fatal(err)
+	}
+
+	copied := capComm.Copy(false)
+	for _, path := range []string{filepath.Join(root, "a"), filepath.Join(dir, "cache", "b")} {
+		if err := copied.CheckSandbox(path); err != nil {
+			t.Error("expected allowed", path, err)
+		}
+	}
+
+	// a root replaces the mission directory
+	capComm = NewCapComm(filepath.Join(root, "mission.yml"), stdlog.New())
+	if err := capComm.SetSandbox(context.Background(), &Sandbox{Root: filepath.Join(dir, "build")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := capComm.CheckSandbox(filepath.Join(root, "a")); err == nil {
+		t.Error("expected mission dir outside of root")
+	}
+
+	if err := capComm.SetSandbox(context.Background(), &Sandbox{Allow: []string{""}}); err == nil {
+		t.Error("expected blank sandbox error")
+	}
+
+	// a disabled sandbox allows any path
+	capComm = NewCapComm(filepath.Join(root, "mission.yml"), stdlog.New())
+	if err := capComm.SetSandbox(context.Background(), &Sandbox{Root: filepath.Join(dir, "build"), Disabled: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := capComm.CheckSandbox("/"); err != nil {
+		t.Error("unexpected disabled sandbox error", err)
+	}
+}
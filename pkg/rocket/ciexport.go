@@ -0,0 +1,230 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rocket
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CIExport writes variables to a file read by the steps of a CI pipeline that follow the mission.
+type CIExport struct {
+	// Format is one of github-env, github-output, gitlab or dotenv.  If blank github-env is used when
+	// running in GitHub Actions, otherwise dotenv.
+	Format string `yaml:"format,omitempty" mapstructure:"format"`
+
+	// Path is the file written to.  It defaults to $GITHUB_ENV or $GITHUB_OUTPUT for the GitHub formats
+	// and must be set for the others.
+	Path string `yaml:"path,omitempty" mapstructure:"path"`
+
+	// Variables are the names of the variables, or params, exported.
+	Variables []string `yaml:"variables,omitempty" mapstructure:"variables"`
+
+	// Append adds to an existing file rather than replacing it.  The GitHub files are always appended to.
+	Append bool `yaml:"append,omitempty" mapstructure:"append"`
+}
+
+// CI export formats.
+const (
+	CIFormatGitHubEnv    = "github-env"
+	CIFormatGitHubOutput = "github-output"
+	CIFormatGitLab       = "gitlab"
+	CIFormatDotEnv       = "dotenv"
+)
+
+var (
+	envNameRegex    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	outputNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+)
+
+// Validate checks the export's settings.
+func (export *CIExport) Validate() error {
+	switch export.Format {
+	case "", CIFormatGitHubEnv, CIFormatGitHubOutput, CIFormatGitLab, CIFormatDotEnv:
+	default:
+		return fmt.Errorf("unknown format %s, use %s, %s, %s or %s", export.Format,
+			CIFormatGitHubEnv, CIFormatGitHubOutput, CIFormatGitLab, CIFormatDotEnv)
+	}
+
+	if len(export.Variables) == 0 {
+		return errors.New("no variables specified")
+	}
+
+	nameRegex := envNameRegex
+	if export.Format == CIFormatGitHubOutput {
+		nameRegex = outputNameRegex
+	}
+
+	for _, name := range export.Variables {
+		if !nameRegex.MatchString(name) {
+			return fmt.Errorf("%s is not a valid name", name)
+		}
+	}
+
+	return nil
+}
+
+// ExportToCI writes the export's variables to its file.  Variables are looked up in the CapComm's variables,
+// then the variables exported by the mission's stages and finally its params.  A dry run logs the file that
+// would be written.
+func (capComm *CapComm) ExportToCI(ctx context.Context, export CIExport) error {
+	if err := export.Validate(); err != nil {
+		return err
+	}
+
+	format := export.Format
+	if format == "" {
+		format = CIFormatDotEnv
+		if capComm.env.Get("GITHUB_ENV") != "" {
+			format = CIFormatGitHubEnv
+		}
+	}
+
+	path, err := capComm.ExpandString(ctx, "path", export.Path)
+	if err != nil {
+		return errors.Wrap(err, "expanding path")
+	}
+
+	appendTo := export.Append
+	switch {
+	case format == CIFormatGitHubEnv || format == CIFormatGitHubOutput:
+		appendTo = true
+		envVar := strings.ToUpper(strings.ReplaceAll(format, "-", "_"))
+		if path == "" {
+			path = capComm.env.Get(envVar)
+		}
+		if path == "" {
+			return fmt.Errorf("no path specified and $%s is not set", envVar)
+		}
+	case path == "":
+		return fmt.Errorf("%s exports need a path", format)
+	default:
+		if path, err = filepath.Abs(path); err != nil {
+			return errors.Wrap(err, "export path")
+		}
+		if err := capComm.CheckSandbox(path); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, name := range export.Variables {
+		value, ok := capComm.lookupCIValue(name)
+		if !ok {
+			return fmt.Errorf("variable %s not found", name)
+		}
+
+		if err := writeCIValue(&buf, format, name, value); err != nil {
+			return err
+		}
+	}
+
+	if IsDryRun(ctx) {
+		capComm.Log().Infof("would export %s to %s", strings.Join(export.Variables, ", "), path)
+		return nil
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if appendTo {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return errors.Wrap(err, "opening export file")
+	}
+
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		_ = f.Close()
+		return errors.Wrapf(err, "writing %s", path)
+	}
+
+	return f.Close()
+}
+
+// lookupCIValue finds the value of a variable or param.
+func (capComm *CapComm) lookupCIValue(name string) (string, bool) {
+	if value, ok := capComm.GetVariable(name); ok {
+		return value, true
+	}
+
+	if capComm.stageExports != nil {
+		if value, ok := capComm.stageExports.Get(name); ok {
+			return value, true
+		}
+	}
+
+	value, ok := capComm.params.All()[name]
+	return value, ok
+}
+
+// writeCIValue writes a single value in the format's encoding.  The GitHub formats write multi-line
+// values as heredocs, dotenv files quote and escape them and GitLab, which cannot read them, fails.
+func writeCIValue(buf *bytes.Buffer, format, name, value string) error {
+	multiLine := strings.ContainsAny(value, "\r\n")
+
+	switch {
+	case !multiLine && format != CIFormatDotEnv:
+		fmt.Fprintf(buf, "%s=%s\n", name, value)
+	case format == CIFormatGitLab:
+		return fmt.Errorf("%s: gitlab dotenv reports do not support multi-line values", name)
+	case format == CIFormatDotEnv:
+		fmt.Fprintf(buf, "%s=%s\n", name, quoteDotEnv(value))
+	default:
+		delimiter, err := newHeredocDelimiter(value)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(buf, "%s<<%s\n%s\n%s\n", name, delimiter, value, delimiter)
+	}
+
+	return nil
+}
+
+// quoteDotEnv double quotes values that need it, escaping backslashes, quotes and line endings.
+func quoteDotEnv(value string) string {
+	if value == "" || (strings.TrimSpace(value) == value && !strings.ContainsAny(value, "\"'\\#$`\r\n")) {
+		return value
+	}
+
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`", "\n", `\n`, "\r", `\r`)
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// newHeredocDelimiter returns a random delimiter that does not occur in the value.
+func newHeredocDelimiter(value string) (string, error) {
+	for {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return "", errors.Wrap(err, "delimiter")
+		}
+
+		delimiter := "EOF_" + hex.EncodeToString(b)
+		if !strings.Contains(value, delimiter) {
+			return delimiter, nil
+		}
+	}
+}
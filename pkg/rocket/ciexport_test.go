@@ -0,0 +1,190 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rocket
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nehemming/cirocket/pkg/loggee"
+	"github.com/nehemming/cirocket/pkg/loggee/stdlog"
+)
+
+func TestCIExportValidate(t *testing.T) {
+	valid := []CIExport{
+		{Variables: []string{"VERSION"}},
+		{Format: CIFormatGitHubOutput, Variables: []string{"image-tag"}},
+		{Format: CIFormatDotEnv, Variables: []string{"_x1"}},
+	}
+	for _, export := range valid {
+		if err := export.Validate(); err != nil {
+			t.Error("unexpected error", export, err)
+		}
+	}
+
+	invalid := []CIExport{
+		{},
+		{Format: "jenkins", Variables: []string{"VERSION"}},
+		{Format: CIFormatGitHubEnv, Variables: []string{"image-tag"}},
+		{Format: CIFormatGitLab, Variables: []string{"1X"}},
+	}
+	for _, export := range invalid {
+		if err := export.Validate(); err == nil {
+			t.Error("expected error", export)
+		}
+	}
+}
+
+func TestWriteCIValue(t *testing.T) {
+	tests := []struct {
+		format, value, expected string
+	}{
+		{CIFormatGitHubEnv, "1.2.3", "V=1.2.3\n"},
+		{CIFormatGitLab, "a b", "V=a b\n"},
+		{CIFormatDotEnv, "plain", "V=plain\n"},
+		{CIFormatDotEnv, "", "V=\n"},
+		{CIFormatDotEnv, "say \"hi\"\nto $USER", "V=\"say \\\"hi\\\"\\nto \\$USER\"\n"},
+		{CIFormatDotEnv, " padded", "V=\" padded\"\n"},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := writeCIValue(&buf, test.format, "V", test.value); err != nil {
+			t.Error(test.format, err)
+		} else if buf.String() != test.expected {
+			t.Errorf("%s: got %q expected %q", test.format, buf.String(), test.expected)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := writeCIValue(&buf, CIFormatGitHubOutput, "notes", "line 1\nline 2"); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(buf.String(), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[0], "notes<<EOF_") || lines[0] != "notes<<"+lines[3] ||
+		lines[1] != "line 1" || lines[2] != "line 2" {
+		t.Errorf("unexpected heredoc %q", buf.String())
+	}
+
+	if err := writeCIValue(&buf, CIFormatGitLab, "notes", "line 1\nline 2"); err == nil {
+		t.Error("expected gitlab multi-line error")
+	}
+}
+
+func TestExportToCI(t *testing.T) {
+	dir := t.TempDir()
+	githubEnv := filepath.Join(dir, "github_env")
+	if err := os.WriteFile(githubEnv, []byte("EXISTING=1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	capComm := NewCapComm(filepath.Join(dir, "mission.yml"), stdlog.New()).
+		Copy(false).
+		MergeBasicEnvMap(VarMap{"GITHUB_ENV": githubEnv})
+	if err := capComm.MergeParams(context.Background(), Params{{Name: "channel", Value: "beta"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := capComm.SetSandbox(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	capComm.SetLocalVariable("version", "1.2.3")
+
+	// the format defaults to github-env when running in GitHub Actions
+	if err := capComm.ExportToCI(context.Background(), CIExport{Variables: []string{"version", "channel"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := os.ReadFile(githubEnv); string(b) != "EXISTING=1\nversion=1.2.3\nchannel=beta\n" {
+		t.Errorf("unexpected github env %q", string(b))
+	}
+
+	if err := capComm.ExportToCI(context.Background(), CIExport{Variables: []string{"missing"}}); err == nil {
+		t.Error("expected missing variable error")
+	}
+
+	if err := capComm.ExportToCI(context.Background(), CIExport{Format: CIFormatDotEnv, Variables: []string{"version"}}); err == nil {
+		t.Error("expected missing path error")
+	}
+
+	if err := capComm.ExportToCI(context.Background(), CIExport{
+		Format: CIFormatDotEnv, Path: filepath.Join(dir, "..", "outside.env"), Variables: []string{"version"},
+	}); err == nil {
+		t.Error("expected sandbox error")
+	}
+
+	dotEnv := filepath.Join(dir, "build.env")
+	ctx := ContextWithDryRun(context.Background(), true)
+	if err := capComm.ExportToCI(ctx, CIExport{Format: CIFormatDotEnv, Path: dotEnv, Variables: []string{"version"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(dotEnv); err == nil {
+		t.Error("dry run wrote file")
+	}
+}
+
+func TestLaunchMissionCIExport(t *testing.T) {
+	loggee.SetLogger(stdlog.New())
+
+	mc := NewMissionControl()
+	mc.RegisterTaskTypes(&testTaskType{t: t})
+
+	dir := t.TempDir()
+	dotEnv := filepath.Join(dir, "build.env")
+	if err := os.WriteFile(dotEnv, []byte("OLD=1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mission := map[string]interface{}{
+		"ciExport": map[string]interface{}{
+			"format":    "dotenv",
+			"path":      filepath.ToSlash(dotEnv),
+			"variables": []interface{}{"version"},
+		},
+		"stages": []interface{}{
+			map[string]interface{}{
+				"name": "compute",
+				"tasks": []interface{}{
+					map[string]interface{}{
+						"type":     "testTask",
+						"name":     "version",
+						"postvars": map[string]interface{}{"version": "2.0.0"},
+						"export":   []interface{}{"version"},
+					},
+				},
+			},
+		},
+	}
+
+	if err := mc.LaunchMission(context.Background(), filepath.Join(dir, "mission.yml"), mission); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := os.ReadFile(dotEnv); string(b) != "version=2.0.0\n" {
+		t.Errorf("unexpected dotenv %q", string(b))
+	}
+
+	mission["ciExport"] = map[string]interface{}{"format": "xml", "variables": []interface{}{"version"}}
+	if err := mc.LaunchMission(context.Background(), filepath.Join(dir, "mission.yml"), mission); err == nil {
+		t.Error("expected format error")
+	}
+}
@@ -22,7 +22,10 @@ import (
 
 type runCtx string
 
-const ctxKey = runCtx("capcomm")
+const (
+	ctxKey       = runCtx("capcomm")
+	dryRunCtxKey = runCtx("dryrun")
+)
 
 // GetCapCommContext returns the capComm from the context.
 func GetCapCommContext(ctx context.Context) *CapComm {
@@ -37,3 +40,15 @@ func GetCapCommContext(ctx context.Context) *CapComm {
 func NewContextWithCapComm(ctx context.Context, capComm *CapComm) context.Context {
 	return context.WithValue(ctx, ctxKey, capComm.Seal())
 }
+
+// ContextWithDryRun returns a context marking the run as a dry run, where tasks
+// that change files only log what they would do.
+func ContextWithDryRun(ctx context.Context, dryRun bool) context.Context {
+	return context.WithValue(ctx, dryRunCtxKey, dryRun)
+}
+
+// IsDryRun returns true if the context is marked as a dry run.
+func IsDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunCtxKey).(bool)
+	return dryRun
+}
@@ -42,3 +42,13 @@ func TestGetCapCommContextWithNoneSet(t *testing.T) {
 		t.Error("ret should be nil")
 	}
 }
+
+func TestDryRunContext(t *testing.T) {
+	if IsDryRun(context.Background()) {
+		t.Error("unexpected dry run")
+	}
+
+	if !IsDryRun(ContextWithDryRun(context.Background(), true)) {
+		t.Error("expected dry run")
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/nehemming/cirocket/pkg/resource"
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// FormatYAML is the yaml document format.
+	FormatYAML = "yaml"
+
+	// FormatJSON is the json document format.
+	FormatJSON = "json"
+
+	// FormatTOML is the toml document format.
+	FormatTOML = "toml"
+)
+
+// DocumentExtensions are the file extensions of the supported document formats in search order.
+var DocumentExtensions = []string{"yml", "yaml", "json", "toml"}
+
+// DocumentFormat returns the format of a document.  The format is detected from the location's extension,
+// if the extension is not recognised the format is detected from the content.  Yaml is the default format.
+func DocumentFormat(location string, b []byte) string {
+	switch strings.ToLower(path.Ext(location)) {
+	case ".yml", ".yaml":
+		return FormatYAML
+	case ".json":
+		return FormatJSON
+	case ".toml":
+		return FormatTOML
+	}
+
+	content := bytes.TrimSpace(b)
+	if bytes.HasPrefix(content, []byte("{")) {
+		return FormatJSON
+	}
+
+	// yaml will not read a toml document as a map, if it fails see if it is toml.
+	m := make(map[string]interface{})
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		if _, err := toml.LoadBytes(b); err == nil {
+			return FormatTOML
+		}
+	}
+
+	return FormatYAML
+}
+
+// decodeDocument decodes a yaml, json or toml document into a map.
+func decodeDocument(location string, b []byte) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+
+	switch DocumentFormat(location, b) {
+	case FormatJSON:
+		if err := json.Unmarshal(b, &m); err != nil {
+			return nil, err
+		}
+	case FormatTOML:
+		tree, err := toml.LoadBytes(b)
+		if err != nil {
+			return nil, err
+		}
+		m = tree.ToMap()
+	default:
+		if err := yaml.NewDecoder(bytes.NewBuffer(b)).Decode(&m); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// documentNames returns the name with each of the supported document extensions.
+// The name should have a yaml extension, names without one are returned unchanged.
+func documentNames(name string) []string {
+	ext := path.Ext(name)
+	if ext != ".yml" && ext != ".yaml" {
+		return []string{name}
+	}
+
+	base := strings.TrimSuffix(name, ext)
+	names := []string{name}
+	for _, e := range DocumentExtensions {
+		if n := base + "." + e; n != name {
+			names = append(names, n)
+		}
+	}
+
+	return names
+}
+
+// readDocument reads the first of the document names found at the location formed from the parts and name.
+// The name of the document read is returned with its contents.
+func readDocument(ctx context.Context, name string, parts ...string) ([]byte, string, error) {
+	var notFound error
+
+	for _, n := range documentNames(name) {
+		b, err := resource.ReadResource(ctx, append(parts, n)...)
+		if err == nil {
+			return b, n, nil
+		}
+
+		if resource.IsNotFoundError(err) == nil {
+			return nil, "", err
+		}
+
+		if notFound == nil {
+			notFound = err
+		}
+	}
+
+	return nil, "", notFound
+}
+
+// searchDocument searches the sources for the first of the document names found.
+func searchDocument(ctx context.Context, name string, progress resource.Progress, sources ...string) ([]byte, *url.URL, error) {
+	var notFound error
+
+	for _, n := range documentNames(name) {
+		b, u, err := resource.Search(ctx, n, progress, sources...)
+		if err == nil {
+			return b, u, nil
+		}
+
+		if resource.IsNotFoundError(err) == nil {
+			return nil, nil, err
+		}
+
+		if notFound == nil {
+			notFound = err
+		}
+	}
+
+	return nil, nil, notFound
+}
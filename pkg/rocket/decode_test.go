@@ -0,0 +1,88 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rocket
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nehemming/cirocket/pkg/loggee"
+	"github.com/nehemming/cirocket/pkg/loggee/stdlog"
+)
+
+func TestDocumentFormat(t *testing.T) {
+	tests := []struct {
+		location string
+		content  string
+		format   string
+	}{
+		{"mission.yml", "{}", FormatYAML},
+		{"mission.YAML", "", FormatYAML},
+		{"mission.json", "", FormatJSON},
+		{"mission.toml", "", FormatTOML},
+		{"", " {\"name\": \"x\"}", FormatJSON},
+		{"", "name = \"x\"\n[params]\n", FormatTOML},
+		{"", "name: x", FormatYAML},
+		{"", "", FormatYAML},
+	}
+
+	for _, test := range tests {
+		if f := DocumentFormat(test.location, []byte(test.content)); f != test.format {
+			t.Error("unexpected format", test.location, test.content, f)
+		}
+	}
+}
+
+func TestDecodeDocument(t *testing.T) {
+	for _, content := range []string{"name: doc", `{"name": "doc"}`, `name = "doc"`} {
+		m, err := decodeDocument("", []byte(content))
+		if err != nil || m["name"] != "doc" {
+			t.Error("unexpected decode", content, m, err)
+		}
+	}
+
+	if _, err := decodeDocument("bad.json", []byte("name: doc")); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestDocumentNames(t *testing.T) {
+	if names := strings.Join(documentNames("dir/blueprint.yml"), ","); names != "dir/blueprint.yml,dir/blueprint.yaml,dir/blueprint.json,dir/blueprint.toml" {
+		t.Error("unexpected names", names)
+	}
+
+	if names := documentNames("runbook.txt"); len(names) != 1 {
+		t.Error("unexpected names", names)
+	}
+}
+
+func TestLaunchMixedFormatIncludes(t *testing.T) {
+	loggee.SetLogger(stdlog.New())
+	mc := NewMissionControl()
+	tt := newRecordTaskType()
+	mc.RegisterTaskTypes(tt)
+
+	mission, location := loadMission("formats")
+	if err := mc.LaunchMission(context.Background(), location, mission); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := tt.get("yaml"); len(v) != 1 || v[0] != "json-toml" {
+		t.Error("unexpected value", v)
+	}
+}
@@ -16,14 +16,82 @@ limitations under the License.
 
 package rocket
 
-import "runtime"
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	globber "github.com/bmatcuk/doublestar/v4"
+	"github.com/pkg/errors"
+)
+
+// ciEnvVars are environment variables set by common CI systems.
+var ciEnvVars = []string{
+	"CI",
+	"BUILD_NUMBER",
+	"BUILDKITE",
+	"CIRCLECI",
+	"CODEBUILD_BUILD_ID",
+	"DRONE",
+	"GITHUB_ACTIONS",
+	"GITLAB_CI",
+	"JENKINS_URL",
+	"TEAMCITY_VERSION",
+	"TF_BUILD",
+	"TRAVIS",
+}
 
 // IsFiltered returns true if the filter should be applied to exclude the item.
-func (filter *Filter) IsFiltered() bool { //nolint:cyclop
+// Env conditions are checked against the host environment and param conditions are not checked.
+// A filter that cannot be evaluated excludes the item.
+func (filter *Filter) IsFiltered() bool {
+	filtered, err := filter.IsFilteredBy(osEnvGetter{}, nil)
+	return filtered || err != nil
+}
+
+// IsFilteredBy returns true if the filter should be applied to exclude the item.
+// The env and params getters provide the values the env and param conditions are checked against,
+// if params is nil param conditions are not checked.  Relative file patterns are relative to the
+// mission directory param, or the working directory if it is not set.
+func (filter *Filter) IsFilteredBy(env, params Getter) (bool, error) {
 	if filter == nil {
-		return false
+		return false, nil
+	}
+
+	if filter.isPlatformFiltered() {
+		return true, nil
+	}
+
+	if filter.CI != nil && *filter.CI != isCI(env) {
+		return true, nil
+	}
+
+	if filtered, err := filterConditionsFail(env, filter.Env); err != nil || filtered {
+		return filtered, errors.Wrap(err, "env")
+	}
+
+	if params != nil {
+		if filtered, err := filterConditionsFail(params, filter.Params); err != nil || filtered {
+			return filtered, errors.Wrap(err, "params")
+		}
+	}
+
+	if filtered, err := filter.isHostnameFiltered(); err != nil || filtered {
+		return filtered, errors.Wrap(err, "hostname")
+	}
+
+	dir := ""
+	if params != nil {
+		dir = params.Get(MissionDirAbsParamName)
 	}
 
+	return filter.isFileFiltered(env, dir)
+}
+
+func (filter *Filter) isPlatformFiltered() bool { //nolint:cyclop
 	if filter.Skip {
 		return true
 	}
@@ -74,3 +142,131 @@ func (filter *Filter) IsFiltered() bool { //nolint:cyclop
 
 	return false
 }
+
+// isCI returns true if any of the well known CI environment variables are set.
+func isCI(env Getter) bool {
+	for _, name := range ciEnvVars {
+		v := strings.ToLower(env.Get(name))
+		if v != "" && v != "false" && v != "0" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filterConditionsFail returns true if any of the conditions are not met.
+func filterConditionsFail(values Getter, conditions []FilterCondition) (bool, error) {
+	for index, condition := range conditions {
+		if condition.Name == "" {
+			return true, errors.Errorf("condition %d has no name", index)
+		}
+
+		ok, err := condition.IsMet(values.Get(condition.Name))
+		if err != nil {
+			return true, errors.Wrap(err, condition.Name)
+		}
+
+		if !ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// IsMet checks if the condition is met by the value.
+// With neither equals nor matches set the condition only requires the value to be non blank.
+func (condition FilterCondition) IsMet(value string) (bool, error) {
+	if condition.Equals == "" && condition.Matches == "" {
+		return value != "", nil
+	}
+
+	if condition.Equals != "" && value != condition.Equals {
+		return false, nil
+	}
+
+	if condition.Matches != "" {
+		re, err := regexp.Compile(condition.Matches)
+		if err != nil {
+			return false, err
+		}
+
+		return re.MatchString(value), nil
+	}
+
+	return true, nil
+}
+
+func (filter *Filter) isHostnameFiltered() (bool, error) {
+	if len(filter.Hostname) == 0 {
+		return false, nil
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return true, err
+	}
+
+	hostname = strings.ToLower(hostname)
+	for _, pattern := range filter.Hostname {
+		ok, err := path.Match(strings.ToLower(pattern), hostname)
+		if err != nil {
+			return true, err
+		}
+
+		if ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (filter *Filter) isFileFiltered(env Getter, dir string) (bool, error) {
+	for _, pattern := range filter.FileExists {
+		ok, err := globMatchesFile(os.Expand(pattern, env.Get), dir)
+		if err != nil {
+			return true, errors.Wrap(err, "fileExists")
+		}
+
+		if !ok {
+			return true, nil
+		}
+	}
+
+	for _, pattern := range filter.FileMissing {
+		ok, err := globMatchesFile(os.Expand(pattern, env.Get), dir)
+		if err != nil {
+			return true, errors.Wrap(err, "fileMissing")
+		}
+
+		if ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// globMatchesFile returns true if the glob pattern matches at least one file or directory.
+// Relative patterns are relative to dir, or the working directory if dir is blank.
+func globMatchesFile(pattern, dir string) (bool, error) {
+	pattern = filepath.FromSlash(pattern)
+	if dir != "" && !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+
+	pattern, err := filepath.Abs(pattern)
+	if err != nil {
+		return false, err
+	}
+
+	base, pat := globber.SplitPattern(filepath.ToSlash(pattern))
+	matches, err := globber.Glob(os.DirFS(filepath.FromSlash(base)), pat)
+	if err != nil {
+		return false, err
+	}
+
+	return len(matches) > 0, nil
+}
@@ -17,10 +17,24 @@ limitations under the License.
 package rocket
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"runtime"
 	"testing"
+
+	"github.com/nehemming/cirocket/pkg/loggee"
+	"github.com/nehemming/cirocket/pkg/loggee/stdlog"
 )
 
+func newTestGetter(kv map[string]string) Getter {
+	g := NewKeyValueGetter(nil)
+	for k, v := range kv {
+		g.kv[k] = v
+	}
+	return g
+}
+
 func TestIsFilteredInclude(t *testing.T) {
 	var f *Filter
 	if f.IsFiltered() != false {
@@ -107,3 +121,164 @@ func TestIsFilteredNotExclude(t *testing.T) {
 		t.Error("Diff Arch should exclude filter")
 	}
 }
+
+func TestFilterConditionIsMet(t *testing.T) {
+	tests := []struct {
+		condition FilterCondition
+		value     string
+		met       bool
+	}{
+		{FilterCondition{Name: "a"}, "", false},
+		{FilterCondition{Name: "a"}, "x", true},
+		{FilterCondition{Name: "a", Equals: "main"}, "main", true},
+		{FilterCondition{Name: "a", Equals: "main"}, "dev", false},
+		{FilterCondition{Name: "a", Matches: `^v\d+`}, "v12", true},
+		{FilterCondition{Name: "a", Matches: `^v\d+`}, "12", false},
+	}
+
+	for i, test := range tests {
+		met, err := test.condition.IsMet(test.value)
+		if err != nil || met != test.met {
+			t.Error("condition", i, met, err)
+		}
+	}
+
+	if _, err := (FilterCondition{Name: "a", Matches: "("}).IsMet("x"); err == nil {
+		t.Error("expected regex error")
+	}
+}
+
+func TestIsFilteredByEnvAndParams(t *testing.T) {
+	env := newTestGetter(map[string]string{"BRANCH": "main"})
+	params := newTestGetter(map[string]string{"release": "yes"})
+
+	f := &Filter{
+		Env:    []FilterCondition{{Name: "BRANCH", Equals: "main"}},
+		Params: []FilterCondition{{Name: "release"}},
+	}
+	if filtered, err := f.IsFilteredBy(env, params); err != nil || filtered {
+		t.Error("should not filter", filtered, err)
+	}
+
+	f.Params = []FilterCondition{{Name: "missing"}}
+	if filtered, err := f.IsFilteredBy(env, params); err != nil || !filtered {
+		t.Error("missing param should filter", filtered, err)
+	}
+
+	// param conditions not checked without params
+	if filtered, err := f.IsFilteredBy(env, nil); err != nil || filtered {
+		t.Error("nil params should not filter", filtered, err)
+	}
+
+	f.Env = []FilterCondition{{Name: "BRANCH", Matches: "("}}
+	if _, err := f.IsFilteredBy(env, params); err == nil {
+		t.Error("expected error")
+	}
+	if !f.IsFiltered() {
+		t.Error("invalid filter should filter")
+	}
+}
+
+func TestIsFilteredByCI(t *testing.T) {
+	ci := true
+	f := &Filter{CI: &ci}
+
+	if filtered, _ := f.IsFilteredBy(newTestGetter(map[string]string{"GITHUB_ACTIONS": "true"}), nil); filtered {
+		t.Error("ci should not filter")
+	}
+	if filtered, _ := f.IsFilteredBy(newTestGetter(map[string]string{"CI": "false"}), nil); !filtered {
+		t.Error("not ci should filter")
+	}
+
+	ci = false
+	if filtered, _ := f.IsFilteredBy(newTestGetter(map[string]string{}), nil); filtered {
+		t.Error("local should not filter")
+	}
+}
+
+func TestIsFilteredByFiles(t *testing.T) {
+	env := newTestGetter(map[string]string{"FILTER_DIR": "testdata"})
+
+	f := &Filter{FileExists: []string{"${FILTER_DIR}/*.yml"}}
+	if filtered, err := f.IsFilteredBy(env, nil); err != nil || filtered {
+		t.Error("existing files should not filter", filtered, err)
+	}
+
+	f = &Filter{FileExists: []string{"testdata/**/*.nope"}}
+	if filtered, err := f.IsFilteredBy(env, nil); err != nil || !filtered {
+		t.Error("missing files should filter", filtered, err)
+	}
+
+	f = &Filter{FileMissing: []string{filepath.Join("testdata", "*.yml")}}
+	if filtered, err := f.IsFilteredBy(env, nil); err != nil || !filtered {
+		t.Error("file present should filter", filtered, err)
+	}
+
+	// relative patterns are relative to the mission dir
+	dir, _ := filepath.Abs("testdata")
+	params := newTestGetter(map[string]string{MissionDirAbsParamName: dir})
+
+	f = &Filter{FileExists: []string{"*.yml"}}
+	if filtered, err := f.IsFilteredBy(env, params); err != nil || filtered {
+		t.Error("mission dir files should not filter", filtered, err)
+	}
+	if filtered, err := f.IsFilteredBy(env, newTestGetter(map[string]string{})); err != nil || !filtered {
+		t.Error("working dir files should filter", filtered, err)
+	}
+}
+
+func TestIsFilteredByHostname(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skip("no hostname", err)
+	}
+
+	f := &Filter{Hostname: []string{"nope-*", hostname}}
+	if filtered, err := f.IsFilteredBy(osEnvGetter{}, nil); err != nil || filtered {
+		t.Error("hostname should not filter", filtered, err)
+	}
+
+	f = &Filter{Hostname: []string{hostname + "-nope"}}
+	if filtered, err := f.IsFilteredBy(osEnvGetter{}, nil); err != nil || !filtered {
+		t.Error("other hostname should filter", filtered, err)
+	}
+}
+
+func TestParamFilteredStage(t *testing.T) {
+	loggee.SetLogger(stdlog.New())
+	mc := NewMissionControl()
+	tt := &testTaskType{t: t}
+	mc.RegisterTaskTypes(tt)
+
+	mission := map[string]interface{}{
+		"params": []interface{}{
+			map[string]interface{}{"name": "target", "value": "docs"},
+		},
+		"stages": []interface{}{
+			map[string]interface{}{
+				"name":   "skipped",
+				"filter": map[string]interface{}{"params": []interface{}{map[string]interface{}{"name": "target", "equals": "build"}}},
+				"tasks":  []interface{}{map[string]interface{}{"type": "testTask"}},
+			},
+			map[string]interface{}{
+				"name":   "run",
+				"filter": map[string]interface{}{"params": []interface{}{map[string]interface{}{"name": "target", "matches": "^do"}}},
+				"tasks":  []interface{}{map[string]interface{}{"type": "testTask"}},
+			},
+			map[string]interface{}{
+				"name":   "stageParams",
+				"params": []interface{}{map[string]interface{}{"name": "target", "value": "build"}},
+				"filter": map[string]interface{}{"params": []interface{}{map[string]interface{}{"name": "target", "equals": "build"}}},
+				"tasks":  []interface{}{map[string]interface{}{"type": "testTask"}},
+			},
+		},
+	}
+
+	if err := mc.LaunchMission(context.Background(), "", mission); err != nil {
+		t.Error("mission", err)
+	}
+
+	if tt.runCount != 2 {
+		t.Error("run count", tt.runCount)
+	}
+}
@@ -17,7 +17,6 @@ limitations under the License.
 package rocket
 
 import (
-	"bytes"
 	"context"
 	"sort"
 
@@ -25,7 +24,6 @@ import (
 	"github.com/nehemming/cirocket/pkg/loggee"
 	"github.com/nehemming/cirocket/pkg/resource"
 	"github.com/pkg/errors"
-	"gopkg.in/yaml.v2"
 )
 
 type (
@@ -62,12 +60,15 @@ type (
 // ListTaskTypes list the types of task registered withmission control.
 func (mc *missionControl) ListTaskTypes(ctx context.Context) (TaskTypeInfoList, error) {
 	var list TaskTypeInfoList
+
+	mc.lock.Lock()
 	for _, tt := range mc.types {
 		list = append(list, TaskTypeInfo{
 			Type:        tt.Type(),
 			Description: tt.Description(),
 		})
 	}
+	mc.lock.Unlock()
 
 	sort.Sort(list)
 
@@ -93,7 +94,7 @@ func (mc *missionControl) ListBlueprints(ctx context.Context, sources []string)
 }
 
 func listSource(ctx context.Context, source string, list []BlueprintInfo) ([]BlueprintInfo, error) {
-	b, err := resource.ReadResource(ctx, source, "inventory.yml")
+	b, name, err := readDocument(ctx, "inventory.yml", source)
 	if err != nil {
 		// ignore no inventories
 		if resource.IsNotFoundError(err) != nil {
@@ -104,7 +105,7 @@ func listSource(ctx context.Context, source string, list []BlueprintInfo) ([]Blu
 	}
 
 	// get resource
-	inventory, err := decodeInventory(b)
+	inventory, err := decodeInventory(name, b)
 	if err != nil {
 		return list, errors.Wrapf(err, "source %s", source)
 	}
@@ -118,9 +119,8 @@ func listSource(ctx context.Context, source string, list []BlueprintInfo) ([]Blu
 	return append(list, info...), nil
 }
 
-func decodeInventory(b []byte) (*Inventory, error) {
-	blueStuff := make(map[string]interface{})
-	err := yaml.NewDecoder(bytes.NewBuffer(b)).Decode(&blueStuff)
+func decodeInventory(location string, b []byte) (*Inventory, error) {
+	blueStuff, err := decodeDocument(location, b)
 	if err != nil {
 		return nil, err
 	}
@@ -146,13 +146,13 @@ func getBlueprintInfoFromInventory(ctx context.Context, source string, inventory
 	// Get each blueprint and extract info
 	for _, item := range inventory.Items {
 		// get manifest
-		b, err := resource.ReadResource(ctx, source, item, manifestFileName[1:])
+		b, name, err := readDocument(ctx, manifestFileName[1:], source, item)
 		if err != nil {
 			return nil, err
 		}
 
 		// get the blueprint
-		blueprint, err := decodeBluerint(b)
+		blueprint, err := decodeBluerint(name, b)
 		if err != nil {
 			return nil, errors.Wrapf(err, "blueprint %s", item)
 		}
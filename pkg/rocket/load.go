@@ -17,7 +17,6 @@ limitations under the License.
 package rocket
 
 import (
-	"bytes"
 	"context"
 	"net/url"
 	"os"
@@ -28,7 +27,6 @@ import (
 	"github.com/nehemming/cirocket/pkg/loggee"
 	"github.com/nehemming/cirocket/pkg/resource"
 	"github.com/pkg/errors"
-	"gopkg.in/yaml.v2"
 )
 
 func getStartingMissionURL(location string) (*url.URL, error) {
@@ -42,22 +40,38 @@ func getStartingMissionURL(location string) (*url.URL, error) {
 func loadPreMission(ctx context.Context, spaceDust map[string]interface{}, missionURL *url.URL) (*Mission, error) {
 	// loadPreMissionMaps returns a slice of maps
 	// each map is then read in sequence and merged into the mission
-	// includes do no override their parent's settings
-	missionMaps, err := loadPreMissionMaps(ctx, spaceDust, missionURL)
+	// includes do no override their parent's settings unless their merge policy allows it
+	missionMaps, modules, err := loadPreMissionMaps(ctx, spaceDust, missionURL)
 	if err != nil {
 		return nil, err
 	}
 
 	// With maps in place now build the mission
-	return buildMissionFromMaps(missionMaps, missionURL)
+	mission, err := buildMissionFromMaps(missionMaps, missionURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Add the namespaced modules
+	for _, m := range modules {
+		mission.Modules = append(mission.Modules, *m)
+	}
+
+	return mission, nil
 }
 
-func buildMissionFromMaps(missionMaps []map[string]interface{}, missionURL *url.URL) (*Mission, error) {
+// missionLayer is a mission map and the policy used to merge it into the missions loaded before it.
+type missionLayer struct {
+	spaceDust map[string]interface{}
+	merge     MergePolicy
+}
+
+func buildMissionFromMaps(missionMaps []missionLayer, missionURL *url.URL) (*Mission, error) {
 	// final mission
 	mission := &Mission{}
 
 	// iterate through config maps loading their missions, merging as we go
-	for _, missionMap := range missionMaps {
+	for index, layer := range missionMaps {
 		partialMission := &Mission{}
 
 		// Load in the mission from the spaceDust
@@ -67,11 +81,13 @@ func buildMissionFromMaps(missionMaps []map[string]interface{}, missionURL *url.
 				Result:           partialMission,
 			}); err != nil {
 			return nil, errors.Wrap(err, "prepare")
-		} else if err := d.Decode(missionMap); err != nil {
+		} else if err := d.Decode(layer.spaceDust); err != nil {
 			return nil, errors.Wrap(loggee.BindMultiErrorFormatting(err), "decode")
 		}
 
-		mergeMissions(mission, partialMission)
+		if err := mergeMissionsWithPolicy(mission, partialMission, layer.merge); err != nil {
+			return nil, errors.Wrapf(err, "merge[%d]", index)
+		}
 	}
 
 	// Setup the mission's name
@@ -86,54 +102,71 @@ func nameFromURL(missionURL *url.URL) string {
 	return strings.TrimSuffix(path.Base(missionURL.Path), path.Ext(missionURL.Path))
 }
 
-func loadPreMissionMaps(ctx context.Context, spaceDust map[string]interface{}, missionURL *url.URL) ([]map[string]interface{}, error) {
+func loadPreMissionMaps(ctx context.Context, spaceDust map[string]interface{}, missionURL *url.URL) ([]missionLayer, []*Module, error) {
 	// spaceDust contains a map of data, load this as a pre-mission to extract the includes
 	missionMaps, includes, err := decodePreMissionSpaceDust(spaceDust, missionURL)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// No includes, exit here
 	if len(includes) == 0 {
-		return missionMaps, nil
+		return missionMaps, nil, nil
 	}
 
 	// Get the base path of the config file so includes are relative to it
 	baseLocation := resource.GetURLParentLocation(missionURL).String()
 
+	var modules []*Module
+
 	// Load the include maps
 	for index, include := range includes {
 		err := include.Validate()
 		if err != nil {
-			return nil, errors.Wrapf(err, "include[%d]", index)
+			return nil, nil, errors.Wrapf(err, "include[%d]", index)
 		}
 
 		// Convert the include struct to a URL
 		url, err := convertIncludeToURL(baseLocation, include)
 		if err != nil {
-			return nil, errors.Wrapf(err, "include[%d]", index)
+			return nil, nil, errors.Wrapf(err, "include[%d]", index)
 		}
 
 		includeMap, err := loadMapFromURL(ctx, url)
 		if err != nil {
-			return nil, errors.Wrapf(err, "include[%d]", index)
+			return nil, nil, errors.Wrapf(err, "include[%d]", index)
+		}
+
+		// namespaced includes are loaded as a separate mission
+		if include.As != "" {
+			m, err := loadModule(ctx, include, includeMap, url)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "include[%d] %s", index, include.As)
+			}
+
+			modules = append(modules, m)
+			continue
 		}
 
 		// if m has its own includes need to load its includes too
 		if _, ok := includeMap["includes"]; ok {
 			// nested includes, recurse this function again
-			pm, err := loadPreMissionMaps(ctx, includeMap, url)
+			pm, nestedModules, err := loadPreMissionMaps(ctx, includeMap, url)
 			if err != nil {
-				return nil, errors.Wrapf(err, "include[%d]", index)
+				return nil, nil, errors.Wrapf(err, "include[%d]", index)
 			}
 
+			// the included mission itself uses this include's policy, its own includes use theirs
+			pm[0].merge = include.Merge
+
 			missionMaps = append(missionMaps, pm...)
+			modules = append(modules, nestedModules...)
 		} else {
-			missionMaps = append(missionMaps, includeMap)
+			missionMaps = append(missionMaps, missionLayer{spaceDust: includeMap, merge: include.Merge})
 		}
 	}
 
-	return missionMaps, nil
+	return missionMaps, modules, nil
 }
 
 func convertIncludeToURL(baseLocation string, include Include) (*url.URL, error) {
@@ -153,9 +186,9 @@ func convertIncludeToURL(baseLocation string, include Include) (*url.URL, error)
 	return resource.UltimateURL(baseLocation, src)
 }
 
-func decodePreMissionSpaceDust(spaceDust map[string]interface{}, missionURL *url.URL) ([]map[string]interface{}, []Include, error) {
+func decodePreMissionSpaceDust(spaceDust map[string]interface{}, missionURL *url.URL) ([]missionLayer, []Include, error) {
 	preMission := &PreMission{}
-	missionMaps := make([]map[string]interface{}, 0)
+	missionMaps := make([]missionLayer, 0)
 
 	// Load in the mission from the spaceDust
 	if d, err := mapstructure.NewDecoder(
@@ -168,7 +201,7 @@ func decodePreMissionSpaceDust(spaceDust map[string]interface{}, missionURL *url
 		return nil, nil, errors.Wrapf(loggee.BindMultiErrorFormatting(err), "parsing mission pre-mission %s ", missionURL)
 	}
 
-	missionMaps = append(missionMaps, preMission.Mission)
+	missionMaps = append(missionMaps, missionLayer{spaceDust: preMission.Mission})
 
 	return missionMaps, preMission.Includes, nil
 }
@@ -179,12 +212,11 @@ func loadMapFromURL(ctx context.Context, url *url.URL) (map[string]interface{},
 		return nil, err
 	}
 
-	// Reads
-	m := make(map[string]interface{})
-	err = yaml.NewDecoder(bytes.NewBuffer(b)).Decode(&m)
-	if err != nil {
+	// Remote documents must match any locked hash
+	if err := resource.VerifyLocked(ctx, url, b); err != nil {
 		return nil, err
 	}
 
-	return m, err
+	// Decode the yaml, json or toml
+	return decodeDocument(url.Path, b)
 }
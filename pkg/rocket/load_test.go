@@ -18,6 +18,9 @@ package rocket
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"strings"
 	"testing"
@@ -157,3 +160,31 @@ func helpLoadMission(mission string) (context.Context, map[string]interface{}, *
 
 	return ctx, spaceDust, url, err
 }
+
+func TestLoadMapFromURLLocked(t *testing.T) {
+	body := "name: first"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+
+	lock := resource.NewLock("test.lock")
+	ctx := resource.ContextWithLock(context.Background(), lock)
+
+	u, err := url.Parse(server.URL + "/include.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadMapFromURL(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	if len(lock.Entries()) != 1 {
+		t.Error("expected include to be locked", lock.Entries())
+	}
+
+	body = "name: second"
+	if _, err := loadMapFromURL(ctx, u); err == nil {
+		t.Error("expected changed include to fail")
+	}
+}
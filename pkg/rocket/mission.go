@@ -16,15 +16,34 @@ limitations under the License.
 
 package rocket
 
-import "github.com/pkg/errors"
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
 
 type (
 	// Include contains details of an include file within the config.
 	Include struct {
 		Path string `mapstructure:"path"`
 		URL  string `mapstructure:"url"`
+
+		// As imports the included mission as a namespaced module.  The module's stages and sequences
+		// are referenced as <as>.<name> and are only run when referenced by a sequence or stage ref.
+		As string `mapstructure:"as"`
+
+		// Params are the params passed to a namespaced module, expanded with the including mission's params.
+		// The module's stages only see these and the module's own mission params.
+		Params Params `mapstructure:"params"`
+
+		// Merge is the policy used to merge the included mission into the including mission.
+		// It defaults to preserve and cannot be used with As.
+		Merge MergePolicy `mapstructure:"merge"`
 	}
 
+	// MergePolicy determines how an included mission is merged into the including mission.
+	MergePolicy string
+
 	// PreMission preprocesses missions before loading them.
 	PreMission struct {
 		Mission  map[string]interface{} `mapstructure:",remain"`
@@ -34,48 +53,123 @@ type (
 	// Mission is activity to complete.
 	Mission struct {
 		// Mission name, defaults to the config file name
-		Name string `mapstructure:"name"`
+		Name string `yaml:"name,omitempty" mapstructure:"name"`
 
 		// Description is a free text description of the mission.
-		Description string `mapstructure:"description"`
+		Description string `yaml:"description,omitempty" mapstructure:"description"`
 
 		// Additional contains any additional parameters specified in the
 		// configuration.  They are included in the template data set
 		// but will be overridden by any other duplicate keys
-		Additional map[string]interface{} `mapstructure:",remain"`
+		Additional map[string]interface{} `yaml:",inline" mapstructure:",remain"`
 
 		// BasicEnv is a map of additional environment variables
 		// They are not template expanded
-		BasicEnv VarMap `mapstructure:"basicEnv"`
+		BasicEnv VarMap `yaml:"basicEnv,omitempty" mapstructure:"basicEnv"`
 
 		// Env is a map of additional environment variables
 		// These are subject to template expansion after the params have been expanded
-		Env VarMap `mapstructure:"env"`
+		Env VarMap `yaml:"env,omitempty" mapstructure:"env"`
 
 		// Must is a slice of params that must be defined prior to the mission starting
 		// Iif any are missing the mission will fail.
-		Must MustHaveParams `mapstructure:"must"`
+		Must MustHaveParams `yaml:"must,omitempty" mapstructure:"must"`
 
 		// Params is a collection of parameters that can be used within
 		// the child stages.  Parameters are template expanded and can use
 		// Environment variables defined in Env
-		Params Params `mapstructure:"params"`
+		Params Params `yaml:"params,omitempty" mapstructure:"params"`
 
 		// Sequences specify a list of stages to run.
 		// If no sequences are provides all stages are run in the order they are defined.
 		// If sequences are included in the mission one must be specified or the mission will fail
-		Sequences map[string][]string `mapstructure:"sequences"`
+		Sequences map[string][]string `yaml:"sequences,omitempty" mapstructure:"sequences"`
 
 		// Stages represents the stages of the mission
 		// If no sequences are included in the file all stages are executed in ordinal order.
 		// If a sequence is included in the mission file the launch mission call must specify the sequence to use.
-		Stages Stages `mapstructure:"stages"`
+		Stages Stages `yaml:"stages,omitempty" mapstructure:"stages"`
 
 		// OnFail is a stage that is executed if the mission fails.
-		OnFail *Stage `mapstructure:"onfail"`
+		OnFail *Stage `yaml:"onfail,omitempty" mapstructure:"onfail"`
+
+		// Sandbox confines the paths the file tasks can change, it defaults to the mission's directory.
+		Sandbox *Sandbox `yaml:"sandbox,omitempty" mapstructure:"sandbox"`
+
+		// CIExport writes variables for the CI pipeline steps that follow a successful mission.
+		CIExport *CIExport `yaml:"ciExport,omitempty" mapstructure:"ciExport"`
+
+		// Modules are the missions imported by namespaced includes.
+		Modules []Module `yaml:"modules,omitempty" mapstructure:"-"`
+
+		// TaskTypes are custom task types defined by the mission.
+		TaskTypes TaskTypeDefs `yaml:"taskTypes,omitempty" mapstructure:"taskTypes"`
+
+		// Templates are shared templates, available to every template expansion of the mission.
+		Templates []TemplateDef `yaml:"templates,omitempty" mapstructure:"templates"`
 
 		// Version of the mission definition
-		Version string `mapstructure:"version"`
+		Version string `yaml:"version,omitempty" mapstructure:"version"`
+	}
+
+	// Module is a mission imported through a namespaced include.  Its stages and sequences are named
+	// <module>.<name> and its stages are only run when referenced by a sequence or another stage's ref.
+	Module struct {
+		// Name is the namespace of the module.
+		Name string `yaml:"name,omitempty" mapstructure:"name"`
+
+		// Sequences are the module's sequences.
+		Sequences map[string][]string `yaml:"sequences,omitempty" mapstructure:"sequences"`
+
+		// Stages are the module's stages.
+		Stages Stages `yaml:"stages,omitempty" mapstructure:"stages"`
+
+		// TaskTypes are the module's custom task types.
+		TaskTypes TaskTypeDefs `yaml:"taskTypes,omitempty" mapstructure:"taskTypes"`
+	}
+
+	// TaskTypeDef defines a custom task type.  A task using the type runs the definition's tasks
+	// with the task's inputs bound as params.
+	TaskTypeDef struct {
+		// Name is the task type name used by tasks.
+		Name string `yaml:"name,omitempty" mapstructure:"name"`
+
+		// Description is a free text description of the task type.
+		Description string `yaml:"description,omitempty" mapstructure:"description"`
+
+		// Inputs are the settings a task of this type can specify.
+		Inputs []TaskTypeInput `yaml:"inputs,omitempty" mapstructure:"inputs"`
+
+		// Tasks are run, in order, when a task of this type is run.
+		Tasks Tasks `yaml:"tasks,omitempty" mapstructure:"tasks"`
+	}
+
+	// TemplateDef is a shared template.  A named template can be used as {{ template "name" . }} or
+	// {{ include "name" . }}, a template without a name is only parsed for the templates it defines.
+	TemplateDef struct {
+		// Name of the template.
+		Name string `yaml:"name,omitempty" mapstructure:"name"`
+
+		// InputSpec is the source of the template.
+		InputSpec `yaml:",inline" mapstructure:",squash"`
+	}
+
+	// TaskTypeDefs is a slice of task type definitions.
+	TaskTypeDefs []TaskTypeDef
+
+	// TaskTypeInput is an input of a custom task type.
+	TaskTypeInput struct {
+		// Name of the input, it is also the name of the param the input is bound to.
+		Name string `yaml:"name,omitempty" mapstructure:"name"`
+
+		// Description is a free text description of the input.
+		Description string `yaml:"description,omitempty" mapstructure:"description"`
+
+		// Default is the value used when the task does not specify the input.
+		Default string `yaml:"default,omitempty" mapstructure:"default"`
+
+		// Required inputs must be specified by the task.
+		Required bool `yaml:"required,omitempty" mapstructure:"required"`
 	}
 
 	// MustHaveParams is a slice of param names that must be definedbefore a mission, stage or activity starts.
@@ -98,17 +192,17 @@ type (
 	Param struct {
 		// Name is the name of the parameter.
 		// Name is mandatory.
-		Name string `mapstructure:"name"`
+		Name string `yaml:"name,omitempty" mapstructure:"name"`
 
 		// Description is a free text description of the parameter.
-		Description string `mapstructure:"description"`
+		Description string `yaml:"description,omitempty" mapstructure:"description"`
 
 		// Filter is an optional filter on the param.
 		// If the param criteria are not met the param value will not be set.
-		Filter *Filter `mapstructure:"filter"`
+		Filter *Filter `yaml:"filter,omitempty" mapstructure:"filter"`
 
 		// Optional if true allows the file not to exist.
-		Optional bool `mapstructure:"optional"`
+		Optional bool `yaml:"optional,omitempty" mapstructure:"optional"`
 
 		// Path is the location of a resource that can provide the parameter's value.
 		// Paths may be either a local file system path or a url to file or http(s) resource.
@@ -120,128 +214,151 @@ type (
 		// If SkipExpand is false the combined value will be processed as a template to
 		// obtain the final value.   If SkipExpand is true the combined value will be used without
 		// any additional expansion.
-		Path string `mapstructure:"path"`
+		Path string `yaml:"path,omitempty" mapstructure:"path"`
 
 		// Print if true will display the value of the parameter once expanded to the log.
-		Print bool `mapstructure:"print"`
+		Print bool `yaml:"print,omitempty" mapstructure:"print"`
 
 		// SkipExpand skip templating the param.
-		SkipExpand bool `mapstructure:"skipExpand"`
+		SkipExpand bool `yaml:"skipExpand,omitempty" mapstructure:"skipExpand"`
 
 		// Value is the value of the parameter.  If SkipExpand is false the value will
 		// be transformed using template expansion.
-		Value string `mapstructure:"value"`
+		Value string `yaml:"value,omitempty" mapstructure:"value"`
 	}
 
 	// VarMap is a map of variables to their values.
 	VarMap map[string]string
 
+	// Sandbox confines the file tasks to the root directory and any allowed directories.
+	Sandbox struct {
+		// Root is the directory the file tasks are confined to, it defaults to the mission's directory.
+		Root string `yaml:"root,omitempty" mapstructure:"root"`
+
+		// Allow lists further directories, outside of the root, that the file tasks can change.
+		Allow []string `yaml:"allow,omitempty" mapstructure:"allow"`
+
+		// Disabled lets the file tasks change any path, as they could before sandboxes were added.
+		Disabled bool `yaml:"disabled,omitempty" mapstructure:"disabled"`
+	}
+
 	// Stage is a collection of tasks that can share a common set of parameters.
 	// All tasks within a stage are executed sequently.
 	Stage struct {
 		// Name of the stage.
 		// If it is not provided it default to the ordinal ID of the stage within the mission
-		Name string `mapstructure:"name"`
+		Name string `yaml:"name,omitempty" mapstructure:"name"`
 
 		// Stage is impmented by another named stage.
-		Ref string `mapstructure:"ref"`
+		Ref string `yaml:"ref,omitempty" mapstructure:"ref"`
 
 		// Description is a free text description of the stage.
-		Description string `mapstructure:"description"`
+		Description string `yaml:"description,omitempty" mapstructure:"description"`
 
 		// BasicEnv is a map of additional environment variables
 		// They are not template expanded
-		BasicEnv VarMap `mapstructure:"basicEnv"`
+		BasicEnv VarMap `yaml:"basicEnv,omitempty" mapstructure:"basicEnv"`
 
 		// If is evaluated prior to running a stage.  If the condition template expression evaluates to true/yes/1 the
 		// stage will be run.  If the template is blank or non true value the stage will not be run and the step will be skipped.
-		If string `mapstructure:"if"`
+		If string `yaml:"if,omitempty" mapstructure:"if"`
 
 		// Dir is the directory to execute the stage in.
-		Dir string `mapstructure:"dir"`
+		Dir string `yaml:"dir,omitempty" mapstructure:"dir"`
 
 		// Env is a map of additional environment variables
 		// These are subject to template expansion after the params have been expanded.
-		Env VarMap `mapstructure:"env"`
+		Env VarMap `yaml:"env,omitempty" mapstructure:"env"`
 
 		// Filter is an optional filter on the stage.
 		// If the filter criteria are not met the stage will not be executed.
-		Filter *Filter `mapstructure:"filter"`
+		Filter *Filter `yaml:"filter,omitempty" mapstructure:"filter"`
 
 		// Must is a slice of params that must be defined prior to the stage starting
 		// Iif any are missing the mission will fail.
-		Must MustHaveParams `mapstructure:"must"`
+		Must MustHaveParams `yaml:"must,omitempty" mapstructure:"must"`
 
 		// NoTrust indicates the stage should not inherit environment
 		// variables or parameters from its parent.  This can be used with a run stage
 		// where you do not want the process to receive API tokens etc.
-		NoTrust bool `mapstructure:"noTrust"`
+		NoTrust bool `yaml:"noTrust,omitempty" mapstructure:"noTrust"`
 
 		// OnFail is a task that is executed if the stage fails.
-		OnFail *Task `mapstructure:"onfail"`
+		OnFail *Task `yaml:"onfail,omitempty" mapstructure:"onfail"`
 
 		// Params is a collection of parameters that can be used within
 		// the child stages.  Parameters are template expanded and can use
 		// Environment variables defined in Env
-		Params Params `mapstructure:"params"`
+		Params Params `yaml:"params,omitempty" mapstructure:"params"`
 
 		// Tasks is a collection of one or more tasks to execute
 		// Tasks are executed sequentially
-		Tasks Tasks `mapstructure:"tasks"`
+		Tasks Tasks `yaml:"tasks,omitempty" mapstructure:"tasks"`
+
+		// module is the scope of the module the stage was imported from.
+		module *moduleScope
+
+		// Watch is a list of glob patterns used by the watch command.  When a matching file
+		// changes the stage is re-run.  Relative patterns are relative to the stage's directory.
+		Watch []string `yaml:"watch,omitempty" mapstructure:"watch"`
+
+		// Override indicates the stage replaces any stage of the same name when missions are merged.
+		// An override stage in the including mission is never replaced by an included stage.
+		Override bool `yaml:"override,omitempty" mapstructure:"override"`
 	}
 
 	// Task is an activity that is executed.
 	Task struct {
 		// Name of the task.
 		// If it is not provided it default to the ordinal ID of the task within the stage
-		Name string `mapstructure:"name"`
+		Name string `yaml:"name,omitempty" mapstructure:"name"`
 
 		// Task is impmented by another named task in the same stage.
-		Ref string `mapstructure:"ref"`
+		Ref string `yaml:"ref,omitempty" mapstructure:"ref"`
 
 		// BasicEnv is a map of additional environment variables.
 		// They are not template expanded.
-		BasicEnv VarMap `mapstructure:"basicEnv"`
+		BasicEnv VarMap `yaml:"basicEnv,omitempty" mapstructure:"basicEnv"`
 
 		// Concurrent is a list of tasks to execute concurrently.
-		Concurrent Tasks `mapstructure:"concurrent"`
+		Concurrent Tasks `yaml:"concurrent,omitempty" mapstructure:"concurrent"`
 
 		// If is evaluated prior to running a task.  If the condition template expression evaluates to true/yes/1 the
 		// task will be run.  If the template is blank or non true value the task will not be run and the step will be skipped.
-		If string `mapstructure:"if"`
+		If string `yaml:"if,omitempty" mapstructure:"if"`
 
 		// Description is a free text description of the task.
-		Description string `mapstructure:"description"`
+		Description string `yaml:"description,omitempty" mapstructure:"description"`
 
 		// Definition contains the additional data required to process the task type
-		Definition map[string]interface{} `mapstructure:",remain"`
+		Definition map[string]interface{} `yaml:",inline" mapstructure:",remain"`
 
 		// Env is a map of additional environment variables.
 		// These are subject to template expansion after the params have been expanded.
-		Env VarMap `mapstructure:"env"`
+		Env VarMap `yaml:"env,omitempty" mapstructure:"env"`
 
 		// Export is a list of variables to export. This list can be used by try and group task types
 		// to export their variables (output from sub tasks) to their parent stage or task.
-		Export Exports `mapstructure:"export"`
+		Export Exports `yaml:"export,omitempty" mapstructure:"export"`
 
 		// Filter is an optional filter on the task.
 		// If the filter criteria are not met the task will not be executed.
-		Filter *Filter `mapstructure:"filter"`
+		Filter *Filter `yaml:"filter,omitempty" mapstructure:"filter"`
 
 		// Try is a list of tasks to try.
-		Group Tasks `mapstructure:"group"`
+		Group Tasks `yaml:"group,omitempty" mapstructure:"group"`
 
 		// Must is a slice of params that must be defined prior to the task starting
 		// Iif any are missing the mission will fail.
-		Must MustHaveParams `mapstructure:"must"`
+		Must MustHaveParams `yaml:"must,omitempty" mapstructure:"must"`
 
 		// NoTrust indicates the task should not inherit environment
 		// variables or parameters from the parent.  This can be used with a run task
 		// where you do not want the process to receive API tokens etc.
-		NoTrust bool `mapstructure:"noTrust"`
+		NoTrust bool `yaml:"noTrust,omitempty" mapstructure:"noTrust"`
 
 		// OnFail is a task that is executed if the stage fails.
-		OnFail *Task `mapstructure:"onfail"`
+		OnFail *Task `yaml:"onfail,omitempty" mapstructure:"onfail"`
 
 		// Params is a collection of parameters that can be used within
 		// the child stages.  Parameters are template expanded and can use
@@ -249,113 +366,161 @@ type (
 		// Params and Env variables are template expanded during the preparation phase of a mission.  That means their
 		// values are calculated prior to any task running in any stage.
 		// If values need to be calculated before or after a run use pre or post variaBLES
-		Params Params `mapstructure:"params"`
+		Params Params `yaml:"params,omitempty" mapstructure:"params"`
 
 		// PostVars are variable evaluated after a task has run
 		// Post variable are automatically exported to the parent task/stage.
-		PostVars VarMap `mapstructure:"postvars"`
+		PostVars VarMap `yaml:"postvars,omitempty" mapstructure:"postvars"`
 
 		// PreVars are variables calculated immediately prior to a run.  They are are not exported to the parent context.
 		// If the variable needs to be used by other tasks it should be explicitly exported (See Export above).
-		PreVars VarMap `mapstructure:"prevars"`
+		PreVars VarMap `yaml:"prevars,omitempty" mapstructure:"prevars"`
 
 		// Try is a list of tasks to try.
-		Try Tasks `mapstructure:"try"`
+		Try Tasks `yaml:"try,omitempty" mapstructure:"try"`
 
 		// Type is the type of the task.  The task type must have been registered
 		// with the mission control.  Tasks not registered will fail the mission.
-		Type string `mapstructure:"type"`
+		Type string `yaml:"type,omitempty" mapstructure:"type"`
 	}
 
 	// Filter restricts running an activity
 	// The filter applis to the OS and Architecture of the machine running
 	// rocket.   This allows OS specific scripts to be used.
+	// Filters can also check environment variables, params, files, the host name and
+	// whether rocket is running in a CI environment.  Filters are checked when the mission
+	// is prepared, so filtered activities are never run.
 	Filter struct {
 		// IncludeOS is a list of operating systems to include.
-		IncludeOS []string `mapstructure:"includeOS"`
+		IncludeOS []string `yaml:"includeOS,omitempty" mapstructure:"includeOS"`
 
 		// IncludeArch is a list of architectures to permit.
-		IncludeArch []string `mapstructure:"includeArch"`
+		IncludeArch []string `yaml:"includeArch,omitempty" mapstructure:"includeArch"`
 
 		// ExcludeOS restricts an operating system from running.
-		ExcludeOS []string `mapstructure:"excludeOS"`
+		ExcludeOS []string `yaml:"excludeOS,omitempty" mapstructure:"excludeOS"`
 
 		// ExcludeArch restricts specific architectures from running.
-		ExcludeArch []string `mapstructure:"excludeArch"`
+		ExcludeArch []string `yaml:"excludeArch,omitempty" mapstructure:"excludeArch"`
 
 		// Skip prevents theactivity from running if true.
-		Skip bool `mapstructure:"skip"`
+		Skip bool `yaml:"skip,omitempty" mapstructure:"skip"`
+
+		// Env is a list of conditions on environment variables, all of which must be met.
+		Env []FilterCondition `yaml:"env,omitempty" mapstructure:"env"`
+
+		// Params is a list of conditions on params, all of which must be met.
+		Params []FilterCondition `yaml:"params,omitempty" mapstructure:"params"`
+
+		// FileExists is a list of glob patterns that must each match at least one file.
+		// Relative patterns are relative to the mission's directory.
+		FileExists []string `yaml:"fileExists,omitempty" mapstructure:"fileExists"`
+
+		// FileMissing is a list of glob patterns that must not match any files.
+		// Relative patterns are relative to the mission's directory.
+		FileMissing []string `yaml:"fileMissing,omitempty" mapstructure:"fileMissing"`
+
+		// Hostname is a list of host name patterns, the host must match one of them.
+		Hostname []string `yaml:"hostname,omitempty" mapstructure:"hostname"`
+
+		// CI if set restricts the activity to only run inside (true) or outside (false) a CI environment.
+		// CI environments are detected from the environment variables set by common CI systems.
+		CI *bool `yaml:"ci,omitempty" mapstructure:"ci"`
+	}
+
+	// FilterCondition is a condition on a named environment variable or param.
+	// If neither Equals or Matches is set the condition is met when the value is not blank.
+	FilterCondition struct {
+		// Name of the environment variable or param.
+		Name string `yaml:"name,omitempty" mapstructure:"name"`
+
+		// Equals requires the value to equal this value.
+		Equals string `yaml:"equals,omitempty" mapstructure:"equals"`
+
+		// Matches requires the value to match this regular expression.
+		Matches string `yaml:"matches,omitempty" mapstructure:"matches"`
 	}
 
 	// OutputSpec defines the method of outputtting for a given resource.  The choice is
 	// either variables or files.
 	OutputSpec struct {
 		// Variable is an exported variable available to later tasks in the same stage.
-		Variable string `mapstructure:"variable"`
+		Variable string `yaml:"variable,omitempty" mapstructure:"variable"`
 
 		// Output is a path to a file replacing STDOUT.
-		Path string `mapstructure:"path"`
+		Path string `yaml:"path,omitempty" mapstructure:"path"`
 
 		// AppendOutput specifies if output should append.
-		Append bool `mapstructure:"append"`
+		Append bool `yaml:"append,omitempty" mapstructure:"append"`
 
 		// SkipExpand when true skips template expansion of the runbook.
-		SkipExpand bool `mapstructure:"skipExpand"`
+		SkipExpand bool `yaml:"skipExpand,omitempty" mapstructure:"skipExpand"`
 
 		// OS File permissions
-		FileMode uint `mapstructure:"fileMode"`
+		FileMode uint `yaml:"fileMode,omitempty" mapstructure:"fileMode"`
 	}
 
 	// InputSpec is a resource input specificsation.  Input data can be provided from
 	// inline valuses, exported stage variables, local files or a web url.
 	InputSpec struct {
 		// Variable name to import from.
-		Variable string `mapstructure:"variable"`
+		Variable string `yaml:"variable,omitempty" mapstructure:"variable"`
 
-		Inline string `mapstructure:"inline"`
+		Inline string `yaml:"inline,omitempty" mapstructure:"inline"`
 
 		// Path provides the path to the input file.
-		Path string `mapstructure:"path"`
+		Path string `yaml:"path,omitempty" mapstructure:"path"`
 
 		// URl provides a url to th input data.
-		URL string `mapstructure:"url"`
+		URL string `yaml:"url,omitempty" mapstructure:"url"`
 
 		// Optional is true if resource can be missing.
-		Optional bool `mapstructure:"optional"`
+		Optional bool `yaml:"optional,omitempty" mapstructure:"optional"`
 
 		// URLTimeout request timeout, default is 30 seconds.
-		URLTimeout uint `mapstructure:"timeout"`
+		URLTimeout uint `yaml:"timeout,omitempty" mapstructure:"timeout"`
 
 		// SkipExpand when true skips template expansion of the runbook.
-		SkipExpand bool `mapstructure:"skipExpand"`
+		SkipExpand bool `yaml:"skipExpand,omitempty" mapstructure:"skipExpand"`
 	}
 
 	// Redirection is provided to a task to interpret
 	// Redirection strings need to be expanded by the task.
 	Redirection struct {
 		// Input runbook
-		Input *InputSpec `mapstructure:"input"`
+		Input *InputSpec `yaml:"input,omitempty" mapstructure:"input"`
 
 		// Output runbook
-		Output *OutputSpec `mapstructure:"output"`
+		Output *OutputSpec `yaml:"output,omitempty" mapstructure:"output"`
 
 		// Error runbook
-		Error *OutputSpec `mapstructure:"error"`
+		Error *OutputSpec `yaml:"error,omitempty" mapstructure:"error"`
 
 		// MergeErrorWithOutput specifies if error output should go to outputt
 		// if specified Error and AppendError are ignored
-		MergeErrorWithOutput bool `mapstructure:"merge"`
+		MergeErrorWithOutput bool `yaml:"merge,omitempty" mapstructure:"merge"`
 
 		// LogOutput if true will cause output to be logged rather than going to go to std output.
 		// If an output file is specified it will be used instead.
-		LogOutput bool `mapstructure:"logStdOut"`
+		LogOutput bool `yaml:"logStdOut,omitempty" mapstructure:"logStdOut"`
 
 		// DirectError when true causes the commands std error output to go direct to running processes std error
 		// When DirectError is false std error output is logged.
-		DirectError bool `mapstructure:"directStdErr"`
+		DirectError bool `yaml:"directStdErr,omitempty" mapstructure:"directStdErr"`
 	}
 )
 
+const (
+	// MergePreserve keeps the including mission's settings and only adds missing items from the include.
+	MergePreserve = MergePolicy("preserve")
+
+	// MergeOverride replaces the including mission's settings with those of the include.
+	MergeOverride = MergePolicy("override")
+
+	// MergeDeep merges stages and sequences of the same name, the including mission's settings take precedence.
+	MergeDeep = MergePolicy("deep")
+)
+
 // Validate checks that include has one and only one resource identifier defined.
 func (l *Include) Validate() error {
 	count := 0
@@ -371,10 +536,59 @@ func (l *Include) Validate() error {
 	if count == 0 {
 		return errors.New("no source was specified")
 	}
+	if l.As == "" && len(l.Params) > 0 {
+		return errors.New("params can only be passed to a namespaced include, specify as")
+	}
+	if strings.TrimSpace(l.As) != l.As {
+		return errors.New("as cannot contain leading or trailing spaces")
+	}
+	if l.As != "" && l.Merge != "" {
+		return errors.New("merge cannot be used with a namespaced include")
+	}
+	switch l.Merge {
+	case "", MergePreserve, MergeOverride, MergeDeep:
+	default:
+		return errors.Errorf("unknown merge policy %s, use preserve, override or deep", l.Merge)
+	}
 
 	return nil
 }
 
+// AllStages returns the mission's stages followed by the stages of its modules.
+func (mission *Mission) AllStages() Stages {
+	stages := make(Stages, 0, len(mission.Stages))
+	stages = append(stages, mission.Stages...)
+	for _, m := range mission.Modules {
+		stages = append(stages, m.Stages...)
+	}
+	return stages
+}
+
+// AllTaskTypes returns the mission's task type definitions followed by those of its modules.
+func (mission *Mission) AllTaskTypes() TaskTypeDefs {
+	defs := make(TaskTypeDefs, 0, len(mission.TaskTypes))
+	defs = append(defs, mission.TaskTypes...)
+	for _, m := range mission.Modules {
+		defs = append(defs, m.TaskTypes...)
+	}
+	return defs
+}
+
+// GetSequence finds the named sequence in the mission or its modules.
+func (mission *Mission) GetSequence(name string) ([]string, bool) {
+	if sequence, ok := mission.Sequences[name]; ok {
+		return sequence, true
+	}
+
+	for _, m := range mission.Modules {
+		if sequence, ok := m.Sequences[name]; ok {
+			return sequence, true
+		}
+	}
+
+	return nil, false
+}
+
 // Copy copies an environment map.
 func (em VarMap) Copy() VarMap {
 	c := make(VarMap)
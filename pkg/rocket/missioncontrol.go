@@ -73,6 +73,10 @@ type (
 		Prepare(ctx context.Context, capComm *CapComm, task Task) (ExecuteFunc, error)
 	}
 
+	// TaskTypeResolver finds task types that are not registered with the mission control when a task of the type
+	// is prepared.  It returns nil if the type is not found.
+	TaskTypeResolver func(ctx context.Context, taskType string) (TaskType, error)
+
 	// MissionController seeks out new civilizations in te CI space.
 	MissionController interface {
 		// Set option sets options on the mission controller
@@ -97,6 +101,14 @@ type (
 			params Params,
 			flightSequences ...string) error
 
+		// LaunchSubMission loads and executes a mission from within a running mission.
+		// The variables exported to the sub mission's stages are returned.
+		LaunchSubMission(ctx context.Context, sub SubMission) (map[string]string, error)
+
+		// LoadMission loads the mission, merging in its includes, without launching it.
+		// Location is used to indicate where the config was read from, if blank the current working directory is assumed.
+		LoadMission(ctx context.Context, location string, spaceDust map[string]interface{}) (*Mission, error)
+
 		// Assemble locates a blueprint from the assembly sources, loads the runbook and builds the assembly following the runbook.
 		Assemble(ctx context.Context, blueprint string, sources []string, runbook string, params Params) error
 
@@ -107,11 +119,28 @@ type (
 		ListBlueprints(ctx context.Context, sources []string) ([]BlueprintInfo, error)
 
 		ListTaskTypes(ctx context.Context) (TaskTypeInfoList, error)
+
+		// WatchMission launches the mission and then watches the files matching the stages' watch patterns,
+		// together with any patterns in the settings, re-running the affected stages when they change.
+		// Watching continues until the context is cancelled.
+		WatchMission(ctx context.Context, location string,
+			spaceDust map[string]interface{},
+			params Params,
+			settings WatchSettings,
+			flightSequences ...string) error
 	}
 
 	// operations is a collection of operations.
 	operations []*operation
 
+	// flightPlan is a loaded mission ready to have its stages prepared and executed.
+	flightPlan struct {
+		mission     *Mission
+		capComm     *CapComm
+		stageMap    StageMap
+		stagesToRun Stages
+	}
+
 	// operation represents an activity to execute.
 	operation struct {
 		description string
@@ -123,9 +152,10 @@ type (
 
 // missionControl implements MissionControl.
 type missionControl struct {
-	lock  sync.Mutex
-	types map[string]TaskType
-	log   loggee.Logger
+	lock     sync.Mutex
+	types    map[string]TaskType
+	log      loggee.Logger
+	resolver TaskTypeResolver
 }
 
 // NewMissionControl create a new mission control.
@@ -164,6 +194,16 @@ func (mc *missionControl) RegisterTaskTypes(types ...TaskType) {
 	}
 }
 
+// lookupType returns the registered task type.  Types can be registered by the resolver while concurrent
+// sub-missions are planned, so the map is read under the lock.
+func (mc *missionControl) lookupType(taskType string) (TaskType, bool) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	tt, ok := mc.types[taskType]
+	return tt, ok
+}
+
 func (mc *missionControl) LaunchMission(ctx context.Context, location string, spaceDust map[string]interface{}, flightSequences ...string) error {
 	return mc.LaunchMissionWithParams(ctx, location, spaceDust, nil, flightSequences...)
 }
@@ -171,58 +211,117 @@ func (mc *missionControl) LaunchMission(ctx context.Context, location string, sp
 func (mc *missionControl) LaunchMissionWithParams(ctx context.Context, location string,
 	spaceDust map[string]interface{}, params Params,
 	flightSequences ...string) error {
-	missionURL, err := getStartingMissionURL(location)
+	plan, err := mc.planFlight(ctx, location, spaceDust, params, nil, flightSequences)
 	if err != nil {
 		return err
 	}
 
+	return mc.fly(ctx, plan, plan.stagesToRun)
+}
+
+// LoadMission loads the mission, merging in its includes, without launching it.
+func (mc *missionControl) LoadMission(ctx context.Context, location string, spaceDust map[string]interface{}) (*Mission, error) {
+	missionURL, err := getStartingMissionURL(location)
+	if err != nil {
+		return nil, err
+	}
+
+	return loadPreMission(ctx, spaceDust, missionURL)
+}
+
+// planFlight loads the mission and establishes the mission level cap comm and the stages to run.
+func (mc *missionControl) planFlight(ctx context.Context, location string,
+	spaceDust map[string]interface{}, params Params, env VarMap,
+	flightSequences []string) (*flightPlan, error) {
+	missionURL, err := getStartingMissionURL(location)
+	if err != nil {
+		return nil, err
+	}
+
 	// Load the mission
 	mission, err := loadPreMission(ctx, spaceDust, missionURL)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if err := mc.checkTaskTypes(mission); err != nil {
+		return nil, err
 	}
 
 	// Create a cap comm object from the environment
 	capComm := newCapCommFromEnvironment(missionURL, mc.missionLog())
+	if len(env) > 0 {
+		capComm = capComm.Copy(false).MergeBasicEnvMap(env).Seal()
+	}
 
 	// Check for missing params
 	if err := checkMustHaveParams(capComm.params, mission.Must); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Misssion has been successfully parsed, load the global settings
 	capComm, err = processGlobals(ctx, capComm, mission, params)
 	if err != nil {
-		return errors.Wrap(err, "global settings failure")
+		return nil, errors.Wrap(err, "global settings failure")
 	}
 
 	// Create a map of staage names to stages, used for ref lookups and flight sequences
-	stageMap, err := convertStagesToMap(mission.Stages)
+	stageMap, err := convertStagesToMap(mission.AllStages())
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// get the stages needed to be run
 	stagesToRun, err := getStagesTooRun(mission, stageMap, flightSequences)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	return &flightPlan{
+		mission:     mission,
+		capComm:     capComm,
+		stageMap:    stageMap,
+		stagesToRun: stagesToRun,
+	}, nil
+}
+
+// fly prepares and executes the passed stages of a flight plan.
+func (mc *missionControl) fly(ctx context.Context, plan *flightPlan, stages Stages) error {
+	ciExport := plan.mission.CIExport
+	if ciExport != nil {
+		if err := ciExport.Validate(); err != nil {
+			return errors.Wrap(err, "ciExport")
+		}
+
+		// collect the variables exported by the stages
+		if plan.capComm.stageExports == nil {
+			plan.capComm.stageExports = newVariableSet()
+		}
 	}
 
 	// prepare the stages
-	operations, err := mc.prepareStages(ctx, capComm, stageMap, stagesToRun)
+	operations, err := mc.prepareStages(ctx, plan.capComm, plan.stageMap, stages)
 	if err != nil {
 		return err
 	}
 
 	var fallbackOp *operation
-	if mission.OnFail != nil {
-		fallbackOp, err = mc.prepareFailStage(ctx, capComm, stageMap, *mission.OnFail)
+	if plan.mission.OnFail != nil {
+		fallbackOp, err = mc.prepareFailStage(ctx, plan.capComm, plan.stageMap, *plan.mission.OnFail)
 		if err != nil {
 			return err
 		}
 	}
 
-	return engage(ctx, operations, fallbackOp, capComm.Log())
+	if err := engage(ctx, operations, fallbackOp, plan.capComm.Log()); err != nil {
+		return err
+	}
+
+	if ciExport != nil {
+		return errors.Wrap(plan.capComm.ExportToCI(ctx, *ciExport), "ciExport")
+	}
+
+	return nil
 }
 
 func mergeStages(stage *Stage, ref string, stageMap StageMap, circular map[string]bool) error { // nolint:cyclop
@@ -278,6 +377,14 @@ func mergeStages(stage *Stage, ref string, stageMap StageMap, circular map[strin
 		stage.Tasks = src.Tasks.Copy()
 	}
 
+	if len(stage.Watch) == 0 {
+		stage.Watch = append([]string(nil), src.Watch...)
+	}
+
+	if stage.module == nil {
+		stage.module = src.module
+	}
+
 	if src.Ref != "" {
 		return mergeStages(stage, src.Ref, stageMap, circular)
 	}
@@ -454,8 +561,17 @@ func (mc *missionControl) prepareFailStage(ctx context.Context, capComm *CapComm
 
 func createStageCapComm(ctx context.Context, missionCapComm *CapComm, stage Stage) (*CapComm, error) {
 	// Create a new CapComm for the stage
-	capComm := missionCapComm.Copy(stage.NoTrust).
-		MergeBasicEnvMap(stage.BasicEnv)
+	capComm := missionCapComm.Copy(stage.NoTrust)
+
+	// Sub missions collect the variables exported to their stages
+	capComm.variables.forward = missionCapComm.stageExports
+
+	// Stages imported from modules have the module's settings applied first
+	if err := applyModuleScope(ctx, capComm, stage.module); err != nil {
+		return nil, errors.Wrap(err, "module")
+	}
+
+	capComm.MergeBasicEnvMap(stage.BasicEnv)
 
 	if err := capComm.MergeParams(ctx, stage.Params); err != nil {
 		return nil, errors.Wrap(err, "merging params")
@@ -472,17 +588,19 @@ func createStageCapComm(ctx context.Context, missionCapComm *CapComm, stage Stag
 }
 
 func (mc *missionControl) prepareStage(ctx context.Context, missionCapComm *CapComm, stage Stage) (*operation, error) {
-	if stage.Filter.IsFiltered() {
-		return nil, nil
+	// Create the cap comm for the stage, the filter is checked against the stage's params
+	capComm, err := createStageCapComm(ctx, missionCapComm, stage)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := checkMustHaveParams(missionCapComm.params, stage.Must); err != nil {
-		return nil, err
+	if filtered, err := capComm.isFiltered(stage.Filter); err != nil {
+		return nil, errors.Wrap(err, "filter")
+	} else if filtered {
+		return nil, nil
 	}
 
-	// Create the cap comm for the stage
-	capComm, err := createStageCapComm(ctx, missionCapComm, stage)
-	if err != nil {
+	if err := checkMustHaveParams(missionCapComm.params, stage.Must); err != nil {
 		return nil, err
 	}
 
@@ -565,7 +683,9 @@ func (mc *missionControl) switchTaskType(ctx context.Context, capComm *CapComm,
 }
 
 func (mc *missionControl) prepareTask(ctx context.Context, parentCapComm *CapComm, task Task) (*operation, error) {
-	if task.Filter.IsFiltered() {
+	if filtered, err := parentCapComm.isFiltered(task.Filter); err != nil {
+		return nil, errors.Wrap(err, "filter")
+	} else if filtered {
 		return nil, nil
 	}
 
@@ -860,12 +980,44 @@ func (mc *missionControl) prepareSequentialTaskList(ctx context.Context, capComm
 	return combineSequentialTaskListOperations(ctx, capComm, operations, onFail, groupDesc, tryOp, taskDir)
 }
 
+// resolveTaskType finds an unregistered task type with the resolver, registering it if found.
+func (mc *missionControl) resolveTaskType(ctx context.Context, taskType string) (TaskType, error) {
+	if mc.resolver == nil {
+		return nil, nil
+	}
+
+	tt, err := mc.resolver(ctx, taskType)
+	if err != nil || tt == nil {
+		return nil, err
+	}
+
+	mc.RegisterTaskTypes(tt)
+
+	return tt, nil
+}
+
 func (mc *missionControl) prepareTaskKindType(ctx context.Context, capComm *CapComm, task Task) (*operation, error) {
 	// Look up task
-	tt, ok := mc.types[task.Type]
+	tt, ok := mc.lookupType(task.Type)
 	if !ok {
-		// Unknown task type
-		return nil, fmt.Errorf("unknown task type %s", task.Type)
+		// Custom task types defined by the mission
+		if capComm.mission != nil {
+			if def, found := capComm.mission.AllTaskTypes().Find(task.Type); found {
+				return mc.prepareTaskKindDefined(ctx, capComm, task, def)
+			}
+		}
+
+		resolved, err := mc.resolveTaskType(ctx, task.Type)
+		if err != nil {
+			return nil, err
+		}
+
+		if resolved == nil {
+			// Unknown task type
+			return nil, fmt.Errorf("unknown task type %s", task.Type)
+		}
+
+		tt = resolved
 	}
 
 	taskFunc, err := tt.Prepare(ctx, capComm.Seal(), task)
@@ -897,11 +1049,18 @@ func (mc *missionControl) prepareTaskKindType(ctx context.Context, capComm *CapC
 }
 
 func processGlobals(ctx context.Context, capComm *CapComm, mission *Mission, suppliedParams Params) (*CapComm, error) {
-	// Copy the inbound CapComm
+	// Copy the inbound CapComm, its params are the base of the params of module stages
+	baseParams := capComm.params
 	capComm = capComm.Copy(false).
 		WithMission(mission).
 		MergeBasicEnvMap(mission.BasicEnv).
 		AddAdditionalMissionData(mission.Additional)
+	capComm.baseParams = baseParams
+
+	// Parse the shared templates, before the params so they can use them
+	if err := capComm.SetTemplates(ctx, mission.Templates); err != nil {
+		return nil, errors.Wrap(err, "templates")
+	}
 
 	// Merge and expand parameters
 	if err := capComm.MergeParams(ctx, suppliedParams); err != nil {
@@ -918,24 +1077,29 @@ func processGlobals(ctx context.Context, capComm *CapComm, mission *Mission, sup
 		return nil, errors.Wrap(err, "merging template envs")
 	}
 
+	// Confine the file tasks
+	if err := capComm.SetSandbox(ctx, mission.Sandbox); err != nil {
+		return nil, errors.Wrap(err, "sandbox")
+	}
+
 	// Return a sealed capComm that cannot be edited
 	return capComm.Seal(), nil
 }
 
 func getStagesTooRun(mission *Mission, stageMap StageMap, flightSequences []string) (Stages, error) {
-	if len(mission.Sequences) == 0 {
-		// using stages alone
-		if len(flightSequences) > 0 {
-			return nil, errors.New("flight sequence specified for a configuration does not use sequences")
+	if len(flightSequences) == 0 {
+		//	Using sequences
+		if len(mission.Sequences) > 0 {
+			return nil, errors.New("no flight sequence specified for a configuration that uses sequences")
 		}
 
 		// Just run all stages in order
 		return mission.Stages, nil
 	}
 
-	//	Using sequences
-	if len(flightSequences) == 0 {
-		return nil, errors.New("no flight sequence specified for a configuration that uses sequences")
+	if len(mission.Sequences) == 0 && len(mission.Modules) == 0 {
+		// using stages alone
+		return nil, errors.New("flight sequence specified for a configuration does not use sequences")
 	}
 
 	var stagesToRun Stages
@@ -943,7 +1107,7 @@ func getStagesTooRun(mission *Mission, stageMap StageMap, flightSequences []stri
 	// Compile stagesToRun from the sequences specified
 	alreadySpecified := make(map[string]bool)
 	for _, flight := range flightSequences {
-		sequence, ok := mission.Sequences[flight]
+		sequence, ok := mission.GetSequence(flight)
 		if !ok {
 			return nil, fmt.Errorf("sequence %s cannot be found", flight)
 		}
@@ -401,6 +401,28 @@ func TestProcessGlobalsPassedInParams(t *testing.T) {
 	}
 }
 
+func TestProcessGlobalsTemplates(t *testing.T) {
+	ctx := context.Background()
+
+	capComm := newCapCommFromEnvironment(getTestMissionFile(), stdlog.New())
+
+	mission := new(Mission)
+	mission.Templates = []TemplateDef{{Name: "image", InputSpec: InputSpec{Inline: "registry/{{ .app }}"}}}
+	mission.Params = []Param{
+		{Name: "app", Value: "demo"},
+		{Name: "image", Value: `{{ include "image" . }}:1.0`},
+	}
+
+	capCommMission, err := processGlobals(ctx, capComm, mission, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := capCommMission.params.Get("image"); v != "registry/demo:1.0" {
+		t.Error("unexpected image", v)
+	}
+}
+
 func TestCheckMustHaveParamsEmpty(t *testing.T) {
 	paramKvg := NewKeyValueGetter(nil)
 
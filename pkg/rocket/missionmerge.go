@@ -16,6 +16,24 @@ limitations under the License.
 
 package rocket
 
+import "github.com/pkg/errors"
+
+// mergeMissionsWithPolicy merges the addition into the mission following the merge policy.
+func mergeMissionsWithPolicy(mission, addition *Mission, policy MergePolicy) error {
+	switch policy {
+	case "", MergePreserve:
+		mergeMissions(mission, addition)
+	case MergeOverride:
+		overrideMissions(mission, addition)
+	case MergeDeep:
+		deepMergeMissions(mission, addition)
+	default:
+		return errors.Errorf("unknown merge policy %s", policy)
+	}
+
+	return nil
+}
+
 func mergeMissions(mission, addition *Mission) {
 	if mission.Name == "" {
 		mission.Name = addition.Name
@@ -33,6 +51,14 @@ func mergeMissions(mission, addition *Mission) {
 		mission.OnFail = addition.OnFail
 	}
 
+	if mission.Sandbox == nil {
+		mission.Sandbox = addition.Sandbox
+	}
+
+	if mission.CIExport == nil {
+		mission.CIExport = addition.CIExport
+	}
+
 	missionMergeEnv(mission, addition)
 
 	if len(addition.Params) > 0 {
@@ -46,6 +72,12 @@ func mergeMissions(mission, addition *Mission) {
 	if len(addition.Sequences) > 0 {
 		missionMergeSequences(mission, addition)
 	}
+
+	mergeTaskTypes(mission, addition)
+
+	if len(addition.Templates) > 0 {
+		missionMergeTemplates(mission, addition)
+	}
 }
 
 func missionMergeEnv(mission, addition *Mission) {
@@ -87,6 +119,24 @@ func missionMergeParams(mission, addition *Mission) {
 	mission.Params = append(mission.Params, params...)
 }
 
+// missionMergeTemplates adds the addition's templates before the mission's, so that templates defined by both
+// the mission and the addition use the mission's definition.
+func missionMergeTemplates(mission, addition *Mission) {
+	m := make(map[string]bool)
+	for _, t := range mission.Templates {
+		m[t.Name] = true
+	}
+
+	templates := make([]TemplateDef, 0, len(addition.Templates)+len(mission.Templates))
+	for _, t := range addition.Templates {
+		if _, ok := m[t.Name]; !ok || t.Name == "" {
+			templates = append(templates, t)
+		}
+	}
+
+	mission.Templates = append(templates, mission.Templates...)
+}
+
 func missionMergeStages(mission, addition *Mission) {
 	m := make(map[string]bool)
 	for _, st := range mission.Stages {
@@ -97,12 +147,277 @@ func missionMergeStages(mission, addition *Mission) {
 	for _, st := range addition.Stages {
 		if _, ok := m[st.Name]; !ok || st.Name == "" {
 			stages = append(stages, st)
+		} else if st.Override {
+			// the included stage insists on replacing the mission's
+			replaceStage(mission, st)
 		}
 	}
 
 	mission.Stages = append(mission.Stages, stages...)
 }
 
+// replaceStage replaces the mission stage with the same name, unless the mission's stage is marked as an override.
+func replaceStage(mission *Mission, stage Stage) {
+	for i, st := range mission.Stages {
+		if st.Name == stage.Name {
+			if !st.Override {
+				mission.Stages[i] = stage
+			}
+			return
+		}
+	}
+}
+
+// overrideMissions merges the addition into the mission with the addition's settings replacing the mission's.
+func overrideMissions(mission, addition *Mission) {
+	if addition.Name != "" {
+		mission.Name = addition.Name
+	}
+
+	if addition.Description != "" {
+		mission.Description = addition.Description
+	}
+
+	if addition.Version != "" {
+		mission.Version = addition.Version
+	}
+
+	if addition.OnFail != nil {
+		mission.OnFail = addition.OnFail
+	}
+
+	if addition.Sandbox != nil {
+		mission.Sandbox = addition.Sandbox
+	}
+
+	if addition.CIExport != nil {
+		mission.CIExport = addition.CIExport
+	}
+
+	mission.BasicEnv = overrideVarMap(mission.BasicEnv, addition.BasicEnv)
+	mission.Env = overrideVarMap(mission.Env, addition.Env)
+	mission.Params = overrideParams(mission.Params, addition.Params)
+
+	for _, st := range addition.Stages {
+		if st.Name == "" || !hasStage(mission.Stages, st.Name) {
+			mission.Stages = append(mission.Stages, st)
+		} else {
+			replaceStage(mission, st)
+		}
+	}
+
+	if len(addition.Sequences) > 0 && mission.Sequences == nil {
+		mission.Sequences = make(map[string][]string)
+	}
+	for k, seq := range addition.Sequences {
+		mission.Sequences[k] = seq
+	}
+
+	overrideTaskTypes(mission, addition)
+
+	mission.Templates = overrideTemplates(mission.Templates, addition.Templates)
+}
+
+// deepMergeMissions merges the addition into the mission, stages and sequences with the same name are merged
+// with the mission's settings taking precedence.
+func deepMergeMissions(mission, addition *Mission) {
+	// stages and sequences are merged here, leaving the remaining settings to the preserve merge
+	stages := addition.Stages
+	sequences := addition.Sequences
+	shallow := *addition
+	shallow.Stages = nil
+	shallow.Sequences = nil
+	mergeMissions(mission, &shallow)
+
+	for _, st := range stages {
+		if st.Name == "" || !hasStage(mission.Stages, st.Name) {
+			mission.Stages = append(mission.Stages, st)
+			continue
+		}
+
+		for i := range mission.Stages {
+			if mission.Stages[i].Name != st.Name {
+				continue
+			}
+
+			switch {
+			case mission.Stages[i].Override:
+				// keep the mission's stage
+			case st.Override:
+				mission.Stages[i] = st
+			default:
+				deepMergeStage(&mission.Stages[i], st)
+			}
+			break
+		}
+	}
+
+	if len(sequences) > 0 && mission.Sequences == nil {
+		mission.Sequences = make(map[string][]string)
+	}
+	for k, seq := range sequences {
+		mission.Sequences[k] = mergeSequence(mission.Sequences[k], seq)
+	}
+}
+
+// deepMergeStage merges the addition stage into the stage, the stage's settings take precedence.
+// Tasks are merged by name, with the stage's tasks replacing those in the addition.
+func deepMergeStage(stage *Stage, addition Stage) { //nolint:cyclop
+	if stage.Description == "" {
+		stage.Description = addition.Description
+	}
+
+	if stage.Ref == "" {
+		stage.Ref = addition.Ref
+	}
+
+	if stage.If == "" {
+		stage.If = addition.If
+	}
+
+	if stage.Dir == "" {
+		stage.Dir = addition.Dir
+	}
+
+	if stage.Filter == nil {
+		stage.Filter = addition.Filter
+	}
+
+	if !stage.NoTrust {
+		stage.NoTrust = addition.NoTrust
+	}
+
+	if stage.OnFail == nil {
+		stage.OnFail = addition.OnFail
+	}
+
+	if stage.module == nil {
+		stage.module = addition.module
+	}
+
+	stage.BasicEnv = overrideVarMap(addition.BasicEnv.Copy(), stage.BasicEnv)
+	stage.Env = overrideVarMap(addition.Env.Copy(), stage.Env)
+	stage.Params = overrideParams(addition.Params.Copy(), stage.Params)
+	stage.Must = mergeStrings(stage.Must, addition.Must)
+	stage.Watch = mergeStrings(stage.Watch, addition.Watch)
+	stage.Tasks = overrideTasks(addition.Tasks.Copy(), stage.Tasks)
+}
+
+// overrideVarMap adds the values of the addition to the map, replacing existing values.
+func overrideVarMap(vm, addition VarMap) VarMap {
+	if len(addition) == 0 {
+		return vm
+	}
+
+	if vm == nil {
+		vm = make(VarMap)
+	}
+
+	for k, v := range addition {
+		vm[k] = v
+	}
+
+	return vm
+}
+
+// overrideParams replaces params with the same name as those in the addition and appends the remainder.
+func overrideParams(params, addition Params) Params {
+	for _, p := range addition {
+		replaced := false
+		if p.Name != "" {
+			for i := range params {
+				if params[i].Name == p.Name {
+					params[i] = p
+					replaced = true
+					break
+				}
+			}
+		}
+
+		if !replaced {
+			params = append(params, p)
+		}
+	}
+
+	return params
+}
+
+// overrideTemplates replaces templates with the same name as those in the addition and appends the remainder.
+func overrideTemplates(templates, addition []TemplateDef) []TemplateDef {
+	for _, t := range addition {
+		replaced := false
+		if t.Name != "" {
+			for i := range templates {
+				if templates[i].Name == t.Name {
+					templates[i] = t
+					replaced = true
+					break
+				}
+			}
+		}
+
+		if !replaced {
+			templates = append(templates, t)
+		}
+	}
+
+	return templates
+}
+
+// overrideTasks replaces tasks with the same name as those in the addition and appends the remainder.
+func overrideTasks(tasks, addition Tasks) Tasks {
+	for _, task := range addition {
+		replaced := false
+		if task.Name != "" {
+			for i := range tasks {
+				if tasks[i].Name == task.Name {
+					tasks[i] = task
+					replaced = true
+					break
+				}
+			}
+		}
+
+		if !replaced {
+			tasks = append(tasks, task)
+		}
+	}
+
+	return tasks
+}
+
+// mergeStrings appends the entries of the addition not already in the list.
+func mergeStrings(list, addition []string) []string {
+	m := make(map[string]bool)
+	for _, s := range list {
+		m[s] = true
+	}
+
+	for _, s := range addition {
+		if !m[s] {
+			m[s] = true
+			list = append(list, s)
+		}
+	}
+
+	return list
+}
+
+// mergeSequence appends the stages of the addition not already in the sequence.
+func mergeSequence(sequence, addition []string) []string {
+	return mergeStrings(append([]string(nil), sequence...), addition)
+}
+
+func hasStage(stages Stages, name string) bool {
+	for _, st := range stages {
+		if st.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
 func missionMergeSequences(mission, addition *Mission) {
 	if mission.Sequences == nil {
 		mission.Sequences = make(map[string][]string)
@@ -16,7 +16,14 @@ limitations under the License.
 
 package rocket
 
-import "testing"
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nehemming/cirocket/pkg/loggee"
+	"github.com/nehemming/cirocket/pkg/loggee/stdlog"
+)
 
 func TestMissionMergeParams(t *testing.T) {
 	// load them in
@@ -137,3 +144,177 @@ func TestMergeMissions(t *testing.T) {
 		t.Error("missing Params", mission.Params, addition.Params)
 	}
 }
+
+func TestMergeMissionsSandbox(t *testing.T) {
+	mission := &Mission{}
+	mergeMissions(mission, &Mission{Sandbox: &Sandbox{Root: "inc"}})
+
+	if mission.Sandbox == nil || mission.Sandbox.Root != "inc" {
+		t.Error("sandbox from addition", mission.Sandbox)
+	}
+
+	mergeMissions(mission, &Mission{Sandbox: &Sandbox{Root: "other"}})
+	if mission.Sandbox.Root != "inc" {
+		t.Error("sandbox preserved", mission.Sandbox)
+	}
+
+	if err := mergeMissionsWithPolicy(mission, &Mission{Sandbox: &Sandbox{Root: "override"}}, MergeOverride); err != nil {
+		t.Fatal(err)
+	}
+	if mission.Sandbox.Root != "override" {
+		t.Error("sandbox overridden", mission.Sandbox)
+	}
+}
+
+func TestMergeMissionsTemplates(t *testing.T) {
+	header := func(inline string) TemplateDef {
+		return TemplateDef{Name: "header", InputSpec: InputSpec{Inline: inline}}
+	}
+
+	mission := &Mission{Templates: []TemplateDef{header("mission")}}
+	mergeMissions(mission, &Mission{Templates: []TemplateDef{header("inc"), {InputSpec: InputSpec{Path: "defines.tmpl"}}}})
+
+	if len(mission.Templates) != 2 || mission.Templates[0].Path != "defines.tmpl" || mission.Templates[1].Inline != "mission" {
+		t.Error("templates preserved", mission.Templates)
+	}
+
+	if err := mergeMissionsWithPolicy(mission, &Mission{Templates: []TemplateDef{header("override")}}, MergeOverride); err != nil {
+		t.Fatal(err)
+	}
+	if len(mission.Templates) != 2 || mission.Templates[1].Inline != "override" {
+		t.Error("templates overridden", mission.Templates)
+	}
+}
+
+func TestMergeMissionsWithPolicyUnknown(t *testing.T) {
+	if err := mergeMissionsWithPolicy(&Mission{}, &Mission{}, MergePolicy("sideways")); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestMergeMissionsOverride(t *testing.T) {
+	mission := &Mission{
+		Name:   "top",
+		Env:    VarMap{"A": "1", "B": "2"},
+		Params: Params{{Name: "one", Value: "1"}, {Name: "two", Value: "2"}},
+		Stages: Stages{
+			{Name: "build", Description: "top"},
+			{Name: "keep", Description: "top", Override: true},
+		},
+		Sequences: map[string][]string{"ci": {"build"}},
+	}
+
+	addition := &Mission{
+		Name:   "inc",
+		Env:    VarMap{"A": "inc", "C": "3"},
+		Params: Params{{Name: "two", Value: "inc"}, {Name: "three", Value: "3"}},
+		Stages: Stages{
+			{Name: "build", Description: "inc"},
+			{Name: "keep", Description: "inc"},
+			{Name: "new", Description: "inc"},
+		},
+		Sequences: map[string][]string{"ci": {"new"}},
+	}
+
+	if err := mergeMissionsWithPolicy(mission, addition, MergeOverride); err != nil {
+		t.Fatal(err)
+	}
+
+	if mission.Name != "inc" {
+		t.Error("name", mission.Name)
+	}
+	if mission.Env["A"] != "inc" || mission.Env["B"] != "2" || mission.Env["C"] != "3" {
+		t.Error("env", mission.Env)
+	}
+	if len(mission.Params) != 3 || mission.Params[1].Value != "inc" || mission.Params[2].Name != "three" {
+		t.Error("params", mission.Params)
+	}
+	if len(mission.Stages) != 3 || mission.Stages[0].Description != "inc" ||
+		mission.Stages[1].Description != "top" || mission.Stages[2].Name != "new" {
+		t.Error("stages", mission.Stages)
+	}
+	if seq := mission.Sequences["ci"]; len(seq) != 1 || seq[0] != "new" {
+		t.Error("sequence", seq)
+	}
+}
+
+func TestMergeMissionsPreserveStageOverride(t *testing.T) {
+	mission := &Mission{
+		Stages: Stages{{Name: "build", Description: "top"}},
+	}
+
+	addition := &Mission{
+		Stages: Stages{{Name: "build", Description: "inc", Override: true}},
+	}
+
+	mergeMissions(mission, addition)
+
+	if len(mission.Stages) != 1 || mission.Stages[0].Description != "inc" {
+		t.Error("stages", mission.Stages)
+	}
+}
+
+func TestLoadMissionDeepMerge(t *testing.T) {
+	mc := NewMissionControl()
+	spaceDust, location := loadMission("merge")
+
+	mission, err := mc.LoadMission(context.Background(), location, spaceDust)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(tasks Tasks) []string {
+		var n []string
+		for _, task := range tasks {
+			n = append(n, task.Name+"="+task.Definition["value"].(string))
+		}
+		return n
+	}
+
+	if len(mission.Stages) != 3 {
+		t.Fatal("stages", len(mission.Stages))
+	}
+
+	build := names(mission.Stages[0].Tasks)
+	if strings.Join(build, ",") != "compile=base-compile,lint={{.greeting}}-lint,test={{.flavour}}-test" {
+		t.Error("build tasks", build)
+	}
+
+	if deploy := names(mission.Stages[1].Tasks); len(deploy) != 1 || deploy[0] != "deploy=top-deploy" {
+		t.Error("deploy tasks", deploy)
+	}
+
+	if release := names(mission.Stages[2].Tasks); len(release) != 1 || release[0] != "release=base-release" {
+		t.Error("release tasks", release)
+	}
+
+	if seq := strings.Join(mission.Sequences["ci"], ","); seq != "build,release,deploy" {
+		t.Error("sequence", seq)
+	}
+
+	if len(mission.Params) != 2 || mission.Params[0].Value != "top" || mission.Params[1].Value != "vanilla" {
+		t.Error("params", mission.Params)
+	}
+}
+
+func TestLaunchDeepMergedMission(t *testing.T) {
+	loggee.SetLogger(stdlog.New())
+	mc := NewMissionControl()
+	tt := newRecordTaskType()
+	mc.RegisterTaskTypes(tt)
+
+	spaceDust, location := loadMission("merge")
+	if err := mc.LaunchMission(context.Background(), location, spaceDust, "ci"); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := tt.get("lint"); len(v) != 1 || v[0] != "top-lint" {
+		t.Error("lint", v)
+	}
+	if v := tt.get("test"); len(v) != 1 || v[0] != "vanilla-test" {
+		t.Error("test", v)
+	}
+	if v := tt.get("deploy"); len(v) != 1 || v[0] != "top-deploy" {
+		t.Error("deploy", v)
+	}
+}
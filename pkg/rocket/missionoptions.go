@@ -33,11 +33,24 @@ func LoggerOption(log loggee.Logger) Option {
 	return missionOptionLog{log}
 }
 
+type missionOptionResolver struct {
+	resolver TaskTypeResolver
+}
+
+func (missionOptionResolver) Name() string { return "resolver" }
+
+// TaskTypeResolverOption sets the resolver used to find task types that have not been registered.
+func TaskTypeResolverOption(resolver TaskTypeResolver) Option {
+	return missionOptionResolver{resolver}
+}
+
 func (mc *missionControl) SetOptions(options ...Option) error {
 	for _, opt := range options {
 		switch option := opt.(type) {
 		case missionOptionLog:
 			mc.log = option.log
+		case missionOptionResolver:
+			mc.resolver = option.resolver
 		default:
 			return fmt.Errorf("option %s not supported", opt.Name())
 		}
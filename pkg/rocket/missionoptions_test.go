@@ -17,6 +17,7 @@ limitations under the License.
 package rocket
 
 import (
+	"context"
 	"testing"
 
 	"github.com/nehemming/cirocket/pkg/loggee/stdlog"
@@ -54,6 +55,21 @@ func TestSetOptionsLog(t *testing.T) {
 	}
 }
 
+func TestSetOptionsResolver(t *testing.T) {
+	mc := NewMissionControl()
+
+	err := mc.SetOptions(TaskTypeResolverOption(func(ctx context.Context, taskType string) (TaskType, error) {
+		return nil, nil
+	}))
+	if err != nil {
+		t.Error("unexpected", err)
+	}
+
+	if mc.(*missionControl).resolver == nil {
+		t.Error("resolver not set")
+	}
+}
+
 type unknownOption struct{}
 
 func (unknownOption) Name() string { return "unknown" }
@@ -0,0 +1,204 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rocket
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// moduleScope holds the mission level settings of a module that apply to each of its stages.  The params of
+// a module's stages are seeded from the params passed to it and its own params, not those of the including
+// mission.  Outer is the scope of the module including this one, if it is nested.
+type moduleScope struct {
+	passed   Params
+	params   Params
+	basicEnv VarMap
+	env      VarMap
+	must     MustHaveParams
+	outer    *moduleScope
+}
+
+// loadModule loads the included mission and places its stages and sequences in the include's namespace.
+func loadModule(ctx context.Context, include Include, includeMap map[string]interface{}, includeURL *url.URL) (*Module, error) {
+	mission, err := loadPreMission(ctx, includeMap, includeURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return namespaceModule(include, mission), nil
+}
+
+// namespaceModule prefixes the mission's stage, sequence and task type names with the include's namespace.
+// Refs, sequences and task types used within the module are updated to the prefixed names.
+func namespaceModule(include Include, mission *Mission) *Module {
+	prefix := include.As + "."
+
+	stages := mission.AllStages()
+	names := make(map[string]bool)
+	for _, stage := range stages {
+		if stage.Name != "" {
+			names[stage.Name] = true
+		}
+	}
+
+	rename := func(name string) string {
+		if names[name] {
+			return prefix + name
+		}
+		return name
+	}
+
+	defs := mission.AllTaskTypes()
+	typeNames := make(map[string]bool)
+	for _, def := range defs {
+		typeNames[def.Name] = true
+	}
+
+	renameType := func(name string) string {
+		if typeNames[name] {
+			return prefix + name
+		}
+		return name
+	}
+
+	scope := &moduleScope{
+		passed:   include.Params.Copy(),
+		params:   moduleParams(include.Params, mission.Params),
+		basicEnv: mission.BasicEnv.Copy(),
+		env:      mission.Env.Copy(),
+		must:     mission.Must.Copy(),
+	}
+
+	m := &Module{
+		Name:      include.As,
+		Stages:    make(Stages, 0, len(stages)),
+		Sequences: make(map[string][]string),
+		TaskTypes: make(TaskTypeDefs, 0, len(defs)),
+	}
+
+	for _, def := range defs {
+		def.Name = prefix + def.Name
+		def.Tasks = renameTaskTypes(def.Tasks, renameType)
+		m.TaskTypes = append(m.TaskTypes, def)
+	}
+
+	for index, stage := range stages {
+		if stage.Name == "" {
+			stage.Name = fmt.Sprintf("stage[%d]", index)
+		}
+		stage.Name = prefix + stage.Name
+		stage.Ref = rename(stage.Ref)
+		stage.Tasks = renameTaskTypes(stage.Tasks, renameType)
+		if stage.OnFail != nil {
+			onFail := renameTaskType(*stage.OnFail, renameType)
+			stage.OnFail = &onFail
+		}
+
+		// stages of nested modules keep their own scope as well as gaining this one
+		stage.module = scope.wrap(stage.module)
+
+		m.Stages = append(m.Stages, stage)
+	}
+
+	sequences := make(map[string][]string)
+	for name, sequence := range mission.Sequences {
+		sequences[name] = sequence
+	}
+	for _, inner := range mission.Modules {
+		for name, sequence := range inner.Sequences {
+			sequences[name] = sequence
+		}
+	}
+
+	for name, sequence := range sequences {
+		renamed := make([]string, len(sequence))
+		for i, stageName := range sequence {
+			renamed[i] = rename(stageName)
+		}
+		m.Sequences[prefix+name] = renamed
+	}
+
+	return m
+}
+
+// moduleParams returns the module's params that are not replaced by those passed to it.
+func moduleParams(includeParams, missionParams Params) Params {
+	passed := make(map[string]bool)
+	for _, p := range includeParams {
+		passed[p.Name] = true
+	}
+
+	var params Params
+	for _, p := range missionParams {
+		if !passed[p.Name] || p.Name == "" {
+			params = append(params, p)
+		}
+	}
+
+	return params
+}
+
+// wrap places an inner module scope within this outer scope.  The inner scope's settings take precedence.
+func (scope *moduleScope) wrap(inner *moduleScope) *moduleScope {
+	if inner == nil {
+		return scope
+	}
+
+	wrapped := *inner
+	wrapped.outer = scope.wrap(inner.outer)
+	return &wrapped
+}
+
+// applyModuleScope merges the module settings of a stage into its cap comm ahead of the stage's own settings.
+// The params passed to the module are expanded with the params of the including mission, or outer module, and
+// then replace them.
+func applyModuleScope(ctx context.Context, capComm *CapComm, scope *moduleScope) error {
+	if scope == nil {
+		return nil
+	}
+
+	if err := applyModuleScope(ctx, capComm, scope.outer); err != nil {
+		return err
+	}
+
+	passed := NewKeyValueGetter(capComm.params)
+	capComm.params = passed
+	if err := capComm.MergeParams(ctx, scope.passed); err != nil {
+		return err
+	}
+
+	params := NewKeyValueGetter(capComm.baseParams)
+	for k, v := range passed.kv {
+		params.kv[k] = v
+	}
+	capComm.params = params
+	capComm.setModified()
+
+	capComm.MergeBasicEnvMap(scope.basicEnv)
+
+	if err := capComm.MergeParams(ctx, scope.params); err != nil {
+		return err
+	}
+
+	if err := checkMustHaveParams(capComm.params, scope.must); err != nil {
+		return err
+	}
+
+	return capComm.MergeTemplateEnvs(ctx, scope.env)
+}
@@ -0,0 +1,260 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rocket
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/nehemming/cirocket/pkg/loggee"
+	"github.com/nehemming/cirocket/pkg/loggee/stdlog"
+)
+
+// recordTaskType records the expanded value definition of each task run.
+type recordTaskType struct {
+	mu     sync.Mutex
+	values map[string][]string
+}
+
+func newRecordTaskType() *recordTaskType {
+	return &recordTaskType{values: make(map[string][]string)}
+}
+
+func (tt *recordTaskType) Type() string        { return "recordTask" }
+func (tt *recordTaskType) Description() string { return "recording task" }
+
+func (tt *recordTaskType) Prepare(ctx context.Context, capComm *CapComm, task Task) (ExecuteFunc, error) {
+	value, _ := task.Definition["value"].(string)
+
+	return func(ctx context.Context) error {
+		v, err := capComm.ExpandString(ctx, "value", value)
+		if err != nil {
+			return err
+		}
+
+		tt.mu.Lock()
+		defer tt.mu.Unlock()
+		tt.values[task.Name] = append(tt.values[task.Name], v)
+		return nil
+	}, nil
+}
+
+func (tt *recordTaskType) get(name string) []string {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	return tt.values[name]
+}
+
+func launchModulesMission(t *testing.T, sequences ...string) (*recordTaskType, error) {
+	t.Helper()
+	loggee.SetLogger(stdlog.New())
+	mc := NewMissionControl()
+	tt := newRecordTaskType()
+	mc.RegisterTaskTypes(tt)
+
+	mission, location := loadMission("modules")
+
+	return tt, mc.LaunchMission(context.Background(), location, mission, sequences...)
+}
+
+func TestModuleStagesUseModuleScope(t *testing.T) {
+	tt, err := launchModulesMission(t, "ci")
+	if err != nil {
+		t.Fatal("launch", err)
+	}
+
+	if v := tt.get("prepare"); len(v) != 1 || v[0] != "hello" {
+		t.Error("prepare", v)
+	}
+
+	if v := tt.get("build"); len(v) != 1 || v[0] != "hello world|lib-hello world|lib-hello world" {
+		t.Error("build", v)
+	}
+}
+
+func TestModuleSequencesAndRefs(t *testing.T) {
+	tt, err := launchModulesMission(t, "lib.all")
+	if err != nil {
+		t.Fatal("launch", err)
+	}
+
+	// lib.test refs lib.build so the build task is run twice
+	if v := tt.get("build"); len(v) != 2 {
+		t.Error("build", v)
+	}
+	if v := tt.get("prepare"); len(v) != 0 {
+		t.Error("prepare should not run", v)
+	}
+}
+
+func TestParentStageRefToModuleStage(t *testing.T) {
+	tt, err := launchModulesMission(t, "local")
+	if err != nil {
+		t.Fatal("launch", err)
+	}
+
+	if v := tt.get("build"); len(v) != 1 || v[0] != "hello world|lib-hello world|lib-hello world" {
+		t.Error("build", v)
+	}
+}
+
+func TestModuleStagesNotRunWithoutSequences(t *testing.T) {
+	loggee.SetLogger(stdlog.New())
+	mc := NewMissionControl()
+	tt := newRecordTaskType()
+	mc.RegisterTaskTypes(tt)
+
+	mission := map[string]interface{}{
+		"includes": []interface{}{
+			map[string]interface{}{"path": "more/lib.yml", "as": "lib"},
+		},
+		"stages": []interface{}{
+			map[string]interface{}{
+				"name":  "own",
+				"tasks": []interface{}{map[string]interface{}{"name": "own", "type": "recordTask", "value": "x"}},
+			},
+		},
+	}
+
+	if err := mc.LaunchMission(context.Background(), "testdata/inline.yml", mission); err != nil {
+		t.Fatal("launch", err)
+	}
+
+	if len(tt.get("own")) != 1 || len(tt.get("build")) != 0 {
+		t.Error("unexpected runs", tt.values)
+	}
+
+	// module sequences can be run from a mission without its own sequences
+	if err := mc.LaunchMission(context.Background(), "testdata/inline.yml", mission, "lib.all"); err != nil {
+		t.Fatal("launch module sequence", err)
+	}
+
+	if v := tt.get("build"); len(v) != 2 || v[0] != "default|lib-default|lib-default" {
+		t.Error("module default params", v)
+	}
+}
+
+func TestIncludeParamsNeedNamespace(t *testing.T) {
+	include := Include{Path: "x.yml", Params: Params{{Name: "a", Value: "b"}}}
+	if err := include.Validate(); err == nil {
+		t.Error("expected error")
+	}
+
+	include.As = "x"
+	if err := include.Validate(); err != nil {
+		t.Error("unexpected error", err)
+	}
+}
+
+func TestNamespaceModuleNested(t *testing.T) {
+	inner := namespaceModule(Include{As: "inner"}, &Mission{
+		Params:    Params{{Name: "p", Value: "inner"}},
+		Sequences: map[string][]string{"seq": {"a"}},
+		Stages:    Stages{{Name: "a"}},
+	})
+
+	outer := namespaceModule(Include{As: "outer"}, &Mission{
+		Params:  Params{{Name: "p", Value: "outer"}, {Name: "q", Value: "outer"}},
+		Stages:  Stages{{Name: "b", Ref: "inner.a"}},
+		Modules: []Module{*inner},
+	})
+
+	if len(outer.Stages) != 2 || outer.Stages[0].Name != "outer.b" || outer.Stages[1].Name != "outer.inner.a" {
+		t.Fatal("stages", outer.Stages)
+	}
+
+	if outer.Stages[0].Ref != "outer.inner.a" {
+		t.Error("ref", outer.Stages[0].Ref)
+	}
+
+	if seq := outer.Sequences["outer.inner.seq"]; len(seq) != 1 || seq[0] != "outer.inner.a" {
+		t.Error("sequence", outer.Sequences)
+	}
+
+	// the inner scope is applied after the outer one so takes precedence
+	scope := outer.Stages[1].module
+	if len(scope.params) != 1 || scope.params[0].Value != "inner" || scope.outer == nil || len(scope.outer.params) != 2 {
+		t.Error("params", scope)
+	}
+}
+
+func TestModuleParamsExcludeMissionParams(t *testing.T) {
+	loggee.SetLogger(stdlog.New())
+	mc := NewMissionControl()
+	tt := newRecordTaskType()
+	mc.RegisterTaskTypes(tt)
+
+	mission := map[string]interface{}{
+		"params": []interface{}{
+			map[string]interface{}{"name": "secret", "value": "s"},
+			map[string]interface{}{"name": "target", "value": "mission"},
+		},
+		"includes": []interface{}{
+			map[string]interface{}{
+				"path":   "more/lib.yml",
+				"as":     "lib",
+				"params": []interface{}{map[string]interface{}{"name": "flavour", "value": "{{.secret}}-passed"}},
+			},
+		},
+		"stages": []interface{}{
+			map[string]interface{}{
+				"name": "leak",
+				"ref":  "lib.build",
+				"tasks": []interface{}{
+					map[string]interface{}{"name": "leak", "type": "recordTask", "value": "{{if .secret}}leaked{{end}}"},
+				},
+			},
+		},
+	}
+
+	if err := mc.LaunchMission(context.Background(), "testdata/inline.yml", mission); err != nil {
+		t.Fatal("launch", err)
+	}
+
+	// the stage ref'ing the module stage has the module's scope
+	if v := tt.get("leak"); len(v) != 1 || v[0] != "" {
+		t.Error("mission params visible to the module", v)
+	}
+
+	// the module's default target is used rather than the mission's, the passed flavour sees the mission's params
+	if err := mc.LaunchMission(context.Background(), "testdata/inline.yml", mission, "lib.all"); err != nil {
+		t.Fatal("launch module sequence", err)
+	}
+
+	if v := tt.get("build"); len(v) != 2 || v[0] != "default|s-passed|s-passed" {
+		t.Error("build", v)
+	}
+}
+
+func TestIncludeMergePolicyValidate(t *testing.T) {
+	include := Include{Path: "x.yml", Merge: MergeDeep}
+	if err := include.Validate(); err != nil {
+		t.Error("unexpected error", err)
+	}
+
+	include.Merge = MergePolicy("sideways")
+	if err := include.Validate(); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+
+	include.Merge = MergeOverride
+	include.As = "x"
+	if err := include.Validate(); err == nil {
+		t.Error("expected error for merge with as")
+	}
+}
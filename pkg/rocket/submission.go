@@ -0,0 +1,123 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rocket
+
+import (
+	"context"
+	"sync"
+
+	"github.com/nehemming/cirocket/pkg/resource"
+	"github.com/pkg/errors"
+)
+
+// SubMission describes a mission launched from within another mission.
+type SubMission struct {
+	// Location is the path or url of the mission file.  A relative location is relative to the directory of
+	// the parent mission, or the working directory if there is no parent.
+	Location string
+
+	// Parent is the url of the mission launching the sub mission.
+	Parent string
+
+	// Dir is the directory the mission is run in.  If blank the directory of a local mission file is used.
+	Dir string
+
+	// Params are supplied to the mission, they are loaded before the mission's own params.
+	Params Params
+
+	// Env are environment variables added to the mission's environment.
+	Env VarMap
+
+	// Sequences are the flight sequences to run.
+	Sequences []string
+}
+
+type subMissionLockKey int
+
+// subMissionLock serialises the sub missions launched by the top level missions.  A sub mission runs in its
+// own directory and the working directory is shared by the whole process, so sub missions launched from a
+// concurrent task list must not overlap.  Each sub mission has a lock of its own for the sub missions it launches,
+// so nested launches do not deadlock.
+var subMissionLock sync.Mutex
+
+// lockSubMission waits for the sub missions launched by the same mission to finish, returning a context
+// for the sub mission and the function releasing the lock.
+func lockSubMission(ctx context.Context) (context.Context, func()) {
+	mu, ok := ctx.Value(subMissionLockKey(0)).(*sync.Mutex)
+	if !ok {
+		mu = &subMissionLock
+	}
+
+	mu.Lock()
+
+	return context.WithValue(ctx, subMissionLockKey(0), &sync.Mutex{}), mu.Unlock
+}
+
+// LaunchSubMission loads and executes a mission from within a running mission.
+// The variables exported to the mission's stages are returned.
+func (mc *missionControl) LaunchSubMission(ctx context.Context, sub SubMission) (map[string]string, error) {
+	if sub.Location == "" {
+		return nil, errors.New("no mission location specified")
+	}
+
+	parts := []string{sub.Location}
+	if sub.Parent != "" {
+		parent, err := resource.GetParentLocation(sub.Parent)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parent mission %s", sub.Parent)
+		}
+		parts = append([]string{parent.String()}, parts...)
+	}
+
+	missionURL, err := resource.UltimateURL(parts...)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, unlock := lockSubMission(ctx)
+	defer unlock()
+
+	spaceDust, err := loadMapFromURL(ctx, missionURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "loading mission %s", resource.Relative(missionURL))
+	}
+
+	dir := sub.Dir
+	if dir == "" && missionURL.Scheme == "file" {
+		dir = resource.GetURLParentLocation(missionURL).String()
+	}
+
+	restore, err := swapDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "mission dir %s", dir)
+	}
+	defer restore()
+
+	plan, err := mc.planFlight(ctx, missionURL.String(), spaceDust, sub.Params, sub.Env, sub.Sequences)
+	if err != nil {
+		return nil, err
+	}
+
+	exports := newVariableSet()
+	plan.capComm.stageExports = exports
+
+	if err := mc.fly(ctx, plan, plan.stagesToRun); err != nil {
+		return nil, err
+	}
+
+	return exports.All(), nil
+}
@@ -0,0 +1,59 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rocket
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/nehemming/cirocket/pkg/loggee"
+	"github.com/nehemming/cirocket/pkg/loggee/stdlog"
+)
+
+func TestLaunchSubMissionNoLocation(t *testing.T) {
+	mc := NewMissionControl()
+
+	if _, err := mc.LaunchSubMission(context.Background(), SubMission{}); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestLaunchSubMissionReturnsExports(t *testing.T) {
+	loggee.SetLogger(stdlog.New())
+	mc := NewMissionControl()
+	tt := newRecordTaskType()
+	mc.RegisterTaskTypes(tt)
+
+	exports, err := mc.LaunchSubMission(context.Background(), SubMission{
+		Location:  filepath.Join("testdata", "submission.yml"),
+		Params:    Params{{Name: "who", Value: "parent"}},
+		Env:       VarMap{"SUB_ENV": "env"},
+		Sequences: []string{"ci"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := tt.get("greet"); len(v) != 1 || v[0] != "parent-env" {
+		t.Error("unexpected value", v)
+	}
+
+	if exports["greeting"] != "hello parent" || len(exports) != 1 {
+		t.Error("unexpected exports", exports)
+	}
+}
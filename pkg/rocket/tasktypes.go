@@ -0,0 +1,193 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rocket
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+type taskTypeKey int
+
+// expandingTaskTypesKey is the context key of the custom task types being prepared.
+const expandingTaskTypesKey = taskTypeKey(0)
+
+// Find returns the task type definition with the name.
+func (defs TaskTypeDefs) Find(name string) (*TaskTypeDef, bool) {
+	for i := range defs {
+		if defs[i].Name == name {
+			return &defs[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// TaskTypeInfo lists the custom task types defined by the mission and its modules.
+func (mission *Mission) TaskTypeInfo() TaskTypeInfoList {
+	var list TaskTypeInfoList
+	for _, def := range mission.AllTaskTypes() {
+		list = append(list, TaskTypeInfo{
+			Type:        def.Name,
+			Description: def.Description,
+		})
+	}
+
+	sort.Sort(list)
+
+	return list
+}
+
+// checkTaskTypes checks the mission's custom task types are named uniquely and do not replace registered types.
+func (mc *missionControl) checkTaskTypes(mission *Mission) error {
+	names := make(map[string]bool)
+
+	for _, def := range mission.AllTaskTypes() {
+		if def.Name == "" {
+			return errors.New("task type has no name")
+		}
+
+		if names[def.Name] {
+			return fmt.Errorf("task type %s is defined more than once", def.Name)
+		}
+		names[def.Name] = true
+
+		if _, ok := mc.lookupType(def.Name); ok {
+			return fmt.Errorf("task type %s is already registered", def.Name)
+		}
+
+		for _, input := range def.Inputs {
+			if input.Name == "" {
+				return fmt.Errorf("task type %s has an input with no name", def.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// bindInputs returns the params binding the task definition to the task type inputs.
+func (def *TaskTypeDef) bindInputs(definition map[string]interface{}) (Params, error) {
+	declared := make(map[string]bool)
+	params := make(Params, 0, len(def.Inputs))
+
+	for _, input := range def.Inputs {
+		declared[input.Name] = true
+
+		value, ok := definition[input.Name]
+		switch {
+		case ok:
+			params = append(params, Param{Name: input.Name, Value: fmt.Sprintf("%v", value)})
+		case input.Required:
+			return nil, fmt.Errorf("%s requires input %s", def.Name, input.Name)
+		default:
+			params = append(params, Param{Name: input.Name, Value: input.Default})
+		}
+	}
+
+	keys := make([]string, 0, len(definition))
+	for k := range definition {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if !declared[k] {
+			return nil, fmt.Errorf("%s has no input %s", def.Name, k)
+		}
+	}
+
+	return params, nil
+}
+
+// prepareTaskKindDefined prepares a task using a custom task type.  The type's tasks are run as a group
+// with the task's inputs merged as params.
+func (mc *missionControl) prepareTaskKindDefined(ctx context.Context, capComm *CapComm, task Task, def *TaskTypeDef) (*operation, error) {
+	expanding, _ := ctx.Value(expandingTaskTypesKey).(map[string]bool)
+	if expanding[def.Name] {
+		return nil, fmt.Errorf("task type %s is used by its own tasks", def.Name)
+	}
+
+	params, err := def.bindInputs(task.Definition)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := capComm.MergeParams(ctx, params); err != nil {
+		return nil, errors.Wrap(err, "merging inputs")
+	}
+
+	nested := make(map[string]bool)
+	for k := range expanding {
+		nested[k] = true
+	}
+	nested[def.Name] = true
+
+	return mc.prepareSequentialTaskList(context.WithValue(ctx, expandingTaskTypesKey, nested),
+		capComm, "task: "+task.Name, def.Tasks.Copy(), task.OnFail, false, "")
+}
+
+// renameTaskTypes returns a copy of the tasks with their types renamed.
+func renameTaskTypes(tasks Tasks, rename func(string) string) Tasks {
+	if tasks == nil {
+		return nil
+	}
+
+	renamed := make(Tasks, len(tasks))
+	for i, task := range tasks {
+		renamed[i] = renameTaskType(task, rename)
+	}
+
+	return renamed
+}
+
+func renameTaskType(task Task, rename func(string) string) Task {
+	task.Type = rename(task.Type)
+	task.Try = renameTaskTypes(task.Try, rename)
+	task.Group = renameTaskTypes(task.Group, rename)
+	task.Concurrent = renameTaskTypes(task.Concurrent, rename)
+
+	if task.OnFail != nil {
+		onFail := renameTaskType(*task.OnFail, rename)
+		task.OnFail = &onFail
+	}
+
+	return task
+}
+
+// mergeTaskTypes appends the task types of the addition not already defined by the mission.
+func mergeTaskTypes(mission, addition *Mission) {
+	for _, def := range addition.TaskTypes {
+		if _, ok := mission.TaskTypes.Find(def.Name); !ok || def.Name == "" {
+			mission.TaskTypes = append(mission.TaskTypes, def)
+		}
+	}
+}
+
+// overrideTaskTypes replaces the mission's task types with those of the same name in the addition.
+func overrideTaskTypes(mission, addition *Mission) {
+	for _, def := range addition.TaskTypes {
+		if existing, ok := mission.TaskTypes.Find(def.Name); ok && def.Name != "" {
+			*existing = def
+		} else {
+			mission.TaskTypes = append(mission.TaskTypes, def)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rocket
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nehemming/cirocket/pkg/loggee"
+	"github.com/nehemming/cirocket/pkg/loggee/stdlog"
+)
+
+func TestLaunchMissionWithTaskTypes(t *testing.T) {
+	loggee.SetLogger(stdlog.New())
+	mc := NewMissionControl()
+	tt := newRecordTaskType()
+	mc.RegisterTaskTypes(tt)
+
+	mission, location := loadMission("tasktypes")
+	if err := mc.LaunchMission(context.Background(), location, mission); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := strings.Join(tt.get("greeting"), ","); v != "hello world,hello world,bye world" {
+		t.Error("unexpected greetings", v)
+	}
+}
+
+func TestMissionTaskTypeInfo(t *testing.T) {
+	mission := &Mission{
+		TaskTypes: TaskTypeDefs{{Name: "b", Description: "b type"}, {Name: "a"}},
+		Modules:   []Module{{TaskTypes: TaskTypeDefs{{Name: "m.c"}}}},
+	}
+
+	list := mission.TaskTypeInfo()
+	if len(list) != 3 || list[0].Type != "a" || list[1].Type != "b" || list[1].Description != "b type" || list[2].Type != "m.c" {
+		t.Error("unexpected list", list)
+	}
+}
+
+func TestBindInputs(t *testing.T) {
+	def := &TaskTypeDef{
+		Name: "greet",
+		Inputs: []TaskTypeInput{
+			{Name: "target", Required: true},
+			{Name: "greeting", Default: "hello"},
+			{Name: "count"},
+		},
+	}
+
+	params, err := def.bindInputs(map[string]interface{}{"target": "world", "count": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(params) != 3 || params[0].Value != "world" || params[1].Value != "hello" || params[2].Value != "2" {
+		t.Error("unexpected params", params)
+	}
+
+	if _, err := def.bindInputs(map[string]interface{}{}); err == nil || err.Error() != "greet requires input target" {
+		t.Error("expected required error", err)
+	}
+
+	if _, err := def.bindInputs(map[string]interface{}{"target": "world", "other": "x"}); err == nil || err.Error() != "greet has no input other" {
+		t.Error("expected unknown input error", err)
+	}
+}
+
+func TestCheckTaskTypes(t *testing.T) {
+	mc := NewMissionControl().(*missionControl)
+	mc.RegisterTaskTypes(newRecordTaskType())
+
+	tests := []struct {
+		defs TaskTypeDefs
+		err  string
+	}{
+		{TaskTypeDefs{{Name: "a"}, {Name: "b"}}, ""},
+		{TaskTypeDefs{{}}, "task type has no name"},
+		{TaskTypeDefs{{Name: "a"}, {Name: "a"}}, "task type a is defined more than once"},
+		{TaskTypeDefs{{Name: "recordTask"}}, "task type recordTask is already registered"},
+		{TaskTypeDefs{{Name: "a", Inputs: []TaskTypeInput{{}}}}, "task type a has an input with no name"},
+	}
+
+	for i, test := range tests {
+		err := mc.checkTaskTypes(&Mission{TaskTypes: test.defs})
+		if (err == nil && test.err != "") || (err != nil && err.Error() != test.err) {
+			t.Error(i, "unexpected error", err)
+		}
+	}
+}
+
+func TestTaskTypeUsedByItself(t *testing.T) {
+	loggee.SetLogger(stdlog.New())
+	mc := NewMissionControl()
+
+	mission := map[string]interface{}{
+		"taskTypes": []interface{}{
+			map[string]interface{}{
+				"name":  "loop",
+				"tasks": []interface{}{map[string]interface{}{"type": "loop"}},
+			},
+		},
+		"stages": []interface{}{
+			map[string]interface{}{
+				"name":  "looping",
+				"tasks": []interface{}{map[string]interface{}{"type": "loop", "name": "start"}},
+			},
+		},
+	}
+
+	err := mc.LaunchMission(context.Background(), "", mission)
+	if err == nil || !strings.Contains(err.Error(), "task type loop is used by its own tasks") {
+		t.Error("expected circular error", err)
+	}
+}
+
+func TestNamespaceModuleTaskTypes(t *testing.T) {
+	m := namespaceModule(Include{As: "lib"}, &Mission{
+		TaskTypes: TaskTypeDefs{
+			{Name: "inner"},
+			{Name: "outer", Tasks: Tasks{{Type: "inner"}, {Group: Tasks{{Type: "recordTask"}}}}},
+		},
+		Stages: Stages{{Name: "a", Tasks: Tasks{{Type: "outer"}}, OnFail: &Task{Type: "inner"}}},
+	})
+
+	if len(m.TaskTypes) != 2 || m.TaskTypes[0].Name != "lib.inner" || m.TaskTypes[1].Name != "lib.outer" {
+		t.Fatal("task types", m.TaskTypes)
+	}
+
+	if tasks := m.TaskTypes[1].Tasks; tasks[0].Type != "lib.inner" || tasks[1].Group[0].Type != "recordTask" {
+		t.Error("task type tasks", tasks)
+	}
+
+	if stage := m.Stages[0]; stage.Tasks[0].Type != "lib.outer" || stage.OnFail.Type != "lib.inner" {
+		t.Error("stage", stage)
+	}
+}
+
+func TestMergeTaskTypes(t *testing.T) {
+	mission := &Mission{TaskTypes: TaskTypeDefs{{Name: "a", Description: "mission"}}}
+	addition := &Mission{TaskTypes: TaskTypeDefs{{Name: "a", Description: "include"}, {Name: "b"}}}
+
+	mergeMissions(mission, addition)
+	if len(mission.TaskTypes) != 2 || mission.TaskTypes[0].Description != "mission" {
+		t.Error("preserve", mission.TaskTypes)
+	}
+
+	overrideMissions(mission, addition)
+	if len(mission.TaskTypes) != 2 || mission.TaskTypes[0].Description != "include" {
+		t.Error("override", mission.TaskTypes)
+	}
+}
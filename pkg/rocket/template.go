@@ -17,6 +17,10 @@ limitations under the License.
 package rocket
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
 	"os"
 	"os/user"
 	"path"
@@ -27,6 +31,15 @@ import (
 
 	"github.com/mitchellh/go-homedir"
 	"github.com/nehemming/cirocket/pkg/resource"
+	"github.com/pkg/errors"
+)
+
+const (
+	// includeFuncName is the name of the function rendering a named template to a string.
+	includeFuncName = "include"
+
+	// maxIncludeDepth limits the nesting of includes, stopping recursive templates.
+	maxIncludeDepth = 100
 )
 
 func initFuncMap() template.FuncMap {
@@ -47,6 +60,98 @@ func initFuncMap() template.FuncMap {
 	return fm
 }
 
+// SetTemplates parses the mission's shared templates into an unsealed CapComm.  The templates are parsed once and
+// associated with every template the CapComm creates.
+func (capComm *CapComm) SetTemplates(ctx context.Context, defs []TemplateDef) error {
+	capComm.mustNotBeSealed()
+
+	if len(defs) == 0 {
+		return nil
+	}
+
+	// include is bound to the executing template set by NewTemplate
+	library := template.New("").
+		Option("missingkey=zero").
+		Funcs(capComm.funcMap).
+		Funcs(template.FuncMap{includeFuncName: func(string, interface{}) (string, error) { return "", nil }})
+
+	for index, def := range defs {
+		// the template text itself is not expanded
+		spec := def.InputSpec
+		if spec.Inline != "" {
+			spec.SkipExpand = true
+		}
+
+		rp, err := capComm.InputSpecToResourceProvider(ctx, spec)
+		if err != nil {
+			return errors.Wrapf(err, "template %d", index)
+		}
+
+		r, err := rp.OpenRead(ctx)
+		if err != nil {
+			return errors.Wrapf(err, "template %d", index)
+		}
+
+		b, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return errors.Wrapf(err, "template %d", index)
+		}
+
+		name := def.Name
+		if name == "" {
+			name = fmt.Sprintf("templates[%d]", index)
+		}
+
+		if _, err := library.New(name).Parse(string(b)); err != nil {
+			return errors.Wrapf(err, "template %s", name)
+		}
+	}
+
+	capComm.templates = library
+
+	return nil
+}
+
+// NewTemplate creates a template using the CapComm's functions and associated with the mission's shared templates.
+// The include function renders a named template to a string, so it can be piped into other functions such as indent.
+func (capComm *CapComm) NewTemplate(name string) (*template.Template, error) {
+	var t *template.Template
+	depth := 0
+	include := func(partial string, data interface{}) (string, error) {
+		if depth >= maxIncludeDepth {
+			return "", fmt.Errorf("include %s exceeds the maximum depth of %d", partial, maxIncludeDepth)
+		}
+		depth++
+		defer func() { depth-- }()
+
+		var buf bytes.Buffer
+		if err := t.ExecuteTemplate(&buf, partial, data); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+
+	if capComm.templates == nil {
+		t = template.New(name)
+	} else {
+		library, err := capComm.templates.Clone()
+		if err != nil {
+			return nil, errors.Wrap(err, "shared templates")
+		}
+
+		// do not replace a shared template with the same name, such as a param's
+		if library.Lookup(name) != nil {
+			name = "expanding " + name
+		}
+		t = library.New(name)
+	}
+
+	return t.Option("missingkey=zero").
+		Funcs(capComm.funcMap).
+		Funcs(template.FuncMap{includeFuncName: include}), nil
+}
+
 func indent(indent int, text string) string {
 	// indent indents all lines, except the first line by indent spaces
 	// use in templates as a pipeline '|'
@@ -18,6 +18,7 @@ package rocket
 
 import (
 	"context"
+	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -121,3 +122,70 @@ func TestBaseNameFilePath(t *testing.T) {
 		t.Error("unexpected", d)
 	}
 }
+
+func TestSharedTemplates(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	defines := filepath.Join(dir, "defines.tmpl")
+	if err := os.WriteFile(defines, []byte(`{{ define "footer" }}-- {{ .name }}{{ end }}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	capComm := NewCapComm(testMissionFile, stdlog.New()).Copy(false)
+	err := capComm.SetTemplates(ctx, []TemplateDef{
+		{Name: "header", InputSpec: InputSpec{Inline: "# {{ .name }}\n# generated"}},
+		{InputSpec: InputSpec{Path: defines}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := capComm.MergeParams(ctx, Params{{Name: "name", Value: "demo"}}); err != nil {
+		t.Fatal(err)
+	}
+	capComm.Seal()
+
+	s, err := capComm.ExpandString(ctx, "test", `{{ template "header" . }}|{{ include "footer" . }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "# demo\n# generated|-- demo" {
+		t.Errorf("unexpected %q", s)
+	}
+
+	s, err = capComm.ExpandString(ctx, "test", `spec:
+  {{ include "header" . | indent 2 }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "spec:\n  # demo\n  # generated\n" {
+		t.Errorf("unexpected indent %q", s)
+	}
+
+	// copies share the templates
+	if s, err := capComm.Copy(false).ExpandString(ctx, "copy", `{{ include "footer" . }}`); err != nil || s != "-- demo" {
+		t.Error("copy", s, err)
+	}
+
+	if _, err := capComm.ExpandString(ctx, "test", `{{ include "missing" . }}`); err == nil {
+		t.Error("expected missing template error")
+	}
+
+	if _, err := capComm.ExpandString(ctx, "self", `{{ include "self" . }}`); err == nil {
+		t.Error("expected recursion error")
+	}
+}
+
+func TestSharedTemplatesErrors(t *testing.T) {
+	ctx := context.Background()
+
+	capComm := NewCapComm(testMissionFile, stdlog.New()).Copy(false)
+	if err := capComm.SetTemplates(ctx, []TemplateDef{{Name: "bad", InputSpec: InputSpec{Inline: "{{ .x "}}}); err == nil {
+		t.Error("expected parse error")
+	}
+
+	if err := capComm.SetTemplates(ctx, []TemplateDef{{Name: "none"}}); err == nil {
+		t.Error("expected missing source error")
+	}
+}
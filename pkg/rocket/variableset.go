@@ -21,6 +21,9 @@ import "sync"
 type variableSet struct {
 	variables map[string]string
 	rwLock    sync.RWMutex
+
+	// forward, if set, receives a copy of each variable set.
+	forward *variableSet
 }
 
 func newVariableSet() *variableSet {
@@ -46,8 +49,12 @@ func (vs *variableSet) All() map[string]string {
 // Set assigns the variable to the set.
 func (vs *variableSet) Set(key, value string) {
 	vs.rwLock.Lock()
-	defer vs.rwLock.Unlock()
 	vs.variables[key] = value
+	vs.rwLock.Unlock()
+
+	if vs.forward != nil {
+		vs.forward.Set(key, value)
+	}
 }
 
 // Get returns the variable value and a boolean to indicate if present.
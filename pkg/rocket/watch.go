@@ -0,0 +1,380 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rocket
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	globber "github.com/bmatcuk/doublestar/v4"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultWatchDebounce is the default quiet period after a change before stages are re-run.
+	DefaultWatchDebounce = 300 * time.Millisecond
+
+	// DefaultWatchInterval is the default interval between checks for file changes.
+	DefaultWatchInterval = 500 * time.Millisecond
+
+	// watchTimeSlack allows for file modification times lagging the clock, as they can be coarser than it.
+	watchTimeSlack = time.Second
+)
+
+type (
+	// WatchSettings control how a watched mission detects file changes.
+	WatchSettings struct {
+		// Patterns are glob patterns watched on behalf of every stage being run.
+		// Relative patterns are relative to the working directory.
+		// Files modified while stages are running are assumed to have been written by them and do not
+		// cause the stages to re-run, this includes any edits made while the stages run.
+		Patterns []string
+
+		// Debounce is the quiet period that must follow a change before the affected stages are re-run.
+		// Zero uses DefaultWatchDebounce.
+		Debounce time.Duration
+
+		// Interval is the period between checks for file changes.
+		// Zero uses DefaultWatchInterval.
+		Interval time.Duration
+	}
+
+	// stageWatch holds the absolute watch patterns of a stage.
+	stageWatch struct {
+		stage    Stage
+		patterns []string
+	}
+
+	// fileStamp records the state of a watched file.
+	fileStamp struct {
+		modTime time.Time
+		size    int64
+	}
+
+	// watchSnapshot maps watched file paths to their state.
+	watchSnapshot map[string]fileStamp
+
+	// watchRun is an in progress run of a watched mission.  Ended is set before done is closed.
+	watchRun struct {
+		cancel  context.CancelFunc
+		done    chan struct{}
+		started time.Time
+		ended   time.Time
+	}
+)
+
+// WatchMission launches the mission and re-runs stages when their watched files change.
+func (mc *missionControl) WatchMission(ctx context.Context, location string,
+	spaceDust map[string]interface{}, params Params,
+	settings WatchSettings,
+	flightSequences ...string) error {
+	plan, err := mc.planFlight(ctx, location, spaceDust, params, nil, flightSequences)
+	if err != nil {
+		return err
+	}
+
+	watches, err := plan.stageWatches(ctx, settings.Patterns)
+	if err != nil {
+		return err
+	}
+
+	patterns := allWatchPatterns(watches)
+	if len(patterns) == 0 {
+		return errors.New("nothing to watch, add watch patterns to the stages or use the --watch flag")
+	}
+
+	if settings.Debounce <= 0 {
+		settings.Debounce = DefaultWatchDebounce
+	}
+	if settings.Interval <= 0 {
+		settings.Interval = DefaultWatchInterval
+	}
+
+	snapshot, err := takeWatchSnapshot(patterns)
+	if err != nil {
+		return err
+	}
+	checked := time.Now()
+
+	run := mc.startWatchRun(ctx, plan, plan.stagesToRun)
+	defer func() { run.stop() }()
+
+	ticker := time.NewTicker(settings.Interval)
+	defer ticker.Stop()
+
+	pending := make(map[string]bool)
+	var quietAt time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			current, err := takeWatchSnapshot(patterns)
+			if err != nil {
+				plan.capComm.Log().Warnf("watch: %s", err)
+				continue
+			}
+
+			changes := run.excludeWritten(snapshot.changes(current), current, checked, now)
+			snapshot, checked = current, now
+
+			if len(changes) > 0 {
+				for _, f := range changes {
+					pending[f] = true
+				}
+				quietAt = now.Add(settings.Debounce)
+				continue
+			}
+
+			if len(pending) == 0 || now.Before(quietAt) {
+				continue
+			}
+
+			stages := affectedStages(watches, pending)
+			pending = make(map[string]bool)
+			if len(stages) == 0 {
+				continue
+			}
+
+			// cancel any in progress run before starting again
+			run.stop()
+
+			plan.capComm.Log().Infof("changes detected, re-running %d stage(s)", len(stages))
+			run = mc.startWatchRun(ctx, plan, stages)
+		}
+	}
+}
+
+// stageWatches builds the absolute watch patterns for each stage to run.
+// The global patterns apply to every stage.
+func (plan *flightPlan) stageWatches(ctx context.Context, globalPatterns []string) ([]stageWatch, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	global, err := absWatchPatterns(ctx, plan.capComm, cwd, globalPatterns)
+	if err != nil {
+		return nil, err
+	}
+
+	watches := make([]stageWatch, 0, len(plan.stagesToRun))
+	for _, stage := range plan.stagesToRun {
+		merged := stage
+		if merged.Ref != "" {
+			if err := mergeStageRef(&merged, plan.stageMap); err != nil {
+				return nil, errors.Wrapf(err, "%s merge with ref %s", stage.Name, stage.Ref)
+			}
+		}
+
+		dir := cwd
+		if merged.Dir != "" {
+			stageDir, err := plan.capComm.ExpandString(ctx, "dir", merged.Dir)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s dir expand", stage.Name)
+			}
+			if filepath.IsAbs(stageDir) {
+				dir = stageDir
+			} else {
+				dir = filepath.Join(cwd, stageDir)
+			}
+		}
+
+		patterns, err := absWatchPatterns(ctx, plan.capComm, dir, merged.Watch)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s watch", stage.Name)
+		}
+
+		watches = append(watches, stageWatch{
+			stage:    stage,
+			patterns: append(patterns, global...),
+		})
+	}
+
+	return watches, nil
+}
+
+// absWatchPatterns expands the patterns and converts them to absolute slash format patterns.
+func absWatchPatterns(ctx context.Context, capComm *CapComm, dir string, patterns []string) ([]string, error) {
+	abs := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		expanded, err := capComm.ExpandString(ctx, "watch", pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		expanded = filepath.FromSlash(expanded)
+		if !filepath.IsAbs(expanded) {
+			expanded = filepath.Join(dir, expanded)
+		}
+
+		abs = append(abs, filepath.ToSlash(expanded))
+	}
+
+	return abs, nil
+}
+
+// allWatchPatterns returns the distinct patterns of all stages.
+func allWatchPatterns(watches []stageWatch) []string {
+	var patterns []string
+	seen := make(map[string]bool)
+
+	for _, w := range watches {
+		for _, p := range w.patterns {
+			if !seen[p] {
+				seen[p] = true
+				patterns = append(patterns, p)
+			}
+		}
+	}
+
+	return patterns
+}
+
+// affectedStages returns the stages, in run order, with a pattern matching one of the changed files.
+func affectedStages(watches []stageWatch, changed map[string]bool) Stages {
+	var stages Stages
+
+	for _, w := range watches {
+		if watchMatches(w.patterns, changed) {
+			stages = append(stages, w.stage)
+		}
+	}
+
+	return stages
+}
+
+func watchMatches(patterns []string, changed map[string]bool) bool {
+	for file := range changed {
+		for _, pattern := range patterns {
+			if ok, _ := globber.Match(pattern, file); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// takeWatchSnapshot records the state of all the files matching the patterns.
+func takeWatchSnapshot(patterns []string) (watchSnapshot, error) {
+	snapshot := make(watchSnapshot)
+
+	for _, pattern := range patterns {
+		base, pat := globber.SplitPattern(pattern)
+		files, err := globber.Glob(os.DirFS(filepath.FromSlash(base)), pat)
+		if err != nil {
+			return nil, errors.Wrapf(err, "pattern %s", pattern)
+		}
+
+		for _, f := range files {
+			name := base + "/" + f
+			if base == "/" {
+				name = "/" + f
+			}
+
+			info, err := os.Stat(filepath.FromSlash(name))
+			if err != nil || info.IsDir() {
+				continue
+			}
+
+			snapshot[name] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+
+	return snapshot, nil
+}
+
+// changes returns the files that have been added, removed or modified between the snapshots.
+func (snapshot watchSnapshot) changes(current watchSnapshot) []string {
+	var changed []string
+
+	for name, stamp := range current {
+		if prev, ok := snapshot[name]; !ok || prev != stamp {
+			changed = append(changed, name)
+		}
+	}
+
+	for name := range snapshot {
+		if _, ok := current[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+
+	return changed
+}
+
+// startWatchRun runs the stages in the background, the run can be cancelled by calling stop.
+func (mc *missionControl) startWatchRun(ctx context.Context, plan *flightPlan, stages Stages) *watchRun {
+	runCtx, cancel := context.WithCancel(ctx)
+	run := &watchRun{cancel: cancel, done: make(chan struct{}), started: time.Now()}
+	log := plan.capComm.Log()
+
+	go func() {
+		defer func() {
+			run.ended = time.Now()
+			close(run.done)
+		}()
+
+		if err := mc.fly(runCtx, plan, stages); err != nil {
+			if runCtx.Err() != nil {
+				log.Warn("run cancelled")
+			} else {
+				log.Errorf("run failed: %s", err)
+			}
+			return
+		}
+
+		log.Info("waiting for changes")
+	}()
+
+	return run
+}
+
+// stop cancels the run and waits for it to finish.
+func (run *watchRun) stop() {
+	run.cancel()
+	<-run.done
+}
+
+// excludeWritten removes the changed files the run is assumed to have written, those modified while it was
+// in progress.  Removed files are excluded if the run was in progress since the previous check.
+func (run *watchRun) excludeWritten(changes []string, current watchSnapshot, checked, now time.Time) []string {
+	ended := now
+	select {
+	case <-run.done:
+		ended = run.ended
+	default:
+	}
+
+	var kept []string
+	for _, f := range changes {
+		from, to := checked, now
+		if stamp, ok := current[f]; ok {
+			from, to = stamp.modTime, stamp.modTime
+		}
+
+		if run.started.Add(-watchTimeSlack).After(to) || ended.Before(from) {
+			kept = append(kept, f)
+		}
+	}
+
+	return kept
+}
@@ -0,0 +1,268 @@
+/*
+Copyright (c) 2021 The cirocket Authors (Neil Hemming)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rocket
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nehemming/cirocket/pkg/loggee"
+	"github.com/nehemming/cirocket/pkg/loggee/stdlog"
+)
+
+type countingTaskType struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (tt *countingTaskType) Type() string        { return "countTask" }
+func (tt *countingTaskType) Description() string { return "counting task" }
+
+func (tt *countingTaskType) Prepare(ctx context.Context, capComm *CapComm, task Task) (ExecuteFunc, error) {
+	write, _ := task.Definition["write"].(string)
+
+	return func(ctx context.Context) error {
+		if write != "" {
+			if err := os.WriteFile(write, []byte(time.Now().String()), 0666); err != nil {
+				return err
+			}
+		}
+
+		tt.mu.Lock()
+		defer tt.mu.Unlock()
+		tt.counts[task.Name]++
+		return nil
+	}, nil
+}
+
+func (tt *countingTaskType) count(name string) int {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	return tt.counts[name]
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchSnapshotChanges(t *testing.T) {
+	dir := t.TempDir()
+	keep := filepath.Join(dir, "keep.txt")
+	gone := filepath.Join(dir, "gone.txt")
+	for _, f := range []string{keep, gone} {
+		if err := os.WriteFile(f, []byte("a"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	patterns := []string{filepath.ToSlash(dir) + "/**/*.txt"}
+	before, err := takeWatchSnapshot(patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) != 2 {
+		t.Error("snapshot size", len(before))
+	}
+
+	if err := os.Remove(gone); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "new.txt"), []byte("b"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := takeWatchSnapshot(patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := before.changes(after)
+	if len(changes) != 2 {
+		t.Error("changes", changes)
+	}
+
+	if len(after.changes(after)) != 0 {
+		t.Error("unexpected changes for same snapshot")
+	}
+}
+
+func TestAffectedStages(t *testing.T) {
+	watches := []stageWatch{
+		{stage: Stage{Name: "go"}, patterns: []string{"/src/**/*.go"}},
+		{stage: Stage{Name: "docs"}, patterns: []string{"/src/docs/*.md"}},
+	}
+
+	stages := affectedStages(watches, map[string]bool{"/src/docs/readme.md": true})
+	if len(stages) != 1 || stages[0].Name != "docs" {
+		t.Error("docs change", stages)
+	}
+
+	stages = affectedStages(watches, map[string]bool{"/src/pkg/a.go": true, "/src/docs/b.md": true})
+	if len(stages) != 2 || stages[0].Name != "go" {
+		t.Error("both changed", stages)
+	}
+
+	stages = affectedStages(watches, map[string]bool{"/other/a.go": true})
+	if len(stages) != 0 {
+		t.Error("unexpected stages", stages)
+	}
+}
+
+func TestWatchMissionNothingToWatch(t *testing.T) {
+	loggee.SetLogger(stdlog.New())
+	mc := NewMissionControl()
+	mc.RegisterTaskTypes(&countingTaskType{counts: make(map[string]int)})
+
+	mission := map[string]interface{}{
+		"stages": []interface{}{
+			map[string]interface{}{
+				"name":  "one",
+				"tasks": []interface{}{map[string]interface{}{"name": "a", "type": "countTask"}},
+			},
+		},
+	}
+
+	if err := mc.WatchMission(context.Background(), "", mission, nil, WatchSettings{}); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestWatchMissionRerunsAffectedStages(t *testing.T) {
+	loggee.SetLogger(stdlog.New())
+	mc := NewMissionControl()
+	tt := &countingTaskType{counts: make(map[string]int)}
+	mc.RegisterTaskTypes(tt)
+
+	dir := filepath.ToSlash(t.TempDir())
+	goFile := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(goFile, []byte("package main"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	mission := map[string]interface{}{
+		"stages": []interface{}{
+			map[string]interface{}{
+				"name":  "build",
+				"watch": []interface{}{dir + "/*.go"},
+				"tasks": []interface{}{map[string]interface{}{"name": "build", "type": "countTask"}},
+			},
+			map[string]interface{}{
+				"name":  "docs",
+				"watch": []interface{}{dir + "/*.md"},
+				"tasks": []interface{}{map[string]interface{}{"name": "docs", "type": "countTask"}},
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	settings := WatchSettings{Debounce: 20 * time.Millisecond, Interval: 10 * time.Millisecond}
+	done := make(chan error)
+	go func() {
+		done <- mc.WatchMission(ctx, "", mission, nil, settings)
+	}()
+
+	waitFor(t, "initial run", func() bool { return tt.count("build") == 1 && tt.count("docs") == 1 })
+
+	// ensure the modification time moves on
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(goFile, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "rerun", func() bool { return tt.count("build") == 2 })
+
+	if tt.count("docs") != 1 {
+		t.Error("docs stage should not have re-run", tt.count("docs"))
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Error("watch error", err)
+	}
+}
+
+func TestWatchMissionIgnoresFilesWrittenByRun(t *testing.T) {
+	loggee.SetLogger(stdlog.New())
+	mc := NewMissionControl()
+	tt := &countingTaskType{counts: make(map[string]int)}
+	mc.RegisterTaskTypes(tt)
+
+	dir := filepath.ToSlash(t.TempDir())
+	goFile := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(goFile, []byte("package main"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	mission := map[string]interface{}{
+		"stages": []interface{}{
+			map[string]interface{}{
+				"name":  "generate",
+				"watch": []interface{}{dir + "/*.go"},
+				"tasks": []interface{}{
+					map[string]interface{}{"name": "generate", "type": "countTask", "write": filepath.Join(dir, "gen.go")},
+				},
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	settings := WatchSettings{Debounce: 20 * time.Millisecond, Interval: 10 * time.Millisecond}
+	done := make(chan error)
+	go func() {
+		done <- mc.WatchMission(ctx, "", mission, nil, settings)
+	}()
+
+	waitFor(t, "initial run", func() bool { return tt.count("generate") == 1 })
+
+	// the generated file does not trigger a re-run
+	time.Sleep(200 * time.Millisecond)
+	if tt.count("generate") != 1 {
+		t.Error("stage re-ran after writing a watched file", tt.count("generate"))
+	}
+
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(goFile, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "rerun", func() bool { return tt.count("generate") == 2 })
+
+	time.Sleep(200 * time.Millisecond)
+	if tt.count("generate") != 2 {
+		t.Error("stage re-ran after its rerun", tt.count("generate"))
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Error("watch error", err)
+	}
+}